@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/database"
+	"github.com/lucaspanzera1/chat/internal/importer"
+	"github.com/lucaspanzera1/chat/internal/repository"
+)
+
+// emailFlags acumula os -email Nome=email repetidos.
+type emailFlags []string
+
+func (e *emailFlags) String() string     { return strings.Join(*e, ", ") }
+func (e *emailFlags) Set(v string) error { *e = append(*e, v); return nil }
+
+// runImport é o subcomando "import":
+//
+//	server import -source slack -file export.zip
+//	server import -source whatsapp -file conversa.txt -email "Ana=ana@x.com" -timezone America/Sao_Paulo
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	source := fs.String("source", "", "origem: slack ou whatsapp")
+	file := fs.String("file", "", "zip da exportação do Slack, ou .txt/.zip da conversa do WhatsApp")
+	emailsFile := fs.String("emails", "", "arquivo com a associação de pessoas a emails (JSON ou linhas Nome=email)")
+	room := fs.String("room", "", "nome da sala (WhatsApp; padrão: o do arquivo)")
+	timezone := fs.String("timezone", "", "fuso do celular que exportou (WhatsApp; padrão: o do servidor)")
+	admin := fs.String("admin", "", "email de quem administra os grupos sem criador no arquivo")
+	var emails emailFlags
+	fs.Var(&emails, "email", "associa uma pessoa a um email, Nome=email (pode repetir)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *source == "" || *file == "" {
+		fs.Usage()
+		return errors.New("informe -source e -file")
+	}
+
+	text := strings.Join(emails, "\n")
+	if *emailsFile != "" {
+		data, err := os.ReadFile(*emailsFile)
+		if err != nil {
+			return err
+		}
+		if len(emails) > 0 {
+			return errors.New("use -emails ou -email, não os dois")
+		}
+		text = string(data)
+	}
+	mapping, err := importer.ParseEmails(text)
+	if err != nil {
+		return err
+	}
+
+	wa := importer.WhatsAppOptions{Name: *room}
+	if *timezone != "" {
+		if wa.Location, err = time.LoadLocation(*timezone); err != nil {
+			return fmt.Errorf("timezone inválido: %w", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	repo := repository.NewImportRepository(database.DB)
+	opts := importer.Options{Emails: mapping}
+	if *admin != "" {
+		id, _, err := repo.UserByEmail(ctx, strings.ToLower(*admin))
+		if err != nil {
+			return err
+		}
+		if id == "" {
+			return fmt.Errorf("nenhuma conta com o email %s", *admin)
+		}
+		opts.CreatedBy = id
+	}
+
+	archive, closer, err := importer.Open(*source, *file, wa)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	report, err := importer.NewImporter(repo).Import(ctx, archive, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Origem: %s\nPessoas: %d associadas, %d contas provisórias\nSalas: %d (%d novas)\nMensagens: %d (%d novas)\n",
+		report.Source, report.UsersMatched, report.UsersCreated, report.Rooms, report.RoomsCreated, report.Messages, report.Inserted)
+	return nil
+}
@@ -1,16 +1,31 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/lucaspanzera1/chat/internal/auth"
 	"github.com/lucaspanzera1/chat/internal/database"
+	"github.com/lucaspanzera1/chat/internal/export"
 	"github.com/lucaspanzera1/chat/internal/handlers"
 	"github.com/lucaspanzera1/chat/internal/hub"
+	"github.com/lucaspanzera1/chat/internal/importer"
+	"github.com/lucaspanzera1/chat/internal/mail"
+	"github.com/lucaspanzera1/chat/internal/messaging"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/preview"
+	"github.com/lucaspanzera1/chat/internal/ratelimit"
 	"github.com/lucaspanzera1/chat/internal/repository"
+	"github.com/lucaspanzera1/chat/internal/sso"
+	"github.com/lucaspanzera1/chat/internal/webhook"
 )
 
 func main() {
@@ -26,10 +41,6 @@ func main() {
 		log.Println("Aviso: arquivo .env não encontrado")
 	}
 
-	if os.Getenv("JWT_SECRET") == "" {
-		log.Fatal("ERRO: JWT_SECRET não configurado no .env")
-	}
-
 	if err := database.Connect(); err != nil {
 		log.Fatalf("Erro ao conectar ao banco: %v", err)
 	}
@@ -39,90 +50,365 @@ func main() {
 		log.Fatalf("Erro ao executar migrações: %v", err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			log.Fatalf("Erro na importação: %v", err)
+		}
+		return
+	}
+
 	userRepo := repository.NewUserRepository(database.DB)
 	messageRepo := repository.NewMessageRepository(database.DB)
 	roomRepo := repository.NewRoomRepository(database.DB)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(database.DB)
+	tokenRepo := repository.NewTokenRepository(database.DB)
+	mfaRepo := repository.NewMFARepository(database.DB)
+	identityRepo := repository.NewIdentityRepository(database.DB)
+	apiTokenRepo := repository.NewAPITokenRepository(database.DB)
+	webhookRepo := repository.NewWebhookRepository(database.DB)
+	outgoingRepo := repository.NewOutgoingWebhookRepository(database.DB)
+	commandRepo := repository.NewBotCommandRepository(database.DB)
+	pollRepo := repository.NewPollRepository(database.DB)
+	scheduledRepo := repository.NewScheduledRepository(database.DB)
+	retentionRepo := repository.NewRetentionRepository(database.DB)
+	keyRepo := repository.NewKeyRepository(database.DB)
+	exportRepo := repository.NewExportRepository(database.DB)
+	importRepo := repository.NewImportRepository(database.DB)
+	previewRepo := repository.NewPreviewRepository(database.DB)
 
 	log.Printf("✓ Repositórios inicializados (DB: %v)", database.DB != nil)
 
 	h := hub.NewHub()
+	h.SetPresence(hub.PresenceConfig{
+		Debounce: envDuration("PRESENCE_DEBOUNCE", 5*time.Second),
+		Settings: roomRepo.GetSettings,
+		Persist:  messageRepo.Create,
+	})
 	go h.Run()
 
-	authHandler := handlers.NewAuthHandler(userRepo)
-	wsHandler := handlers.NewWSHandler(h, userRepo, messageRepo)
-	httpHandler := handlers.NewHTTPHandler(messageRepo, roomRepo, userRepo)
-	oauthHandler := handlers.NewOAuthHandler(userRepo)
+	pipeline := messaging.NewPipeline(messageRepo, roomRepo, h.Broadcast)
+	pipeline.SetFloodGuard(ratelimit.NewFloodGuard(ratelimit.FloodConfig{
+		UserRate:   envFloat("RATE_LIMIT_USER_PER_SEC", 1),
+		UserBurst:  envInt("RATE_LIMIT_USER_BURST", 5),
+		RoomRate:   envFloat("RATE_LIMIT_ROOM_PER_SEC", 20),
+		RoomBurst:  envInt("RATE_LIMIT_ROOM_BURST", 40),
+		MuteAfter:  envInt("FLOOD_MUTE_AFTER", 10),
+		MuteWindow: envDuration("FLOOD_MUTE_WINDOW", time.Minute),
+		MuteFor:    envDuration("FLOOD_MUTE_DURATION", 5*time.Minute),
+	}))
 
-	http.HandleFunc("/api/register", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		authHandler.Register(w, r)
+	dispatcher := webhook.NewDispatcher(outgoingRepo, webhook.Config{
+		PollInterval: envDuration("WEBHOOK_POLL_INTERVAL", 2*time.Second),
+		Timeout:      envDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		MaxAttempts:  envInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		BaseBackoff:  envDuration("WEBHOOK_BASE_BACKOFF", 30*time.Second),
+		MaxBackoff:   envDuration("WEBHOOK_MAX_BACKOFF", 6*time.Hour),
 	})
-
-	http.HandleFunc("/api/login", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		authHandler.Login(w, r)
+	pipeline.SetEvents(dispatcher)
+	pipeline.SetTargeted(h)
+	pipeline.SetPolls(pollRepo)
+	pipeline.SetScheduler(scheduledRepo, userRepo)
+	retentionDays := envInt("MESSAGE_RETENTION_DAYS", 0)
+	pipeline.SetRetention(retentionRepo, messaging.RetentionConfig{
+		DefaultDays: retentionDays,
+		Interval:    envDuration("RETENTION_INTERVAL", time.Minute),
+		BatchSize:   envInt("RETENTION_BATCH_SIZE", 500),
+	})
+	pipeline.SetCommands(messaging.CommandConfig{
+		Store: commandRepo,
+		Rooms: roomRepo,
+		Users: userRepo,
 	})
+	exporter := export.NewExporter(exportRepo, export.Config{
+		Dir:          os.Getenv("EXPORT_DIR"),
+		TTL:          envDuration("EXPORT_TTL", 24*time.Hour),
+		PollInterval: envDuration("EXPORT_INTERVAL", 5*time.Second),
+	})
+	exporter.SetNotify(pipeline.Notify)
+	pipeline.SetExports(exporter)
+	previewer := preview.NewPreviewer(previewRepo, h.Broadcast, preview.Config{
+		Timeout:  envDuration("PREVIEW_TIMEOUT", 5*time.Second),
+		MaxBytes: int64(envInt("PREVIEW_MAX_KB", 512)) << 10,
+		CacheTTL: envDuration("PREVIEW_CACHE_TTL", 24*time.Hour),
+		Workers:  envInt("PREVIEW_WORKERS", 4),
+	})
+	pipeline.SetPreviews(previewer)
 
-	http.HandleFunc("/ws", wsHandler.ServeWS)
+	// Login e cadastro: limite por IP, em tentativas por minuto.
+	authPerMinute := envInt("RATE_LIMIT_AUTH_PER_MIN", 10)
+	authLimiter := ratelimit.NewLimiter(float64(authPerMinute)/60, authPerMinute)
 
-	http.HandleFunc("/api/messages", httpHandler.GetHistory)
-	http.HandleFunc("/api/room/messages", httpHandler.GetRoomHistory)
-	http.HandleFunc("/api/users", httpHandler.GetUsers)
+	lockout := auth.DefaultLockoutPolicy()
+	lockout.Threshold = envInt("LOGIN_LOCKOUT_THRESHOLD", lockout.Threshold)
+	lockout.LockDuration = envDuration("LOGIN_LOCKOUT_DURATION", lockout.LockDuration)
+	lockout.IPThreshold = envInt("LOGIN_IP_THRESHOLD", lockout.IPThreshold)
+	lockout.IPWindow = envDuration("LOGIN_IP_WINDOW", lockout.IPWindow)
 
-	http.HandleFunc("/api/room/private", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		httpHandler.CreatePrivateRoom(w, r)
-	})
+	port := os.Getenv("SERVER_PORT")
+	if port == "" {
+		port = "8080"
+	}
 
-	http.HandleFunc("/api/group/create", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		httpHandler.CreateGroup(w, r)
-	})
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:" + port
+	}
+
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir == "" {
+		keysDir = "keys"
+	}
+	jwtIssuer := os.Getenv("JWT_ISSUER")
+	if jwtIssuer == "" {
+		jwtIssuer = appURL
+	}
+	jwtAudience := os.Getenv("JWT_AUDIENCE")
+	if jwtAudience == "" {
+		jwtAudience = "chat"
+	}
+	keySet, err := auth.LoadKeyDir(keysDir, os.Getenv("JWT_SIGNING_KEY"), jwtIssuer, jwtAudience)
+	if err != nil {
+		log.Fatalf("Erro ao carregar chaves JWT: %v", err)
+	}
+	auth.SetKeySet(keySet)
+	log.Printf("✓ Tokens assinados com %s (kid %s)", keySet.Signing().Algorithm(), keySet.Signing().ID)
+
+	accountHandler := handlers.NewAccountHandler(userRepo, tokenRepo, loginAttemptRepo, mail.FromEnv(), appURL)
+	authHandler := handlers.NewAuthHandler(userRepo, loginAttemptRepo, mfaRepo, lockout, accountHandler)
+	mfaIssuer := os.Getenv("TOTP_ISSUER")
+	if mfaIssuer == "" {
+		mfaIssuer = "Chat"
+	}
+	mfaHandler := handlers.NewMFAHandler(userRepo, mfaRepo, mfaIssuer)
+	adminHandler := handlers.NewAdminHandler(userRepo, loginAttemptRepo)
+	adminHandler.SetImporter(importer.NewImporter(importRepo), int64(envInt("IMPORT_MAX_MB", 512))<<20)
+	wsHandler := handlers.NewWSHandler(h, userRepo, pipeline)
+	streamHandler := handlers.NewStreamHandler(h, userRepo, pipeline)
+	wsHandler.SetAPITokens(apiTokenRepo)
+	streamHandler.SetAPITokens(apiTokenRepo)
+	apiTokenHandler := handlers.NewAPITokenHandler(apiTokenRepo, userRepo, roomRepo, commandRepo)
+	webhookHandler := handlers.NewWebhookHandler(webhookRepo, roomRepo, pipeline, appURL)
+	outgoingHandler := handlers.NewOutgoingWebhookHandler(outgoingRepo, userRepo, roomRepo)
+	keyHandler := handlers.NewKeyHandler(keyRepo, roomRepo)
+	exportHandler := handlers.NewExportHandler(exporter, roomRepo)
+	httpHandler := handlers.NewHTTPHandler(messageRepo, roomRepo, userRepo, mfaRepo)
+	httpHandler.SetEvents(dispatcher)
+	httpHandler.SetPolls(pollRepo)
+	httpHandler.SetPreviews(previewRepo)
+	httpHandler.SetDefaultRetention(retentionDays)
+	apiTokenHandler.SetEvents(dispatcher)
+	discoveryCtx, cancelDiscovery := context.WithTimeout(context.Background(), 10*time.Second)
+	providers := sso.FromEnv(discoveryCtx, appURL)
+	cancelDiscovery()
+	oauthHandler := handlers.NewOAuthHandler(userRepo, identityRepo, providers)
+
+	limited := func(h http.HandlerFunc) http.HandlerFunc {
+		return handlers.RateLimitByIP(authLimiter, h)
+	}
+	authed := handlers.RequireAuth
+	canRead := handlers.RequireScope(apiTokenRepo, models.ScopeMessagesRead)
+	canWrite := handlers.RequireScope(apiTokenRepo, models.ScopeMessagesWrite)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /api/register", limited(authHandler.Register))
+	mux.HandleFunc("POST /api/login", limited(authHandler.Login))
+	mux.HandleFunc("POST /api/login/2fa", limited(authHandler.LoginMFA))
+	mux.HandleFunc("POST /api/auth/forgot", limited(accountHandler.ForgotPassword))
+	mux.HandleFunc("POST /api/auth/reset", limited(accountHandler.ResetPassword))
+	mux.HandleFunc("GET /api/auth/verify", accountHandler.VerifyEmail)
+	mux.HandleFunc("POST /api/auth/verify/resend", limited(authed(accountHandler.ResendVerification)))
+
+	mux.HandleFunc("GET /.well-known/jwks.json", authHandler.JWKS)
+	mux.HandleFunc("GET /api/auth/providers", oauthHandler.Providers)
+	mux.HandleFunc("GET /api/auth/sso/{provider}", oauthHandler.Login)
+	mux.HandleFunc("GET /api/auth/sso/{provider}/callback", oauthHandler.Callback)
+	mux.HandleFunc("GET /api/auth/google", oauthHandler.GoogleLogin)
+	mux.HandleFunc("GET /api/auth/google/callback", oauthHandler.GoogleCallback)
+
+	// WebSocket, SSE e long-polling autenticam pelo token na query string;
+	// aceitam também tokens de API com o escopo realtime.
+	mux.HandleFunc("GET /ws", wsHandler.ServeWS)
+	mux.HandleFunc("GET /sse", streamHandler.ServeSSE)
+	mux.HandleFunc("GET /api/poll", streamHandler.Poll)
+	mux.HandleFunc("POST /api/room/send", streamHandler.SendMessage)
+
+	// Webhooks de entrada: o segredo na URL é a autenticação.
+	mux.HandleFunc("POST /hooks/{secret}", webhookHandler.Receive)
+
+	mux.HandleFunc("GET /api/messages", authed(httpHandler.GetHistory))
+	mux.HandleFunc("GET /api/users", authed(httpHandler.GetUsers))
+	mux.HandleFunc("GET /api/groups", authed(httpHandler.GetUserGroups))
+	mux.HandleFunc("POST /api/room/private", authed(httpHandler.CreatePrivateRoom))
+	mux.HandleFunc("POST /api/group/create", authed(httpHandler.CreateGroup))
 
-	http.HandleFunc("/api/groups", httpHandler.GetUserGroups)
-	http.HandleFunc("/api/group/members", httpHandler.GetGroupMembers)
+	mux.HandleFunc("GET /api/rooms/{id}/messages", canRead(httpHandler.GetRoomHistory))
+	mux.HandleFunc("POST /api/rooms/{id}/messages", streamHandler.SendMessage)
+	mux.HandleFunc("POST /api/rooms/{id}/ephemeral", streamHandler.SendEphemeral)
+	mux.HandleFunc("POST /api/rooms/{id}/polls", streamHandler.CreatePoll)
+	mux.HandleFunc("GET /api/polls/{id}", streamHandler.GetPoll)
+	mux.HandleFunc("POST /api/polls/{id}/votes", streamHandler.Vote)
+	mux.HandleFunc("DELETE /api/polls/{id}/votes", streamHandler.Unvote)
+	mux.HandleFunc("DELETE /api/polls/{id}/votes/{optionId}", streamHandler.Unvote)
+	mux.HandleFunc("POST /api/polls/{id}/close", streamHandler.ClosePoll)
+	mux.HandleFunc("POST /api/rooms/{id}/scheduled", streamHandler.CreateScheduled)
+	mux.HandleFunc("POST /api/rooms/{id}/reminders", streamHandler.CreateReminder)
+	mux.HandleFunc("POST /api/rooms/{id}/messages/{messageId}/reminders", streamHandler.CreateReminder)
+	mux.HandleFunc("GET /api/scheduled", streamHandler.ListScheduled)
+	mux.HandleFunc("PATCH /api/scheduled/{id}", streamHandler.UpdateScheduled)
+	mux.HandleFunc("DELETE /api/scheduled/{id}", streamHandler.CancelScheduled)
+	mux.HandleFunc("PATCH /api/rooms/{id}/messages/{messageId}", streamHandler.EditMessage)
+	mux.HandleFunc("DELETE /api/rooms/{id}/messages/{messageId}", streamHandler.DeleteMessage)
+	mux.HandleFunc("GET /api/rooms/{id}/members", canRead(httpHandler.GetGroupMembers))
+	mux.HandleFunc("POST /api/rooms/{id}/members", authed(httpHandler.AddMember))
+	mux.HandleFunc("DELETE /api/rooms/{id}/members/{userId}", authed(httpHandler.RemoveMember))
+	mux.HandleFunc("GET /api/rooms/{id}/settings", authed(httpHandler.GetRoomSettings))
+	mux.HandleFunc("PATCH /api/rooms/{id}/settings", authed(httpHandler.UpdateRoomSettings))
+	mux.HandleFunc("GET /api/rooms/{id}/keys", authed(keyHandler.RoomKeys))
+	mux.HandleFunc("POST /api/rooms/{id}/keys/claim", authed(keyHandler.ClaimRoomKeys))
+	mux.HandleFunc("POST /api/rooms/{id}/exports", canRead(exportHandler.Create))
+	mux.HandleFunc("GET /api/rooms/{id}/webhooks", authed(webhookHandler.ListIncoming))
+	mux.HandleFunc("POST /api/rooms/{id}/webhooks", authed(webhookHandler.CreateIncoming))
+	mux.HandleFunc("DELETE /api/rooms/{id}/webhooks/{hookId}", authed(webhookHandler.DeleteIncoming))
 
-	http.HandleFunc("/api/auth/google", oauthHandler.GoogleLogin)
-	http.HandleFunc("/api/auth/google/callback", oauthHandler.GoogleCallback)
+	// Rotas antigas, com o id da sala em ?roomId= ou no corpo.
+	mux.HandleFunc("GET /api/room/messages", authed(httpHandler.GetRoomHistory))
+	mux.HandleFunc("GET /api/group/members", authed(httpHandler.GetGroupMembers))
+	mux.HandleFunc("GET /api/room/settings", authed(httpHandler.GetRoomSettings))
+	mux.HandleFunc("POST /api/room/settings", authed(httpHandler.UpdateRoomSettings))
 
-	http.HandleFunc("/api/user/username", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	mux.HandleFunc("GET /api/user/me", authed(httpHandler.GetCurrentUser))
+	mux.HandleFunc("GET /api/user/profile", authed(httpHandler.GetUserProfile))
+	mux.HandleFunc("POST /api/user/username", authed(httpHandler.SetUsername))
+	mux.HandleFunc("POST /api/user/password", authed(httpHandler.ChangePassword))
+	mux.HandleFunc("POST /api/user/timezone", authed(httpHandler.SetTimezone))
+
+	mux.HandleFunc("GET /api/exports", canRead(exportHandler.List))
+	mux.HandleFunc("GET /api/exports/{id}", canRead(exportHandler.Get))
+	mux.HandleFunc("GET /api/exports/{id}/download", canRead(exportHandler.Download))
+
+	mux.HandleFunc("GET /api/keys/devices", authed(keyHandler.ListDevices))
+	mux.HandleFunc("PUT /api/keys/devices/{deviceId}", authed(keyHandler.UploadKeys))
+	mux.HandleFunc("DELETE /api/keys/devices/{deviceId}", authed(keyHandler.DeleteDevice))
+
+	mux.HandleFunc("GET /api/user/identities", authed(oauthHandler.ListIdentities))
+	mux.HandleFunc("POST /api/user/identities/link", authed(oauthHandler.Link))
+	mux.HandleFunc("POST /api/user/identities/unlink", authed(oauthHandler.Unlink))
+
+	mux.HandleFunc("GET /api/user/2fa", authed(mfaHandler.Status))
+	mux.HandleFunc("POST /api/user/2fa/setup", limited(authed(mfaHandler.Setup)))
+	mux.HandleFunc("POST /api/user/2fa/confirm", limited(authed(mfaHandler.Confirm)))
+	mux.HandleFunc("POST /api/user/2fa/disable", limited(authed(mfaHandler.Disable)))
+	mux.HandleFunc("POST /api/user/2fa/recovery-codes", limited(authed(mfaHandler.RegenerateRecoveryCodes)))
+
+	mux.HandleFunc("GET /api/bots", authed(apiTokenHandler.ListBots))
+	mux.HandleFunc("POST /api/bots", authed(apiTokenHandler.CreateBot))
+	mux.HandleFunc("DELETE /api/bots/{id}", authed(apiTokenHandler.DeleteBot))
+	mux.HandleFunc("POST /api/bots/{id}/rooms", authed(apiTokenHandler.AddBotToRoom))
+	mux.HandleFunc("GET /api/bots/{id}/commands", canWrite(apiTokenHandler.ListCommands))
+	mux.HandleFunc("POST /api/bots/{id}/commands", canWrite(apiTokenHandler.CreateCommand))
+	mux.HandleFunc("DELETE /api/bots/{id}/commands/{commandId}", canWrite(apiTokenHandler.DeleteCommand))
+	mux.HandleFunc("POST /api/commands/{invocationId}/respond", streamHandler.RespondCommand)
+	mux.HandleFunc("GET /api/tokens", authed(apiTokenHandler.ListTokens))
+	mux.HandleFunc("POST /api/tokens", authed(apiTokenHandler.CreateToken))
+	mux.HandleFunc("DELETE /api/tokens/{id}", authed(apiTokenHandler.RevokeToken))
+
+	mux.HandleFunc("GET /api/webhooks", authed(outgoingHandler.List))
+	mux.HandleFunc("POST /api/webhooks", authed(outgoingHandler.Create))
+	mux.HandleFunc("DELETE /api/webhooks/{id}", authed(outgoingHandler.Delete))
+	mux.HandleFunc("GET /api/webhooks/{id}/deliveries", authed(outgoingHandler.ListDeliveries))
+	mux.HandleFunc("GET /api/webhooks/{id}/deliveries/{deliveryId}/attempts", authed(outgoingHandler.ListAttempts))
+	mux.HandleFunc("POST /api/webhooks/{id}/deliveries/{deliveryId}/retry", authed(outgoingHandler.Retry))
+
+	mux.HandleFunc("POST /api/admin/unlock", authed(adminHandler.UnlockLogin))
+	mux.HandleFunc("POST /api/admin/import/{source}", authed(adminHandler.Import))
+
+	mux.Handle("GET /", http.FileServer(http.Dir("web")))
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: handlers.Chain(mux, handlers.RequestID, handlers.AccessLog, handlers.Recover),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go dispatcher.Run(ctx)
+	go pipeline.RunPollCloser(ctx)
+	go pipeline.RunScheduler(ctx, envDuration("SCHEDULER_INTERVAL", 5*time.Second))
+	go pipeline.RunRetention(ctx)
+	go exporter.Run(ctx)
+	go previewer.Run(ctx)
+
+	go func() {
+		log.Printf("Servidor rodando em http://localhost:%s", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Erro no servidor: %v", err)
 		}
-		httpHandler.SetUsername(w, r)
-	})
+	}()
+
+	<-ctx.Done()
+	stop()
 
-	http.HandleFunc("/api/user/me", httpHandler.GetCurrentUser)
-	http.HandleFunc("/api/user/profile", httpHandler.GetUserProfile)
-	http.HandleFunc("/api/user/password", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	timeout := envDuration("SHUTDOWN_TIMEOUT", 15*time.Second)
+	log.Printf("Encerrando servidor (timeout %s)...", timeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// O hub é avisado assim que o listener fecha; isso também encerra os
+	// streams SSE e long-polling que o Shutdown estaria esperando.
+	srv.RegisterOnShutdown(func() {
+		if err := h.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Erro ao notificar clientes: %v", err)
 		}
-		httpHandler.ChangePassword(w, r)
 	})
 
-	fs := http.FileServer(http.Dir("web"))
-	http.Handle("/", fs)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Erro ao parar servidor HTTP: %v", err)
+	}
 
-	port := os.Getenv("SERVER_PORT")
-	if port == "" {
-		port = "8080"
+	if err := wsHandler.Drain(shutdownCtx); err != nil {
+		log.Printf("Conexões WebSocket não encerraram a tempo: %v", err)
+	}
+
+	if err := messageRepo.Wait(shutdownCtx); err != nil {
+		log.Printf("Escritas de mensagens não concluíram a tempo: %v", err)
+	}
+
+	if err := userRepo.SetAllOffline(shutdownCtx); err != nil {
+		log.Printf("Erro ao marcar usuários offline: %v", err)
+	}
+
+	log.Println("✓ Servidor encerrado")
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Aviso: %s inválido (%q), usando %s", name, v, def)
 	}
+	return def
+}
 
-	log.Printf("Servidor rodando em http://localhost:%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+func envInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+		log.Printf("Aviso: %s inválido (%q), usando %d", name, v, def)
+	}
+	return def
+}
+
+func envFloat(name string, def float64) float64 {
+	if v := os.Getenv(name); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			return f
+		}
+		log.Printf("Aviso: %s inválido (%q), usando %g", name, v, def)
+	}
+	return def
 }
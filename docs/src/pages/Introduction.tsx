@@ -116,7 +116,7 @@ export const Introduction: React.FC = () => {
                                 <span className="text-purple-400">cd</span> chat<br />
                                 <span className="text-purple-400">cp</span> .env.example .env<br />
                                 <span className="text-purple-400">docker-compose</span> up -d<br />
-                                <span className="text-purple-400">go</span> run cmd/server/main.go
+                                <span className="text-purple-400">go</span> run ./cmd/server
                             </div>
                         </div>
                     </div>
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+type claimsKey struct{}
+
+// WithClaims guarda no contexto as claims do token já validado.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFrom devolve as claims postas pelo middleware de autenticação, ou nil.
+func ClaimsFrom(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsKey{}).(*Claims)
+	return claims
+}
+
+// BearerToken extrai o token de um header Authorization "Bearer <token>".
+// O token sem prefixo ainda é aceito para clientes antigos.
+func BearerToken(header string) string {
+	header = strings.TrimSpace(header)
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return header
+}
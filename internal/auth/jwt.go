@@ -3,38 +3,55 @@ package auth
 import (
 	"errors"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/lucaspanzera1/chat/internal/models"
 )
 
+// PurposeMFAPending marca o token entregue após a senha quando a conta tem
+// 2FA: só serve para concluir o login em /api/login/2fa.
+const PurposeMFAPending = "mfa_pending"
+
+const mfaTokenTTL = 5 * time.Minute
+
 type Claims struct {
 	UserID   string `json:"userId"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
+	Purpose  string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func GenerateToken(user *models.User) (string, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return "", errors.New("JWT_SECRET não configurado no .env")
-	}
+	return generate(user, "", 24*time.Hour)
+}
+
+func GenerateMFAToken(user *models.User) (string, error) {
+	return generate(user, PurposeMFAPending, mfaTokenTTL)
+}
+
+func generate(user *models.User, purpose string, ttl time.Duration) (string, error) {
+	keys := currentKeySet()
+	now := time.Now()
 
 	claims := Claims{
 		UserID:   user.ID,
 		Username: user.Username,
 		Email:    user.Email,
+		Purpose:  purpose,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    keys.Issuer,
+			Subject:   user.ID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
+	if keys.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{keys.Audience}
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(secret))
+	tokenString, err := keys.sign(claims)
 	if err != nil {
 		return "", fmt.Errorf("erro ao assinar token: %v", err)
 	}
@@ -42,16 +59,34 @@ func GenerateToken(user *models.User) (string, error) {
 	return tokenString, nil
 }
 
+// ValidateToken aceita apenas tokens de sessão; tokens com finalidade
+// específica (como mfa_pending) são recusados.
 func ValidateToken(tokenString string) (*Claims, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return nil, errors.New("JWT_SECRET não configurado")
+	claims, err := parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("token inválido")
 	}
+	return claims, nil
+}
+
+func ValidateMFAToken(tokenString string) (*Claims, error) {
+	claims, err := parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != PurposeMFAPending {
+		return nil, errors.New("token inválido")
+	}
+	return claims, nil
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+func parse(tokenString string) (*Claims, error) {
+	keys := currentKeySet()
 
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.keyFunc, keys.parserOptions()...)
 	if err != nil {
 		return nil, err
 	}
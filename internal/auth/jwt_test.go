@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+var testUser = &models.User{ID: "u1", Username: "alice", Email: "alice@example.com"}
+
+// useKeySet instala s no lugar do conjunto global até o fim do teste.
+func useKeySet(t *testing.T, s *KeySet) {
+	t.Helper()
+	keySetMu.RLock()
+	prev := keySet
+	keySetMu.RUnlock()
+	SetKeySet(s)
+	t.Cleanup(func() { SetKeySet(prev) })
+}
+
+func newEd25519(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustRSA(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testKeySet tem uma chave Ed25519 ("ed") que assina e uma RSA ("rsa") só
+// para verificação, com issuer e audience exigidos.
+func testKeySet(t *testing.T) (*KeySet, ed25519.PrivateKey, *rsa.PrivateKey) {
+	t.Helper()
+	edKey := newEd25519(t)
+	rsaKey := mustRSA(t)
+
+	s := NewKeySet("chat", "chat-api")
+	if err := s.Add("ed", edKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("rsa", &rsaKey.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSigning("ed"); err != nil {
+		t.Fatal(err)
+	}
+	useKeySet(t, s)
+	return s, edKey, rsaKey
+}
+
+func validClaims() Claims {
+	now := time.Now()
+	return Claims{
+		UserID:   testUser.ID,
+		Username: testUser.Username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chat",
+			Audience:  jwt.ClaimStrings{"chat-api"},
+			Subject:   testUser.ID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+}
+
+func signWith(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	testKeySet(t)
+
+	token, err := GenerateToken(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.UserID != testUser.ID || claims.Issuer != "chat" || claims.ExpiresAt == nil {
+		t.Errorf("claims = %+v", claims)
+	}
+	if _, err := ValidateMFAToken(token); err == nil {
+		t.Error("token de sessão aceito como mfa_pending")
+	}
+}
+
+func TestRejectsHS256AndNone(t *testing.T) {
+	_, edKey, rsaKey := testKeySet(t)
+
+	// Confusão de algoritmo: HMAC com a chave pública como segredo.
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]string{
+		"HS256 com a chave RSA": signWith(t, jwt.SigningMethodHS256, der, "rsa", validClaims()),
+		"HS256 com a chave Ed":  signWith(t, jwt.SigningMethodHS256, []byte(edKey.Public().(ed25519.PublicKey)), "ed", validClaims()),
+		"none":                  signWith(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "ed", validClaims()),
+	}
+	for name, token := range tokens {
+		if _, err := ValidateToken(token); err == nil {
+			t.Errorf("%s: token aceito", name)
+		}
+	}
+}
+
+func TestRejectsUnknownOrMismatchedKid(t *testing.T) {
+	_, edKey, _ := testKeySet(t)
+
+	tokens := map[string]string{
+		"kid desconhecido":          signWith(t, jwt.SigningMethodEdDSA, edKey, "outra", validClaims()),
+		"sem kid":                   signWith(t, jwt.SigningMethodEdDSA, edKey, "", validClaims()),
+		"kid de outro algoritmo":    signWith(t, jwt.SigningMethodEdDSA, edKey, "rsa", validClaims()),
+		"chave fora do conjunto":    signWith(t, jwt.SigningMethodEdDSA, newEd25519(t), "ed", validClaims()),
+		"RS256 com kid da Ed25519":  signWith(t, jwt.SigningMethodRS256, mustRSA(t), "ed", validClaims()),
+		"RS256 com chave diferente": signWith(t, jwt.SigningMethodRS256, mustRSA(t), "rsa", validClaims()),
+	}
+	for name, token := range tokens {
+		if _, err := ValidateToken(token); err == nil {
+			t.Errorf("%s: token aceito", name)
+		}
+	}
+}
+
+func TestRejectsWrongIssuerAudienceAndMissingExp(t *testing.T) {
+	_, edKey, _ := testKeySet(t)
+
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "outro"
+	wrongAudience := validClaims()
+	wrongAudience.Audience = jwt.ClaimStrings{"outro-servico"}
+	noAudience := validClaims()
+	noAudience.Audience = nil
+	noExp := validClaims()
+	noExp.ExpiresAt = nil
+	expired := validClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	tokens := map[string]Claims{
+		"issuer errado":   wrongIssuer,
+		"audience errada": wrongAudience,
+		"sem audience":    noAudience,
+		"sem exp":         noExp,
+		"expirado":        expired,
+	}
+	for name, claims := range tokens {
+		if _, err := ValidateToken(signWith(t, jwt.SigningMethodEdDSA, edKey, "ed", claims)); err == nil {
+			t.Errorf("%s: token aceito", name)
+		}
+	}
+
+	// O mesmo token com as claims certas passa: a recusa acima é pelas claims.
+	if _, err := ValidateToken(signWith(t, jwt.SigningMethodEdDSA, edKey, "ed", validClaims())); err != nil {
+		t.Errorf("token válido recusado: %v", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	s, edKey, rsaKey := testKeySet(t)
+
+	data, err := json.Marshal(s.JWKS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Keys) != 2 {
+		t.Fatalf("chaves = %d, quer 2: %s", len(doc.Keys), data)
+	}
+
+	ed, rsaJWK := doc.Keys[0], doc.Keys[1]
+	wantEd := map[string]string{
+		"kty": "OKP", "crv": "Ed25519", "kid": "ed", "use": "sig", "alg": "EdDSA",
+		"x": base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)),
+	}
+	if !maps.Equal(ed, wantEd) {
+		t.Errorf("JWK Ed25519 = %v, quer %v", ed, wantEd)
+	}
+	wantRSA := map[string]string{
+		"kty": "RSA", "kid": "rsa", "use": "sig", "alg": "RS256", "e": "AQAB",
+		"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+	}
+	if !maps.Equal(rsaJWK, wantRSA) {
+		t.Errorf("JWK RSA = %v, quer %v", rsaJWK, wantRSA)
+	}
+	if strings.Contains(string(data), `"d"`) {
+		t.Errorf("JWKS expõe a parte privada: %s", data)
+	}
+}
+
+func TestLoadKeyDirRotation(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := writeEd25519Key(filepath.Join(dir, "2026-01-01.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := LoadKeyDir(dir, "", "chat", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeySet(t, old)
+	oldToken, err := GenerateToken(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chave nova, com nome posterior: passa a assinar sem configuração.
+	if _, err := writeEd25519Key(filepath.Join(dir, "2026-06-01.pem")); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := LoadKeyDir(dir, "", "chat", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeySet(rotated)
+
+	if got := rotated.Signing().ID; got != "2026-06-01" {
+		t.Errorf("chave de assinatura = %s, quer 2026-06-01", got)
+	}
+	newToken, err := GenerateToken(testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenKid(t, newToken); kid != "2026-06-01" {
+		t.Errorf("kid do token novo = %s", kid)
+	}
+	if _, err := ValidateToken(oldToken); err != nil {
+		t.Errorf("token da chave antiga recusado depois da rotação: %v", err)
+	}
+	if _, err := ValidateToken(newToken); err != nil {
+		t.Errorf("token novo recusado: %v", err)
+	}
+
+	// Com a chave antiga removida do diretório, os tokens dela caem.
+	if err := os.Remove(filepath.Join(dir, "2026-01-01.pem")); err != nil {
+		t.Fatal(err)
+	}
+	retired, err := LoadKeyDir(dir, "", "chat", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeySet(retired)
+	if _, err := ValidateToken(oldToken); err == nil {
+		t.Error("token de chave removida aceito")
+	}
+}
+
+func tokenKid(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
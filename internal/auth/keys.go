@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key é uma chave de assinatura identificada pelo kid. Chaves aposentadas
+// ficam só com a parte pública e continuam validando tokens já emitidos.
+type Key struct {
+	ID      string
+	private crypto.Signer
+	public  crypto.PublicKey
+	method  jwt.SigningMethod
+}
+
+func (k *Key) Algorithm() string {
+	return k.method.Alg()
+}
+
+// KeySet guarda a chave que assina os tokens novos e todas as que ainda
+// validam tokens, além do issuer e audience exigidos.
+type KeySet struct {
+	Issuer   string
+	Audience string
+
+	signing *Key
+	keys    map[string]*Key
+	order   []string
+}
+
+func NewKeySet(issuer, audience string) *KeySet {
+	return &KeySet{Issuer: issuer, Audience: audience, keys: make(map[string]*Key)}
+}
+
+// Add registra uma chave. A chave privada pode ser RSA (RS256) ou Ed25519
+// (EdDSA); também aceita só a pública, para verificação.
+func (s *KeySet) Add(id string, key any) error {
+	k := &Key{ID: id}
+
+	switch v := key.(type) {
+	case *rsa.PrivateKey:
+		k.private, k.public, k.method = v, &v.PublicKey, jwt.SigningMethodRS256
+	case *rsa.PublicKey:
+		k.public, k.method = v, jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		k.private, k.public, k.method = v, v.Public(), jwt.SigningMethodEdDSA
+	case ed25519.PublicKey:
+		k.public, k.method = v, jwt.SigningMethodEdDSA
+	default:
+		return fmt.Errorf("chave %s: tipo %T não suportado", id, key)
+	}
+
+	if rsaKey, ok := k.public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < 2048 {
+		return fmt.Errorf("chave %s: RSA precisa de ao menos 2048 bits", id)
+	}
+
+	if _, exists := s.keys[id]; !exists {
+		s.order = append(s.order, id)
+	}
+	s.keys[id] = k
+	return nil
+}
+
+// SetSigning escolhe a chave que assina os tokens novos.
+func (s *KeySet) SetSigning(id string) error {
+	k := s.keys[id]
+	if k == nil {
+		return fmt.Errorf("chave %s não encontrada", id)
+	}
+	if k.private == nil {
+		return fmt.Errorf("chave %s não tem parte privada", id)
+	}
+	s.signing = k
+	return nil
+}
+
+func (s *KeySet) Signing() *Key {
+	return s.signing
+}
+
+func (s *KeySet) sign(claims jwt.Claims) (string, error) {
+	if s.signing == nil {
+		return "", errors.New("nenhuma chave de assinatura configurada")
+	}
+	token := jwt.NewWithClaims(s.signing.method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.private)
+}
+
+// keyFunc só aceita tokens com kid conhecido e assinados exatamente com o
+// algoritmo daquela chave; "none" e HS256 nunca passam.
+func (s *KeySet) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	k := s.keys[kid]
+	if k == nil {
+		return nil, errors.New("kid desconhecido")
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("algoritmo %s não permitido", token.Method.Alg())
+	}
+	return k.public, nil
+}
+
+func (s *KeySet) parserOptions() []jwt.ParserOption {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if s.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(s.Issuer))
+	}
+	if s.Audience != "" {
+		opts = append(opts, jwt.WithAudience(s.Audience))
+	}
+	return opts
+}
+
+// JWK é a forma pública de uma chave (RFC 7517), servida em
+// /.well-known/jwks.json para outros serviços validarem nossos tokens.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (s *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, id := range s.order {
+		k := s.keys[id]
+		jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.method.Alg()}
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// LoadKeyDir lê as chaves PEM (*.pem) de dir; o nome do arquivo sem extensão
+// é o kid. A chave de assinatura é signingID ou, se vazio, a última chave
+// privada em ordem alfabética, então nomes com data (2026-10-19.pem) fazem a
+// rotação sozinhos. Um diretório sem chave privada ganha uma Ed25519 nova.
+func LoadKeyDir(dir, signingID, issuer, audience string) (*KeySet, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	set := NewKeySet(issuer, audience)
+	lastPrivate := ""
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := readPEM(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if err := set.Add(id, key); err != nil {
+			return nil, err
+		}
+		if set.keys[id].private != nil {
+			lastPrivate = id
+		}
+	}
+
+	if lastPrivate == "" {
+		id := time.Now().UTC().Format("2006-01-02T150405")
+		key, err := writeEd25519Key(filepath.Join(dir, id+".pem"))
+		if err != nil {
+			return nil, err
+		}
+		set.Add(id, key)
+		lastPrivate = id
+		log.Printf("✓ Nova chave de assinatura JWT gerada: %s", id)
+	}
+
+	if signingID == "" {
+		signingID = lastPrivate
+	}
+	if err := set.SetSigning(signingID); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func readPEM(file string) (any, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("PEM inválido")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("bloco PEM %q não suportado", block.Type)
+	}
+}
+
+func writeEd25519Key(file string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// PublicKeys devolve o JWKS do conjunto em uso.
+func PublicKeys() JWKS {
+	return currentKeySet().JWKS()
+}
+
+var (
+	keySetMu  sync.RWMutex
+	keySet    *KeySet
+	ephemeral sync.Once
+)
+
+// SetKeySet instala o conjunto de chaves usado por GenerateToken e
+// ValidateToken.
+func SetKeySet(s *KeySet) {
+	keySetMu.Lock()
+	keySet = s
+	keySetMu.Unlock()
+}
+
+// currentKeySet devolve o conjunto instalado. Sem SetKeySet (testes, por
+// exemplo) usa uma chave Ed25519 em memória, que não sobrevive a reinícios.
+func currentKeySet() *KeySet {
+	keySetMu.RLock()
+	s := keySet
+	keySetMu.RUnlock()
+	if s != nil {
+		return s
+	}
+
+	ephemeral.Do(func() {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		s := NewKeySet("", "")
+		s.Add("ephemeral", key)
+		s.SetSigning("ephemeral")
+
+		keySetMu.Lock()
+		if keySet == nil {
+			keySet = s
+		}
+		keySetMu.Unlock()
+	})
+
+	keySetMu.RLock()
+	defer keySetMu.RUnlock()
+	return keySet
+}
@@ -0,0 +1,60 @@
+package auth
+
+import "time"
+
+// LockoutPolicy define a proteção contra força bruta no login por senha.
+// Falhas de uma conta contam desde o último login bem-sucedido (ou
+// desbloqueio) dentro de AccountWindow; falhas de um IP contam dentro de
+// IPWindow, não importa o email tentado.
+type LockoutPolicy struct {
+	Threshold     int
+	LockDuration  time.Duration
+	MaxLock       time.Duration
+	AccountWindow time.Duration
+	IPThreshold   int
+	IPWindow      time.Duration
+}
+
+func DefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		Threshold:     5,
+		LockDuration:  15 * time.Minute,
+		MaxLock:       24 * time.Hour,
+		AccountWindow: 24 * time.Hour,
+		IPThreshold:   20,
+		IPWindow:      15 * time.Minute,
+	}
+}
+
+// LockedUntil devolve até quando a conta fica bloqueada depois de `failures`
+// falhas, a última em `last`. Cada falha além do limite dobra o bloqueio.
+func (p LockoutPolicy) LockedUntil(failures int, last time.Time) time.Time {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return time.Time{}
+	}
+
+	lock := p.LockDuration
+	for i := p.Threshold; i < failures && lock < p.MaxLock; i++ {
+		// Compara com a metade para não estourar com um MaxLock enorme.
+		if lock > p.MaxLock/2 {
+			lock = p.MaxLock
+			break
+		}
+		lock *= 2
+	}
+	return last.Add(min(lock, p.MaxLock))
+}
+
+// Delay é a espera aplicada à resposta de uma tentativa que falhou: nada na
+// primeira, depois 500ms dobrando a cada falha, até 8s.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if failures <= 1 {
+		return 0
+	}
+
+	delay := 500 * time.Millisecond
+	for i := 2; i < failures && delay < 8*time.Second; i++ {
+		delay *= 2
+	}
+	return min(delay, 8*time.Second)
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLockedUntil(t *testing.T) {
+	last := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	p := DefaultLockoutPolicy()
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, 15 * time.Minute},
+		{6, 30 * time.Minute},
+		{7, time.Hour},
+		{9, 4 * time.Hour},
+		{11, 16 * time.Hour},
+		{12, 24 * time.Hour}, // 32h passa do MaxLock
+		{13, 24 * time.Hour},
+		{1000, 24 * time.Hour},
+		{math.MaxInt, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		until := p.LockedUntil(tt.failures, last)
+		got := time.Duration(0)
+		if !until.IsZero() {
+			got = until.Sub(last)
+		}
+		if got != tt.want {
+			t.Errorf("LockedUntil(%d) = +%s, quer +%s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLockedUntilEdgeCases(t *testing.T) {
+	last := time.Unix(0, 0)
+
+	// Sem limite configurado a conta nunca bloqueia.
+	off := DefaultLockoutPolicy()
+	off.Threshold = 0
+	if until := off.LockedUntil(100, last); !until.IsZero() {
+		t.Errorf("Threshold 0: bloqueado até %s", until)
+	}
+
+	// LockDuration acima do MaxLock fica no teto já na primeira vez.
+	long := DefaultLockoutPolicy()
+	long.LockDuration = 48 * time.Hour
+	if got := long.LockedUntil(5, last).Sub(last); got != long.MaxLock {
+		t.Errorf("LockDuration > MaxLock: +%s, quer +%s", got, long.MaxLock)
+	}
+
+	// Com um MaxLock enorme as dobras param antes de estourar o int64.
+	huge := DefaultLockoutPolicy()
+	huge.MaxLock = math.MaxInt64
+	got := huge.LockedUntil(math.MaxInt, last).Sub(last)
+	if got <= 0 || got < huge.MaxLock/2 {
+		t.Errorf("MaxLock enorme: +%s, quer um bloqueio positivo perto do teto", got)
+	}
+	if got := huge.LockedUntil(6, last).Sub(last); got != 30*time.Minute {
+		t.Errorf("MaxLock enorme, 6 falhas: +%s, quer +30m", got)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := DefaultLockoutPolicy()
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{-1, 0},
+		{0, 0},
+		{1, 0},
+		{2, 500 * time.Millisecond},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{7, 8 * time.Second},
+		{100, 8 * time.Second},
+		{math.MaxInt, 8 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %s, quer %s", tt.failures, got, tt.want)
+		}
+	}
+}
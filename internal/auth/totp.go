@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP segundo a RFC 6238 com os parâmetros que todo aplicativo
+// autenticador aceita: HMAC-SHA1, 6 dígitos, passos de 30s.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew aceita o passo anterior e o seguinte para tolerar relógios
+	// levemente dessincronizados.
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI monta o otpauth:// usado nos QR codes dos autenticadores.
+func TOTPURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// ValidateTOTP devolve o passo em que o código bate, para que quem chama
+// impeça a reutilização do mesmo código, ou -1 se não bater.
+func ValidateTOTP(secret, code string, now time.Time) int64 {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return -1
+	}
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return -1
+	}
+
+	step := now.Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step+int64(i))), []byte(code)) == 1 {
+			return step + int64(i)
+		}
+	}
+	return -1
+}
+
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// GenerateRecoveryCodes gera códigos no formato xxxxx-xxxxx.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	// 32 símbolos, sem l, o, 0 e 1, que se confundem.
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+	codes := make([]string, n)
+	buf := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		for j, c := range buf {
+			if j == 5 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(alphabet[c&31])
+		}
+		codes[i] = b.String()
+	}
+	return codes, nil
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// rfcSecret é a chave dos vetores de teste das RFCs 4226 e 6238
+// ("12345678901234567890") em base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 4226, apêndice D.
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	key := []byte("12345678901234567890")
+	for counter, code := range want {
+		if got := hotp(key, int64(counter)); got != code {
+			t.Errorf("hotp(%d) = %s, quer %s", counter, got, code)
+		}
+	}
+}
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// RFC 6238, apêndice B (SHA-1), nos 6 últimos dígitos.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if step := ValidateTOTP(rfcSecret, tt.code, time.Unix(tt.unix, 0)); step != tt.unix/totpPeriod {
+			t.Errorf("ValidateTOTP(%s, t=%d) = %d, quer passo %d", tt.code, tt.unix, step, tt.unix/totpPeriod)
+		}
+	}
+	// Segredo em minúsculas e código com espaços, como vêm do formulário.
+	if step := ValidateTOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", " 287082 ", time.Unix(59, 0)); step != 1 {
+		t.Errorf("entrada normalizada: passo = %d, quer 1", step)
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	key := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+	step := now.Unix() / totpPeriod
+
+	for _, delta := range []int64{-1, 0, 1} {
+		if got := ValidateTOTP(rfcSecret, hotp(key, step+delta), now); got != step+delta {
+			t.Errorf("passo %+d: ValidateTOTP = %d, quer %d", delta, got, step+delta)
+		}
+	}
+	for _, delta := range []int64{-2, 2} {
+		if got := ValidateTOTP(rfcSecret, hotp(key, step+delta), now); got != -1 {
+			t.Errorf("passo %+d fora da tolerância aceito: %d", delta, got)
+		}
+	}
+
+	// Na virada do passo: o código de t=59 (passo 1) ainda vale até o fim
+	// do passo 2 e deixa de valer no primeiro segundo do passo 3.
+	if got := ValidateTOTP(rfcSecret, "287082", time.Unix(89, 0)); got != 1 {
+		t.Errorf("t=89: passo = %d, quer 1", got)
+	}
+	if got := ValidateTOTP(rfcSecret, "287082", time.Unix(90, 0)); got != -1 {
+		t.Errorf("t=90: passo = %d, quer -1", got)
+	}
+}
+
+func TestValidateTOTPRejectsMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "abcdef"} {
+		if got := ValidateTOTP(rfcSecret, code, now); got != -1 {
+			t.Errorf("código %q aceito: passo %d", code, got)
+		}
+	}
+	if got := ValidateTOTP("não é base32!", "287082", now); got != -1 {
+		t.Errorf("segredo inválido aceito: passo %d", got)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("códigos = %d, quer 10", len(codes))
+	}
+
+	format := regexp.MustCompile(`^[a-km-np-z2-9]{5}-[a-km-np-z2-9]{5}$`)
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if !format.MatchString(c) {
+			t.Errorf("código %q fora do formato xxxxx-xxxxx", c)
+		}
+		if seen[c] {
+			t.Errorf("código repetido: %s", c)
+		}
+		seen[c] = true
+	}
+}
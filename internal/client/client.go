@@ -2,67 +2,150 @@ package client
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"log"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/lucaspanzera1/chat/internal/hub"
+	"github.com/lucaspanzera1/chat/internal/messaging"
 	"github.com/lucaspanzera1/chat/internal/models"
-	"github.com/lucaspanzera1/chat/internal/repository"
+	"github.com/lucaspanzera1/chat/internal/protocol"
 )
 
 const (
 	writeWait      = 10 * time.Second
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
+	maxMessageSize = 8192
+	// readLimit é o limite duro do gorilla: acima dele a conexão é derrubada.
+	// Entre maxMessageSize e readLimit o cliente recebe um erro too_large.
+	readLimit = 64 * 1024
 )
 
 type HubInterface interface {
-	BroadcastLeave(username string)
+	BroadcastLeave(client hub.ClientInterface)
 }
 
 type Client struct {
 	Hub       HubInterface
 	Conn      *websocket.Conn
-	Send      chan models.Message
+	Send      chan *hub.Frame
 	Username  string
 	UserID    string
 	RoomID    string
 	AvatarURL string
+	Codec     protocol.Codec
+	// Direct leva respostas apenas para esta conexão (ack, pong, error). Ao
+	// contrário de Send, nunca é fechado pelo hub.
+	Direct chan []byte
+	// ReadOnly vale para tokens de API sem messages:write: a conexão recebe
+	// eventos, mas não envia mensagens.
+	ReadOnly bool
+	IsBot    bool
 }
 
 func (c *Client) GetRoomID() string {
 	return c.RoomID
 }
 
-func (c *Client) GetSendChannel() chan models.Message {
+func (c *Client) GetSendChannel() chan *hub.Frame {
 	return c.Send
 }
 
-func NewClient(hub HubInterface, conn *websocket.Conn, username string) *Client {
+func (c *Client) GetUserID() string {
+	return c.UserID
+}
+
+func (c *Client) GetUsername() string {
+	return c.Username
+}
+
+func (c *Client) GetAvatarURL() string {
+	return c.AvatarURL
+}
+
+func NewClient(h HubInterface, conn *websocket.Conn, username string) *Client {
 	return &Client{
-		Hub:      hub,
+		Hub:      h,
 		Conn:     conn,
-		Send:     make(chan models.Message, 256),
+		Send:     make(chan *hub.Frame, 256),
 		Username: username,
+		Codec:    protocol.CodecFor(conn.Subprotocol()),
+		Direct:   make(chan []byte, 16),
 	}
 }
 
 type UnregisterFunc func(c *Client)
 
-func (c *Client) ReadPump(broadcast chan<- models.Message, unregister UnregisterFunc, messageRepo *repository.MessageRepository) {
+func (c *Client) Sender() messaging.Sender {
+	return messaging.Sender{
+		UserID:    c.UserID,
+		Username:  c.Username,
+		AvatarURL: c.AvatarURL,
+		IsBot:     c.IsBot,
+	}
+}
+
+func (c *Client) codec() protocol.Codec {
+	if c.Codec == nil {
+		return protocol.LegacyCodec{}
+	}
+	return c.Codec
+}
+
+// reply envia um envelope só para esta conexão. Se o buffer estiver cheio a
+// resposta é descartada: o cliente já está atrasado demais para ela importar.
+func (c *Client) reply(env protocol.Envelope) {
+	data, err := c.codec().EncodeEnvelope(env)
+	if err != nil {
+		log.Printf("Erro ao serializar resposta: %v", err)
+		return
+	}
+	if data == nil {
+		return
+	}
+
+	select {
+	case c.Direct <- data:
+	default:
+	}
+}
+
+// replyMessage entrega um evento só a esta conexão, no formato do codec.
+func (c *Client) replyMessage(msg models.Message) {
+	data, err := c.codec().EncodeMessage(msg)
+	if err != nil {
+		log.Printf("Erro ao serializar resposta: %v", err)
+		return
+	}
+
+	select {
+	case c.Direct <- data:
+	default:
+	}
+}
+
+func (c *Client) replyError(id string, err error) {
+	var perr *protocol.Error
+	if !errors.As(err, &perr) {
+		log.Printf("Erro ao processar frame: %v", err)
+		perr = protocol.NewError(protocol.CodeInternal, "Erro interno")
+	}
+	c.reply(protocol.ErrorEnvelope(id, perr))
+}
+
+func (c *Client) ReadPump(pipeline *messaging.Pipeline, unregister UnregisterFunc) {
 	defer func() {
 		if c.Hub != nil {
-			c.Hub.BroadcastLeave(c.Username)
+			c.Hub.BroadcastLeave(c)
 		}
 
 		unregister(c)
 		c.Conn.Close()
 	}()
 
-	c.Conn.SetReadLimit(maxMessageSize)
+	c.Conn.SetReadLimit(readLimit)
 	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
@@ -78,34 +161,95 @@ func (c *Client) ReadPump(broadcast chan<- models.Message, unregister Unregister
 			break
 		}
 
-		var incoming struct {
-			Content string `json:"content"`
+		if len(data) > maxMessageSize {
+			c.replyError("", protocol.NewError(protocol.CodeTooLarge, "Frame excede o tamanho máximo"))
+			continue
 		}
-		if err := json.Unmarshal(data, &incoming); err != nil {
-			log.Printf("error unmarshaling: %v", err)
+
+		req, err := c.codec().DecodeRequest(data)
+		if err != nil {
+			c.replyError("", protocol.NewError(protocol.CodeValidation, "Frame inválido"))
 			continue
 		}
 
-		msg := models.Message{
-			ID:        uuid.New().String(),
-			RoomID:    c.RoomID,
-			Username:  c.Username,
-			AvatarURL: c.AvatarURL,
-			Content:   incoming.Content,
-			Timestamp: time.Now(),
-			Type:      "message",
+		c.handleRequest(pipeline, req)
+	}
+}
+
+func (c *Client) handleRequest(pipeline *messaging.Pipeline, req protocol.Request) {
+	switch req.Type {
+	case protocol.TypeSend:
+		if c.ReadOnly {
+			c.replyError(req.ID, protocol.NewError(protocol.CodeForbidden, "Token sem o escopo messages:write"))
+			return
 		}
 
-		if err := messageRepo.Create(context.Background(), &msg, c.UserID); err != nil {
-			log.Printf("Erro ao salvar mensagem: %v", err)
+		var payload protocol.SendPayload
+		if err := req.DecodePayload(&payload); err != nil {
+			c.replyError(req.ID, protocol.NewError(protocol.CodeValidation, "Payload inválido"))
+			return
 		}
 
-		broadcast <- msg
+		var msg *models.Message
+		var err error
+		if payload.Encryption != "" {
+			msg, err = pipeline.SendEncrypted(context.Background(), c.Sender(), c.RoomID, payload.Content, payload.Encryption)
+		} else {
+			msg, err = pipeline.Send(context.Background(), c.Sender(), c.RoomID, payload.Content)
+		}
+		if err != nil {
+			c.replyError(req.ID, err)
+			return
+		}
+		if msg.Type == "ephemeral" {
+			c.replyMessage(*msg)
+		}
+		c.reply(protocol.Envelope{Type: protocol.TypeAck, ID: req.ID, Payload: protocol.AckPayload{MessageID: msg.ID}})
+
+	case protocol.TypeCommandRespond:
+		var payload protocol.CommandRespondPayload
+		if err := req.DecodePayload(&payload); err != nil {
+			c.replyError(req.ID, protocol.NewError(protocol.CodeValidation, "Payload inválido"))
+			return
+		}
+
+		reply := messaging.CommandReply{Text: payload.Text, Ephemeral: payload.Ephemeral}
+		if err := pipeline.RespondCommand(context.Background(), c.UserID, payload.InvocationID, reply); err != nil {
+			c.replyError(req.ID, err)
+			return
+		}
+		c.reply(protocol.Envelope{Type: protocol.TypeAck, ID: req.ID, Payload: protocol.AckPayload{MessageID: payload.InvocationID}})
+
+	case protocol.TypeEphemeralSend:
+		if c.ReadOnly {
+			c.replyError(req.ID, protocol.NewError(protocol.CodeForbidden, "Token sem o escopo messages:write"))
+			return
+		}
+
+		var payload protocol.EphemeralSendPayload
+		if err := req.DecodePayload(&payload); err != nil {
+			c.replyError(req.ID, protocol.NewError(protocol.CodeValidation, "Payload inválido"))
+			return
+		}
+
+		msg, _, err := pipeline.SendEphemeral(context.Background(), c.Sender(), c.RoomID, payload.UserIDs, payload.Content)
+		if err != nil {
+			c.replyError(req.ID, err)
+			return
+		}
+		c.reply(protocol.Envelope{Type: protocol.TypeAck, ID: req.ID, Payload: protocol.AckPayload{MessageID: msg.ID}})
+
+	case protocol.TypePing:
+		c.reply(protocol.Envelope{Type: protocol.TypePong, ID: req.ID})
+
+	default:
+		c.replyError(req.ID, protocol.NewError(protocol.CodeValidation, "Tipo de frame desconhecido: "+req.Type))
 	}
 }
 
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(pingPeriod)
+	restarting := false
 	defer func() {
 		ticker.Stop()
 		c.Conn.Close()
@@ -113,14 +257,34 @@ func (c *Client) WritePump() {
 
 	for {
 		select {
-		case message, ok := <-c.Send:
+		case frame, ok := <-c.Send:
 			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				closeMsg := []byte{}
+				if restarting {
+					closeMsg = websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server_restarting")
+				}
+				c.Conn.WriteMessage(websocket.CloseMessage, closeMsg)
 				return
 			}
 
-			if err := c.Conn.WriteJSON(message); err != nil {
+			if frame.Message.Type == "server_restarting" {
+				restarting = true
+			}
+
+			prepared, err := preparedFrame(frame, c.codec())
+			if err != nil {
+				log.Printf("Erro ao serializar mensagem: %v", err)
+				continue
+			}
+
+			if err := c.Conn.WritePreparedMessage(prepared); err != nil {
+				return
+			}
+
+		case data := <-c.Direct:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(c.codec().FrameType(), data); err != nil {
 				return
 			}
 
@@ -132,3 +296,21 @@ func (c *Client) WritePump() {
 		}
 	}
 }
+
+// preparedFrame guarda no Frame a mensagem WebSocket pronta, para que a
+// compressão (permessage-deflate) também aconteça uma vez por codec e não
+// por conexão.
+func preparedFrame(frame *hub.Frame, codec protocol.Codec) (*websocket.PreparedMessage, error) {
+	data, err := frame.Encode(codec)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := frame.Memo("ws:"+codec.Name(), func() (any, error) {
+		return websocket.NewPreparedMessage(codec.FrameType(), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*websocket.PreparedMessage), nil
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/hub"
+	"github.com/lucaspanzera1/chat/internal/messaging"
+)
+
+// StreamClient é a contraparte do Client para transportes HTTP (SSE e
+// long-polling). Para o hub ele é só mais um ClientInterface.
+type StreamClient struct {
+	Send      chan *hub.Frame
+	Username  string
+	UserID    string
+	RoomID    string
+	AvatarURL string
+
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+func NewStreamClient(userID, username, avatarURL, roomID string) *StreamClient {
+	return &StreamClient{
+		Send:      make(chan *hub.Frame, 256),
+		Username:  username,
+		UserID:    userID,
+		RoomID:    roomID,
+		AvatarURL: avatarURL,
+		lastSeen:  time.Now(),
+	}
+}
+
+func (c *StreamClient) GetRoomID() string {
+	return c.RoomID
+}
+
+func (c *StreamClient) GetSendChannel() chan *hub.Frame {
+	return c.Send
+}
+
+func (c *StreamClient) GetUserID() string {
+	return c.UserID
+}
+
+func (c *StreamClient) GetUsername() string {
+	return c.Username
+}
+
+func (c *StreamClient) GetAvatarURL() string {
+	return c.AvatarURL
+}
+
+func (c *StreamClient) Sender() messaging.Sender {
+	return messaging.Sender{
+		UserID:    c.UserID,
+		Username:  c.Username,
+		AvatarURL: c.AvatarURL,
+	}
+}
+
+func (c *StreamClient) Touch() {
+	c.mu.Lock()
+	c.lastSeen = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *StreamClient) IdleSince(t time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastSeen.Before(t)
+}
@@ -43,11 +43,246 @@ func RunMigrations() error {
 		`ALTER TABLE users ADD COLUMN IF NOT EXISTS is_online BOOLEAN DEFAULT FALSE`,
 		`ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen TIMESTAMP DEFAULT NOW()`,
 		`CREATE INDEX IF NOT EXISTS idx_users_is_online ON users(is_online)`,
-		`ALTER TABLE users ADD COLUMN IF NOT EXISTS google_id VARCHAR(255) UNIQUE`,
 		`ALTER TABLE users ALTER COLUMN password_hash DROP NOT NULL`,
-		`CREATE INDEX IF NOT EXISTS idx_users_google_id ON users(google_id)`,
 		`ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url TEXT`,
 		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS avatar_url TEXT`,
+		`ALTER TABLE rooms ADD COLUMN IF NOT EXISTS presence_events BOOLEAN NOT NULL DEFAULT TRUE`,
+		`ALTER TABLE rooms ADD COLUMN IF NOT EXISTS persist_presence BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE rooms ADD COLUMN IF NOT EXISTS slow_mode_seconds INTEGER NOT NULL DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS login_attempts (
+			id BIGSERIAL PRIMARY KEY,
+			email VARCHAR(255) NOT NULL DEFAULT '',
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			outcome VARCHAR(20) NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts(email, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip, created_at)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE TABLE IF NOT EXISTS user_tokens (
+			token_hash VARCHAR(64) PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			purpose VARCHAR(30) NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			used_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_tokens_user ON user_tokens(user_id, purpose)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS recovery_codes (
+			id BIGSERIAL PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash VARCHAR(64) NOT NULL,
+			used_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id)`,
+		`CREATE TABLE IF NOT EXISTS user_identities (
+			id BIGSERIAL PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider VARCHAR(50) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			email VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (provider, subject),
+			UNIQUE (user_id, provider)
+		)`,
+		// Bancos antigos guardavam o login Google em users.google_id.
+		`DO $$
+		BEGIN
+			IF EXISTS (SELECT 1 FROM information_schema.columns
+					   WHERE table_name = 'users' AND column_name = 'google_id') THEN
+				INSERT INTO user_identities (user_id, provider, subject, email)
+				SELECT id, 'google', google_id, email FROM users WHERE google_id IS NOT NULL
+				ON CONFLICT DO NOTHING;
+				ALTER TABLE users DROP COLUMN google_id;
+			END IF;
+		END $$`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS bot_owner_id UUID REFERENCES users(id) ON DELETE CASCADE`,
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			prefix VARCHAR(16) NOT NULL,
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			room_ids UUID[] NOT NULL DEFAULT '{}',
+			expires_at TIMESTAMPTZ,
+			last_used_at TIMESTAMPTZ,
+			revoked_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_id)`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS attachments JSONB`,
+		`CREATE TABLE IF NOT EXISTS incoming_webhooks (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			username VARCHAR(50) NOT NULL,
+			avatar_url TEXT NOT NULL DEFAULT '',
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			last_used_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_incoming_webhooks_room ON incoming_webhooks(room_id)`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ`,
+		`CREATE TABLE IF NOT EXISTS outgoing_webhooks (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+			created_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT[] NOT NULL,
+			active BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id BIGSERIAL PRIMARY KEY,
+			webhook_id UUID NOT NULL REFERENCES outgoing_webhooks(id) ON DELETE CASCADE,
+			event_id UUID NOT NULL,
+			event_type VARCHAR(50) NOT NULL,
+			payload JSONB NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			last_error TEXT,
+			response_status INTEGER,
+			delivered_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'pending'`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id DESC)`,
+		`CREATE TABLE IF NOT EXISTS webhook_attempts (
+			id BIGSERIAL PRIMARY KEY,
+			delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+			status_code INTEGER,
+			error TEXT,
+			duration_ms INTEGER NOT NULL,
+			attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery ON webhook_attempts(delivery_id)`,
+		`ALTER TABLE rooms ADD COLUMN IF NOT EXISTS topic TEXT`,
+		`CREATE TABLE IF NOT EXISTS room_mutes (
+			room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			muted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			muted_until TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (room_id, user_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS bot_commands (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			bot_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(32) NOT NULL UNIQUE,
+			description VARCHAR(200) NOT NULL DEFAULT '',
+			url TEXT,
+			secret TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS polls (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			message_id UUID NOT NULL UNIQUE REFERENCES messages(id) ON DELETE CASCADE,
+			room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			question TEXT NOT NULL,
+			multiple BOOLEAN NOT NULL DEFAULT FALSE,
+			anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+			closes_at TIMESTAMPTZ,
+			closed_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_polls_closing ON polls(closes_at) WHERE closed_at IS NULL AND closes_at IS NOT NULL`,
+		`CREATE TABLE IF NOT EXISTS poll_options (
+			id BIGSERIAL PRIMARY KEY,
+			poll_id UUID NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+			position SMALLINT NOT NULL,
+			text VARCHAR(200) NOT NULL,
+			UNIQUE (poll_id, position)
+		)`,
+		`CREATE TABLE IF NOT EXISTS poll_votes (
+			poll_id UUID NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+			option_id BIGINT NOT NULL REFERENCES poll_options(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			voted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (option_id, user_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_poll_votes_poll_user ON poll_votes(poll_id, user_id)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'America/Sao_Paulo'`,
+		`CREATE TABLE IF NOT EXISTS scheduled_messages (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			kind VARCHAR(10) NOT NULL DEFAULT 'message',
+			content TEXT NOT NULL DEFAULT '',
+			message_id UUID REFERENCES messages(id) ON DELETE CASCADE,
+			send_at TIMESTAMPTZ NOT NULL,
+			timezone VARCHAR(64) NOT NULL,
+			status VARCHAR(10) NOT NULL DEFAULT 'pending',
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			locked_until TIMESTAMPTZ,
+			sent_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_due ON scheduled_messages(send_at) WHERE status = 'pending'`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_user ON scheduled_messages(user_id, status, send_at)`,
+		`ALTER TABLE rooms ADD COLUMN IF NOT EXISTS retention_days INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE rooms ADD COLUMN IF NOT EXISTS disappearing_hours INTEGER NOT NULL DEFAULT 0`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_room_created ON messages(room_id, created_at)`,
+		`ALTER TABLE rooms ADD COLUMN IF NOT EXISTS encrypted BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS encryption VARCHAR(32)`,
+		`CREATE TABLE IF NOT EXISTS device_keys (
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			device_id VARCHAR(64) NOT NULL,
+			identity_key TEXT NOT NULL,
+			signed_prekey_id BIGINT NOT NULL,
+			signed_prekey TEXT NOT NULL,
+			signed_prekey_signature TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (user_id, device_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS one_time_prekeys (
+			user_id UUID NOT NULL,
+			device_id VARCHAR(64) NOT NULL,
+			key_id BIGINT NOT NULL,
+			public_key TEXT NOT NULL,
+			PRIMARY KEY (user_id, device_id, key_id),
+			FOREIGN KEY (user_id, device_id) REFERENCES device_keys(user_id, device_id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS exports (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+			format VARCHAR(8) NOT NULL,
+			timezone VARCHAR(64) NOT NULL,
+			status VARCHAR(10) NOT NULL DEFAULT 'pending',
+			locked_until TIMESTAMPTZ,
+			file VARCHAR(128),
+			size_bytes BIGINT NOT NULL DEFAULT 0,
+			message_count INTEGER NOT NULL DEFAULT 0,
+			error TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at TIMESTAMPTZ,
+			expires_at TIMESTAMPTZ
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_exports_user ON exports(user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_exports_queue ON exports(created_at) WHERE status IN ('pending', 'running')`,
+		`CREATE TABLE IF NOT EXISTS link_previews (
+			url TEXT PRIMARY KEY,
+			title TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			image_url TEXT NOT NULL DEFAULT '',
+			site_name TEXT NOT NULL DEFAULT '',
+			failed BOOLEAN NOT NULL DEFAULT FALSE,
+			fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
 	}
 
 	for _, query := range queries {
@@ -0,0 +1,350 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+)
+
+var (
+	ErrInvalidFormat   = protocol.NewError(protocol.CodeValidation, "Formato inválido (jsonl, html ou txt)")
+	ErrTooManyExports  = protocol.NewError(protocol.CodeRateLimited, "Aguarde as exportações em andamento terminarem")
+	ErrExportNotFound  = protocol.NewError(protocol.CodeForbidden, "Exportação não encontrada")
+	ErrExportNotReady  = protocol.NewError(protocol.CodeValidation, "A exportação ainda não terminou")
+	errInvalidTimezone = protocol.NewError(protocol.CodeValidation, "Fuso horário inválido")
+)
+
+// Store guarda os pedidos (exports no Postgres) e lê o histórico. Claim
+// precisa ser seguro com várias réplicas: cada pedido reservado fica
+// invisível às outras até o lease expirar. Messages pagina por
+// (created_at, id), depois de afterTime/afterID; afterID vazio começa do
+// início.
+type Store interface {
+	Create(ctx context.Context, e *models.Export) error
+	CountActive(ctx context.Context, userID string) (int, error)
+	Get(ctx context.Context, id, userID string) (*models.Export, error)
+	List(ctx context.Context, userID string) ([]models.Export, error)
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]models.Export, error)
+	Extend(ctx context.Context, id string, lease time.Duration) error
+	Complete(ctx context.Context, id, file string, size int64, messages int, expiresAt time.Time) error
+	Fail(ctx context.Context, id, reason string, expiresAt time.Time) error
+	PurgeExpired(ctx context.Context) ([]string, error)
+	UserTimezone(ctx context.Context, userID string) (string, error)
+	Room(ctx context.Context, roomID string) (*models.ExportRoom, error)
+	Messages(ctx context.Context, roomID string, afterTime time.Time, afterID string, limit int) ([]models.Message, error)
+}
+
+// Config: Dir é onde os arquivos ficam (com várias réplicas, um volume
+// compartilhado); TTL é por quanto tempo ficam disponíveis; MaxActive
+// limita os pedidos em andamento por usuário.
+type Config struct {
+	Dir          string
+	TTL          time.Duration
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxActive    int
+	BatchSize    int
+}
+
+func (c *Config) defaults() {
+	if c.Dir == "" {
+		c.Dir = "exports"
+	}
+	if c.TTL <= 0 {
+		c.TTL = 24 * time.Hour
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = 5 * time.Second
+	}
+	if c.Lease <= 0 {
+		c.Lease = 5 * time.Minute
+	}
+	if c.MaxActive <= 0 {
+		c.MaxActive = 3
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 1000
+	}
+}
+
+// Exporter recebe os pedidos (Request) e, em Run, gera os arquivos em
+// segundo plano, avisa quem pediu e apaga os vencidos.
+type Exporter struct {
+	store  Store
+	cfg    Config
+	notify func(roomID string, userIDs []string, text string) int
+	now    func() time.Time
+}
+
+func NewExporter(store Store, cfg Config) *Exporter {
+	cfg.defaults()
+	return &Exporter{store: store, cfg: cfg, now: time.Now}
+}
+
+// SetNotify liga o aviso de exportação pronta (Pipeline.Notify).
+func (e *Exporter) SetNotify(notify func(roomID string, userIDs []string, text string) int) {
+	e.notify = notify
+}
+
+// DownloadPath é o caminho de download de uma exportação pronta.
+func DownloadPath(id string) string {
+	return "/api/exports/" + id + "/download"
+}
+
+func withURL(ex *models.Export) *models.Export {
+	if ex.Status == models.ExportDone {
+		ex.DownloadURL = DownloadPath(ex.ID)
+	}
+	return ex
+}
+
+// Request enfileira a exportação da sala. Quem chama já conferiu que o
+// usuário participa dela. timezone vazio usa o fuso do usuário.
+func (e *Exporter) Request(ctx context.Context, userID, roomID, format, timezone string) (*models.Export, error) {
+	if format == "" {
+		format = models.ExportJSONL
+	}
+	if !models.ValidExportFormat(format) {
+		return nil, ErrInvalidFormat
+	}
+	if timezone == "" {
+		tz, err := e.store.UserTimezone(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		timezone = tz
+	}
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "" || timezone == "Local" {
+		return nil, errInvalidTimezone
+	}
+
+	active, err := e.store.CountActive(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if active >= e.cfg.MaxActive {
+		return nil, ErrTooManyExports
+	}
+
+	ex := &models.Export{UserID: userID, RoomID: roomID, Format: format, Timezone: timezone}
+	if err := e.store.Create(ctx, ex); err != nil {
+		return nil, err
+	}
+	return ex, nil
+}
+
+func (e *Exporter) Get(ctx context.Context, userID, id string) (*models.Export, error) {
+	ex, err := e.store.Get(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if ex == nil {
+		return nil, ErrExportNotFound
+	}
+	return withURL(ex), nil
+}
+
+func (e *Exporter) List(ctx context.Context, userID string) ([]models.Export, error) {
+	items, err := e.store.List(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		withURL(&items[i])
+	}
+	return items, nil
+}
+
+// Open abre o arquivo de uma exportação pronta do usuário. Quem chama
+// fecha o arquivo.
+func (e *Exporter) Open(ctx context.Context, userID, id string) (*models.Export, *os.File, error) {
+	ex, err := e.Get(ctx, userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ex.Status != models.ExportDone || ex.File == "" {
+		return nil, nil, ErrExportNotReady
+	}
+	f, err := os.Open(filepath.Join(e.cfg.Dir, ex.File))
+	if errors.Is(err, os.ErrNotExist) {
+		// Já foi apagado, por vencimento ou em outra réplica sem o volume.
+		return nil, nil, ErrExportNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return ex, f, nil
+}
+
+// FileName é o nome sugerido no download.
+func FileName(ex *models.Export) string {
+	return fmt.Sprintf("chat-%s-%s.%s", ex.RoomID, ex.CreatedAt.Format("20060102-150405"), Extension(ex.Format))
+}
+
+// Run processa a fila até o contexto ser cancelado.
+func (e *Exporter) Run(ctx context.Context) {
+	if err := os.MkdirAll(e.cfg.Dir, 0o750); err != nil {
+		log.Printf("Erro ao criar diretório de exportações: %v", err)
+		return
+	}
+
+	ticker := time.NewTicker(e.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := e.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Erro ao processar exportações: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce apaga as exportações vencidas, gera as pendentes e devolve
+// quantas foram processadas.
+func (e *Exporter) RunOnce(ctx context.Context) (int, error) {
+	files, err := e.store.PurgeExpired(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range files {
+		if err := os.Remove(filepath.Join(e.cfg.Dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Erro ao apagar exportação %s: %v", name, err)
+		}
+	}
+
+	// Uma por vez: salas grandes levam tempo e não há pressa.
+	items, err := e.store.Claim(ctx, 1, e.cfg.Lease)
+	if err != nil {
+		return 0, err
+	}
+	for _, ex := range items {
+		e.process(ctx, ex)
+	}
+	return len(items), nil
+}
+
+func (e *Exporter) process(ctx context.Context, ex models.Export) {
+	file, size, count, err := e.write(ctx, ex)
+	if ctx.Err() != nil {
+		// Desligando: o lease expira e outra passada recomeça do zero.
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao exportar sala %s (%s): %v", ex.RoomID, ex.ID, err)
+		reason := "Erro interno"
+		var perr *protocol.Error
+		if errors.As(err, &perr) {
+			reason = perr.Message
+		}
+		if err := e.store.Fail(ctx, ex.ID, reason, e.now().Add(e.cfg.TTL)); err != nil {
+			log.Printf("Erro ao registrar falha da exportação %s: %v", ex.ID, err)
+		}
+		return
+	}
+
+	expiresAt := e.now().Add(e.cfg.TTL)
+	if err := e.store.Complete(ctx, ex.ID, file, size, count, expiresAt); err != nil {
+		log.Printf("Erro ao concluir exportação %s: %v", ex.ID, err)
+		return
+	}
+	if e.notify != nil {
+		e.notify(ex.RoomID, []string{ex.UserID}, fmt.Sprintf("Exportação pronta (%d mensagens): %s — disponível até %s",
+			count, DownloadPath(ex.ID), expiresAt.Format("02/01 15:04")))
+	}
+}
+
+// write grava o histórico num arquivo temporário e só o renomeia no fim,
+// para que um download nunca veja um arquivo pela metade.
+func (e *Exporter) write(ctx context.Context, ex models.Export) (string, int64, int, error) {
+	loc, err := time.LoadLocation(ex.Timezone)
+	if err != nil {
+		return "", 0, 0, errInvalidTimezone
+	}
+	room, err := e.store.Room(ctx, ex.RoomID)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if room == nil {
+		return "", 0, 0, protocol.NewError(protocol.CodeValidation, "A sala não existe mais")
+	}
+
+	name := ex.ID + "." + Extension(ex.Format)
+	tmp, err := os.CreateTemp(e.cfg.Dir, ex.ID+"-*.tmp")
+	if err != nil {
+		return "", 0, 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	buf := bufio.NewWriterSize(tmp, 64<<10)
+	count, err := e.stream(ctx, ex, room, loc, newWriter(ex.Format, buf))
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if err := buf.Flush(); err != nil {
+		return "", 0, 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, 0, err
+	}
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(e.cfg.Dir, name)); err != nil {
+		return "", 0, 0, err
+	}
+	return name, info.Size(), count, nil
+}
+
+// stream percorre o histórico em lotes, do mais antigo ao mais novo, com os
+// horários no fuso do pedido. O lease é renovado a cada lote.
+func (e *Exporter) stream(ctx context.Context, ex models.Export, room *models.ExportRoom, loc *time.Location, w writer) (int, error) {
+	err := w.header(Header{Room: *room, Timezone: ex.Timezone, ExportedAt: e.now().In(loc)})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var afterTime time.Time
+	afterID := ""
+	for {
+		batch, err := e.store.Messages(ctx, ex.RoomID, afterTime, afterID, e.cfg.BatchSize)
+		if err != nil {
+			return 0, err
+		}
+		for _, msg := range batch {
+			msg.Timestamp = msg.Timestamp.In(loc)
+			if msg.EditedAt != nil {
+				edited := msg.EditedAt.In(loc)
+				msg.EditedAt = &edited
+			}
+			if err := w.message(msg); err != nil {
+				return 0, err
+			}
+		}
+		count += len(batch)
+		if len(batch) < e.cfg.BatchSize {
+			break
+		}
+
+		last := batch[len(batch)-1]
+		afterTime, afterID = last.Timestamp, last.ID
+		if err := e.store.Extend(ctx, ex.ID, e.cfg.Lease); err != nil {
+			return 0, err
+		}
+	}
+	return count, w.footer(count)
+}
@@ -0,0 +1,306 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// memStore guarda os pedidos e o histórico de uma única sala em memória.
+type memStore struct {
+	mu       sync.Mutex
+	exports  []*models.Export
+	room     *models.ExportRoom
+	messages []models.Message
+	extended int
+	purged   []string
+}
+
+func (s *memStore) Create(ctx context.Context, e *models.Export) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = "e" + string(rune('0'+len(s.exports)))
+	e.Status = models.ExportPending
+	e.CreatedAt = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	cp := *e
+	s.exports = append(s.exports, &cp)
+	return nil
+}
+
+func (s *memStore) CountActive(ctx context.Context, userID string) (int, error) {
+	n := 0
+	for _, e := range s.exports {
+		if e.UserID == userID && (e.Status == models.ExportPending || e.Status == models.ExportRunning) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memStore) Get(ctx context.Context, id, userID string) (*models.Export, error) {
+	for _, e := range s.exports {
+		if e.ID == id && e.UserID == userID {
+			cp := *e
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memStore) List(ctx context.Context, userID string) ([]models.Export, error) {
+	var items []models.Export
+	for _, e := range s.exports {
+		if e.UserID == userID {
+			items = append(items, *e)
+		}
+	}
+	return items, nil
+}
+
+func (s *memStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]models.Export, error) {
+	var items []models.Export
+	for _, e := range s.exports {
+		if len(items) < limit && e.Status == models.ExportPending {
+			e.Status = models.ExportRunning
+			items = append(items, *e)
+		}
+	}
+	return items, nil
+}
+
+func (s *memStore) Extend(ctx context.Context, id string, lease time.Duration) error {
+	s.extended++
+	return nil
+}
+
+func (s *memStore) Complete(ctx context.Context, id, file string, size int64, messages int, expiresAt time.Time) error {
+	for _, e := range s.exports {
+		if e.ID == id {
+			e.Status, e.File, e.Size, e.Messages, e.ExpiresAt = models.ExportDone, file, size, messages, &expiresAt
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Fail(ctx context.Context, id, reason string, expiresAt time.Time) error {
+	for _, e := range s.exports {
+		if e.ID == id {
+			e.Status, e.Error, e.ExpiresAt = models.ExportFailed, reason, &expiresAt
+		}
+	}
+	return nil
+}
+
+func (s *memStore) PurgeExpired(ctx context.Context) ([]string, error) {
+	files := s.purged
+	s.purged = nil
+	return files, nil
+}
+
+func (s *memStore) UserTimezone(ctx context.Context, userID string) (string, error) {
+	return "America/Sao_Paulo", nil
+}
+
+func (s *memStore) Room(ctx context.Context, roomID string) (*models.ExportRoom, error) {
+	if s.room == nil || s.room.ID != roomID {
+		return nil, nil
+	}
+	return s.room, nil
+}
+
+func (s *memStore) Messages(ctx context.Context, roomID string, afterTime time.Time, afterID string, limit int) ([]models.Message, error) {
+	var page []models.Message
+	for _, m := range s.messages {
+		after := m.Timestamp.After(afterTime) || (m.Timestamp.Equal(afterTime) && m.ID > afterID)
+		if afterID != "" && !after {
+			continue
+		}
+		if len(page) == limit {
+			break
+		}
+		page = append(page, m)
+	}
+	return page, nil
+}
+
+func newTestExporter(t *testing.T) (*Exporter, *memStore) {
+	t.Helper()
+	base := time.Date(2026, 10, 19, 15, 0, 0, 0, time.UTC)
+	edited := base.Add(90 * time.Minute)
+	store := &memStore{
+		room: &models.ExportRoom{ID: "r1", Name: "Time <A>", Type: "group", Members: []string{"ana", "bia"}},
+		messages: []models.Message{
+			{ID: "m1", RoomID: "r1", Username: "ana", Type: "message", Content: "oi <b>todos</b>", Timestamp: base},
+			{ID: "m2", RoomID: "r1", Username: "bia", Type: "message", Content: "relatório", Timestamp: base.Add(time.Minute),
+				EditedAt: &edited, Attachments: []models.Attachment{{Title: "Q3", URL: "https://example.com/q3.pdf"}}},
+			{ID: "m3", RoomID: "r1", Username: "ana", Type: "message", Content: "AAEC", Encryption: models.EncryptionSignalV1,
+				Timestamp: base.Add(2 * time.Minute)},
+			{ID: "m4", RoomID: "r1", Username: "bia", Type: "action", Content: "saiu para o almoço", Timestamp: base.Add(3 * time.Minute)},
+			{ID: "m5", RoomID: "r1", Username: "sistema", Type: "system", Content: "carla entrou", Timestamp: base.Add(4 * time.Minute)},
+		},
+	}
+	e := NewExporter(store, Config{Dir: t.TempDir(), BatchSize: 2})
+	return e, store
+}
+
+// runExport pede, gera e devolve o conteúdo do arquivo pronto.
+func runExport(t *testing.T, e *Exporter, format string) (*models.Export, string) {
+	t.Helper()
+	ctx := context.Background()
+	ex, err := e.Request(ctx, "u1", "r1", format, "")
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if n, err := e.RunOnce(ctx); err != nil || n != 1 {
+		t.Fatalf("RunOnce = %d, %v", n, err)
+	}
+
+	done, f, err := e.Open(ctx, "u1", ex.ID)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return done, string(data)
+}
+
+func TestExportJSONL(t *testing.T) {
+	e, store := newTestExporter(t)
+	var notified []string
+	e.SetNotify(func(roomID string, userIDs []string, text string) int {
+		notified = append(notified, roomID+" "+strings.Join(userIDs, ",")+" "+text)
+		return 1
+	})
+
+	ex, data := runExport(t, e, models.ExportJSONL)
+	if ex.Messages != 5 || ex.Size != int64(len(data)) || ex.DownloadURL != "/api/exports/"+ex.ID+"/download" {
+		t.Fatalf("export = %+v", ex)
+	}
+	// Cinco mensagens em lotes de dois: o lease é renovado entre os lotes.
+	if store.extended != 2 {
+		t.Fatalf("extended = %d, want 2", store.extended)
+	}
+	if len(notified) != 1 || !strings.HasPrefix(notified[0], "r1 u1 Exportação pronta (5 mensagens): /api/exports/") {
+		t.Fatalf("notified = %q", notified)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	var lines []map[string]any
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("linha inválida %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 6 || lines[0]["type"] != "export" || lines[0]["timezone"] != "America/Sao_Paulo" {
+		t.Fatalf("lines = %v", lines)
+	}
+	// 15:00 UTC é 12:00 em São Paulo; a ordem é a do histórico.
+	if lines[1]["id"] != "m1" || lines[1]["timestamp"] != "2026-10-19T12:00:00-03:00" || lines[5]["id"] != "m5" {
+		t.Fatalf("primeira/última mensagem = %v / %v", lines[1], lines[5])
+	}
+	if lines[2]["editedAt"] != "2026-10-19T13:30:00-03:00" || lines[3]["encryption"] != models.EncryptionSignalV1 {
+		t.Fatalf("edição/criptografia = %v / %v", lines[2], lines[3])
+	}
+}
+
+func TestExportText(t *testing.T) {
+	e, _ := newTestExporter(t)
+	_, data := runExport(t, e, models.ExportText)
+
+	for _, want := range []string{
+		"Sala: Time <A> (group)\nMembros: ana, bia\n",
+		"[19/10/2026 12:00:00] ana: oi <b>todos</b>\n",
+		"[19/10/2026 12:01:00] bia: relatório\n    (editada em 19/10/2026 13:30:00)\n    anexo: Q3 <https://example.com/q3.pdf>\n",
+		"[19/10/2026 12:02:00] ana: [mensagem criptografada]\n",
+		"[19/10/2026 12:03:00] * bia saiu para o almoço\n",
+		"[19/10/2026 12:04:00] -- carla entrou\n",
+		"\n5 mensagens\n",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("falta %q em:\n%s", want, data)
+		}
+	}
+	if strings.Contains(data, "AAEC") {
+		t.Error("o texto cifrado não deveria aparecer")
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	e, _ := newTestExporter(t)
+	_, data := runExport(t, e, models.ExportHTML)
+
+	for _, want := range []string{
+		"<title>Time &lt;A&gt;</title>",
+		"oi &lt;b&gt;todos&lt;/b&gt;",
+		`<a href="https://example.com/q3.pdf">Q3</a>`,
+		"editada em 19/10/2026 13:30:00",
+		"[mensagem criptografada]",
+		"<p>5 mensagens</p>",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("falta %q", want)
+		}
+	}
+	if strings.Contains(data, "<b>todos</b>") || strings.Contains(data, "AAEC") {
+		t.Error("conteúdo sem escape ou texto cifrado no HTML")
+	}
+}
+
+func TestExportRequestLimits(t *testing.T) {
+	e, _ := newTestExporter(t)
+	ctx := context.Background()
+
+	if _, err := e.Request(ctx, "u1", "r1", "pdf", ""); !errors.Is(err, ErrInvalidFormat) {
+		t.Fatalf("formato: err = %v", err)
+	}
+	if _, err := e.Request(ctx, "u1", "r1", "", "Marte/Olimpo"); err == nil {
+		t.Fatal("fuso inválido aceito")
+	}
+	for i := 0; i < e.cfg.MaxActive; i++ {
+		if _, err := e.Request(ctx, "u1", "r1", "", ""); err != nil {
+			t.Fatalf("pedido %d: %v", i, err)
+		}
+	}
+	if _, err := e.Request(ctx, "u1", "r1", "", ""); !errors.Is(err, ErrTooManyExports) {
+		t.Fatalf("limite: err = %v", err)
+	}
+
+	items, _ := e.List(ctx, "u1")
+	if _, _, err := e.Open(ctx, "u1", items[0].ID); !errors.Is(err, ErrExportNotReady) {
+		t.Fatalf("Open antes de terminar: err = %v", err)
+	}
+	if _, _, err := e.Open(ctx, "u2", items[0].ID); !errors.Is(err, ErrExportNotFound) {
+		t.Fatalf("Open de outro usuário: err = %v", err)
+	}
+}
+
+func TestExportRoomGone(t *testing.T) {
+	e, store := newTestExporter(t)
+	ctx := context.Background()
+	ex, err := e.Request(ctx, "u1", "r1", models.ExportText, "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.room = nil
+
+	if _, err := e.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := e.Get(ctx, "u1", ex.ID)
+	if got.Status != models.ExportFailed || got.Error != "A sala não existe mais" || got.DownloadURL != "" {
+		t.Fatalf("export = %+v", got)
+	}
+}
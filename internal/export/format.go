@@ -0,0 +1,214 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+const (
+	textTimeLayout = "02/01/2006 15:04:05"
+	encryptedLabel = "[mensagem criptografada]"
+)
+
+// Header abre o arquivo: a sala, quem exportou e em que fuso estão os
+// horários.
+type Header struct {
+	Room       models.ExportRoom `json:"room"`
+	Timezone   string            `json:"timezone"`
+	ExportedAt time.Time         `json:"exportedAt"`
+}
+
+// writer grava um formato de forma incremental: o histórico inteiro nunca
+// fica em memória.
+type writer interface {
+	header(h Header) error
+	message(msg models.Message) error
+	footer(count int) error
+}
+
+func newWriter(format string, w io.Writer) writer {
+	switch format {
+	case models.ExportHTML:
+		return &htmlWriter{w: w}
+	case models.ExportText:
+		return &textWriter{w: w}
+	default:
+		return &jsonlWriter{enc: json.NewEncoder(w)}
+	}
+}
+
+// Extension é a extensão do arquivo de cada formato.
+func Extension(format string) string {
+	if format == models.ExportText {
+		return "txt"
+	}
+	return format
+}
+
+// ContentType é o tipo do arquivo de cada formato no download.
+func ContentType(format string) string {
+	switch format {
+	case models.ExportHTML:
+		return "text/html; charset=utf-8"
+	case models.ExportText:
+		return "text/plain; charset=utf-8"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+// jsonlWriter: uma linha por registro, todas com "type". A primeira é o
+// cabeçalho (type "export"); as demais são models.Message.
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) header(h Header) error {
+	return j.enc.Encode(struct {
+		Type string `json:"type"`
+		Header
+	}{"export", h})
+}
+
+func (j *jsonlWriter) message(msg models.Message) error {
+	return j.enc.Encode(msg)
+}
+
+func (j *jsonlWriter) footer(count int) error {
+	return nil
+}
+
+type textWriter struct {
+	w io.Writer
+}
+
+func (t *textWriter) header(h Header) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Sala: %s (%s)\n", h.Room.Name, h.Room.Type)
+	if len(h.Room.Members) > 0 {
+		fmt.Fprintf(&b, "Membros: %s\n", strings.Join(h.Room.Members, ", "))
+	}
+	fmt.Fprintf(&b, "Exportado em %s (%s)\n\n", h.ExportedAt.Format(textTimeLayout), h.Timezone)
+	_, err := io.WriteString(t.w, b.String())
+	return err
+}
+
+func (t *textWriter) message(msg models.Message) error {
+	var b strings.Builder
+	content := msg.Content
+	if msg.Encrypted() {
+		content = encryptedLabel
+	}
+
+	stamp := "[" + msg.Timestamp.Format(textTimeLayout) + "] "
+	switch msg.Type {
+	case "action":
+		b.WriteString(stamp + "* " + msg.Username + " " + content)
+	case "message", "poll":
+		author := msg.Username
+		if msg.Bot {
+			author += " (bot)"
+		}
+		b.WriteString(stamp + author + ": " + content)
+	default:
+		b.WriteString(stamp + "-- " + content)
+	}
+	b.WriteByte('\n')
+
+	if msg.EditedAt != nil {
+		b.WriteString("    (editada em " + msg.EditedAt.Format(textTimeLayout) + ")\n")
+	}
+	for _, a := range msg.Attachments {
+		b.WriteString("    anexo: " + attachmentLabel(a))
+		if a.URL != "" {
+			b.WriteString(" <" + a.URL + ">")
+		}
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(t.w, b.String())
+	return err
+}
+
+func (t *textWriter) footer(count int) error {
+	_, err := fmt.Fprintf(t.w, "\n%d mensagens\n", count)
+	return err
+}
+
+func attachmentLabel(a models.Attachment) string {
+	switch {
+	case a.Title != "":
+		return a.Title
+	case a.Text != "":
+		return a.Text
+	case a.URL != "":
+		return a.URL
+	default:
+		return a.ImageURL
+	}
+}
+
+// htmlWriter gera uma página única, com o estilo embutido, que abre em
+// qualquer navegador sem depender do servidor.
+type htmlWriter struct {
+	w io.Writer
+}
+
+var htmlTemplates = template.Must(template.New("export").Funcs(template.FuncMap{
+	"when":  func(t time.Time) string { return t.Format(textTimeLayout) },
+	"label": attachmentLabel,
+}).Parse(`{{define "header"}}<!DOCTYPE html>
+<html lang="pt-BR">
+<head>
+<meta charset="utf-8">
+<title>{{.Room.Name}}</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:860px;margin:2rem auto;padding:0 1rem;color:#1a1a1a}
+header{border-bottom:1px solid #ddd;margin-bottom:1rem}
+.msg{padding:.4rem 0;border-bottom:1px solid #f0f0f0}
+.meta{font-size:.8rem;color:#666}
+.author{font-weight:600;color:#1a1a1a}
+.content{white-space:pre-wrap;word-break:break-word;margin:.2rem 0}
+.system,.encrypted{color:#666;font-style:italic}
+.att{font-size:.85rem;margin-left:1rem}
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Room.Name}}</h1>
+{{if .Room.Members}}<p>Membros: {{range $i, $m := .Room.Members}}{{if $i}}, {{end}}{{$m}}{{end}}</p>{{end}}
+<p class="meta">Exportado em {{when .ExportedAt}} ({{.Timezone}})</p>
+</header>
+<main>
+{{end}}
+{{define "message"}}<div class="msg" id="m-{{.ID}}">
+<div class="meta"><span class="author">{{.Username}}</span>{{if .Bot}} · bot{{end}} · {{when .Timestamp}}{{if .EditedAt}} · editada em {{when .EditedAt}}{{end}}</div>
+{{if .Encrypted}}<p class="content encrypted">` + encryptedLabel + `</p>
+{{else if eq .Type "action"}}<p class="content system">* {{.Username}} {{.Content}}</p>
+{{else if or (eq .Type "message") (eq .Type "poll")}}<p class="content">{{.Content}}</p>
+{{else}}<p class="content system">{{.Content}}</p>
+{{end}}{{range .Attachments}}<div class="att">anexo: {{if .URL}}<a href="{{.URL}}">{{label .}}</a>{{else}}{{label .}}{{end}}</div>
+{{end}}</div>
+{{end}}
+{{define "footer"}}</main>
+<footer class="meta"><p>{{.}} mensagens</p></footer>
+</body>
+</html>
+{{end}}`))
+
+func (h *htmlWriter) header(hd Header) error {
+	return htmlTemplates.ExecuteTemplate(h.w, "header", hd)
+}
+
+func (h *htmlWriter) message(msg models.Message) error {
+	return htmlTemplates.ExecuteTemplate(h.w, "message", &msg)
+}
+
+func (h *htmlWriter) footer(count int) error {
+	return htmlTemplates.ExecuteTemplate(h.w, "footer", count)
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/mail"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	resetTokenTTL  = time.Hour
+	verifyTokenTTL = 48 * time.Hour
+	mailWorkers    = 2
+	mailQueueSize  = 100
+)
+
+// AccountUserStore é o que os fluxos por email usam das contas.
+type AccountUserStore interface {
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	GetByID(ctx context.Context, id string) (*models.User, error)
+	UpdatePassword(ctx context.Context, userID, hashedPassword string) error
+	SetEmailVerified(ctx context.Context, userID string) error
+}
+
+// AccountTokenStore emite e consome os tokens de uso único dos links. Create
+// invalida os pendentes do usuário com a mesma finalidade; Consume devolve
+// repository.ErrInvalidToken para tokens usados, expirados ou de outra
+// finalidade.
+type AccountTokenStore interface {
+	Create(ctx context.Context, userID, purpose string, ttl time.Duration) (string, error)
+	Consume(ctx context.Context, token, purpose string) (string, error)
+}
+
+// LoginUnlocker libera o bloqueio de login de um email.
+type LoginUnlocker interface {
+	Unlock(ctx context.Context, email, ip string) error
+}
+
+// AccountHandler cuida dos fluxos por email: redefinição de senha e
+// confirmação do endereço. Os emails saem por uma fila com poucos workers:
+// pedidos em excesso são descartados em vez de abrir uma goroutine cada.
+type AccountHandler struct {
+	userRepo AccountUserStore
+	tokens   AccountTokenStore
+	attempts LoginUnlocker
+	mailer   mail.Mailer
+	appURL   string
+	mail     chan func()
+}
+
+func NewAccountHandler(userRepo AccountUserStore, tokens AccountTokenStore, attempts LoginUnlocker, mailer mail.Mailer, appURL string) *AccountHandler {
+	h := &AccountHandler{
+		userRepo: userRepo,
+		tokens:   tokens,
+		attempts: attempts,
+		mailer:   mailer,
+		appURL:   strings.TrimRight(appURL, "/"),
+		mail:     make(chan func(), mailQueueSize),
+	}
+	for range mailWorkers {
+		go h.mailWorker()
+	}
+	return h
+}
+
+func (h *AccountHandler) mailWorker() {
+	for send := range h.mail {
+		send()
+	}
+}
+
+// queueMail enfileira o envio sem bloquear; com a fila cheia ele é
+// descartado.
+func (h *AccountHandler) queueMail(kind string, send func()) {
+	select {
+	case h.mail <- send:
+	default:
+		log.Printf("Fila de emails cheia, %s descartado", kind)
+	}
+}
+
+// ForgotPassword sempre responde da mesma forma e envia o email em segundo
+// plano, para não revelar quais emails estão cadastrados.
+func (h *AccountHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	h.queueMail("email de redefinição", func() { h.sendReset(email) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Se o email estiver cadastrado, enviaremos as instruções"})
+}
+
+func (h *AccountHandler) sendReset(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user, err := h.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		log.Printf("Erro ao buscar usuário para redefinição: %v", err)
+		return
+	}
+	if user == nil {
+		return
+	}
+
+	token, err := h.tokens.Create(ctx, user.ID, repository.TokenPasswordReset, resetTokenTTL)
+	if err != nil {
+		log.Printf("Erro ao gerar token de redefinição: %v", err)
+		return
+	}
+
+	err = h.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Redefinição de senha",
+		Text: fmt.Sprintf("Olá %s,\n\nPara criar uma nova senha acesse o link abaixo. Ele vale por 1 hora e só pode ser usado uma vez.\n\n%s/reset.html?token=%s\n\nSe você não pediu a redefinição, ignore este email.\n",
+			user.Username, h.appURL, token),
+	})
+	if err != nil {
+		log.Printf("Erro ao enviar email de redefinição: %v", err)
+	}
+}
+
+func (h *AccountHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Password) < 6 {
+		http.Error(w, "Senha deve ter no mínimo 6 caracteres", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.tokens.Consume(r.Context(), req.Token, repository.TokenPasswordReset)
+	if errors.Is(err, repository.ErrInvalidToken) {
+		http.Error(w, "Link inválido ou expirado", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao validar token de redefinição: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Erro ao processar senha", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.userRepo.UpdatePassword(r.Context(), userID, string(hashed)); err != nil {
+		http.Error(w, "Erro ao atualizar senha", http.StatusInternalServerError)
+		return
+	}
+
+	// Quem recebeu o link controla o email: confirma o endereço e libera um
+	// eventual bloqueio de login.
+	if err := h.userRepo.SetEmailVerified(r.Context(), userID); err != nil {
+		log.Printf("Erro ao confirmar email: %v", err)
+	}
+	if user, err := h.userRepo.GetByID(r.Context(), userID); err == nil && user != nil {
+		if err := h.attempts.Unlock(r.Context(), strings.ToLower(user.Email), ""); err != nil {
+			log.Printf("Erro ao desbloquear login: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Senha redefinida com sucesso"})
+}
+
+// VerifyEmail é aberto pelo link do email e redireciona para a tela de login.
+func (h *AccountHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Redirect(w, r, "/auth.html?verified=0", http.StatusSeeOther)
+		return
+	}
+
+	userID, err := h.tokens.Consume(r.Context(), token, repository.TokenEmailVerify)
+	if err != nil {
+		if !errors.Is(err, repository.ErrInvalidToken) {
+			log.Printf("Erro ao validar token de confirmação: %v", err)
+		}
+		http.Redirect(w, r, "/auth.html?verified=0", http.StatusSeeOther)
+		return
+	}
+
+	if err := h.userRepo.SetEmailVerified(r.Context(), userID); err != nil {
+		log.Printf("Erro ao confirmar email: %v", err)
+		http.Redirect(w, r, "/auth.html?verified=0", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/auth.html?verified=1", http.StatusSeeOther)
+}
+
+func (h *AccountHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	user, err := h.userRepo.GetByID(r.Context(), claims.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+
+	if user.EmailVerified {
+		http.Error(w, "Email já confirmado", http.StatusBadRequest)
+		return
+	}
+
+	h.SendVerification(user)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email de confirmação enviado"})
+}
+
+// SendVerification enfileira o envio do link de confirmação. Chamado no
+// cadastro e no reenvio; erros só são registrados no log.
+func (h *AccountHandler) SendVerification(user *models.User) {
+	h.queueMail("email de confirmação", func() { h.sendVerification(user) })
+}
+
+func (h *AccountHandler) sendVerification(user *models.User) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	token, err := h.tokens.Create(ctx, user.ID, repository.TokenEmailVerify, verifyTokenTTL)
+	if err != nil {
+		log.Printf("Erro ao gerar token de confirmação: %v", err)
+		return
+	}
+
+	err = h.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Confirme seu email",
+		Text: fmt.Sprintf("Olá %s,\n\nConfirme seu email acessando o link abaixo (válido por 48 horas):\n\n%s/api/auth/verify?token=%s\n",
+			user.Username, h.appURL, token),
+	})
+	if err != nil {
+		log.Printf("Erro ao enviar email de confirmação: %v", err)
+	}
+}
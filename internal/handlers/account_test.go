@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/mail"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeAccountUsers struct {
+	mu        sync.Mutex
+	users     map[string]*models.User
+	passwords map[string]string
+}
+
+func (f *fakeAccountUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if strings.EqualFold(u.Email, email) {
+			copy := *u
+			return &copy, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeAccountUsers) GetByID(ctx context.Context, id string) (*models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if u, ok := f.users[id]; ok {
+		copy := *u
+		return &copy, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeAccountUsers) UpdatePassword(ctx context.Context, userID, hashedPassword string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.passwords[userID] = hashedPassword
+	return nil
+}
+
+func (f *fakeAccountUsers) SetEmailVerified(ctx context.Context, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[userID].EmailVerified = true
+	return nil
+}
+
+// fakeTokens segue o contrato de repository.TokenRepository, com um relógio
+// que o teste adianta.
+type fakeTokens struct {
+	mu     sync.Mutex
+	now    time.Time
+	next   int
+	tokens map[string]*fakeToken
+}
+
+type fakeToken struct {
+	userID, purpose string
+	expires         time.Time
+	used            bool
+}
+
+func (f *fakeTokens) Create(ctx context.Context, userID, purpose string, ttl time.Duration) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.tokens {
+		if t.userID == userID && t.purpose == purpose {
+			t.used = true
+		}
+	}
+	f.next++
+	token := fmt.Sprintf("tok-%d", f.next)
+	f.tokens[token] = &fakeToken{userID: userID, purpose: purpose, expires: f.now.Add(ttl)}
+	return token, nil
+}
+
+func (f *fakeTokens) Consume(ctx context.Context, token, purpose string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.tokens[token]
+	if !ok || t.used || t.purpose != purpose || !f.now.Before(t.expires) {
+		return "", repository.ErrInvalidToken
+	}
+	t.used = true
+	return t.userID, nil
+}
+
+func (f *fakeTokens) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+type fakeUnlocker struct {
+	mu       sync.Mutex
+	unlocked []string
+}
+
+func (f *fakeUnlocker) Unlock(ctx context.Context, email, ip string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unlocked = append(f.unlocked, email)
+	return nil
+}
+
+type accountFixture struct {
+	*AccountHandler
+	users    *fakeAccountUsers
+	tokens   *fakeTokens
+	unlocker *fakeUnlocker
+	mailDir  string
+}
+
+// newAccountFixture usa o LogMailer com um diretório: cada email vira um
+// .eml que o teste lê.
+func newAccountFixture(t *testing.T) *accountFixture {
+	t.Helper()
+	f := &accountFixture{
+		users: &fakeAccountUsers{
+			users:     map[string]*models.User{"alice": {ID: "alice", Username: "alice", Email: "Alice@example.com"}},
+			passwords: make(map[string]string),
+		},
+		tokens:   &fakeTokens{now: time.Now(), tokens: make(map[string]*fakeToken)},
+		unlocker: &fakeUnlocker{},
+		mailDir:  t.TempDir(),
+	}
+	f.AccountHandler = NewAccountHandler(f.users, f.tokens, f.unlocker, &mail.LogMailer{Dir: f.mailDir, From: "chat@test"}, "https://chat.example.com/")
+	return f
+}
+
+var linkToken = regexp.MustCompile(`token=([\w-]+)`)
+
+// waitMail espera o n-ésimo email e devolve o token do link dele.
+func (f *accountFixture) waitMail(t *testing.T, n int) string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		files, _ := filepath.Glob(filepath.Join(f.mailDir, "*.eml"))
+		if len(files) >= n {
+			slices.Sort(files)
+			data, err := os.ReadFile(files[n-1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := linkToken.FindSubmatch(data)
+			if m == nil {
+				t.Fatalf("email sem link: %s", data)
+			}
+			return string(m[1])
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("email %d não chegou (%d enviados)", n, len(files))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (f *accountFixture) forgot(t *testing.T, email string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	f.ForgotPassword(rec, httptest.NewRequest(http.MethodPost, "/api/auth/forgot", strings.NewReader(`{"email": "`+email+`"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("forgot = %d", rec.Code)
+	}
+}
+
+func (f *accountFixture) reset(token, password string) int {
+	rec := httptest.NewRecorder()
+	body := fmt.Sprintf(`{"token": %q, "password": %q}`, token, password)
+	f.ResetPassword(rec, httptest.NewRequest(http.MethodPost, "/api/auth/reset", strings.NewReader(body)))
+	return rec.Code
+}
+
+func (f *accountFixture) verify(token string) string {
+	rec := httptest.NewRecorder()
+	f.VerifyEmail(rec, httptest.NewRequest(http.MethodGet, "/api/auth/verify?token="+token, nil))
+	return rec.Header().Get("Location")
+}
+
+func TestResetPasswordSingleUse(t *testing.T) {
+	f := newAccountFixture(t)
+
+	f.forgot(t, " alice@example.com ")
+	token := f.waitMail(t, 1)
+
+	if code := f.reset(token, "curta"); code != http.StatusBadRequest {
+		t.Errorf("senha curta = %d", code)
+	}
+	if code := f.reset(token, "nova-senha"); code != http.StatusOK {
+		t.Fatalf("reset = %d", code)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(f.users.passwords["alice"]), []byte("nova-senha")); err != nil {
+		t.Errorf("senha não trocada: %v", err)
+	}
+	if !f.users.users["alice"].EmailVerified {
+		t.Error("reset não confirmou o email")
+	}
+	if !slices.Equal(f.unlocker.unlocked, []string{"alice@example.com"}) {
+		t.Errorf("desbloqueios = %v", f.unlocker.unlocked)
+	}
+
+	if code := f.reset(token, "outra-senha"); code != http.StatusBadRequest {
+		t.Errorf("link reutilizado = %d, quer 400", code)
+	}
+}
+
+func TestResetPasswordExpiry(t *testing.T) {
+	f := newAccountFixture(t)
+
+	f.forgot(t, "alice@example.com")
+	token := f.waitMail(t, 1)
+	f.tokens.advance(resetTokenTTL)
+
+	if code := f.reset(token, "nova-senha"); code != http.StatusBadRequest {
+		t.Errorf("link vencido = %d, quer 400", code)
+	}
+	if _, ok := f.users.passwords["alice"]; ok {
+		t.Error("senha trocada com link vencido")
+	}
+}
+
+func TestNewResetLinkInvalidatesEarlier(t *testing.T) {
+	f := newAccountFixture(t)
+
+	f.forgot(t, "alice@example.com")
+	first := f.waitMail(t, 1)
+	f.forgot(t, "alice@example.com")
+	second := f.waitMail(t, 2)
+
+	if code := f.reset(first, "nova-senha"); code != http.StatusBadRequest {
+		t.Errorf("link anterior = %d, quer 400", code)
+	}
+	if code := f.reset(second, "nova-senha"); code != http.StatusOK {
+		t.Errorf("link novo = %d", code)
+	}
+}
+
+func TestAccountTokensWrongPurpose(t *testing.T) {
+	f := newAccountFixture(t)
+
+	f.SendVerification(f.users.users["alice"])
+	verifyToken := f.waitMail(t, 1)
+	f.forgot(t, "alice@example.com")
+	resetToken := f.waitMail(t, 2)
+
+	if code := f.reset(verifyToken, "nova-senha"); code != http.StatusBadRequest {
+		t.Errorf("token de confirmação na redefinição = %d, quer 400", code)
+	}
+	if loc := f.verify(resetToken); loc != "/auth.html?verified=0" {
+		t.Errorf("token de redefinição na confirmação = %s", loc)
+	}
+	if f.users.users["alice"].EmailVerified {
+		t.Fatal("email confirmado com o token errado")
+	}
+
+	// Cada um continua valendo para a própria finalidade, uma vez.
+	if loc := f.verify(verifyToken); loc != "/auth.html?verified=1" || !f.users.users["alice"].EmailVerified {
+		t.Errorf("confirmação = %s", loc)
+	}
+	if loc := f.verify(verifyToken); loc != "/auth.html?verified=0" {
+		t.Errorf("confirmação repetida = %s", loc)
+	}
+	if code := f.reset(resetToken, "nova-senha"); code != http.StatusOK {
+		t.Errorf("redefinição = %d", code)
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	f := newAccountFixture(t)
+
+	rec := httptest.NewRecorder()
+	f.ForgotPassword(rec, httptest.NewRequest(http.MethodPost, "/api/auth/forgot", strings.NewReader(`{"email": "ninguem@example.com"}`)))
+	known := httptest.NewRecorder()
+	f.ForgotPassword(known, httptest.NewRequest(http.MethodPost, "/api/auth/forgot", strings.NewReader(`{"email": "alice@example.com"}`)))
+	if rec.Code != known.Code || rec.Body.String() != known.Body.String() {
+		t.Errorf("respostas diferentes: %d %q e %d %q", rec.Code, rec.Body, known.Code, known.Body)
+	}
+
+	f.waitMail(t, 1)
+	files, _ := filepath.Glob(filepath.Join(f.mailDir, "*.eml"))
+	if len(files) != 1 || !strings.HasSuffix(files[0], "-Alice@example.com.eml") {
+		t.Errorf("emails = %v", files)
+	}
+}
+
+func TestForgotPasswordQueueIsBounded(t *testing.T) {
+	// Sem workers, a fila só enche: os pedidos além dela são descartados
+	// sem bloquear a resposta.
+	f := newAccountFixture(t)
+	h := &AccountHandler{userRepo: f.users, tokens: f.tokens, attempts: f.unlocker, mailer: &mail.LogMailer{}, mail: make(chan func(), 2)}
+
+	for range 10 {
+		rec := httptest.NewRecorder()
+		h.ForgotPassword(rec, httptest.NewRequest(http.MethodPost, "/api/auth/forgot", strings.NewReader(`{"email": "alice@example.com"}`)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("forgot = %d", rec.Code)
+		}
+	}
+	if len(h.mail) != 2 {
+		t.Errorf("fila = %d, quer 2", len(h.mail))
+	}
+}
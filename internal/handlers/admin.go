@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/importer"
+	"github.com/lucaspanzera1/chat/internal/repository"
+)
+
+type AdminHandler struct {
+	userRepo *repository.UserRepository
+	attempts *repository.LoginAttemptRepository
+
+	importer       *importer.Importer
+	importMaxBytes int64
+}
+
+func NewAdminHandler(userRepo *repository.UserRepository, attempts *repository.LoginAttemptRepository) *AdminHandler {
+	return &AdminHandler{
+		userRepo: userRepo,
+		attempts: attempts,
+	}
+}
+
+// requireAdmin confere users.is_admin para o usuário autenticado. Em caso
+// de erro já responde e retorna false.
+func (h *AdminHandler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	claims := auth.ClaimsFrom(r.Context())
+
+	isAdmin, err := h.userRepo.IsAdmin(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Erro ao verificar administrador: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return false
+	}
+	if !isAdmin {
+		http.Error(w, "Acesso negado", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// UnlockLogin zera as falhas de login de um email e/ou IP.
+func (h *AdminHandler) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	ip := strings.TrimSpace(req.IP)
+	if email == "" && ip == "" {
+		http.Error(w, "Informe email ou ip", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.attempts.Unlock(r.Context(), email, ip); err != nil {
+		log.Printf("Erro ao desbloquear login: %v", err)
+		http.Error(w, "Erro ao desbloquear", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Login desbloqueado"})
+}
@@ -2,19 +2,35 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/lucaspanzera1/chat/internal/auth"
 	"github.com/lucaspanzera1/chat/internal/models"
 	"github.com/lucaspanzera1/chat/internal/repository"
 )
 
+const tooManyAttempts = "Muitas tentativas, tente novamente mais tarde"
+
 type AuthHandler struct {
 	userRepo *repository.UserRepository
+	attempts *repository.LoginAttemptRepository
+	mfa      *repository.MFARepository
+	lockout  auth.LockoutPolicy
+	accounts *AccountHandler
 }
 
-func NewAuthHandler(userRepo *repository.UserRepository) *AuthHandler {
-	return &AuthHandler{userRepo: userRepo}
+func NewAuthHandler(userRepo *repository.UserRepository, attempts *repository.LoginAttemptRepository, mfa *repository.MFARepository, lockout auth.LockoutPolicy, accounts *AccountHandler) *AuthHandler {
+	return &AuthHandler{
+		userRepo: userRepo,
+		attempts: attempts,
+		mfa:      mfa,
+		lockout:  lockout,
+		accounts: accounts,
+	}
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -35,22 +51,19 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user, err := h.userRepo.Register(r.Context(), req)
-	if err != nil {
-		http.Error(w, "Erro ao registrar usuário: "+err.Error(), http.StatusInternalServerError)
+	if errors.Is(err, repository.ErrUserExists) {
+		http.Error(w, "Usuário ou email já cadastrado", http.StatusConflict)
 		return
 	}
-
-	token, err := auth.GenerateToken(user)
 	if err != nil {
-		http.Error(w, "Erro ao gerar token", http.StatusInternalServerError)
+		log.Printf("Erro ao registrar usuário: %v", err)
+		http.Error(w, "Erro ao registrar usuário", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.AuthResponse{
-		Token: token,
-		User:  *user,
-	})
+	h.accounts.SendVerification(user)
+
+	h.respondToken(w, user)
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -60,12 +73,135 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	ip := clientIP(r)
+
+	failures, ok := h.checkLockout(w, r, email, ip)
+	if !ok {
+		return
+	}
+
 	user, err := h.userRepo.Login(r.Context(), req.Email, req.Password)
+	if errors.Is(err, repository.ErrInvalidCredentials) {
+		h.record(r, email, ip, repository.LoginFailure)
+		h.wait(r, h.lockout.Delay(failures+1))
+		http.Error(w, "Credenciais inválidas", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao autenticar: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	// Com 2FA a senha certa só rende um token mfa_pending; o sucesso é
+	// registrado no segundo passo, senão acertar a senha zeraria as falhas
+	// de código.
+	if user.TOTPEnabled {
+		mfaToken, err := auth.GenerateMFAToken(user)
+		if err != nil {
+			http.Error(w, "Erro ao gerar token", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.MFAChallenge{MFARequired: true, MFAToken: mfaToken})
+		return
+	}
+
+	h.record(r, email, ip, repository.LoginSuccess)
+	h.respondToken(w, user)
+}
+
+// LoginMFA conclui o login de contas com 2FA trocando o token mfa_pending e
+// um código TOTP (ou de recuperação) pelo token de sessão.
+func (h *AuthHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req models.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := auth.ValidateMFAToken(req.MFAToken)
+	if err != nil {
+		http.Error(w, "Sessão de login expirada, entre novamente", http.StatusUnauthorized)
+		return
+	}
+
+	email := strings.ToLower(claims.Email)
+	ip := clientIP(r)
+
+	failures, ok := h.checkLockout(w, r, email, ip)
+	if !ok {
+		return
+	}
+
+	valid, err := verifySecondFactor(r.Context(), h.mfa, claims.UserID, req.Code, time.Now())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		log.Printf("Erro ao verificar código 2FA: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
 		return
 	}
+	if !valid {
+		h.record(r, email, ip, repository.LoginFailure)
+		h.wait(r, h.lockout.Delay(failures+1))
+		http.Error(w, "Código inválido", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.userRepo.GetByID(r.Context(), claims.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Usuário não encontrado", http.StatusUnauthorized)
+		return
+	}
+
+	h.record(r, email, ip, repository.LoginSuccess)
+	h.respondToken(w, user)
+}
+
+// checkLockout aplica os bloqueios por IP e por email. As mesmas respostas
+// valem para emails que existem ou não: o bloqueio é calculado a partir das
+// tentativas, não da conta. Devolve o maior número de falhas recentes, usado
+// no atraso progressivo.
+func (h *AuthHandler) checkLockout(w http.ResponseWriter, r *http.Request, email, ip string) (int, bool) {
+	now := time.Now()
+
+	ipFailures, err := h.attempts.IPFailures(r.Context(), ip, now.Add(-h.lockout.IPWindow))
+	if err != nil {
+		log.Printf("Erro ao consultar tentativas de login: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return 0, false
+	}
+	if h.lockout.IPThreshold > 0 && ipFailures >= h.lockout.IPThreshold {
+		h.record(r, email, ip, repository.LoginBlocked)
+		setRetryAfter(w, h.lockout.IPWindow)
+		http.Error(w, tooManyAttempts, http.StatusTooManyRequests)
+		return 0, false
+	}
 
+	failures, last, err := h.attempts.AccountFailures(r.Context(), email, now.Add(-h.lockout.AccountWindow))
+	if err != nil {
+		log.Printf("Erro ao consultar tentativas de login: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return 0, false
+	}
+	if until := h.lockout.LockedUntil(failures, last); now.Before(until) {
+		h.record(r, email, ip, repository.LoginBlocked)
+		setRetryAfter(w, until.Sub(now))
+		http.Error(w, tooManyAttempts, http.StatusTooManyRequests)
+		return 0, false
+	}
+
+	return max(failures, ipFailures), true
+}
+
+// JWKS publica as chaves públicas que validam os tokens emitidos aqui.
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(auth.PublicKeys())
+}
+
+func (h *AuthHandler) respondToken(w http.ResponseWriter, user *models.User) {
 	token, err := auth.GenerateToken(user)
 	if err != nil {
 		http.Error(w, "Erro ao gerar token", http.StatusInternalServerError)
@@ -78,3 +214,23 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		User:  *user,
 	})
 }
+
+func (h *AuthHandler) record(r *http.Request, email, ip, outcome string) {
+	if err := h.attempts.Record(r.Context(), email, ip, outcome); err != nil {
+		log.Printf("Erro ao registrar tentativa de login: %v", err)
+	}
+}
+
+// wait atrasa a resposta de uma falha, sem segurar a goroutine se o cliente
+// desistir.
+func (h *AuthHandler) wait(r *http.Request, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/export"
+	"github.com/lucaspanzera1/chat/internal/repository"
+)
+
+// ExportHandler expõe as exportações do histórico. A geração acontece em
+// segundo plano; o pedido responde 202 e o arquivo é baixado depois.
+type ExportHandler struct {
+	exporter *export.Exporter
+	roomRepo *repository.RoomRepository
+}
+
+func NewExportHandler(exporter *export.Exporter, roomRepo *repository.RoomRepository) *ExportHandler {
+	return &ExportHandler{exporter: exporter, roomRepo: roomRepo}
+}
+
+// readable confere se o usuário ainda pode ler a sala: a geral é de todos,
+// as demais só dos membros. Em caso de erro já responde.
+func (h *ExportHandler) readable(w http.ResponseWriter, r *http.Request, roomID, userID string) bool {
+	room, err := h.roomRepo.GetByID(r.Context(), roomID)
+	if err != nil || room == nil {
+		http.Error(w, "Sala não encontrada", http.StatusNotFound)
+		return false
+	}
+	if !tokenAllowsRoom(w, APITokenFrom(r.Context()), roomID) {
+		return false
+	}
+	if room.Type != "general" {
+		isMember, err := h.roomRepo.IsMember(r.Context(), roomID, userID)
+		if err != nil || !isMember {
+			http.Error(w, "Acesso negado", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// Create atende POST /api/rooms/{id}/exports com {"format", "timezone"?};
+// sem timezone vale o fuso do usuário.
+func (h *ExportHandler) Create(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+	roomID := r.PathValue("id")
+	if !h.readable(w, r, roomID, claims.UserID) {
+		return
+	}
+
+	var req struct {
+		Format   string `json:"format"`
+		Timezone string `json:"timezone"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Dados inválidos", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ex, err := h.exporter.Request(r.Context(), claims.UserID, roomID, strings.ToLower(req.Format), req.Timezone)
+	if err != nil {
+		writePipelineError(w, err, "Erro ao pedir exportação")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/exports/"+ex.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(ex)
+}
+
+func (h *ExportHandler) List(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	items, err := h.exporter.List(r.Context(), claims.UserID)
+	if err != nil {
+		writePipelineError(w, err, "Erro ao listar exportações")
+		return
+	}
+	if apiToken := APITokenFrom(r.Context()); apiToken != nil {
+		visible := items[:0]
+		for _, item := range items {
+			if apiToken.AllowsRoom(item.RoomID) {
+				visible = append(visible, item)
+			}
+		}
+		items = visible
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func (h *ExportHandler) Get(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	ex, err := h.exporter.Get(r.Context(), claims.UserID, r.PathValue("id"))
+	if err != nil {
+		writePipelineError(w, err, "Erro ao buscar exportação")
+		return
+	}
+	if !tokenAllowsRoom(w, APITokenFrom(r.Context()), ex.RoomID) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ex)
+}
+
+// Download entrega o arquivo pronto. Quem saiu da sala depois do pedido
+// não baixa mais.
+func (h *ExportHandler) Download(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	ex, f, err := h.exporter.Open(r.Context(), claims.UserID, r.PathValue("id"))
+	if err != nil {
+		writePipelineError(w, err, "Erro ao abrir exportação")
+		return
+	}
+	defer f.Close()
+	if !h.readable(w, r, ex.RoomID, claims.UserID) {
+		return
+	}
+
+	name := export.FileName(ex)
+	w.Header().Set("Content-Type", export.ContentType(ex.Format))
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	modified := ex.CreatedAt
+	if ex.FinishedAt != nil {
+		modified = *ex.FinishedAt
+	}
+	http.ServeContent(w, r, name, modified, f)
+}
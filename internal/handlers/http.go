@@ -1,28 +1,57 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/messaging"
 	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/preview"
 	"github.com/lucaspanzera1/chat/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	maxSlowModeSeconds   = 3600
+	maxRetentionDays     = 3650
+	maxDisappearingHours = 720
+)
+
 type HTTPHandler struct {
 	messageRepo *repository.MessageRepository
 	roomRepo    *repository.RoomRepository
 	userRepo    *repository.UserRepository
+	mfaRepo     *repository.MFARepository
+	events      messaging.EventPublisher
+	polls       PollLoader
+	previews    PreviewLoader
+	// defaultRetentionDays é MESSAGE_RETENTION_DAYS: o padrão das salas e o
+	// teto da retenção que elas podem escolher.
+	defaultRetentionDays int
+}
+
+// PollLoader carrega as enquetes das mensagens "poll" do histórico.
+type PollLoader interface {
+	ForMessages(ctx context.Context, messageIDs []string, viewerID string) (map[string]*models.Poll, error)
 }
 
-func NewHTTPHandler(messageRepo *repository.MessageRepository, roomRepo *repository.RoomRepository, userRepo *repository.UserRepository) *HTTPHandler {
+// PreviewLoader consulta o cache das prévias de links.
+type PreviewLoader interface {
+	Lookup(ctx context.Context, urls []string) (map[string]preview.Entry, error)
+}
+
+func NewHTTPHandler(messageRepo *repository.MessageRepository, roomRepo *repository.RoomRepository, userRepo *repository.UserRepository, mfaRepo *repository.MFARepository) *HTTPHandler {
 	return &HTTPHandler{
 		messageRepo: messageRepo,
 		roomRepo:    roomRepo,
 		userRepo:    userRepo,
+		mfaRepo:     mfaRepo,
 	}
 }
 
@@ -44,6 +73,8 @@ func (h *HTTPHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Erro ao buscar mensagens", http.StatusInternalServerError)
 		return
 	}
+	h.attachPolls(r, messages)
+	h.attachPreviews(r, messages)
 
 	if messages == nil {
 		messages = []models.Message{}
@@ -53,12 +84,17 @@ func (h *HTTPHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HTTPHandler) GetRoomHistory(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	claims := auth.ClaimsFrom(r.Context())
 
-	roomID := r.URL.Query().Get("roomId")
+	roomID := roomIDParam(r)
 	if roomID == "" {
 		roomID = "00000000-0000-0000-0000-000000000001"
 	}
+	if h.roomForMember(w, r, roomID, claims.UserID) == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 
 	limitStr := r.URL.Query().Get("limit")
 	limit := 50
@@ -74,6 +110,8 @@ func (h *HTTPHandler) GetRoomHistory(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Erro ao buscar mensagens", http.StatusInternalServerError)
 		return
 	}
+	h.attachPolls(r, messages)
+	h.attachPreviews(r, messages)
 
 	if messages == nil {
 		messages = []models.Message{}
@@ -82,18 +120,85 @@ func (h *HTTPHandler) GetRoomHistory(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(messages)
 }
 
-func (h *HTTPHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Token não fornecido", http.StatusUnauthorized)
+// SetPolls faz o histórico trazer as enquetes com os votos de quem consulta.
+func (h *HTTPHandler) SetPolls(polls PollLoader) {
+	h.polls = polls
+}
+
+// SetPreviews faz o histórico trazer as prévias de links já em cache.
+func (h *HTTPHandler) SetPreviews(previews PreviewLoader) {
+	h.previews = previews
+}
+
+// SetDefaultRetention informa a retenção global, em dias (zero: sem limite).
+func (h *HTTPHandler) SetDefaultRetention(days int) {
+	h.defaultRetentionDays = days
+}
+
+func (h *HTTPHandler) attachPolls(r *http.Request, messages []models.Message) {
+	if h.polls == nil {
+		return
+	}
+
+	var ids []string
+	for _, msg := range messages {
+		if msg.Type == "poll" {
+			ids = append(ids, msg.ID)
+		}
+	}
+	if len(ids) == 0 {
 		return
 	}
 
-	claims, err := auth.ValidateToken(token)
+	polls, err := h.polls.ForMessages(r.Context(), ids, auth.ClaimsFrom(r.Context()).UserID)
 	if err != nil {
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
+		// O histórico segue sem os resultados; o cliente pode buscá-los depois.
+		log.Printf("Erro ao carregar enquetes: %v", err)
 		return
 	}
+	for i := range messages {
+		messages[i].Poll = polls[messages[i].ID]
+	}
+}
+
+// attachPreviews completa o histórico com as prévias do cache. Links ainda
+// sem prévia ficam de fora; nada é buscado aqui.
+func (h *HTTPHandler) attachPreviews(r *http.Request, messages []models.Message) {
+	if h.previews == nil {
+		return
+	}
+
+	links := make(map[int][]string)
+	var urls []string
+	for i, msg := range messages {
+		if msg.Encrypted() || msg.Type != "message" {
+			continue
+		}
+		if found := preview.URLs(msg.Content); len(found) > 0 {
+			links[i] = found
+			urls = append(urls, found...)
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+
+	entries, err := h.previews.Lookup(r.Context(), urls)
+	if err != nil {
+		log.Printf("Erro ao carregar prévias de links: %v", err)
+		return
+	}
+	for i, found := range links {
+		for _, u := range found {
+			if e, ok := entries[u]; ok && !e.Failed {
+				messages[i].Previews = append(messages[i].Previews, e.LinkPreview)
+			}
+		}
+	}
+}
+
+func (h *HTTPHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
 
 	users, err := h.userRepo.GetAllWithStatus(r.Context(), claims.UserID)
 	if err != nil {
@@ -106,19 +211,7 @@ func (h *HTTPHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HTTPHandler) CreatePrivateRoom(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		log.Println("CreatePrivateRoom: Token não fornecido")
-		http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-		return
-	}
-
-	claims, err := auth.ValidateToken(token)
-	if err != nil {
-		log.Printf("CreatePrivateRoom: Erro ao validar token: %v", err)
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
-		return
-	}
+	claims := auth.ClaimsFrom(r.Context())
 
 	var req struct {
 		OtherUserID string `json:"otherUserId"`
@@ -131,12 +224,15 @@ func (h *HTTPHandler) CreatePrivateRoom(w http.ResponseWriter, r *http.Request)
 
 	log.Printf("GetOrCreatePrivateRoom: Buscando/criando sala entre %s e %s", claims.UserID, req.OtherUserID)
 
-	room, err := h.roomRepo.GetOrCreatePrivateRoom(r.Context(), claims.UserID, req.OtherUserID)
+	room, created, err := h.roomRepo.GetOrCreatePrivateRoom(r.Context(), claims.UserID, req.OtherUserID)
 	if err != nil {
 		log.Printf("GetOrCreatePrivateRoom: Erro: %v", err)
 		http.Error(w, "Erro ao buscar/criar sala privada: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if created {
+		h.publish(r, models.EventRoomCreated, room.ID, room)
+	}
 
 	log.Printf("GetOrCreatePrivateRoom: Retornando sala: %s", room.ID)
 
@@ -145,17 +241,7 @@ func (h *HTTPHandler) CreatePrivateRoom(w http.ResponseWriter, r *http.Request)
 }
 
 func (h *HTTPHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-		return
-	}
-
-	claims, err := auth.ValidateToken(token)
-	if err != nil {
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
-		return
-	}
+	claims := auth.ClaimsFrom(r.Context())
 
 	var req models.CreateGroupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -179,23 +265,25 @@ func (h *HTTPHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Erro ao criar grupo: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.publish(r, models.EventRoomCreated, room.ID, room)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(room)
 }
 
-func (h *HTTPHandler) GetUserGroups(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-		return
-	}
+// SetEvents liga a publicação de eventos de salas e membros.
+func (h *HTTPHandler) SetEvents(e messaging.EventPublisher) {
+	h.events = e
+}
 
-	claims, err := auth.ValidateToken(token)
-	if err != nil {
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
-		return
+func (h *HTTPHandler) publish(r *http.Request, eventType, roomID string, data any) {
+	if h.events != nil {
+		h.events.Publish(r.Context(), models.Event{Type: eventType, RoomID: roomID, Data: data})
 	}
+}
+
+func (h *HTTPHandler) GetUserGroups(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
 
 	groups, err := h.roomRepo.GetUserGroups(r.Context(), claims.UserID)
 	if err != nil {
@@ -208,11 +296,16 @@ func (h *HTTPHandler) GetUserGroups(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HTTPHandler) GetGroupMembers(w http.ResponseWriter, r *http.Request) {
-	roomID := r.URL.Query().Get("roomId")
+	claims := auth.ClaimsFrom(r.Context())
+
+	roomID := roomIDParam(r)
 	if roomID == "" {
 		http.Error(w, "roomId é obrigatório", http.StatusBadRequest)
 		return
 	}
+	if h.roomForMember(w, r, roomID, claims.UserID) == nil {
+		return
+	}
 
 	members, err := h.roomRepo.GetGroupMembers(r.Context(), roomID)
 	if err != nil {
@@ -224,19 +317,97 @@ func (h *HTTPHandler) GetGroupMembers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(members)
 }
 
-func (h *HTTPHandler) SetUsername(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Token não fornecido", http.StatusUnauthorized)
+// AddMember coloca um usuário no grupo; só o criador decide quem entra.
+func (h *HTTPHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	var req struct {
+		UserID string `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.roomRepo.GetByID(r.Context(), r.PathValue("id"))
+	if err != nil || room == nil {
+		http.Error(w, "Sala não encontrada", http.StatusNotFound)
+		return
+	}
+	if room.Type != "group" || room.CreatedBy != claims.UserID {
+		http.Error(w, "Apenas o criador do grupo pode adicionar membros", http.StatusForbidden)
+		return
+	}
+
+	user, err := h.userRepo.GetByID(r.Context(), req.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+
+	isMember, err := h.roomRepo.IsMember(r.Context(), room.ID, user.ID)
+	if err != nil {
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		if err := h.roomRepo.AddUserToGroup(r.Context(), room.ID, user.ID); err != nil {
+			log.Printf("Erro ao adicionar membro: %v", err)
+			http.Error(w, "Erro ao adicionar membro", http.StatusInternalServerError)
+			return
+		}
+		h.publish(r, models.EventMemberJoined, room.ID, models.MemberEvent{
+			RoomID: room.ID, UserID: user.ID, Username: user.Username, ActorID: claims.UserID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Membro adicionado"})
+}
+
+// RemoveMember tira alguém do grupo: o criador remove qualquer membro e cada
+// um pode sair sozinho. O criador não sai do próprio grupo.
+func (h *HTTPHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+	userID := r.PathValue("userId")
+
+	room, err := h.roomRepo.GetByID(r.Context(), r.PathValue("id"))
+	if err != nil || room == nil || room.Type != "group" {
+		http.Error(w, "Sala não encontrada", http.StatusNotFound)
+		return
+	}
+	if userID != claims.UserID && room.CreatedBy != claims.UserID {
+		http.Error(w, "Apenas o criador do grupo pode remover membros", http.StatusForbidden)
+		return
+	}
+	if userID == room.CreatedBy {
+		http.Error(w, "O criador não pode sair do grupo", http.StatusBadRequest)
 		return
 	}
 
-	claims, err := auth.ValidateToken(token)
+	isMember, err := h.roomRepo.IsMember(r.Context(), room.ID, userID)
 	if err != nil {
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "Usuário não participa do grupo", http.StatusNotFound)
 		return
 	}
 
+	if err := h.roomRepo.RemoveUserFromGroup(r.Context(), room.ID, userID); err != nil {
+		log.Printf("Erro ao remover membro: %v", err)
+		http.Error(w, "Erro ao remover membro", http.StatusInternalServerError)
+		return
+	}
+	h.publish(r, models.EventMemberLeft, room.ID, models.MemberEvent{RoomID: room.ID, UserID: userID, ActorID: claims.UserID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTPHandler) SetUsername(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
 	var req struct {
 		Username string `json:"username"`
 	}
@@ -286,17 +457,7 @@ func (h *HTTPHandler) SetUsername(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HTTPHandler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-		return
-	}
-
-	claims, err := auth.ValidateToken(token)
-	if err != nil {
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
-		return
-	}
+	claims := auth.ClaimsFrom(r.Context())
 
 	user, err := h.userRepo.GetByID(r.Context(), claims.UserID)
 	if err != nil || user == nil {
@@ -309,17 +470,7 @@ func (h *HTTPHandler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HTTPHandler) GetUserProfile(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-		return
-	}
-
-	claims, err := auth.ValidateToken(token)
-	if err != nil {
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
-		return
-	}
+	claims := auth.ClaimsFrom(r.Context())
 
 	user, err := h.userRepo.GetByID(r.Context(), claims.UserID)
 	if err != nil || user == nil {
@@ -346,22 +497,45 @@ func (h *HTTPHandler) GetUserProfile(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(profile)
 }
 
-func (h *HTTPHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Token não fornecido", http.StatusUnauthorized)
+// SetTimezone guarda o fuso IANA (ex.: "America/Sao_Paulo") usado para ler
+// os horários de mensagens agendadas e lembretes.
+func (h *HTTPHandler) SetTimezone(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	var req struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.LoadLocation(req.Timezone); err != nil || req.Timezone == "" || req.Timezone == "Local" {
+		http.Error(w, "Fuso horário inválido", http.StatusBadRequest)
 		return
 	}
 
-	claims, err := auth.ValidateToken(token)
-	if err != nil {
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
+	if err := h.userRepo.SetTimezone(r.Context(), claims.UserID, req.Timezone); err != nil {
+		http.Error(w, "Erro ao salvar fuso horário", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.userRepo.GetByID(r.Context(), claims.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *HTTPHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
 	var req struct {
 		CurrentPassword string `json:"currentPassword"`
 		NewPassword     string `json:"newPassword"`
+		Code            string `json:"code"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -386,9 +560,10 @@ func (h *HTTPHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verificar se é conta Google (não tem senha)
-	if user.GoogleID != nil && *user.GoogleID != "" {
-		http.Error(w, "Contas Google não podem alterar senha aqui", http.StatusBadRequest)
+	// Contas criadas por login externo não têm senha: definem uma pelo
+	// "Esqueci minha senha".
+	if !user.HasPassword {
+		http.Error(w, "Conta sem senha: use \"Esqueci minha senha\" para criar uma", http.StatusBadRequest)
 		return
 	}
 
@@ -398,6 +573,19 @@ func (h *HTTPHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.TOTPEnabled {
+		valid, err := verifySecondFactor(r.Context(), h.mfaRepo, claims.UserID, req.Code, time.Now())
+		if err != nil {
+			log.Printf("Erro ao verificar código 2FA: %v", err)
+			http.Error(w, "Erro interno", http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			http.Error(w, "Código de verificação inválido", http.StatusUnauthorized)
+			return
+		}
+	}
+
 	// Hash da nova senha
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
@@ -414,3 +602,180 @@ func (h *HTTPHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Senha atualizada com sucesso"})
 }
+
+func (h *HTTPHandler) GetRoomSettings(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	roomID := roomIDParam(r)
+	if roomID == "" {
+		http.Error(w, "roomId é obrigatório", http.StatusBadRequest)
+		return
+	}
+
+	if h.roomForMember(w, r, roomID, claims.UserID) == nil {
+		return
+	}
+
+	settings, err := h.roomRepo.GetSettings(r.Context(), roomID)
+	if err != nil || settings == nil {
+		http.Error(w, "Erro ao buscar configurações", http.StatusInternalServerError)
+		return
+	}
+	settings.DefaultRetentionDays = h.defaultRetentionDays
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func (h *HTTPHandler) UpdateRoomSettings(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	// Campos omitidos mantêm o valor atual.
+	var req struct {
+		RoomID            string `json:"roomId"`
+		PresenceEvents    *bool  `json:"presenceEvents"`
+		PersistPresence   *bool  `json:"persistPresence"`
+		SlowModeSeconds   *int   `json:"slowModeSeconds"`
+		RetentionDays     *int   `json:"retentionDays"`
+		DisappearingHours *int   `json:"disappearingHours"`
+		Encrypted         *bool  `json:"encrypted"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+	if id := r.PathValue("id"); id != "" {
+		req.RoomID = id
+	}
+	if req.RoomID == "" {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.roomRepo.GetByID(r.Context(), req.RoomID)
+	if err != nil || room == nil {
+		http.Error(w, "Sala não encontrada", http.StatusNotFound)
+		return
+	}
+
+	allowed, err := h.canManageRoom(r, room, claims.UserID)
+	if err != nil {
+		http.Error(w, "Erro ao verificar permissões", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Apenas o criador do grupo pode alterar as configurações", http.StatusForbidden)
+		return
+	}
+
+	settings, err := h.roomRepo.GetSettings(r.Context(), room.ID)
+	if err != nil || settings == nil {
+		http.Error(w, "Erro ao buscar configurações", http.StatusInternalServerError)
+		return
+	}
+	if req.PresenceEvents != nil {
+		settings.PresenceEvents = *req.PresenceEvents
+	}
+	if req.PersistPresence != nil {
+		settings.PersistPresence = *req.PersistPresence
+	}
+	if req.SlowModeSeconds != nil {
+		settings.SlowModeSeconds = *req.SlowModeSeconds
+	}
+	if req.RetentionDays != nil {
+		settings.RetentionDays = *req.RetentionDays
+	}
+	if req.DisappearingHours != nil {
+		settings.DisappearingHours = *req.DisappearingHours
+	}
+	if req.Encrypted != nil {
+		if settings.Encrypted && !*req.Encrypted {
+			http.Error(w, "A criptografia de ponta a ponta não pode ser desativada", http.StatusBadRequest)
+			return
+		}
+		settings.Encrypted = *req.Encrypted
+	}
+
+	if settings.PersistPresence && room.Type != "group" {
+		http.Error(w, "Eventos só podem ser salvos no histórico de grupos", http.StatusBadRequest)
+		return
+	}
+	if settings.SlowModeSeconds < 0 || settings.SlowModeSeconds > maxSlowModeSeconds {
+		http.Error(w, "Modo lento deve ficar entre 0 e 3600 segundos", http.StatusBadRequest)
+		return
+	}
+	if settings.RetentionDays < 0 || settings.RetentionDays > maxRetentionDays {
+		http.Error(w, "Retenção deve ficar entre 0 e 3650 dias", http.StatusBadRequest)
+		return
+	}
+	if h.defaultRetentionDays > 0 && settings.RetentionDays > h.defaultRetentionDays {
+		http.Error(w, fmt.Sprintf("Retenção não pode passar do limite do servidor (%d dias)", h.defaultRetentionDays), http.StatusBadRequest)
+		return
+	}
+	if settings.DisappearingHours < 0 || settings.DisappearingHours > maxDisappearingHours {
+		http.Error(w, "Mensagens temporárias devem durar entre 1 e 720 horas", http.StatusBadRequest)
+		return
+	}
+	if settings.DisappearingHours > 0 && room.Type != "private" {
+		http.Error(w, "Mensagens temporárias só existem em conversas privadas", http.StatusBadRequest)
+		return
+	}
+	if settings.Encrypted && room.Type != "private" {
+		http.Error(w, "Criptografia de ponta a ponta só existe em conversas privadas", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.roomRepo.UpdateSettings(r.Context(), room.ID, *settings); err != nil {
+		http.Error(w, "Erro ao salvar configurações", http.StatusInternalServerError)
+		return
+	}
+	settings.DefaultRetentionDays = h.defaultRetentionDays
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// roomIDParam lê o id da sala do caminho (/api/rooms/{id}/...) ou, nas rotas
+// antigas, do parâmetro roomId.
+func roomIDParam(r *http.Request) string {
+	if id := r.PathValue("id"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("roomId")
+}
+
+// roomForMember busca a sala e confere se o usuário pode lê-la: a sala geral
+// é de todos, as demais só dos membros. Em caso de erro já responde e
+// retorna nil.
+func (h *HTTPHandler) roomForMember(w http.ResponseWriter, r *http.Request, roomID, userID string) *models.Room {
+	room, err := h.roomRepo.GetByID(r.Context(), roomID)
+	if err != nil || room == nil {
+		http.Error(w, "Sala não encontrada", http.StatusNotFound)
+		return nil
+	}
+	if !tokenAllowsRoom(w, APITokenFrom(r.Context()), roomID) {
+		return nil
+	}
+
+	if room.Type != "general" {
+		isMember, err := h.roomRepo.IsMember(r.Context(), roomID, userID)
+		if err != nil || !isMember {
+			http.Error(w, "Acesso negado", http.StatusForbidden)
+			return nil
+		}
+	}
+	return room
+}
+
+// canManageRoom: grupos só pelo criador, salas privadas por qualquer membro
+// e a sala geral por ninguém.
+func (h *HTTPHandler) canManageRoom(r *http.Request, room *models.Room, userID string) (bool, error) {
+	switch room.Type {
+	case "group":
+		return room.CreatedBy == userID, nil
+	case "private":
+		return h.roomRepo.IsMember(r.Context(), room.ID, userID)
+	default:
+		return false, nil
+	}
+}
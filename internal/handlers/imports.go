@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/importer"
+)
+
+// SetImporter habilita POST /api/admin/import/{source}; maxBytes limita o
+// arquivo enviado.
+func (h *AdminHandler) SetImporter(imp *importer.Importer, maxBytes int64) {
+	h.importer = imp
+	h.importMaxBytes = maxBytes
+}
+
+// Import atende POST /api/admin/import/{source} (slack ou whatsapp) com um
+// formulário multipart: file, e opcionalmente emails (JSON ou linhas
+// Nome=email), room (nome da conversa do WhatsApp) e timezone (fuso do
+// celular que exportou). A importação roda na requisição; repetir o mesmo
+// arquivo não duplica nada e retoma uma importação interrompida.
+func (h *AdminHandler) Import(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	if h.importer == nil {
+		http.Error(w, "Importação desabilitada", http.StatusNotFound)
+		return
+	}
+
+	source := r.PathValue("source")
+	if source != importer.SourceSlack && source != importer.SourceWhatsApp {
+		http.Error(w, "Origem inválida (use slack ou whatsapp)", http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.importMaxBytes)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Arquivo muito grande", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Formulário inválido", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Envie o arquivo no campo file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	emails, err := importer.ParseEmails(r.FormValue("emails"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wa := importer.WhatsAppOptions{Name: r.FormValue("room")}
+	if tz := r.FormValue("timezone"); tz != "" {
+		if wa.Location, err = time.LoadLocation(tz); err != nil {
+			http.Error(w, "Timezone inválido", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// O zip precisa de acesso aleatório, e o nome do arquivo vira o nome da
+	// conversa do WhatsApp: grava numa pasta temporária com o nome original.
+	dir, err := os.MkdirTemp("", "import-")
+	if err != nil {
+		log.Printf("Erro ao criar pasta temporária: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Base(header.Filename)
+	if name == "." || name == string(filepath.Separator) {
+		name = "upload"
+	}
+	path := filepath.Join(dir, name)
+	if err := saveUpload(path, file); err != nil {
+		log.Printf("Erro ao gravar arquivo de importação: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	archive, closer, err := importer.Open(source, path, wa)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer closer.Close()
+
+	claims := auth.ClaimsFrom(r.Context())
+	report, err := h.importer.Import(r.Context(), archive, importer.Options{Emails: emails, CreatedBy: claims.UserID})
+	if err != nil {
+		log.Printf("Erro na importação %s: %v", source, err)
+		http.Error(w, "Erro na importação: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("✓ Importação %s por %s: %d salas, %d mensagens novas", report.Source, claims.Username, report.Rooms, report.Inserted)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func saveUpload(path string, src io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/repository"
+)
+
+const (
+	maxDevicesPerUser    = 10
+	maxOneTimeKeys       = 100
+	maxPublicKeyEncoding = 1024
+)
+
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// KeyHandler publica o diretório de chaves da criptografia de ponta a
+// ponta. O servidor só guarda chaves públicas; as privadas nunca saem dos
+// dispositivos.
+type KeyHandler struct {
+	keys     *repository.KeyRepository
+	roomRepo *repository.RoomRepository
+}
+
+func NewKeyHandler(keys *repository.KeyRepository, roomRepo *repository.RoomRepository) *KeyHandler {
+	return &KeyHandler{keys: keys, roomRepo: roomRepo}
+}
+
+func validPublicKey(s string) bool {
+	if s == "" || len(s) > maxPublicKeyEncoding {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(s)
+	return err == nil
+}
+
+func validateKeyUpload(up models.KeyUpload) string {
+	if !validPublicKey(up.IdentityKey) {
+		return "identityKey inválida"
+	}
+	if !validPublicKey(up.SignedPreKey.PublicKey) || !validPublicKey(up.SignedPreKey.Signature) {
+		return "signedPreKey precisa de publicKey e signature em base64"
+	}
+	if len(up.OneTimeKeys) > maxOneTimeKeys {
+		return "Envie no máximo 100 chaves de uso único por vez"
+	}
+	for _, k := range up.OneTimeKeys {
+		if !validPublicKey(k.PublicKey) {
+			return "Chave de uso único inválida"
+		}
+	}
+	return ""
+}
+
+func (h *KeyHandler) ListDevices(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	devices, err := h.keys.Devices(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Erro ao listar dispositivos: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(devices)
+}
+
+// UploadKeys atende PUT /api/keys/devices/{deviceId}: registra o
+// dispositivo ou troca a chave pré-assinada, e repõe as chaves de uso
+// único. A resposta diz quantas ainda restam.
+func (h *KeyHandler) UploadKeys(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	deviceID := r.PathValue("deviceId")
+	if !deviceIDPattern.MatchString(deviceID) {
+		http.Error(w, "deviceId inválido", http.StatusBadRequest)
+		return
+	}
+
+	var up models.KeyUpload
+	if err := json.NewDecoder(r.Body).Decode(&up); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+	if msg := validateKeyUpload(up); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	device, err := h.keys.Upload(r.Context(), claims.UserID, deviceID, up, maxDevicesPerUser, maxOneTimeKeys)
+	switch {
+	case errors.Is(err, repository.ErrTooManyDevices):
+		http.Error(w, "Limite de 10 dispositivos atingido", http.StatusConflict)
+		return
+	case errors.Is(err, repository.ErrTooManyOneTimeKeys):
+		http.Error(w, "O dispositivo já tem chaves de uso único demais", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Erro ao salvar chaves: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(device)
+}
+
+func (h *KeyHandler) DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	deleted, err := h.keys.DeleteDevice(r.Context(), claims.UserID, r.PathValue("deviceId"))
+	if err != nil {
+		log.Printf("Erro ao remover dispositivo: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Dispositivo não encontrado", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// memberRoom confere se quem pede participa da sala de {id}. Em caso de
+// erro já responde.
+func (h *KeyHandler) memberRoom(w http.ResponseWriter, r *http.Request) (string, bool) {
+	claims := auth.ClaimsFrom(r.Context())
+	roomID := r.PathValue("id")
+
+	room, err := h.roomRepo.GetByID(r.Context(), roomID)
+	if err != nil || room == nil {
+		http.Error(w, "Sala não encontrada", http.StatusNotFound)
+		return "", false
+	}
+	isMember, err := h.roomRepo.IsMember(r.Context(), roomID, claims.UserID)
+	if err != nil || !isMember {
+		http.Error(w, "Acesso negado", http.StatusForbidden)
+		return "", false
+	}
+	return roomID, true
+}
+
+// RoomKeys atende GET /api/rooms/{id}/keys: as chaves públicas dos
+// dispositivos dos membros, para conferir identidades.
+func (h *KeyHandler) RoomKeys(w http.ResponseWriter, r *http.Request) {
+	roomID, ok := h.memberRoom(w, r)
+	if !ok {
+		return
+	}
+
+	bundles, err := h.keys.RoomBundles(r.Context(), roomID)
+	if err != nil {
+		log.Printf("Erro ao buscar chaves da sala: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bundles)
+}
+
+// ClaimRoomKeys atende POST /api/rooms/{id}/keys/claim?deviceId=...: um
+// pacote por dispositivo dos membros (menos o de quem pede), cada um com
+// uma chave de uso único que deixa de existir no servidor.
+func (h *KeyHandler) ClaimRoomKeys(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+	roomID, ok := h.memberRoom(w, r)
+	if !ok {
+		return
+	}
+
+	bundles, err := h.keys.ClaimRoom(r.Context(), roomID, claims.UserID, r.URL.Query().Get("deviceId"))
+	if err != nil {
+		log.Printf("Erro ao reivindicar chaves: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bundles)
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const recoveryCodeCount = 10
+
+// MFAHandler gerencia o 2FA por TOTP da conta logada.
+type MFAHandler struct {
+	userRepo *repository.UserRepository
+	mfa      *repository.MFARepository
+	issuer   string
+}
+
+func NewMFAHandler(userRepo *repository.UserRepository, mfa *repository.MFARepository, issuer string) *MFAHandler {
+	return &MFAHandler{
+		userRepo: userRepo,
+		mfa:      mfa,
+		issuer:   issuer,
+	}
+}
+
+// SecondFactorStore é a parte do MFARepository usada para conferir o 2FA.
+type SecondFactorStore interface {
+	GetTOTP(ctx context.Context, userID string) (*repository.TOTPState, error)
+	UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID, code string) (bool, error)
+}
+
+// verifySecondFactor aceita um código TOTP ainda não usado ou um código de
+// recuperação. Contas sem 2FA ativo nunca passam.
+func verifySecondFactor(ctx context.Context, mfa SecondFactorStore, userID, code string, now time.Time) (bool, error) {
+	state, err := mfa.GetTOTP(ctx, userID)
+	if err != nil || state == nil || !state.Enabled {
+		return false, err
+	}
+
+	if step := auth.ValidateTOTP(state.Secret, code, now); step >= 0 {
+		return mfa.UseTOTPStep(ctx, userID, step)
+	}
+	return mfa.UseRecoveryCode(ctx, userID, code)
+}
+
+func (h *MFAHandler) Status(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	state, err := h.mfa.GetTOTP(r.Context(), claims.UserID)
+	if err != nil || state == nil {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+
+	remaining := 0
+	if state.Enabled {
+		remaining, err = h.mfa.RemainingRecoveryCodes(r.Context(), claims.UserID)
+		if err != nil {
+			log.Printf("Erro ao contar códigos de recuperação: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"enabled":                state.Enabled,
+		"recoveryCodesRemaining": remaining,
+	})
+}
+
+// Setup gera um novo segredo pendente. O 2FA só passa a valer depois de
+// Confirm com um código gerado a partir dele.
+func (h *MFAHandler) Setup(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	state, err := h.mfa.GetTOTP(r.Context(), claims.UserID)
+	if err != nil || state == nil {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+	if state.Enabled {
+		http.Error(w, "2FA já está ativo", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Erro ao gerar segredo", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.mfa.SetPendingSecret(r.Context(), claims.UserID, secret); err != nil {
+		log.Printf("Erro ao salvar segredo 2FA: %v", err)
+		http.Error(w, "Erro ao salvar segredo", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret": secret,
+		"uri":    auth.TOTPURI(h.issuer, claims.Email, secret),
+	})
+}
+
+func (h *MFAHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	state, err := h.mfa.GetTOTP(r.Context(), claims.UserID)
+	if err != nil || state == nil {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+	if state.Enabled {
+		http.Error(w, "2FA já está ativo", http.StatusBadRequest)
+		return
+	}
+	if state.Secret == "" {
+		http.Error(w, "Inicie a configuração do 2FA primeiro", http.StatusBadRequest)
+		return
+	}
+
+	step := auth.ValidateTOTP(state.Secret, req.Code, time.Now())
+	if step < 0 {
+		http.Error(w, "Código inválido", http.StatusUnauthorized)
+		return
+	}
+	if _, err := h.mfa.UseTOTPStep(r.Context(), claims.UserID, step); err != nil {
+		log.Printf("Erro ao registrar código 2FA: %v", err)
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		http.Error(w, "Erro ao gerar códigos de recuperação", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.mfa.Enable(r.Context(), claims.UserID, codes); err != nil {
+		log.Printf("Erro ao ativar 2FA: %v", err)
+		http.Error(w, "Erro ao ativar 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"recoveryCodes": codes})
+}
+
+// Disable exige a senha (em contas que têm senha) e um código válido.
+func (h *MFAHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userRepo.GetByIDWithPassword(r.Context(), claims.UserID)
+	if err != nil || user == nil {
+		http.Error(w, "Usuário não encontrado", http.StatusNotFound)
+		return
+	}
+
+	if user.PasswordHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+			http.Error(w, "Senha incorreta", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	valid, err := verifySecondFactor(r.Context(), h.mfa, claims.UserID, req.Code, time.Now())
+	if err != nil {
+		log.Printf("Erro ao verificar código 2FA: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Código inválido", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.mfa.Disable(r.Context(), claims.UserID); err != nil {
+		log.Printf("Erro ao desativar 2FA: %v", err)
+		http.Error(w, "Erro ao desativar 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "2FA desativado"})
+}
+
+// RegenerateRecoveryCodes invalida os códigos antigos e devolve novos.
+func (h *MFAHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	valid, err := verifySecondFactor(r.Context(), h.mfa, claims.UserID, req.Code, time.Now())
+	if err != nil {
+		log.Printf("Erro ao verificar código 2FA: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Código inválido", http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		http.Error(w, "Erro ao gerar códigos de recuperação", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.mfa.ReplaceRecoveryCodes(r.Context(), claims.UserID, codes); err != nil {
+		log.Printf("Erro ao salvar códigos de recuperação: %v", err)
+		http.Error(w, "Erro ao salvar códigos", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"recoveryCodes": codes})
+}
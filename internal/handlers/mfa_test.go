@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/repository"
+)
+
+// fakeSecondFactor reproduz as regras do MFARepository: o passo só avança
+// (totp_last_step < passo) e cada código de recuperação vale uma vez.
+type fakeSecondFactor struct {
+	state    repository.TOTPState
+	lastStep int64
+	recovery map[string]bool
+}
+
+func (f *fakeSecondFactor) GetTOTP(ctx context.Context, userID string) (*repository.TOTPState, error) {
+	return &f.state, nil
+}
+
+func (f *fakeSecondFactor) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	if f.lastStep >= step {
+		return false, nil
+	}
+	f.lastStep = step
+	return true, nil
+}
+
+func (f *fakeSecondFactor) UseRecoveryCode(ctx context.Context, userID, code string) (bool, error) {
+	if !f.recovery[code] {
+		return false, nil
+	}
+	f.recovery[code] = false
+	return true, nil
+}
+
+func TestSecondFactorRejectsReplayedStep(t *testing.T) {
+	// Segredo e códigos dos vetores da RFC 6238: 287082 é o passo 1 (t=59)
+	// e 081804 o passo 37037036 (t=1111111109).
+	mfa := &fakeSecondFactor{state: repository.TOTPState{Secret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", Enabled: true}}
+	ctx := context.Background()
+
+	if ok, _ := verifySecondFactor(ctx, mfa, "u1", "287082", time.Unix(59, 0)); !ok {
+		t.Fatal("primeiro uso do código recusado")
+	}
+	if ok, _ := verifySecondFactor(ctx, mfa, "u1", "287082", time.Unix(60, 0)); ok {
+		t.Error("código reutilizado dentro da tolerância aceito")
+	}
+	if ok, _ := verifySecondFactor(ctx, mfa, "u1", "081804", time.Unix(1111111109, 0)); !ok {
+		t.Error("código de um passo posterior recusado")
+	}
+	if ok, _ := verifySecondFactor(ctx, mfa, "u1", "287082", time.Unix(59, 0)); ok {
+		t.Error("código de um passo anterior ao último usado aceito")
+	}
+}
+
+func TestSecondFactorRecoveryCodeSingleUse(t *testing.T) {
+	mfa := &fakeSecondFactor{
+		state:    repository.TOTPState{Secret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", Enabled: true},
+		recovery: map[string]bool{"abcde-fghij": true},
+	}
+	ctx := context.Background()
+	now := time.Unix(59, 0)
+
+	if ok, _ := verifySecondFactor(ctx, mfa, "u1", "abcde-fghij", now); !ok {
+		t.Fatal("código de recuperação recusado")
+	}
+	if ok, _ := verifySecondFactor(ctx, mfa, "u1", "abcde-fghij", now); ok {
+		t.Error("código de recuperação aceito duas vezes")
+	}
+
+	// Sem 2FA ativo nada passa, nem um código TOTP correto.
+	mfa.state.Enabled = false
+	if ok, _ := verifySecondFactor(ctx, mfa, "u1", "287082", now); ok {
+		t.Error("código aceito com 2FA desativado")
+	}
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/repository"
+)
+
+// Middleware envolve um handler; Chain aplica a lista na ordem em que foi
+// escrita, então o primeiro é o mais externo.
+type Middleware func(http.Handler) http.Handler
+
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// RequireAuth valida o header Authorization e põe as claims no contexto;
+// os handlers as leem com auth.ClaimsFrom.
+func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := auth.BearerToken(r.Header.Get("Authorization"))
+		if token == "" {
+			http.Error(w, "Token não fornecido", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := auth.ValidateToken(token)
+		if err != nil {
+			http.Error(w, "Token inválido", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+	}
+}
+
+// APITokenStore autentica tokens de API (prefixo "chat_").
+type APITokenStore interface {
+	Authenticate(ctx context.Context, token string) (*models.APIToken, error)
+}
+
+type apiTokenKey struct{}
+
+// APITokenFrom devolve o token de API que autenticou a requisição, ou nil
+// quando ela veio com um JWT de sessão.
+func APITokenFrom(ctx context.Context) *models.APIToken {
+	token, _ := ctx.Value(apiTokenKey{}).(*models.APIToken)
+	return token
+}
+
+// RequireScope é o RequireAuth das rotas abertas a tokens de API: aceita o
+// JWT de sessão ou um token de API com o escopo pedido. Com token de API as
+// claims trazem só UserID e Username.
+func RequireScope(tokens APITokenStore, scope string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token := auth.BearerToken(r.Header.Get("Authorization"))
+			if !strings.HasPrefix(token, repository.APITokenPrefix) {
+				RequireAuth(next)(w, r)
+				return
+			}
+
+			apiToken, ok := authenticateAPIToken(w, r.Context(), tokens, token, scope)
+			if !ok {
+				return
+			}
+
+			ctx := auth.WithClaims(r.Context(), &auth.Claims{UserID: apiToken.UserID, Username: apiToken.Username})
+			next(w, r.WithContext(context.WithValue(ctx, apiTokenKey{}, apiToken)))
+		}
+	}
+}
+
+func authenticateAPIToken(w http.ResponseWriter, ctx context.Context, tokens APITokenStore, token, scope string) (*models.APIToken, bool) {
+	if tokens == nil {
+		http.Error(w, "Token inválido", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	apiToken, err := tokens.Authenticate(ctx, token)
+	if err != nil {
+		log.Printf("Erro ao validar token de API: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return nil, false
+	}
+	if apiToken == nil {
+		http.Error(w, "Token inválido", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !apiToken.HasScope(scope) {
+		http.Error(w, "Token sem o escopo "+scope, http.StatusForbidden)
+		return nil, false
+	}
+	return apiToken, true
+}
+
+// tokenAllowsRoom recusa salas fora da lista do token de API; sem token
+// (sessão normal) não restringe nada.
+func tokenAllowsRoom(w http.ResponseWriter, token *models.APIToken, roomID string) bool {
+	if token != nil && !token.AllowsRoom(roomID) {
+		http.Error(w, "Token sem acesso a esta sala", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+type requestIDKey struct{}
+
+// RequestID reaproveita um X-Request-ID curto vindo do proxy ou gera um novo,
+// devolve no header da resposta e o deixa no contexto para os logs.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLog registra método, caminho, status e duração. A query string fica
+// de fora porque WebSocket, SSE e long-polling levam o token nela.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		log.Printf("%s %s %d %s ip=%s id=%s", r.Method, logPath(r.URL.Path), status,
+			time.Since(start).Round(time.Millisecond), clientIP(r), RequestIDFrom(r.Context()))
+	})
+}
+
+// logPath esconde o segredo das URLs de webhook.
+func logPath(path string) string {
+	if strings.HasPrefix(path, "/hooks/") {
+		return "/hooks/***"
+	}
+	return path
+}
+
+// Recover transforma um panic no handler em 500, sem derrubar a conexão dos
+// outros clientes.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			log.Printf("Panic em %s %s (id=%s): %v\n%s", r.Method, logPath(r.URL.Path), RequestIDFrom(r.Context()), err, debug.Stack())
+			if rec, ok := w.(*statusRecorder); !ok || rec.status == 0 {
+				http.Error(w, "Erro interno", http.StatusInternalServerError)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder guarda o status da resposta e repassa Flush e Hijack, que
+// SSE e WebSocket precisam.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack não suportado")
+	}
+	if r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
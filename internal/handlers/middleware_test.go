@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+func TestRequireAuth(t *testing.T) {
+	token, err := auth.GenerateToken(&models.User{ID: "alice", Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(auth.ClaimsFrom(r.Context()).UserID))
+	})
+
+	cases := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"bearer", "Bearer " + token, http.StatusOK},
+		{"sem prefixo", token, http.StatusOK},
+		{"ausente", "", http.StatusUnauthorized},
+		{"inválido", "Bearer abc.def.ghi", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/me", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Code != tc.status {
+			t.Errorf("%s: status = %d, esperado %d", tc.name, rec.Code, tc.status)
+		}
+		if tc.status == http.StatusOK && rec.Body.String() != "alice" {
+			t.Errorf("%s: claims não chegaram ao handler: %q", tc.name, rec.Body.String())
+		}
+	}
+}
+
+type fakeAPITokens map[string]*models.APIToken
+
+func (f fakeAPITokens) Authenticate(ctx context.Context, token string) (*models.APIToken, error) {
+	return f[token], nil
+}
+
+func TestRequireScope(t *testing.T) {
+	jwtToken, err := auth.GenerateToken(&models.User{ID: "alice", Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := fakeAPITokens{
+		"chat_reader": {UserID: "bot", Username: "bot", Scopes: []string{models.ScopeMessagesRead}, RoomIDs: []string{"r1"}},
+		"chat_writer": {UserID: "bot", Username: "bot", Scopes: []string{models.ScopeMessagesWrite}},
+	}
+
+	handler := RequireScope(tokens, models.ScopeMessagesRead)(func(w http.ResponseWriter, r *http.Request) {
+		if !tokenAllowsRoom(w, APITokenFrom(r.Context()), "r2") {
+			return
+		}
+		w.Write([]byte(auth.ClaimsFrom(r.Context()).UserID))
+	})
+
+	cases := []struct {
+		name   string
+		token  string
+		status int
+		user   string
+	}{
+		{"jwt", jwtToken, http.StatusOK, "alice"},
+		{"sem escopo", "chat_writer", http.StatusForbidden, ""},
+		{"sala fora da lista", "chat_reader", http.StatusForbidden, ""},
+		{"revogado", "chat_revogado", http.StatusUnauthorized, ""},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/rooms/r2/messages", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Code != tc.status {
+			t.Errorf("%s: status = %d, esperado %d", tc.name, rec.Code, tc.status)
+		}
+		if tc.user != "" && rec.Body.String() != tc.user {
+			t.Errorf("%s: usuário = %q", tc.name, rec.Body.String())
+		}
+	}
+
+	tokens["chat_all"] = &models.APIToken{UserID: "bot", Username: "bot", Scopes: []string{models.ScopeMessagesRead}}
+	req := httptest.NewRequest(http.MethodGet, "/api/rooms/r2/messages", nil)
+	req.Header.Set("Authorization", "Bearer chat_all")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "bot" {
+		t.Errorf("token sem lista de salas: status = %d, corpo %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/rooms/{id}/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.PathValue("id")))
+	})
+	mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	handler := Chain(mux, RequestID, AccessLog, Recover)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/rooms/r1/messages", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "r1" {
+		t.Fatalf("rota com parâmetro: %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Request-ID") == "" {
+		t.Fatal("X-Request-ID ausente")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/rooms/r1/messages", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("método errado: status %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("panic: status %d", rec.Code)
+	}
+	if rec.Header().Get("X-Request-ID") != "abc-123" {
+		t.Fatalf("X-Request-ID do cliente não foi mantido: %q", rec.Header().Get("X-Request-ID"))
+	}
+}
@@ -5,54 +5,46 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 
 	"github.com/lucaspanzera1/chat/internal/auth"
 	"github.com/lucaspanzera1/chat/internal/models"
 	"github.com/lucaspanzera1/chat/internal/repository"
+	"github.com/lucaspanzera1/chat/internal/sso"
 )
 
 type OAuthHandler struct {
 	userRepo   *repository.UserRepository
-	config     *oauth2.Config
-	stateStore map[string]time.Time
+	identities *repository.IdentityRepository
+	providers  *sso.Registry
+	stateStore map[string]oauthState
 	stateMutex sync.RWMutex
 }
 
-type GoogleUserInfo struct {
-	ID            string `json:"id"`
-	Email         string `json:"email"`
-	VerifiedEmail bool   `json:"verified_email"`
-	Name          string `json:"name"`
-	Picture       string `json:"picture"`
+// oauthState acompanha um login em andamento. linkUserID preenchido indica
+// que o usuário já logado está vinculando um novo provedor à conta.
+type oauthState struct {
+	provider   string
+	nonce      string
+	verifier   string
+	linkUserID string
+	expiry     time.Time
 }
 
-func NewOAuthHandler(userRepo *repository.UserRepository) *OAuthHandler {
-	config := &oauth2.Config{
-		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-		RedirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile",
-		},
-		Endpoint: google.Endpoint,
-	}
-
+func NewOAuthHandler(userRepo *repository.UserRepository, identities *repository.IdentityRepository, providers *sso.Registry) *OAuthHandler {
 	handler := &OAuthHandler{
 		userRepo:   userRepo,
-		config:     config,
-		stateStore: make(map[string]time.Time),
+		identities: identities,
+		providers:  providers,
+		stateStore: make(map[string]oauthState),
 	}
 
 	go handler.cleanupStates()
@@ -60,32 +52,39 @@ func NewOAuthHandler(userRepo *repository.UserRepository) *OAuthHandler {
 	return handler
 }
 
-func (h *OAuthHandler) generateState() string {
+func randomString() string {
 	b := make([]byte, 32)
 	rand.Read(b)
-	state := base64.URLEncoding.EncodeToString(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (h *OAuthHandler) generateState(provider, linkUserID string) (string, oauthState) {
+	state := randomString()
+	entry := oauthState{
+		provider:   provider,
+		nonce:      randomString(),
+		verifier:   oauth2.GenerateVerifier(),
+		linkUserID: linkUserID,
+		expiry:     time.Now().Add(10 * time.Minute),
+	}
 
 	h.stateMutex.Lock()
-	h.stateStore[state] = time.Now().Add(10 * time.Minute)
+	h.stateStore[state] = entry
 	h.stateMutex.Unlock()
 
-	return state
+	return state, entry
 }
 
-func (h *OAuthHandler) validateState(state string) bool {
-	h.stateMutex.RLock()
-	expiry, exists := h.stateStore[state]
-	h.stateMutex.RUnlock()
-
-	if !exists || time.Now().After(expiry) {
-		return false
-	}
-
+func (h *OAuthHandler) validateState(state, provider string) (oauthState, bool) {
 	h.stateMutex.Lock()
+	entry, exists := h.stateStore[state]
 	delete(h.stateStore, state)
 	h.stateMutex.Unlock()
 
-	return true
+	if !exists || time.Now().After(entry.expiry) || entry.provider != provider {
+		return oauthState{}, false
+	}
+	return entry, true
 }
 
 func (h *OAuthHandler) cleanupStates() {
@@ -93,8 +92,8 @@ func (h *OAuthHandler) cleanupStates() {
 	for range ticker.C {
 		h.stateMutex.Lock()
 		now := time.Now()
-		for state, expiry := range h.stateStore {
-			if now.After(expiry) {
+		for state, entry := range h.stateStore {
+			if now.After(entry.expiry) {
 				delete(h.stateStore, state)
 			}
 		}
@@ -102,71 +101,121 @@ func (h *OAuthHandler) cleanupStates() {
 	}
 }
 
+// Providers lista os logins externos configurados, para a tela de login.
+func (h *OAuthHandler) Providers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.providers.List())
+}
+
+// Login e Callback atendem /api/auth/sso/{provider} e o callback.
+func (h *OAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	h.login(w, r, r.PathValue("provider"))
+}
+
+func (h *OAuthHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	h.callback(w, r, r.PathValue("provider"))
+}
+
+// GoogleLogin e GoogleCallback mantêm as URLs antigas, já cadastradas no
+// console do Google como redirect.
 func (h *OAuthHandler) GoogleLogin(w http.ResponseWriter, r *http.Request) {
-	if h.config.ClientID == "" {
-		http.Error(w, "Google OAuth não configurado", http.StatusInternalServerError)
+	h.login(w, r, "google")
+}
+
+func (h *OAuthHandler) GoogleCallback(w http.ResponseWriter, r *http.Request) {
+	h.callback(w, r, "google")
+}
+
+func (h *OAuthHandler) login(w http.ResponseWriter, r *http.Request, name string) {
+	provider := h.providers.Get(name)
+	if provider == nil {
+		http.Error(w, "Provedor de login não configurado", http.StatusNotFound)
 		return
 	}
 
-	state := h.generateState()
-	url := h.config.AuthCodeURL(state, oauth2.AccessTypeOffline)
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	state, entry := h.generateState(name, "")
+	http.Redirect(w, r, provider.AuthCodeURL(state, entry.nonce, entry.verifier), http.StatusTemporaryRedirect)
 }
 
-func (h *OAuthHandler) GoogleCallback(w http.ResponseWriter, r *http.Request) {
-	state := r.URL.Query().Get("state")
-	if !h.validateState(state) {
+func (h *OAuthHandler) callback(w http.ResponseWriter, r *http.Request, name string) {
+	provider := h.providers.Get(name)
+	if provider == nil {
+		http.Redirect(w, r, "/auth.html?error=unknown_provider", http.StatusTemporaryRedirect)
+		return
+	}
+
+	entry, ok := h.validateState(r.URL.Query().Get("state"), name)
+	if !ok {
 		http.Redirect(w, r, "/auth.html?error=invalid_state", http.StatusTemporaryRedirect)
 		return
 	}
 
+	fail := func(code string) {
+		if entry.linkUserID != "" {
+			http.Redirect(w, r, "/profile.html?linkError="+code, http.StatusTemporaryRedirect)
+			return
+		}
+		http.Redirect(w, r, "/auth.html?error="+code, http.StatusTemporaryRedirect)
+	}
+
 	code := r.URL.Query().Get("code")
 	if code == "" {
-		http.Redirect(w, r, "/auth.html?error=no_code", http.StatusTemporaryRedirect)
+		fail("no_code")
 		return
 	}
 
-	token, err := h.config.Exchange(context.Background(), code)
+	identity, err := provider.Authenticate(r.Context(), code, entry.nonce, entry.verifier)
 	if err != nil {
-		log.Printf("Erro ao trocar código por token: %v", err)
-		http.Redirect(w, r, "/auth.html?error=token_exchange", http.StatusTemporaryRedirect)
+		log.Printf("Erro ao autenticar com %s: %v", name, err)
+		fail("token_exchange")
 		return
 	}
 
-	client := h.config.Client(context.Background(), token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
-	if err != nil {
-		log.Printf("Erro ao buscar info do usuário: %v", err)
-		http.Redirect(w, r, "/auth.html?error=user_info", http.StatusTemporaryRedirect)
+	if entry.linkUserID != "" {
+		err := h.identities.Link(r.Context(), entry.linkUserID, name, identity.Subject, identity.Email)
+		if errors.Is(err, repository.ErrIdentityTaken) {
+			fail("taken")
+			return
+		}
+		if err != nil {
+			log.Printf("Erro ao vincular %s: %v", name, err)
+			fail("link_failed")
+			return
+		}
+		http.Redirect(w, r, "/profile.html?linked="+url.QueryEscape(name), http.StatusTemporaryRedirect)
 		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	user, isNewUser, err := h.findOrCreateUser(r.Context(), name, identity)
 	if err != nil {
-		log.Printf("Erro ao ler resposta: %v", err)
-		http.Redirect(w, r, "/auth.html?error=read_response", http.StatusTemporaryRedirect)
-		return
-	}
-
-	var googleUser GoogleUserInfo
-	if err := json.Unmarshal(body, &googleUser); err != nil {
-		log.Printf("Erro ao parsear usuário: %v", err)
-		http.Redirect(w, r, "/auth.html?error=parse_user", http.StatusTemporaryRedirect)
+		switch {
+		case errors.Is(err, errNoEmail):
+			fail("no_email")
+		case errors.Is(err, repository.ErrUserExists):
+			fail("email_in_use")
+		default:
+			log.Printf("Erro ao criar/buscar usuário: %v", err)
+			fail("create_user")
+		}
 		return
 	}
 
-	user, isNewUser, err := h.findOrCreateUser(r.Context(), googleUser)
-	if err != nil {
-		log.Printf("Erro ao criar/buscar usuário: %v", err)
-		http.Redirect(w, r, "/auth.html?error=create_user", http.StatusTemporaryRedirect)
+	// Contas com 2FA concluem o login em /api/login/2fa, como no login por senha.
+	if user.TOTPEnabled {
+		mfaToken, err := auth.GenerateMFAToken(user)
+		if err != nil {
+			log.Printf("Erro ao gerar token: %v", err)
+			fail("generate_token")
+			return
+		}
+		http.Redirect(w, r, "/auth.html?mfa="+url.QueryEscape(mfaToken), http.StatusTemporaryRedirect)
 		return
 	}
 
 	jwtToken, err := auth.GenerateToken(user)
 	if err != nil {
 		log.Printf("Erro ao gerar token: %v", err)
-		http.Redirect(w, r, "/auth.html?error=generate_token", http.StatusTemporaryRedirect)
+		fail("generate_token")
 		return
 	}
 
@@ -174,7 +223,7 @@ func (h *OAuthHandler) GoogleCallback(w http.ResponseWriter, r *http.Request) {
 		redirectURL := fmt.Sprintf("/setup.html?token=%s&email=%s&avatar=%s",
 			jwtToken,
 			url.QueryEscape(user.Email),
-			url.QueryEscape(googleUser.Picture))
+			url.QueryEscape(identity.Picture))
 		http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
 		return
 	}
@@ -183,9 +232,13 @@ func (h *OAuthHandler) GoogleCallback(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
 }
 
-func (h *OAuthHandler) findOrCreateUser(ctx context.Context, googleUser GoogleUserInfo) (*models.User, bool, error) {
+var errNoEmail = errors.New("provedor não informou email")
 
-	user, err := h.userRepo.GetByGoogleID(ctx, googleUser.ID)
+// findOrCreateUser procura a conta pela identidade; se não houver, vincula a
+// uma conta existente com o mesmo email apenas quando o provedor garante que
+// o email é verificado.
+func (h *OAuthHandler) findOrCreateUser(ctx context.Context, provider string, identity *sso.Identity) (*models.User, bool, error) {
+	user, err := h.identities.GetUser(ctx, provider, identity.Subject)
 	if err != nil {
 		return nil, false, err
 	}
@@ -193,24 +246,124 @@ func (h *OAuthHandler) findOrCreateUser(ctx context.Context, googleUser GoogleUs
 		return user, false, nil
 	}
 
-	user, err = h.userRepo.GetByEmail(ctx, googleUser.Email)
+	if identity.Email == "" {
+		return nil, false, errNoEmail
+	}
+
+	user, err = h.userRepo.GetByEmail(ctx, identity.Email)
 	if err != nil {
 		return nil, false, err
 	}
 	if user != nil {
-		if err := h.userRepo.LinkGoogleAccount(ctx, user.ID, googleUser.ID, googleUser.Picture); err != nil {
+		if !identity.EmailVerified {
+			return nil, false, repository.ErrUserExists
+		}
+		if err := h.identities.Link(ctx, user.ID, provider, identity.Subject, identity.Email); err != nil {
+			return nil, false, err
+		}
+		if err := h.userRepo.FillFromIdentity(ctx, user.ID, identity.Picture, true); err != nil {
 			return nil, false, err
 		}
-		user.GoogleID = &googleUser.ID
-		if user.AvatarURL == nil {
-			user.AvatarURL = &googleUser.Picture
+		if user.AvatarURL == nil && identity.Picture != "" {
+			user.AvatarURL = &identity.Picture
 		}
 		return user, false, nil
 	}
 
-	user, err = h.userRepo.CreateWithGoogle(ctx, googleUser.Email, googleUser.ID, googleUser.Picture)
+	user, err = h.userRepo.CreateWithIdentity(ctx, provider, identity.Subject, identity.Email, identity.Picture, identity.EmailVerified)
 	if err != nil {
 		return nil, false, err
 	}
 	return user, true, nil
 }
+
+// ListIdentities devolve os provedores vinculados e os disponíveis.
+func (h *OAuthHandler) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	identities, err := h.identities.ListByUser(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Erro ao listar identidades: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"identities": identities,
+		"providers":  h.providers.List(),
+	})
+}
+
+// Link inicia o vínculo de um provedor à conta logada. O navegador não envia
+// o Authorization num redirect, então a URL do provedor volta no JSON e o
+// usuário fica amarrado ao state.
+func (h *OAuthHandler) Link(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	var req struct {
+		Provider string `json:"provider"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Requisição inválida", http.StatusBadRequest)
+		return
+	}
+
+	provider := h.providers.Get(req.Provider)
+	if provider == nil {
+		http.Error(w, "Provedor de login não configurado", http.StatusNotFound)
+		return
+	}
+
+	state, entry := h.generateState(provider.Name, claims.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"url": provider.AuthCodeURL(state, entry.nonce, entry.verifier),
+	})
+}
+
+func (h *OAuthHandler) Unlink(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	var req struct {
+		Provider string `json:"provider"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Provider == "" {
+		http.Error(w, "Requisição inválida", http.StatusBadRequest)
+		return
+	}
+
+	identities, err := h.identities.ListByUser(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Erro ao listar identidades: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	linked := false
+	for _, identity := range identities {
+		if identity.Provider == req.Provider {
+			linked = true
+			break
+		}
+	}
+	if !linked {
+		http.Error(w, "Provedor não vinculado", http.StatusNotFound)
+		return
+	}
+
+	removed, err := h.identities.Unlink(r.Context(), claims.UserID, req.Provider)
+	if err != nil {
+		log.Printf("Erro ao desvincular %s: %v", req.Provider, err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Defina uma senha antes de remover seu único meio de login", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Provedor desvinculado"})
+}
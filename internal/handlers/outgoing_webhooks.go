@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/repository"
+	"github.com/lucaspanzera1/chat/internal/safehttp"
+)
+
+const maxDeliveriesListed = 100
+
+// OutgoingWebhookHandler gerencia as inscrições de webhooks de saída e expõe
+// o log de entregas, incluindo as mortas, com retry manual.
+type OutgoingWebhookHandler struct {
+	webhooks *repository.OutgoingWebhookRepository
+	userRepo *repository.UserRepository
+	roomRepo *repository.RoomRepository
+}
+
+func NewOutgoingWebhookHandler(webhooks *repository.OutgoingWebhookRepository, userRepo *repository.UserRepository, roomRepo *repository.RoomRepository) *OutgoingWebhookHandler {
+	return &OutgoingWebhookHandler{webhooks: webhooks, userRepo: userRepo, roomRepo: roomRepo}
+}
+
+func (h *OutgoingWebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	hooks, err := h.webhooks.ListByCreator(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Erro ao listar webhooks de saída: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+// Create inscreve uma URL nos eventos de uma sala. Sem roomId a inscrição
+// vale para todas as salas e exige administrador, assim como a sala geral.
+func (h *OutgoingWebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	var req struct {
+		RoomID string   `json:"roomId"`
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	if err := safehttp.CheckURL(r.Context(), req.URL); err != nil {
+		http.Error(w, "URL deve ser http(s) e apontar para um endereço público", http.StatusBadRequest)
+		return
+	}
+	if len(req.Events) == 0 {
+		req.Events = models.EventTypes
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(models.EventTypes, event) {
+			http.Error(w, "Evento desconhecido: "+event, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !h.canSubscribe(w, r, req.RoomID, claims.UserID) {
+		return
+	}
+
+	hook := &models.OutgoingWebhook{RoomID: req.RoomID, CreatedBy: claims.UserID, URL: req.URL, Events: req.Events}
+	secret, err := h.webhooks.Create(r.Context(), hook)
+	if err != nil {
+		log.Printf("Erro ao criar webhook de saída: %v", err)
+		http.Error(w, "Erro ao criar webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"webhook": hook,
+		"secret":  secret,
+	})
+}
+
+// canSubscribe aplica as mesmas regras de UpdateRoomSettings: grupos pelo
+// criador e salas privadas pelos membros. Em caso de erro já responde.
+func (h *OutgoingWebhookHandler) canSubscribe(w http.ResponseWriter, r *http.Request, roomID, userID string) bool {
+	if roomID != "" {
+		room, err := h.roomRepo.GetByID(r.Context(), roomID)
+		if err != nil || room == nil {
+			http.Error(w, "Sala não encontrada", http.StatusNotFound)
+			return false
+		}
+
+		switch room.Type {
+		case "group":
+			if room.CreatedBy != userID {
+				http.Error(w, "Apenas o criador do grupo gerencia webhooks", http.StatusForbidden)
+				return false
+			}
+			return true
+		case "private":
+			isMember, err := h.roomRepo.IsMember(r.Context(), roomID, userID)
+			if err != nil || !isMember {
+				http.Error(w, "Acesso negado", http.StatusForbidden)
+				return false
+			}
+			return true
+		}
+	}
+
+	isAdmin, err := h.userRepo.IsAdmin(r.Context(), userID)
+	if err != nil {
+		log.Printf("Erro ao verificar administrador: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return false
+	}
+	if !isAdmin {
+		http.Error(w, "Apenas administradores assinam a sala geral ou todas as salas", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *OutgoingWebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	deleted, err := h.webhooks.Delete(r.Context(), r.PathValue("id"), claims.UserID)
+	if err != nil {
+		log.Printf("Erro ao apagar webhook de saída: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Webhook não encontrado", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownedWebhook busca o webhook do caminho se ele é do usuário. Em caso de
+// erro já responde e retorna nil.
+func (h *OutgoingWebhookHandler) ownedWebhook(w http.ResponseWriter, r *http.Request) *models.OutgoingWebhook {
+	claims := auth.ClaimsFrom(r.Context())
+
+	hook, err := h.webhooks.GetByCreator(r.Context(), r.PathValue("id"), claims.UserID)
+	if err != nil || hook == nil {
+		http.Error(w, "Webhook não encontrado", http.StatusNotFound)
+		return nil
+	}
+	return hook
+}
+
+// ListDeliveries lista as últimas entregas; ?status=dead mostra a fila de
+// mortas.
+func (h *OutgoingWebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook := h.ownedWebhook(w, r)
+	if hook == nil {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead:
+	default:
+		http.Error(w, "Status inválido", http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := h.webhooks.ListDeliveries(r.Context(), hook.ID, status, maxDeliveriesListed)
+	if err != nil {
+		log.Printf("Erro ao listar entregas: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func (h *OutgoingWebhookHandler) ListAttempts(w http.ResponseWriter, r *http.Request) {
+	hook := h.ownedWebhook(w, r)
+	if hook == nil {
+		return
+	}
+	deliveryID, err := strconv.ParseInt(r.PathValue("deliveryId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Entrega inválida", http.StatusBadRequest)
+		return
+	}
+
+	attempts, err := h.webhooks.ListAttempts(r.Context(), hook.ID, deliveryID)
+	if err != nil {
+		log.Printf("Erro ao listar tentativas: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
+
+// Retry devolve uma entrega à fila com as tentativas zeradas.
+func (h *OutgoingWebhookHandler) Retry(w http.ResponseWriter, r *http.Request) {
+	hook := h.ownedWebhook(w, r)
+	if hook == nil {
+		return
+	}
+	deliveryID, err := strconv.ParseInt(r.PathValue("deliveryId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Entrega inválida", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := h.webhooks.Retry(r.Context(), hook.ID, deliveryID)
+	if err != nil {
+		log.Printf("Erro ao reenfileirar entrega: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Entrega não encontrada ou já entregue", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/lucaspanzera1/chat/internal/messaging"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// CreatePoll atende POST /api/rooms/{id}/polls com
+// {"question", "options", "multiple", "anonymous", "closesAt"?}.
+func (sh *StreamHandler) CreatePoll(w http.ResponseWriter, r *http.Request) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeMessagesWrite)
+	if !ok {
+		return
+	}
+	roomID := r.PathValue("id")
+	if !tokenAllowsRoom(w, apiToken, roomID) {
+		return
+	}
+
+	var spec messaging.PollSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := sh.pipeline.CreatePoll(r.Context(), senderOf(user), roomID, spec)
+	if err != nil {
+		writePipelineError(w, err, "Erro ao criar enquete")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(msg)
+}
+
+// pollRequest autentica, busca a enquete do caminho como o usuário a vê e
+// confere se o token alcança a sala dela. Em caso de erro já responde.
+func (sh *StreamHandler) pollRequest(w http.ResponseWriter, r *http.Request, scope string) (messaging.Sender, *models.Poll, bool) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, scope)
+	if !ok {
+		return messaging.Sender{}, nil, false
+	}
+
+	poll, err := sh.pipeline.Poll(r.Context(), user.ID, r.PathValue("id"))
+	if err != nil {
+		writePipelineError(w, err, "Erro ao buscar enquete")
+		return messaging.Sender{}, nil, false
+	}
+	if !tokenAllowsRoom(w, apiToken, poll.RoomID) {
+		return messaging.Sender{}, nil, false
+	}
+	return senderOf(user), poll, true
+}
+
+func writePoll(w http.ResponseWriter, poll *models.Poll) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(poll)
+}
+
+// GetPoll devolve os resultados e, em myVotes, as escolhas de quem consulta.
+func (sh *StreamHandler) GetPoll(w http.ResponseWriter, r *http.Request) {
+	_, poll, ok := sh.pollRequest(w, r, models.ScopeMessagesRead)
+	if ok {
+		writePoll(w, poll)
+	}
+}
+
+// Vote atende POST /api/polls/{id}/votes com {"optionIds": [...]}.
+func (sh *StreamHandler) Vote(w http.ResponseWriter, r *http.Request) {
+	sender, _, ok := sh.pollRequest(w, r, models.ScopeMessagesWrite)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		OptionIDs []int64 `json:"optionIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	poll, err := sh.pipeline.Vote(r.Context(), sender, r.PathValue("id"), req.OptionIDs)
+	if err != nil {
+		writePipelineError(w, err, "Erro ao votar")
+		return
+	}
+	writePoll(w, poll)
+}
+
+// Unvote atende DELETE /api/polls/{id}/votes (todos os votos) e
+// DELETE /api/polls/{id}/votes/{optionId}.
+func (sh *StreamHandler) Unvote(w http.ResponseWriter, r *http.Request) {
+	sender, _, ok := sh.pollRequest(w, r, models.ScopeMessagesWrite)
+	if !ok {
+		return
+	}
+
+	var optionID int64
+	if raw := r.PathValue("optionId"); raw != "" {
+		var err error
+		if optionID, err = strconv.ParseInt(raw, 10, 64); err != nil || optionID <= 0 {
+			http.Error(w, "Opção inválida", http.StatusBadRequest)
+			return
+		}
+	}
+
+	poll, err := sh.pipeline.Unvote(r.Context(), sender, r.PathValue("id"), optionID)
+	if err != nil {
+		writePipelineError(w, err, "Erro ao retirar voto")
+		return
+	}
+	writePoll(w, poll)
+}
+
+func (sh *StreamHandler) ClosePoll(w http.ResponseWriter, r *http.Request) {
+	sender, _, ok := sh.pollRequest(w, r, models.ScopeMessagesWrite)
+	if !ok {
+		return
+	}
+
+	poll, err := sh.pipeline.ClosePoll(r.Context(), sender, r.PathValue("id"))
+	if err != nil {
+		writePipelineError(w, err, "Erro ao encerrar enquete")
+		return
+	}
+	writePoll(w, poll)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/ratelimit"
+)
+
+// RateLimitByIP responde 429 quando o IP de origem estoura o limite.
+func RateLimitByIP(limiter *ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := limiter.Allow(clientIP(r)); !ok {
+			setRetryAfter(w, wait)
+			http.Error(w, "Muitas tentativas, aguarde um pouco", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// defaultTrustedProxies vale quando TRUSTED_PROXIES não é informado: o
+// proxy reverso costuma estar na mesma máquina ou na rede interna.
+const defaultTrustedProxies = "127.0.0.0/8,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,fc00::/7"
+
+// clientIP usa X-Forwarded-For apenas com TRUST_PROXY=true e quando a
+// conexão vem de um proxy de TRUSTED_PROXIES; caso contrário qualquer
+// cliente poderia escolher o próprio IP. Cada proxy acrescenta o endereço
+// de quem o chamou ao fim do cabeçalho, então o IP do cliente é a entrada
+// de número TRUSTED_PROXY_HOPS (padrão 1) a partir da direita: as que
+// estão antes dela vêm do próprio cliente.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if os.Getenv("TRUST_PROXY") != "true" || !trustedProxy(host) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(entry))
+		}
+	}
+	n := 1
+	if v, err := strconv.Atoi(os.Getenv("TRUSTED_PROXY_HOPS")); err == nil && v > 0 {
+		n = v
+	}
+	if len(hops) < n {
+		return host
+	}
+	ip, err := netip.ParseAddr(hops[len(hops)-n])
+	if err != nil {
+		return host
+	}
+	return ip.Unmap().String()
+}
+
+// trustedProxy diz se o endereço da conexão é de um proxy configurado.
+func trustedProxy(host string) bool {
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+
+	proxies := os.Getenv("TRUSTED_PROXIES")
+	if proxies == "" {
+		proxies = defaultTrustedProxies
+	}
+	for _, entry := range strings.Split(proxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			if prefix.Contains(ip) {
+				return true
+			}
+		} else if addr, err := netip.ParseAddr(entry); err == nil && addr.Unmap() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+}
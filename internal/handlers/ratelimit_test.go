@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lucaspanzera1/chat/internal/ratelimit"
+)
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name       string
+		trust      string
+		proxies    string
+		hops       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"sem TRUST_PROXY", "", "", "", "10.0.0.5:4000", []string{"1.2.3.4"}, "10.0.0.5"},
+		{"proxy confiável", "true", "", "", "10.0.0.5:4000", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"entrada forjada à esquerda", "true", "", "", "10.0.0.5:4000", []string{"1.2.3.4, 203.0.113.7"}, "203.0.113.7"},
+		{"cabeçalhos repetidos", "true", "", "", "10.0.0.5:4000", []string{"1.2.3.4", "203.0.113.7"}, "203.0.113.7"},
+		{"conexão direta de fora", "true", "", "", "198.51.100.9:4000", []string{"1.2.3.4"}, "198.51.100.9"},
+		{"fora de TRUSTED_PROXIES", "true", "192.0.2.1", "", "10.0.0.5:4000", []string{"1.2.3.4"}, "10.0.0.5"},
+		{"proxy listado", "true", "192.0.2.1, 10.1.0.0/16", "", "10.1.2.3:4000", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"dois proxies", "true", "", "2", "10.0.0.5:4000", []string{"1.2.3.4, 203.0.113.7, 10.0.0.9"}, "203.0.113.7"},
+		{"menos entradas que proxies", "true", "", "2", "10.0.0.5:4000", []string{"203.0.113.7"}, "10.0.0.5"},
+		{"entrada inválida", "true", "", "", "10.0.0.5:4000", []string{"1.2.3.4, lixo"}, "10.0.0.5"},
+		{"sem cabeçalho", "true", "", "", "10.0.0.5:4000", nil, "10.0.0.5"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TRUST_PROXY", tc.trust)
+			t.Setenv("TRUSTED_PROXIES", tc.proxies)
+			t.Setenv("TRUSTED_PROXY_HOPS", tc.hops)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/login", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(req); got != tc.want {
+				t.Errorf("clientIP = %s, quer %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitByIPIgnoresSpoofedForwardedFor(t *testing.T) {
+	t.Setenv("TRUST_PROXY", "true")
+	handler := RateLimitByIP(ratelimit.NewLimiter(0.001, 2), func(w http.ResponseWriter, r *http.Request) {})
+
+	// O cliente troca a primeira entrada a cada tentativa; o proxy acrescenta
+	// sempre o mesmo endereço real.
+	var codes []int
+	for _, spoofed := range []string{"1.2.3.4", "1.2.3.5", "1.2.3.6"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/login", nil)
+		req.RemoteAddr = "127.0.0.1:4000"
+		req.Header.Set("X-Forwarded-For", spoofed+", 203.0.113.7")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[2] != http.StatusTooManyRequests {
+		t.Errorf("status = %v, a terceira tentativa devia estourar o limite", codes)
+	}
+}
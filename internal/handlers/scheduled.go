@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/lucaspanzera1/chat/internal/messaging"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+func writeScheduled(w http.ResponseWriter, status int, item *models.ScheduledMessage) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(item)
+}
+
+// CreateScheduled atende POST /api/rooms/{id}/scheduled com
+// {"content", "sendAt"} ou {"content", "when", "timezone"?}.
+func (sh *StreamHandler) CreateScheduled(w http.ResponseWriter, r *http.Request) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeMessagesWrite)
+	if !ok {
+		return
+	}
+	roomID := r.PathValue("id")
+	if !tokenAllowsRoom(w, apiToken, roomID) {
+		return
+	}
+
+	var spec messaging.ScheduleSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	item, err := sh.pipeline.Schedule(r.Context(), senderOf(user), roomID, spec)
+	if err != nil {
+		writePipelineError(w, err, "Erro ao agendar mensagem")
+		return
+	}
+	writeScheduled(w, http.StatusCreated, item)
+}
+
+// CreateReminder atende POST /api/rooms/{id}/reminders e
+// POST /api/rooms/{id}/messages/{messageId}/reminders; content é o texto do
+// lembrete, opcional quando ele aponta para uma mensagem.
+func (sh *StreamHandler) CreateReminder(w http.ResponseWriter, r *http.Request) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeMessagesWrite)
+	if !ok {
+		return
+	}
+	roomID := r.PathValue("id")
+	if !tokenAllowsRoom(w, apiToken, roomID) {
+		return
+	}
+
+	var spec messaging.ScheduleSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	item, err := sh.pipeline.Remind(r.Context(), senderOf(user), roomID, r.PathValue("messageId"), spec)
+	if err != nil {
+		writePipelineError(w, err, "Erro ao criar lembrete")
+		return
+	}
+	writeScheduled(w, http.StatusCreated, item)
+}
+
+// ListScheduled atende GET /api/scheduled[?status=pending]. Tokens
+// restritos a algumas salas só veem os agendamentos delas.
+func (sh *StreamHandler) ListScheduled(w http.ResponseWriter, r *http.Request) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeMessagesRead)
+	if !ok {
+		return
+	}
+
+	items, err := sh.pipeline.ScheduledList(r.Context(), user.ID, r.URL.Query().Get("status"))
+	if err != nil {
+		writePipelineError(w, err, "Erro ao listar agendamentos")
+		return
+	}
+	if apiToken != nil {
+		visible := items[:0]
+		for _, item := range items {
+			if apiToken.AllowsRoom(item.RoomID) {
+				visible = append(visible, item)
+			}
+		}
+		items = visible
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// scheduledRequest autentica e confere se o token alcança a sala do
+// agendamento do caminho. Em caso de erro já responde.
+func (sh *StreamHandler) scheduledRequest(w http.ResponseWriter, r *http.Request) (messaging.Sender, bool) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeMessagesWrite)
+	if !ok {
+		return messaging.Sender{}, false
+	}
+
+	item, err := sh.pipeline.Scheduled(r.Context(), user.ID, r.PathValue("id"))
+	if err != nil {
+		writePipelineError(w, err, "Erro ao buscar agendamento")
+		return messaging.Sender{}, false
+	}
+	if !tokenAllowsRoom(w, apiToken, item.RoomID) {
+		return messaging.Sender{}, false
+	}
+	return senderOf(user), true
+}
+
+// UpdateScheduled atende PATCH /api/scheduled/{id}; campos omitidos ficam
+// como estão.
+func (sh *StreamHandler) UpdateScheduled(w http.ResponseWriter, r *http.Request) {
+	sender, ok := sh.scheduledRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var spec messaging.ScheduleSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	item, err := sh.pipeline.UpdateScheduled(r.Context(), sender, r.PathValue("id"), spec)
+	if err != nil {
+		writePipelineError(w, err, "Erro ao atualizar agendamento")
+		return
+	}
+	writeScheduled(w, http.StatusOK, item)
+}
+
+func (sh *StreamHandler) CancelScheduled(w http.ResponseWriter, r *http.Request) {
+	sender, ok := sh.scheduledRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := sh.pipeline.CancelScheduled(r.Context(), sender.UserID, r.PathValue("id")); err != nil {
+		writePipelineError(w, err, "Erro ao cancelar agendamento")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
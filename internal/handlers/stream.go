@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucaspanzera1/chat/internal/client"
+	"github.com/lucaspanzera1/chat/internal/hub"
+	"github.com/lucaspanzera1/chat/internal/messaging"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+)
+
+const (
+	sseHeartbeat    = 25 * time.Second
+	pollWait        = 25 * time.Second
+	pollSessionTTL  = 60 * time.Second
+	pollMaxEvents   = 100
+	pollCleanupTick = 30 * time.Second
+)
+
+// StreamHandler oferece transportes alternativos para quem não consegue abrir
+// WebSocket (proxies corporativos): SSE para receber, POST para enviar e
+// long-polling como último recurso.
+type StreamHandler struct {
+	hub        *hub.Hub
+	userRepo   ConnectionUserStore
+	pipeline   *messaging.Pipeline
+	tokens     APITokenStore
+	sessions   map[string]*client.StreamClient
+	sessionsMu sync.Mutex
+}
+
+func NewStreamHandler(h *hub.Hub, userRepo ConnectionUserStore, pipeline *messaging.Pipeline) *StreamHandler {
+	handler := &StreamHandler{
+		hub:      h,
+		userRepo: userRepo,
+		pipeline: pipeline,
+		sessions: make(map[string]*client.StreamClient),
+	}
+
+	go handler.cleanupSessions()
+
+	return handler
+}
+
+// SetAPITokens habilita SSE, long-polling e envio com tokens de API.
+func (sh *StreamHandler) SetAPITokens(tokens APITokenStore) {
+	sh.tokens = tokens
+}
+
+func (sh *StreamHandler) connect(c *client.StreamClient) {
+	if err := sh.userRepo.SetOnline(context.Background(), c.UserID); err != nil {
+		log.Printf("Erro ao marcar usuário online: %v", err)
+	}
+	sh.hub.Register(c)
+}
+
+func (sh *StreamHandler) disconnect(c *client.StreamClient) {
+	sh.hub.BroadcastLeave(c)
+	if err := sh.userRepo.SetOffline(context.Background(), c.UserID); err != nil {
+		log.Printf("Erro ao marcar usuário offline: %v", err)
+	}
+	sh.hub.Unregister(c)
+}
+
+func (sh *StreamHandler) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeRealtime)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming não suportado", http.StatusInternalServerError)
+		return
+	}
+
+	roomID := connectionRoomID(r)
+	if !tokenAllowsRoom(w, apiToken, roomID) || !authorizeRoom(w, r, sh.pipeline, roomID, user.ID) {
+		return
+	}
+
+	avatarURL := ""
+	if user.AvatarURL != nil {
+		avatarURL = *user.AvatarURL
+	}
+	c := client.NewStreamClient(user.ID, user.Username, avatarURL, roomID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	sh.connect(c)
+	defer sh.disconnect(c)
+
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case frame, ok := <-c.Send:
+			if !ok {
+				return
+			}
+
+			data, err := frame.JSON()
+			if err != nil {
+				log.Printf("Erro ao serializar mensagem: %v", err)
+				continue
+			}
+
+			if frame.Message.ID != "" {
+				fmt.Fprintf(w, "id: %s\n", frame.Message.ID)
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// SendMessage atende POST /api/room/send (sala no corpo) e
+// POST /api/rooms/{id}/messages, usado por bots e integrações.
+func (sh *StreamHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeMessagesWrite)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		RoomID     string `json:"roomId"`
+		Content    string `json:"content"`
+		Encryption string `json:"encryption"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+	if id := r.PathValue("id"); id != "" {
+		req.RoomID = id
+	}
+	if req.RoomID == "" {
+		req.RoomID = "00000000-0000-0000-0000-000000000001"
+	}
+	if !tokenAllowsRoom(w, apiToken, req.RoomID) {
+		return
+	}
+
+	sender := senderOf(user)
+
+	var msg *models.Message
+	var err error
+	if req.Encryption != "" {
+		msg, err = sh.pipeline.SendEncrypted(r.Context(), sender, req.RoomID, req.Content, req.Encryption)
+	} else {
+		msg, err = sh.pipeline.Send(r.Context(), sender, req.RoomID, req.Content)
+	}
+	if err != nil {
+		writePipelineError(w, err, "Erro ao enviar mensagem")
+		return
+	}
+
+	// Respostas efêmeras de comandos só existem nesta resposta.
+	w.Header().Set("Content-Type", "application/json")
+	if msg.Type != "ephemeral" {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(msg)
+}
+
+// RespondCommand atende POST /api/commands/{invocationId}/respond, a
+// alternativa HTTP ao frame command.respond para bots sem WebSocket de envio.
+func (sh *StreamHandler) RespondCommand(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeMessagesWrite)
+	if !ok {
+		return
+	}
+
+	var reply messaging.CommandReply
+	if err := json.NewDecoder(r.Body).Decode(&reply); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	if err := sh.pipeline.RespondCommand(r.Context(), user.ID, r.PathValue("invocationId"), reply); err != nil {
+		writePipelineError(w, err, "Erro ao responder comando")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SendEphemeral atende POST /api/rooms/{id}/ephemeral: um bot manda uma
+// mensagem que só os usuários indicados veem, sem gravá-la. A resposta diz
+// quantas conexões a receberam.
+func (sh *StreamHandler) SendEphemeral(w http.ResponseWriter, r *http.Request) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeMessagesWrite)
+	if !ok {
+		return
+	}
+	roomID := r.PathValue("id")
+	if !tokenAllowsRoom(w, apiToken, roomID) {
+		return
+	}
+
+	var req struct {
+		UserIDs []string `json:"userIds"`
+		Content string   `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	sender := senderOf(user)
+
+	msg, reached, err := sh.pipeline.SendEphemeral(r.Context(), sender, roomID, req.UserIDs, req.Content)
+	if err != nil {
+		writePipelineError(w, err, "Erro ao enviar mensagem efêmera")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":   msg,
+		"delivered": reached,
+	})
+}
+
+// EditMessage atende PATCH /api/rooms/{id}/messages/{messageId}; só o autor
+// edita.
+func (sh *StreamHandler) EditMessage(w http.ResponseWriter, r *http.Request) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeMessagesWrite)
+	if !ok {
+		return
+	}
+	roomID := r.PathValue("id")
+	if !tokenAllowsRoom(w, apiToken, roomID) {
+		return
+	}
+
+	var req struct {
+		Content    string `json:"content"`
+		Encryption string `json:"encryption"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	sender := messaging.Sender{UserID: user.ID, Username: user.Username, IsBot: user.IsBot}
+	var msg *models.Message
+	var err error
+	if req.Encryption != "" {
+		msg, err = sh.pipeline.EditEncrypted(r.Context(), sender, roomID, r.PathValue("messageId"), req.Content, req.Encryption)
+	} else {
+		msg, err = sh.pipeline.Edit(r.Context(), sender, roomID, r.PathValue("messageId"), req.Content)
+	}
+	if err != nil {
+		writePipelineError(w, err, "Erro ao editar mensagem")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
+
+// DeleteMessage atende DELETE /api/rooms/{id}/messages/{messageId}.
+func (sh *StreamHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeMessagesWrite)
+	if !ok {
+		return
+	}
+	roomID := r.PathValue("id")
+	if !tokenAllowsRoom(w, apiToken, roomID) {
+		return
+	}
+
+	sender := messaging.Sender{UserID: user.ID, Username: user.Username, IsBot: user.IsBot}
+	if err := sh.pipeline.Delete(r.Context(), sender, roomID, r.PathValue("messageId")); err != nil {
+		writePipelineError(w, err, "Erro ao apagar mensagem")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// senderOf identifica o usuário autenticado como remetente no pipeline.
+func senderOf(user *models.User) messaging.Sender {
+	avatarURL := ""
+	if user.AvatarURL != nil {
+		avatarURL = *user.AvatarURL
+	}
+	return messaging.Sender{UserID: user.ID, Username: user.Username, AvatarURL: avatarURL, IsBot: user.IsBot}
+}
+
+// writePipelineError responde com o status do *protocol.Error ou, para
+// erros inesperados, com 500 e a mensagem dada.
+func writePipelineError(w http.ResponseWriter, err error, message string) {
+	var perr *protocol.Error
+	if errors.As(err, &perr) {
+		setRetryAfter(w, perr.RetryAfter)
+		http.Error(w, perr.Message, protocol.HTTPStatus(perr.Code))
+		return
+	}
+	log.Printf("%s: %v", message, err)
+	http.Error(w, message, http.StatusInternalServerError)
+}
+
+// Poll implementa long-polling. A primeira chamada (sem session) cria a sessão
+// e a registra no hub; as seguintes esperam até pollWait por eventos.
+func (sh *StreamHandler) Poll(w http.ResponseWriter, r *http.Request) {
+	user, apiToken, ok := authenticateConnection(w, r, sh.userRepo, sh.tokens, models.ScopeRealtime)
+	if !ok {
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session")
+	if sessionID == "" {
+		roomID := connectionRoomID(r)
+		if !tokenAllowsRoom(w, apiToken, roomID) || !authorizeRoom(w, r, sh.pipeline, roomID, user.ID) {
+			return
+		}
+
+		avatarURL := ""
+		if user.AvatarURL != nil {
+			avatarURL = *user.AvatarURL
+		}
+		c := client.NewStreamClient(user.ID, user.Username, avatarURL, roomID)
+		sessionID = uuid.New().String()
+
+		sh.sessionsMu.Lock()
+		sh.sessions[sessionID] = c
+		sh.sessionsMu.Unlock()
+
+		sh.connect(c)
+		writePollResponse(w, sessionID, nil)
+		return
+	}
+
+	sh.sessionsMu.Lock()
+	c, exists := sh.sessions[sessionID]
+	sh.sessionsMu.Unlock()
+
+	if !exists || c.UserID != user.ID {
+		http.Error(w, "Sessão expirada", http.StatusGone)
+		return
+	}
+
+	c.Touch()
+	defer c.Touch()
+
+	events := []json.RawMessage{}
+	timer := time.NewTimer(pollWait)
+	defer timer.Stop()
+
+	select {
+	case <-r.Context().Done():
+		return
+	case <-timer.C:
+		writePollResponse(w, sessionID, events)
+		return
+	case frame, ok := <-c.Send:
+		if !ok {
+			sh.closeSession(sessionID)
+			writePollResponse(w, sessionID, events)
+			return
+		}
+		events = appendFrame(events, frame)
+	}
+
+drain:
+	for len(events) < pollMaxEvents {
+		select {
+		case frame, ok := <-c.Send:
+			if !ok {
+				sh.closeSession(sessionID)
+				writePollResponse(w, sessionID, events)
+				return
+			}
+			events = appendFrame(events, frame)
+		default:
+			break drain
+		}
+	}
+
+	writePollResponse(w, sessionID, events)
+}
+
+func appendFrame(events []json.RawMessage, frame *hub.Frame) []json.RawMessage {
+	data, err := frame.JSON()
+	if err != nil {
+		log.Printf("Erro ao serializar mensagem: %v", err)
+		return events
+	}
+	return append(events, data)
+}
+
+func writePollResponse(w http.ResponseWriter, sessionID string, events []json.RawMessage) {
+	if events == nil {
+		events = []json.RawMessage{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(struct {
+		Session string            `json:"session"`
+		Events  []json.RawMessage `json:"events"`
+	}{
+		Session: sessionID,
+		Events:  events,
+	})
+}
+
+// closeSession remove a sessão sem desregistrar: usado quando o hub já
+// fechou o canal do cliente.
+func (sh *StreamHandler) closeSession(sessionID string) {
+	sh.sessionsMu.Lock()
+	delete(sh.sessions, sessionID)
+	sh.sessionsMu.Unlock()
+}
+
+func (sh *StreamHandler) cleanupSessions() {
+	ticker := time.NewTicker(pollCleanupTick)
+	for range ticker.C {
+		sh.expireSessions(time.Now().Add(-pollSessionTTL))
+	}
+}
+
+// expireSessions encerra as sessões de long-polling sem requisições desde
+// limit.
+func (sh *StreamHandler) expireSessions(limit time.Time) {
+	var expired []*client.StreamClient
+	sh.sessionsMu.Lock()
+	for id, c := range sh.sessions {
+		if c.IdleSince(limit) {
+			expired = append(expired, c)
+			delete(sh.sessions, id)
+		}
+	}
+	sh.sessionsMu.Unlock()
+
+	for _, c := range expired {
+		sh.disconnect(c)
+	}
+}
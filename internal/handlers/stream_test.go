@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/messaging"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// streamServer expõe o SSE e o long-polling sobre o hub e o pipeline do
+// protocolServer.
+type streamServer struct {
+	*protocolServer
+	stream *StreamHandler
+	url    string
+}
+
+func newStreamServer(t *testing.T) *streamServer {
+	t.Helper()
+	ps := newProtocolServer(t)
+	sh := NewStreamHandler(ps.hub, ps.users, ps.pipeline)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sse", sh.ServeSSE)
+	mux.HandleFunc("GET /api/poll", sh.Poll)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return &streamServer{protocolServer: ps, stream: sh, url: srv.URL}
+}
+
+func (ss *streamServer) query(user, roomID, session string) string {
+	q := url.Values{}
+	if user != "" {
+		q.Set("token", ss.tokens[user])
+	}
+	if roomID != "" {
+		q.Set("roomId", roomID)
+	}
+	if session != "" {
+		q.Set("session", session)
+	}
+	return q.Encode()
+}
+
+type pollResponse struct {
+	Session string           `json:"session"`
+	Events  []models.Message `json:"events"`
+}
+
+// poll faz uma requisição de long-polling. Sem session ela cria a sessão.
+func (ss *streamServer) poll(t *testing.T, user, roomID, session string) (int, pollResponse) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ss.url+"/api/poll?"+ss.query(user, roomID, session), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body pollResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("resposta do poll: %v", err)
+		}
+	}
+	return resp.StatusCode, body
+}
+
+// pollUntil repete o poll até chegar um evento que satisfaça match e
+// devolve esse evento.
+func (ss *streamServer) pollUntil(t *testing.T, user, session string, match func(models.Message) bool) models.Message {
+	t.Helper()
+	for range 10 {
+		status, body := ss.poll(t, user, "", session)
+		if status != http.StatusOK {
+			t.Fatalf("poll = %d", status)
+		}
+		for _, e := range body.Events {
+			if match(e) {
+				return e
+			}
+		}
+	}
+	t.Fatal("evento esperado não chegou")
+	return models.Message{}
+}
+
+func (ss *streamServer) sessions() int {
+	ss.stream.sessionsMu.Lock()
+	defer ss.stream.sessionsMu.Unlock()
+	return len(ss.stream.sessions)
+}
+
+func ofType(typ string) func(models.Message) bool {
+	return func(m models.Message) bool { return m.Type == typ }
+}
+
+// readSSE lê o próximo evento com dados, ignorando o retry e os pings.
+func readSSE(t *testing.T, r *bufio.Reader) (string, models.Message) {
+	t.Helper()
+	var id, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("leitura do SSE: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			var msg models.Message
+			if err := json.Unmarshal([]byte(data), &msg); err != nil {
+				t.Fatalf("evento SSE inválido: %s", data)
+			}
+			return id, msg
+		}
+	}
+}
+
+func TestSSERequiresAccess(t *testing.T) {
+	ss := newStreamServer(t)
+
+	cases := map[string]struct {
+		query string
+		want  int
+	}{
+		"sem token":           {ss.query("", generalRoom, ""), http.StatusUnauthorized},
+		"sala sem participar": {ss.query("bob", privateRoom, ""), http.StatusForbidden},
+	}
+	for name, c := range cases {
+		resp, err := http.Get(ss.url + "/sse?" + c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("%s: status = %d, quer %d", name, resp.StatusCode, c.want)
+		}
+	}
+}
+
+func TestSSEStream(t *testing.T) {
+	ss := newStreamServer(t)
+
+	// bob acompanha a sala por long-polling para ver a saída do alice.
+	status, created := ss.poll(t, "bob", generalRoom, "")
+	if status != http.StatusOK {
+		t.Fatalf("criação da sessão = %d", status)
+	}
+	bobSession := created.Session
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ss.url+"/sse?"+ss.query("alice", generalRoom, ""), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, Content-Type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	r := bufio.NewReader(resp.Body)
+	if line, _ := r.ReadString('\n'); line != "retry: 3000\n" {
+		t.Fatalf("primeira linha = %q", line)
+	}
+
+	// A entrada na sala gera a contagem, sem id de mensagem.
+	if id, msg := readSSE(t, r); msg.Type != "count" || id != "" {
+		t.Fatalf("primeiro evento = %q %+v", id, msg)
+	}
+
+	sent, err := ss.pipeline.Send(context.Background(), messaging.Sender{UserID: "bob", Username: "bob"}, generalRoom, "oi pelo SSE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		id, msg := readSSE(t, r)
+		if msg.Type != "message" {
+			continue
+		}
+		if id != sent.ID || msg.ID != sent.ID || msg.Content != "oi pelo SSE" || msg.Username != "bob" {
+			t.Fatalf("mensagem = %q %+v", id, msg)
+		}
+		break
+	}
+
+	// Fechar a conexão desregistra o alice: a contagem da sala volta a 1.
+	ss.pollUntil(t, "bob", bobSession, func(m models.Message) bool { return m.ID == sent.ID })
+	cancel()
+	ss.pollUntil(t, "bob", bobSession, func(m models.Message) bool { return m.Type == "count" && m.OnlineCount == 1 })
+}
+
+func TestPollSessionLifecycle(t *testing.T) {
+	ss := newStreamServer(t)
+
+	if status, _ := ss.poll(t, "bob", privateRoom, ""); status != http.StatusForbidden {
+		t.Errorf("sessão em sala sem participar = %d", status)
+	}
+
+	status, created := ss.poll(t, "alice", generalRoom, "")
+	if status != http.StatusOK || created.Session == "" || created.Events == nil || len(created.Events) != 0 {
+		t.Fatalf("criação = %d %+v", status, created)
+	}
+	session := created.Session
+
+	if status, _ := ss.poll(t, "bob", "", session); status != http.StatusGone {
+		t.Errorf("sessão de outro usuário = %d, quer 410", status)
+	}
+	if status, _ := ss.poll(t, "alice", "", "nao-existe"); status != http.StatusGone {
+		t.Errorf("sessão desconhecida = %d, quer 410", status)
+	}
+
+	ss.pollUntil(t, "alice", session, ofType("count"))
+	sent, err := ss.pipeline.Send(context.Background(), messaging.Sender{UserID: "bob", Username: "bob"}, generalRoom, "oi pelo poll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := ss.pollUntil(t, "alice", session, ofType("message"))
+	if got.ID != sent.ID || got.Content != "oi pelo poll" {
+		t.Errorf("mensagem = %+v", got)
+	}
+
+	// Uma sessão usada há pouco sobrevive à limpeza; ociosa, expira.
+	ss.stream.expireSessions(time.Now().Add(-pollSessionTTL))
+	if ss.sessions() != 1 {
+		t.Fatal("sessão ativa expirou")
+	}
+	ss.stream.expireSessions(time.Now().Add(time.Minute))
+	if ss.sessions() != 0 {
+		t.Error("sessão ociosa não expirou")
+	}
+	if status, _ := ss.poll(t, "alice", "", session); status != http.StatusGone {
+		t.Errorf("sessão expirada = %d, quer 410", status)
+	}
+}
+
+func TestPollAfterHubCloses(t *testing.T) {
+	ss := newStreamServer(t)
+
+	_, created := ss.poll(t, "alice", generalRoom, "")
+	session := created.Session
+	ss.pollUntil(t, "alice", session, ofType("count"))
+
+	// O Shutdown avisa e fecha o canal do cliente: o poll entrega o aviso e
+	// a sessão acaba, sem desregistrar de novo no hub.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := ss.hub.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ss.pollUntil(t, "alice", session, ofType("server_restarting"))
+
+	// Se o fechamento chegou depois do aviso, o próximo poll o encontra e
+	// responde vazio; o seguinte já não tem sessão.
+	for range 2 {
+		status, body := ss.poll(t, "alice", "", session)
+		if status == http.StatusGone {
+			return
+		}
+		if status != http.StatusOK || len(body.Events) != 0 {
+			t.Fatalf("poll depois do fechamento = %d %+v", status, body)
+		}
+	}
+	t.Fatal("sessão continuou depois de o hub fechar o canal")
+}
@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/messaging"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/repository"
+	"github.com/lucaspanzera1/chat/internal/safehttp"
+)
+
+// APITokenHandler gerencia bots e tokens de API. As rotas exigem a sessão
+// (JWT): um token de API não cria nem revoga outros tokens.
+type APITokenHandler struct {
+	tokens   *repository.APITokenRepository
+	userRepo *repository.UserRepository
+	roomRepo *repository.RoomRepository
+	commands *repository.BotCommandRepository
+	events   messaging.EventPublisher
+}
+
+func NewAPITokenHandler(tokens *repository.APITokenRepository, userRepo *repository.UserRepository, roomRepo *repository.RoomRepository, commands *repository.BotCommandRepository) *APITokenHandler {
+	return &APITokenHandler{tokens: tokens, userRepo: userRepo, roomRepo: roomRepo, commands: commands}
+}
+
+// SetEvents liga a publicação de member.joined quando um bot entra num grupo.
+func (h *APITokenHandler) SetEvents(e messaging.EventPublisher) {
+	h.events = e
+}
+
+func (h *APITokenHandler) ListBots(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	bots, err := h.userRepo.ListBots(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Erro ao listar bots: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bots)
+}
+
+func (h *APITokenHandler) CreateBot(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	username := strings.TrimSpace(req.Username)
+	if len(username) < 3 || len(username) > 20 {
+		http.Error(w, "Username deve ter entre 3 e 20 caracteres", http.StatusBadRequest)
+		return
+	}
+
+	exists, err := h.userRepo.UsernameExists(r.Context(), username)
+	if err != nil {
+		http.Error(w, "Erro ao verificar username", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "Username já está em uso", http.StatusConflict)
+		return
+	}
+
+	bot, err := h.userRepo.CreateBot(r.Context(), claims.UserID, username)
+	if errors.Is(err, repository.ErrUserExists) {
+		http.Error(w, "Username já está em uso", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao criar bot: %v", err)
+		http.Error(w, "Erro ao criar bot", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(bot)
+}
+
+func (h *APITokenHandler) DeleteBot(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	deleted, err := h.userRepo.DeleteBot(r.Context(), r.PathValue("id"), claims.UserID)
+	if err != nil {
+		log.Printf("Erro ao apagar bot: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Bot não encontrado", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddBotToRoom coloca o bot num grupo. Como em UpdateRoomSettings, só o
+// criador do grupo decide quem entra.
+func (h *APITokenHandler) AddBotToRoom(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+	botID := r.PathValue("id")
+
+	var req struct {
+		RoomID string `json:"roomId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RoomID == "" {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	owner, err := h.userRepo.IsBotOwner(r.Context(), botID, claims.UserID)
+	if err != nil || !owner {
+		http.Error(w, "Bot não encontrado", http.StatusNotFound)
+		return
+	}
+
+	room, err := h.roomRepo.GetByID(r.Context(), req.RoomID)
+	if err != nil || room == nil {
+		http.Error(w, "Sala não encontrada", http.StatusNotFound)
+		return
+	}
+	if room.Type != "group" || room.CreatedBy != claims.UserID {
+		http.Error(w, "Apenas o criador do grupo pode adicionar bots", http.StatusForbidden)
+		return
+	}
+
+	isMember, err := h.roomRepo.IsMember(r.Context(), room.ID, botID)
+	if err != nil {
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		if err := h.roomRepo.AddUserToGroup(r.Context(), room.ID, botID); err != nil {
+			log.Printf("Erro ao adicionar bot ao grupo: %v", err)
+			http.Error(w, "Erro ao adicionar bot", http.StatusInternalServerError)
+			return
+		}
+		if h.events != nil {
+			h.events.Publish(r.Context(), models.Event{
+				Type:   models.EventMemberJoined,
+				RoomID: room.ID,
+				Data:   models.MemberEvent{RoomID: room.ID, UserID: botID, ActorID: claims.UserID},
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Bot adicionado ao grupo"})
+}
+
+func (h *APITokenHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	tokens, err := h.tokens.ListByOwner(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Erro ao listar tokens: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateToken emite um token para o próprio usuário ou, com botId, para um
+// bot dele. O valor em claro só aparece nesta resposta.
+func (h *APITokenHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	var req struct {
+		Name          string   `json:"name"`
+		BotID         string   `json:"botId"`
+		Scopes        []string `json:"scopes"`
+		RoomIDs       []string `json:"roomIds"`
+		ExpiresInDays int      `json:"expiresInDays"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Nome deve ter entre 1 e 100 caracteres", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "Informe ao menos um escopo", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.APITokenScopes, scope) {
+			http.Error(w, "Escopo desconhecido: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.RoomIDs == nil {
+		req.RoomIDs = []string{}
+	}
+	for _, roomID := range req.RoomIDs {
+		if _, err := uuid.Parse(roomID); err != nil {
+			http.Error(w, "Sala inválida: "+roomID, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "Validade inválida", http.StatusBadRequest)
+		return
+	}
+
+	userID := claims.UserID
+	if req.BotID != "" {
+		owner, err := h.userRepo.IsBotOwner(r.Context(), req.BotID, claims.UserID)
+		if err != nil || !owner {
+			http.Error(w, "Bot não encontrado", http.StatusNotFound)
+			return
+		}
+		userID = req.BotID
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	token, secret, err := h.tokens.Create(r.Context(), userID, req.Name, req.Scopes, req.RoomIDs, expiresAt)
+	if err != nil {
+		log.Printf("Erro ao criar token: %v", err)
+		http.Error(w, "Erro ao criar token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"token":    secret,
+		"apiToken": token,
+	})
+}
+
+func (h *APITokenHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+
+	revoked, err := h.tokens.Revoke(r.Context(), r.PathValue("id"), claims.UserID)
+	if err != nil {
+		log.Printf("Erro ao revogar token: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "Token não encontrado", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// commandBot confere o bot do caminho para as rotas de comandos, que aceitam
+// tanto a sessão do dono quanto um token do próprio bot.
+func (h *APITokenHandler) commandBot(w http.ResponseWriter, r *http.Request) (string, bool) {
+	claims := auth.ClaimsFrom(r.Context())
+	botID := r.PathValue("id")
+	if botID == claims.UserID && APITokenFrom(r.Context()) != nil {
+		return botID, true
+	}
+
+	owner, err := h.userRepo.IsBotOwner(r.Context(), botID, claims.UserID)
+	if err != nil || !owner {
+		http.Error(w, "Bot não encontrado", http.StatusNotFound)
+		return "", false
+	}
+	return botID, true
+}
+
+func (h *APITokenHandler) ListCommands(w http.ResponseWriter, r *http.Request) {
+	botID, ok := h.commandBot(w, r)
+	if !ok {
+		return
+	}
+
+	commands, err := h.commands.ListByBot(r.Context(), botID)
+	if err != nil {
+		log.Printf("Erro ao listar comandos: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(commands)
+}
+
+// CreateCommand registra um comando de barra do bot. Com url, as invocações
+// são POSTs assinados com o secret devolvido aqui; sem url, chegam pelo
+// WebSocket do bot como eventos "command".
+func (h *APITokenHandler) CreateCommand(w http.ResponseWriter, r *http.Request) {
+	botID, ok := h.commandBot(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		URL         string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimPrefix(strings.TrimSpace(req.Name), "/")
+	if !messaging.CommandNamePattern.MatchString(req.Name) {
+		http.Error(w, "Nome deve ter até 32 letras minúsculas, números, - ou _", http.StatusBadRequest)
+		return
+	}
+	if messaging.IsBuiltinCommand(req.Name) {
+		http.Error(w, "Nome reservado para um comando embutido", http.StatusConflict)
+		return
+	}
+	if len(req.Description) > 200 {
+		http.Error(w, "Descrição deve ter até 200 caracteres", http.StatusBadRequest)
+		return
+	}
+	if req.URL != "" {
+		if err := safehttp.CheckURL(r.Context(), req.URL); err != nil {
+			http.Error(w, "URL deve ser http(s) e apontar para um endereço público", http.StatusBadRequest)
+			return
+		}
+	}
+
+	cmd := &models.BotCommand{BotID: botID, Name: req.Name, Description: req.Description, URL: req.URL}
+	secret, err := h.commands.Create(r.Context(), cmd)
+	if errors.Is(err, repository.ErrCommandExists) {
+		http.Error(w, "Já existe um comando com esse nome", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Erro ao registrar comando: %v", err)
+		http.Error(w, "Erro ao registrar comando", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"command": cmd,
+		"secret":  secret,
+	})
+}
+
+func (h *APITokenHandler) DeleteCommand(w http.ResponseWriter, r *http.Request) {
+	botID, ok := h.commandBot(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := h.commands.Delete(r.Context(), botID, r.PathValue("commandId"))
+	if err != nil {
+		log.Printf("Erro ao apagar comando: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Comando não encontrado", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// botRequest autentica a requisição com o token de API do próprio bot, que
+// dispensa a consulta ao dono no banco.
+func botRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	ctx := auth.WithClaims(r.Context(), &auth.Claims{UserID: "robo", Username: "robo"})
+	ctx = context.WithValue(ctx, apiTokenKey{}, &models.APIToken{UserID: "robo", Username: "robo"})
+	r = r.WithContext(ctx)
+	r.SetPathValue("id", "robo")
+	return r
+}
+
+func TestCreateCommandRefusesInternalURL(t *testing.T) {
+	h := &APITokenHandler{}
+	for _, u := range []string{"http://127.0.0.1:6379/", "http://localhost:8080/admin", "http://169.254.169.254/latest/meta-data/", "http://10.0.0.2/", "file:///etc/passwd"} {
+		w := httptest.NewRecorder()
+		h.CreateCommand(w, botRequest(http.MethodPost, "/api/bots/robo/commands", `{"name": "deploy", "url": "`+u+`"}`))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("url %s: status = %d, quer 400", u, w.Code)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/messaging"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+	"github.com/lucaspanzera1/chat/internal/repository"
+)
+
+const (
+	webhookMaxBody        = 64 << 10
+	webhookMaxAttachments = 10
+)
+
+// WebhookHandler gerencia os webhooks de entrada dos grupos e recebe os
+// posts deles em /hooks/{secret}.
+type WebhookHandler struct {
+	webhooks *repository.WebhookRepository
+	roomRepo *repository.RoomRepository
+	pipeline *messaging.Pipeline
+	appURL   string
+}
+
+func NewWebhookHandler(webhooks *repository.WebhookRepository, roomRepo *repository.RoomRepository, pipeline *messaging.Pipeline, appURL string) *WebhookHandler {
+	return &WebhookHandler{
+		webhooks: webhooks,
+		roomRepo: roomRepo,
+		pipeline: pipeline,
+		appURL:   strings.TrimRight(appURL, "/"),
+	}
+}
+
+// managedGroup devolve o grupo de {id} se o usuário for o criador; em caso
+// de erro já responde e retorna nil.
+func (h *WebhookHandler) managedGroup(w http.ResponseWriter, r *http.Request) *models.Room {
+	claims := auth.ClaimsFrom(r.Context())
+
+	room, err := h.roomRepo.GetByID(r.Context(), r.PathValue("id"))
+	if err != nil || room == nil {
+		http.Error(w, "Sala não encontrada", http.StatusNotFound)
+		return nil
+	}
+	if room.Type != "group" || room.CreatedBy != claims.UserID {
+		http.Error(w, "Apenas o criador do grupo gerencia webhooks", http.StatusForbidden)
+		return nil
+	}
+	return room
+}
+
+func (h *WebhookHandler) ListIncoming(w http.ResponseWriter, r *http.Request) {
+	room := h.managedGroup(w, r)
+	if room == nil {
+		return
+	}
+
+	hooks, err := h.webhooks.ListIncoming(r.Context(), room.ID)
+	if err != nil {
+		log.Printf("Erro ao listar webhooks: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+// CreateIncoming devolve a URL do webhook, que contém o segredo e só aparece
+// nesta resposta.
+func (h *WebhookHandler) CreateIncoming(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFrom(r.Context())
+	room := h.managedGroup(w, r)
+	if room == nil {
+		return
+	}
+
+	var req struct {
+		Name      string `json:"name"`
+		Username  string `json:"username"`
+		AvatarURL string `json:"avatarUrl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Dados inválidos", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Nome deve ter entre 1 e 100 caracteres", http.StatusBadRequest)
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" {
+		req.Username = req.Name
+	}
+	if len(req.Username) > 50 {
+		http.Error(w, "Nome de exibição deve ter até 50 caracteres", http.StatusBadRequest)
+		return
+	}
+	if !validWebhookUsername(req.Username) {
+		http.Error(w, "Nome de exibição não pode ter aspas nem < >", http.StatusBadRequest)
+		return
+	}
+	if req.AvatarURL != "" && !isHTTPURL(req.AvatarURL) {
+		http.Error(w, "Avatar deve ser uma URL http(s)", http.StatusBadRequest)
+		return
+	}
+
+	hook := &models.IncomingWebhook{
+		RoomID:    room.ID,
+		CreatedBy: claims.UserID,
+		Name:      req.Name,
+		Username:  req.Username,
+		AvatarURL: req.AvatarURL,
+	}
+	secret, err := h.webhooks.CreateIncoming(r.Context(), hook)
+	if err != nil {
+		log.Printf("Erro ao criar webhook: %v", err)
+		http.Error(w, "Erro ao criar webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"webhook": hook,
+		"url":     h.appURL + "/hooks/" + secret,
+	})
+}
+
+func (h *WebhookHandler) DeleteIncoming(w http.ResponseWriter, r *http.Request) {
+	room := h.managedGroup(w, r)
+	if room == nil {
+		return
+	}
+
+	deleted, err := h.webhooks.DeleteIncoming(r.Context(), room.ID, r.PathValue("hookId"))
+	if err != nil {
+		log.Printf("Erro ao apagar webhook: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Webhook não encontrado", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Receive atende POST /hooks/{secret}. Aceita o formato próprio em JSON e o
+// do Slack, inclusive como formulário com o campo payload. Clientes do Slack
+// esperam "ok" no corpo; no formato próprio a resposta é a mensagem criada.
+func (h *WebhookHandler) Receive(w http.ResponseWriter, r *http.Request) {
+	hook, err := h.webhooks.GetIncomingBySecret(r.Context(), r.PathValue("secret"))
+	if err != nil {
+		log.Printf("Erro ao buscar webhook: %v", err)
+		http.Error(w, "Erro interno", http.StatusInternalServerError)
+		return
+	}
+	if hook == nil {
+		http.Error(w, "Webhook não encontrado", http.StatusNotFound)
+		return
+	}
+
+	payload, slack, err := readWebhookPayload(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msg, err := payload.message(hook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := h.pipeline.Post(r.Context(), hook.RoomID, "webhook:"+hook.ID, msg)
+	if err != nil {
+		var perr *protocol.Error
+		if errors.As(err, &perr) {
+			setRetryAfter(w, perr.RetryAfter)
+			http.Error(w, perr.Message, protocol.HTTPStatus(perr.Code))
+			return
+		}
+		log.Printf("Erro ao publicar mensagem do webhook %s: %v", hook.ID, err)
+		http.Error(w, "Erro ao enviar mensagem", http.StatusInternalServerError)
+		return
+	}
+
+	if slack {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "ok")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// webhookPayload aceita os campos do formato próprio e os equivalentes do
+// Slack (icon_url, title_link, image_url, blocks).
+type webhookPayload struct {
+	Text        string              `json:"text"`
+	Username    string              `json:"username"`
+	AvatarURL   string              `json:"avatarUrl"`
+	IconURL     string              `json:"icon_url"`
+	Attachments []webhookAttachment `json:"attachments"`
+	Blocks      []struct {
+		Text *struct {
+			Text string `json:"text"`
+		} `json:"text"`
+	} `json:"blocks"`
+}
+
+type webhookAttachment struct {
+	Fallback  string                   `json:"fallback"`
+	Pretext   string                   `json:"pretext"`
+	Title     string                   `json:"title"`
+	URL       string                   `json:"url"`
+	TitleLink string                   `json:"title_link"`
+	Text      string                   `json:"text"`
+	Color     string                   `json:"color"`
+	ImageURL  string                   `json:"imageUrl"`
+	ImageURL2 string                   `json:"image_url"`
+	Fields    []models.AttachmentField `json:"fields"`
+}
+
+// readWebhookPayload decodifica o corpo e diz se ele veio no formato do
+// Slack (formulário ou campos exclusivos do Slack).
+func readWebhookPayload(w http.ResponseWriter, r *http.Request) (*webhookPayload, bool, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, webhookMaxBody)
+
+	var payload webhookPayload
+	slack := false
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if err := r.ParseForm(); err != nil {
+			return nil, false, errors.New("Formulário inválido")
+		}
+		if err := json.Unmarshal([]byte(r.PostForm.Get("payload")), &payload); err != nil {
+			return nil, false, errors.New("Campo payload inválido")
+		}
+		slack = true
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, false, errors.New("Corpo muito grande")
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, false, errors.New("JSON inválido")
+		}
+		var raw map[string]json.RawMessage
+		json.Unmarshal(body, &raw)
+		for _, key := range []string{"icon_url", "icon_emoji", "blocks", "channel", "mrkdwn"} {
+			if _, ok := raw[key]; ok {
+				slack = true
+			}
+		}
+	}
+
+	if slack {
+		payload.Text = slackToPlain(payload.Text)
+	}
+	return &payload, slack, nil
+}
+
+func (p *webhookPayload) message(hook *models.IncomingWebhook) (models.Message, error) {
+	msg := models.Message{
+		Username:  hook.Username,
+		AvatarURL: hook.AvatarURL,
+		Content:   p.Text,
+	}
+
+	if username := strings.TrimSpace(p.Username); username != "" {
+		if len(username) > 50 {
+			return msg, errors.New("username deve ter até 50 caracteres")
+		}
+		if !validWebhookUsername(username) {
+			return msg, errors.New("username não pode ter aspas nem < >")
+		}
+		msg.Username = username
+	}
+	for _, avatar := range []string{p.AvatarURL, p.IconURL} {
+		if avatar == "" {
+			continue
+		}
+		if !isHTTPURL(avatar) {
+			return msg, errors.New("Avatar deve ser uma URL http(s)")
+		}
+		msg.AvatarURL = avatar
+	}
+
+	if strings.TrimSpace(msg.Content) == "" {
+		var parts []string
+		for _, block := range p.Blocks {
+			if block.Text != nil && block.Text.Text != "" {
+				parts = append(parts, slackToPlain(block.Text.Text))
+			}
+		}
+		msg.Content = strings.Join(parts, "\n")
+	}
+
+	if len(p.Attachments) > webhookMaxAttachments {
+		return msg, fmt.Errorf("No máximo %d anexos por mensagem", webhookMaxAttachments)
+	}
+	for _, a := range p.Attachments {
+		if a.Pretext != "" {
+			msg.Content = strings.TrimSpace(msg.Content + "\n" + slackToPlain(a.Pretext))
+		}
+
+		attachment := models.Attachment{
+			Title:    a.Title,
+			URL:      firstNonEmpty(a.URL, a.TitleLink),
+			Text:     slackToPlain(firstNonEmpty(a.Text, a.Fallback)),
+			Color:    a.Color,
+			ImageURL: firstNonEmpty(a.ImageURL, a.ImageURL2),
+			Fields:   a.Fields,
+		}
+		if attachment.URL != "" && !isHTTPURL(attachment.URL) {
+			return msg, errors.New("Link do anexo deve ser uma URL http(s)")
+		}
+		if attachment.ImageURL != "" && !isHTTPURL(attachment.ImageURL) {
+			return msg, errors.New("Imagem do anexo deve ser uma URL http(s)")
+		}
+		msg.Attachments = append(msg.Attachments, attachment)
+	}
+
+	return msg, nil
+}
+
+var slackLink = regexp.MustCompile(`<([^|>]+)(?:\|([^>]+))?>`)
+
+// slackToPlain converte a marcação de links do Slack (<url|texto>) em texto
+// simples e desfaz os escapes &amp; &lt; &gt;.
+func slackToPlain(text string) string {
+	text = slackLink.ReplaceAllStringFunc(text, func(m string) string {
+		parts := slackLink.FindStringSubmatch(m)
+		target, label := parts[1], parts[2]
+		switch {
+		case strings.HasPrefix(target, "!"), strings.HasPrefix(target, "@"), strings.HasPrefix(target, "#"):
+			if label != "" {
+				return label
+			}
+			return "@" + strings.TrimLeft(target, "!@#")
+		case label != "" && label != target:
+			return label + " (" + target + ")"
+		default:
+			return target
+		}
+	})
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(text)
+}
+
+// isHTTPURL aceita só URLs http(s) absolutas, com host e sem aspas, sinais
+// de menor/maior ou espaços, que poderiam sair do atributo src no cliente.
+func isHTTPURL(s string) bool {
+	if strings.ContainsAny(s, "\"'<>` \t\r\n") {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.User == nil
+}
+
+// validWebhookUsername recusa nomes de exibição com aspas e sinais de
+// menor/maior, que o cliente web mostraria como HTML.
+func validWebhookUsername(name string) bool {
+	return !strings.ContainsAny(name, "\"'<>`")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
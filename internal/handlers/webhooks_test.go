@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+func parseWebhook(t *testing.T, contentType, body string) (models.Message, bool) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/hooks/x", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+
+	payload, slack, err := readWebhookPayload(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Fatalf("readWebhookPayload: %v", err)
+	}
+	msg, err := payload.message(&models.IncomingWebhook{Username: "CI", AvatarURL: "https://example.com/ci.png"})
+	if err != nil {
+		t.Fatalf("message: %v", err)
+	}
+	return msg, slack
+}
+
+func TestWebhookPayloadSimple(t *testing.T) {
+	msg, slack := parseWebhook(t, "application/json", `{
+		"text": "build #42 ok",
+		"username": "Jenkins",
+		"attachments": [{"title": "Log", "url": "https://ci.example.com/42", "color": "#36a64f",
+			"fields": [{"title": "branch", "value": "main"}]}]
+	}`)
+
+	if slack {
+		t.Error("formato próprio detectado como Slack")
+	}
+	if msg.Content != "build #42 ok" || msg.Username != "Jenkins" || msg.AvatarURL != "https://example.com/ci.png" {
+		t.Fatalf("mensagem inesperada: %+v", msg)
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0].URL != "https://ci.example.com/42" || msg.Attachments[0].Fields[0].Value != "main" {
+		t.Fatalf("anexos inesperados: %+v", msg.Attachments)
+	}
+}
+
+func TestWebhookPayloadSlack(t *testing.T) {
+	payload := `{"icon_url": "https://example.com/bot.png", "blocks": [{"type": "section",
+		"text": {"type": "mrkdwn", "text": "Deploy <https://app.example.com|app> &amp; <@U123>"}}],
+		"attachments": [{"fallback": "falhou", "title_link": "https://ci.example.com", "image_url": "https://example.com/g.png"}]}`
+	form := url.Values{"payload": {payload}}.Encode()
+
+	msg, slack := parseWebhook(t, "application/x-www-form-urlencoded", form)
+
+	if !slack {
+		t.Error("payload do Slack não detectado")
+	}
+	if msg.Content != "Deploy app (https://app.example.com) & @U123" {
+		t.Fatalf("content = %q", msg.Content)
+	}
+	if msg.Username != "CI" || msg.AvatarURL != "https://example.com/bot.png" {
+		t.Fatalf("username/avatar = %q %q", msg.Username, msg.AvatarURL)
+	}
+	a := msg.Attachments[0]
+	if a.Text != "falhou" || a.URL != "https://ci.example.com" || a.ImageURL != "https://example.com/g.png" {
+		t.Fatalf("anexo = %+v", a)
+	}
+}
+
+func TestWebhookPayloadRejectsBadURL(t *testing.T) {
+	bodies := map[string]string{
+		"javascript:":          `{"text": "x", "avatarUrl": "javascript:alert(1)"}`,
+		"aspas no avatar":      `{"text": "x", "avatarUrl": "https://x\" onerror=\"alert(1)"}`,
+		"aspas no icon_url":    `{"text": "x", "icon_url": "https://example.com/a.png'onerror='alert(1)"}`,
+		"sem host":             `{"text": "x", "avatarUrl": "https:///a.png"}`,
+		"só o prefixo":         `{"text": "x", "avatarUrl": "http://"}`,
+		"HTML no username":     `{"text": "x", "username": "<img src=x onerror=alert(1)>"}`,
+		"aspas no username":    `{"text": "x", "username": "CI\" onmouseover=\"alert(1)"}`,
+		"imagem do anexo ruim": `{"text": "x", "attachments": [{"image_url": "https://x/\"><script>"}]}`,
+	}
+	for name, body := range bodies {
+		req := httptest.NewRequest(http.MethodPost, "/hooks/x", strings.NewReader(body))
+		payload, _, err := readWebhookPayload(httptest.NewRecorder(), req)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if msg, err := payload.message(&models.IncomingWebhook{Username: "CI"}); err == nil {
+			t.Errorf("%s: aceito como %+v", name, msg)
+		}
+	}
+}
+
+func TestOutgoingWebhookRefusesInternalURL(t *testing.T) {
+	h := &OutgoingWebhookHandler{}
+	for _, u := range []string{"http://127.0.0.1:5432/", "http://localhost:8080/api/admin", "http://169.254.169.254/latest/meta-data/", "http://[::1]/", "http://192.168.0.1/", "gopher://example.com/"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/webhooks", strings.NewReader(`{"url": "`+u+`"}`))
+		r = r.WithContext(auth.WithClaims(r.Context(), &auth.Claims{UserID: "alice", Username: "alice"}))
+		w := httptest.NewRecorder()
+		h.Create(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("url %s: status = %d, quer 400", u, w.Code)
+		}
+	}
+}
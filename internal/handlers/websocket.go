@@ -1,70 +1,113 @@
 package handlers
 
 import (
+	"compress/flate"
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/gorilla/websocket"
 	"github.com/lucaspanzera1/chat/internal/auth"
 	"github.com/lucaspanzera1/chat/internal/client"
 	"github.com/lucaspanzera1/chat/internal/hub"
+	"github.com/lucaspanzera1/chat/internal/messaging"
 	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
 	"github.com/lucaspanzera1/chat/internal/repository"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// ConnectionUserStore é o subconjunto do UserRepository usado pelos
+// transportes em tempo real.
+type ConnectionUserStore interface {
+	GetByID(ctx context.Context, id string) (*models.User, error)
+	SetOnline(ctx context.Context, userID string) error
+	SetOffline(ctx context.Context, userID string) error
 }
 
 type WSHandler struct {
-	hub         *hub.Hub
-	userRepo    *repository.UserRepository
-	messageRepo *repository.MessageRepository
+	upgrader         websocket.Upgrader
+	compressionLevel int
+	hub              *hub.Hub
+	userRepo         ConnectionUserStore
+	pipeline         *messaging.Pipeline
+	tokens           APITokenStore
+	conns            sync.WaitGroup
 }
 
-func NewWSHandler(h *hub.Hub, userRepo *repository.UserRepository, messageRepo *repository.MessageRepository) *WSHandler {
+func NewWSHandler(h *hub.Hub, userRepo ConnectionUserStore, pipeline *messaging.Pipeline) *WSHandler {
+	enabled, level := compressionFromEnv()
+
 	return &WSHandler{
-		hub:         h,
-		userRepo:    userRepo,
-		messageRepo: messageRepo,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:    1024,
+			WriteBufferSize:   1024,
+			Subprotocols:      protocol.Subprotocols,
+			EnableCompression: enabled,
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+		compressionLevel: level,
+		hub:              h,
+		userRepo:         userRepo,
+		pipeline:         pipeline,
 	}
 }
 
-func (wsh *WSHandler) ServeWS(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
-	roomID := r.URL.Query().Get("roomId")
+// SetAPITokens habilita a conexão com tokens de API (bots e integrações).
+func (wsh *WSHandler) SetAPITokens(tokens APITokenStore) {
+	wsh.tokens = tokens
+}
 
-	if roomID == "" {
-		roomID = "00000000-0000-0000-0000-000000000001" // Sala geral
+// compressionFromEnv lê WS_COMPRESSION (permessage-deflate, ativo por padrão)
+// e WS_COMPRESSION_LEVEL (1 a 9, padrão flate.BestSpeed).
+func compressionFromEnv() (bool, int) {
+	enabled := true
+	if v := os.Getenv("WS_COMPRESSION"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Printf("Aviso: WS_COMPRESSION inválido (%q), mantendo compressão ativa", v)
+		} else {
+			enabled = b
+		}
 	}
 
-	if token == "" {
-		http.Error(w, "Token não fornecido", http.StatusUnauthorized)
-		return
+	level := flate.BestSpeed
+	if v := os.Getenv("WS_COMPRESSION_LEVEL"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < flate.BestSpeed || l > flate.BestCompression {
+			log.Printf("Aviso: WS_COMPRESSION_LEVEL inválido (%q), usando %d", v, flate.BestSpeed)
+		} else {
+			level = l
+		}
 	}
 
-	claims, err := auth.ValidateToken(token)
-	if err != nil {
-		http.Error(w, "Token inválido", http.StatusUnauthorized)
+	return enabled, level
+}
+
+func (wsh *WSHandler) ServeWS(w http.ResponseWriter, r *http.Request) {
+	user, apiToken, ok := authenticateConnection(w, r, wsh.userRepo, wsh.tokens, models.ScopeRealtime)
+	if !ok {
 		return
 	}
-
-	user, err := wsh.userRepo.GetByID(context.Background(), claims.UserID)
-	if err != nil || user == nil {
-		http.Error(w, "Usuário não encontrado", http.StatusUnauthorized)
+	roomID := connectionRoomID(r)
+	if !tokenAllowsRoom(w, apiToken, roomID) || !authorizeRoom(w, r, wsh.pipeline, roomID, user.ID) {
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := wsh.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	if err := conn.SetCompressionLevel(wsh.compressionLevel); err != nil {
+		log.Printf("Erro ao configurar compressão: %v", err)
+	}
 
 	avatarURL := ""
 	if user.AvatarURL != nil {
@@ -74,27 +117,124 @@ func (wsh *WSHandler) ServeWS(w http.ResponseWriter, r *http.Request) {
 	c := &client.Client{
 		Hub:       wsh.hub,
 		Conn:      conn,
-		Send:      make(chan models.Message, 256),
+		Send:      make(chan *hub.Frame, 256),
 		Username:  user.Username,
 		UserID:    user.ID,
 		RoomID:    roomID,
 		AvatarURL: avatarURL,
+		Codec:     protocol.CodecFor(conn.Subprotocol()),
+		Direct:    make(chan []byte, 16),
+		IsBot:     user.IsBot,
+		ReadOnly:  apiToken != nil && !apiToken.HasScope(models.ScopeMessagesWrite),
 	}
 
 	if err := wsh.userRepo.SetOnline(context.Background(), user.ID); err != nil {
 		log.Printf("Erro ao marcar usuário online: %v", err)
 	}
 
-	wsh.hub.Register <- c
+	wsh.conns.Add(1)
+	wsh.hub.Register(c)
 
 	unregisterFunc := func(client *client.Client) {
+		defer wsh.conns.Done()
 
 		if err := wsh.userRepo.SetOffline(context.Background(), client.UserID); err != nil {
 			log.Printf("Erro ao marcar usuário offline: %v", err)
 		}
-		wsh.hub.Unregister <- client
+		wsh.hub.Unregister(client)
 	}
 
 	go c.WritePump()
-	go c.ReadPump(wsh.hub.Broadcast, unregisterFunc, wsh.messageRepo)
+	go c.ReadPump(wsh.pipeline, unregisterFunc)
+}
+
+// authenticateConnection é usado por todos os transportes (WebSocket, SSE e
+// long-polling). O token vem da query string, já que nem WebSocket nem
+// EventSource permitem headers no navegador, ou do header Authorization.
+// Tokens de API precisam do escopo pedido e voltam junto com o usuário.
+// Com REQUIRE_EMAIL_VERIFICATION=true contas sem email confirmado são recusadas.
+func authenticateConnection(w http.ResponseWriter, r *http.Request, userRepo ConnectionUserStore, tokens APITokenStore, scope string) (*models.User, *models.APIToken, bool) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = auth.BearerToken(r.Header.Get("Authorization"))
+	}
+
+	if token == "" {
+		http.Error(w, "Token não fornecido", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	var userID string
+	var apiToken *models.APIToken
+	if strings.HasPrefix(token, repository.APITokenPrefix) {
+		var ok bool
+		apiToken, ok = authenticateAPIToken(w, r.Context(), tokens, token, scope)
+		if !ok {
+			return nil, nil, false
+		}
+		userID = apiToken.UserID
+	} else {
+		claims, err := auth.ValidateToken(token)
+		if err != nil {
+			http.Error(w, "Token inválido", http.StatusUnauthorized)
+			return nil, nil, false
+		}
+		userID = claims.UserID
+	}
+
+	user, err := userRepo.GetByID(context.Background(), userID)
+	if err != nil || user == nil {
+		http.Error(w, "Usuário não encontrado", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	if os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true" && !user.EmailVerified {
+		http.Error(w, "Confirme seu email para entrar no chat", http.StatusForbidden)
+		return nil, nil, false
+	}
+
+	return user, apiToken, true
+}
+
+// authorizeRoom recusa a conexão antes do upgrade para que não-membros não
+// recebam eventos de salas privadas.
+func authorizeRoom(w http.ResponseWriter, r *http.Request, pipeline *messaging.Pipeline, roomID, userID string) bool {
+	err := pipeline.CanAccess(r.Context(), roomID, userID)
+	if err == nil {
+		return true
+	}
+
+	var perr *protocol.Error
+	if errors.As(err, &perr) {
+		http.Error(w, perr.Message, protocol.HTTPStatus(perr.Code))
+		return false
+	}
+
+	log.Printf("Erro ao verificar acesso à sala: %v", err)
+	http.Error(w, "Erro ao verificar acesso à sala", http.StatusInternalServerError)
+	return false
+}
+
+func connectionRoomID(r *http.Request) string {
+	roomID := r.URL.Query().Get("roomId")
+	if roomID == "" {
+		roomID = "00000000-0000-0000-0000-000000000001" // Sala geral
+	}
+	return roomID
+}
+
+// Drain espera todas as conexões WebSocket encerrarem seus ReadPumps.
+func (wsh *WSHandler) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		wsh.conns.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
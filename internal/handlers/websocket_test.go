@@ -0,0 +1,1100 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/lucaspanzera1/chat/internal/auth"
+	"github.com/lucaspanzera1/chat/internal/hub"
+	"github.com/lucaspanzera1/chat/internal/messaging"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+	"github.com/lucaspanzera1/chat/internal/ratelimit"
+)
+
+const (
+	generalRoom = "00000000-0000-0000-0000-000000000001"
+	privateRoom = "00000000-0000-0000-0000-0000000000aa"
+)
+
+type fakeUsers struct {
+	users map[string]*models.User
+}
+
+func (f *fakeUsers) GetByID(ctx context.Context, id string) (*models.User, error) {
+	return f.users[id], nil
+}
+
+func (f *fakeUsers) SetOnline(ctx context.Context, userID string) error  { return nil }
+func (f *fakeUsers) SetOffline(ctx context.Context, userID string) error { return nil }
+
+type fakeRooms struct {
+	mu      sync.Mutex
+	rooms   map[string]*models.Room
+	members map[string]bool
+}
+
+func (f *fakeRooms) GetByID(ctx context.Context, roomID string) (*models.Room, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rooms[roomID], nil
+}
+
+func (f *fakeRooms) IsMember(ctx context.Context, roomID, userID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.members[roomID+":"+userID], nil
+}
+
+func (f *fakeRooms) setMember(roomID, userID string, member bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.members[roomID+":"+userID] = member
+}
+
+type fakeMessages struct {
+	mu       sync.Mutex
+	messages []models.Message
+}
+
+func (f *fakeMessages) Create(ctx context.Context, msg *models.Message, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, *msg)
+	return nil
+}
+
+func (f *fakeMessages) Update(ctx context.Context, roomID, id, userID, content, encryption string) (*models.Message, error) {
+	return nil, nil
+}
+
+func (f *fakeMessages) Delete(ctx context.Context, roomID, id, userID string) (bool, error) {
+	return false, nil
+}
+
+type protocolServer struct {
+	srv      *httptest.Server
+	hub      *hub.Hub
+	users    *fakeUsers
+	rooms    *fakeRooms
+	pipeline *messaging.Pipeline
+	tokens   map[string]string
+}
+
+func newProtocolServer(t *testing.T) *protocolServer {
+	t.Helper()
+
+	users := &fakeUsers{users: map[string]*models.User{
+		"alice": {ID: "alice", Username: "alice", Email: "alice@example.com"},
+		"bob":   {ID: "bob", Username: "bob", Email: "bob@example.com"},
+		"robo":  {ID: "robo", Username: "robo", Email: "robo@bots.local", IsBot: true},
+	}}
+	rooms := &fakeRooms{
+		rooms: map[string]*models.Room{
+			generalRoom: {ID: generalRoom, Type: "general"},
+			privateRoom: {ID: privateRoom, Type: "private"},
+		},
+		members: map[string]bool{privateRoom + ":alice": true},
+	}
+
+	h := hub.NewShardedHub(1)
+	go h.Run()
+
+	pipeline := messaging.NewPipeline(&fakeMessages{}, rooms, h.Broadcast)
+	pipeline.SetTargeted(h)
+	wsh := NewWSHandler(h, users, pipeline)
+	srv := httptest.NewServer(http.HandlerFunc(wsh.ServeWS))
+	t.Cleanup(srv.Close)
+
+	tokens := make(map[string]string)
+	for id, u := range users.users {
+		token, err := auth.GenerateToken(u)
+		if err != nil {
+			t.Fatalf("GenerateToken: %v", err)
+		}
+		tokens[id] = token
+	}
+
+	return &protocolServer{srv: srv, hub: h, users: users, rooms: rooms, pipeline: pipeline, tokens: tokens}
+}
+
+func (ps *protocolServer) dial(t *testing.T, user, roomID string, subprotocols ...string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+
+	u := "ws" + strings.TrimPrefix(ps.srv.URL, "http") + "/ws?roomId=" + url.QueryEscape(roomID)
+	if user != "" {
+		u += "&token=" + url.QueryEscape(ps.tokens[user])
+	}
+
+	dialer := websocket.Dialer{Subprotocols: subprotocols, HandshakeTimeout: 2 * time.Second}
+	conn, resp, err := dialer.Dial(u, nil)
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+func (ps *protocolServer) mustDial(t *testing.T, user, roomID string, subprotocols ...string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := ps.dial(t, user, roomID, subprotocols...)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	return conn
+}
+
+type envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// readEnvelope ignora eventos de contagem, que chegam a cada entrada na sala.
+func readEnvelope(t *testing.T, conn *websocket.Conn) envelope {
+	t.Helper()
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var env envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			t.Fatalf("frame não é um envelope: %s", data)
+		}
+		if env.Type == "count" {
+			continue
+		}
+		return env
+	}
+}
+
+func send(t *testing.T, conn *websocket.Conn, v any) {
+	t.Helper()
+	if err := conn.WriteJSON(v); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func expectError(t *testing.T, conn *websocket.Conn, id, code string) {
+	t.Helper()
+	env := readEnvelope(t, conn)
+	if env.Type != protocol.TypeError {
+		t.Fatalf("esperado frame error, recebido %q", env.Type)
+	}
+	if env.ID != id {
+		t.Fatalf("id do erro = %q, esperado %q", env.ID, id)
+	}
+	var payload protocol.ErrorPayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		t.Fatalf("payload de erro inválido: %v", err)
+	}
+	if payload.Code != code {
+		t.Fatalf("código = %q, esperado %q", payload.Code, code)
+	}
+	if payload.Message == "" {
+		t.Fatal("erro sem mensagem")
+	}
+}
+
+func TestProtocolRequiresToken(t *testing.T) {
+	ps := newProtocolServer(t)
+
+	_, resp, err := ps.dial(t, "", generalRoom, protocol.V1)
+	if err == nil {
+		t.Fatal("conexão sem token foi aceita")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status = %v, esperado 401", resp)
+	}
+}
+
+func TestProtocolNegotiation(t *testing.T) {
+	ps := newProtocolServer(t)
+
+	conn := ps.mustDial(t, "alice", generalRoom, "chat.v99", protocol.V1)
+	if got := conn.Subprotocol(); got != protocol.V1 {
+		t.Fatalf("subprotocolo = %q, esperado %q", got, protocol.V1)
+	}
+
+	legacy := ps.mustDial(t, "alice", generalRoom, "chat.v99")
+	if got := legacy.Subprotocol(); got != "" {
+		t.Fatalf("subprotocolo desconhecido negociado: %q", got)
+	}
+}
+
+func TestProtocolSendAckAndBroadcast(t *testing.T) {
+	ps := newProtocolServer(t)
+
+	alice := ps.mustDial(t, "alice", generalRoom, protocol.V1)
+	bob := ps.mustDial(t, "bob", generalRoom, protocol.V1)
+
+	send(t, alice, map[string]any{"type": "message.send", "id": "req-1", "payload": map[string]string{"content": "olá"}})
+
+	var messageID string
+	for i := 0; i < 2; i++ {
+		env := readEnvelope(t, alice)
+		switch env.Type {
+		case protocol.TypeAck:
+			if env.ID != "req-1" {
+				t.Fatalf("ack com id %q", env.ID)
+			}
+			var ack protocol.AckPayload
+			json.Unmarshal(env.Payload, &ack)
+			if ack.MessageID == "" {
+				t.Fatal("ack sem messageId")
+			}
+			if messageID != "" && messageID != ack.MessageID {
+				t.Fatalf("ack %q não corresponde à mensagem %q", ack.MessageID, messageID)
+			}
+			messageID = ack.MessageID
+		case "message":
+			if messageID != "" && messageID != env.ID {
+				t.Fatalf("mensagem %q não corresponde ao ack %q", env.ID, messageID)
+			}
+			messageID = env.ID
+		default:
+			t.Fatalf("frame inesperado: %q", env.Type)
+		}
+	}
+
+	env := readEnvelope(t, bob)
+	if env.Type != "message" || env.ID != messageID {
+		t.Fatalf("bob recebeu %q/%q, esperado message/%q", env.Type, env.ID, messageID)
+	}
+	var msg models.Message
+	if err := json.Unmarshal(env.Payload, &msg); err != nil {
+		t.Fatalf("payload da mensagem: %v", err)
+	}
+	if msg.Content != "olá" || msg.Username != "alice" || msg.RoomID != generalRoom {
+		t.Fatalf("mensagem inesperada: %+v", msg)
+	}
+}
+
+type fakeCommands map[string]*models.BotCommand
+
+func (f fakeCommands) GetCommand(ctx context.Context, name string) (*models.BotCommand, error) {
+	return f[name], nil
+}
+
+// readUntil descarta frames até chegar um do tipo pedido.
+func readUntil(t *testing.T, conn *websocket.Conn, typ string) envelope {
+	t.Helper()
+	for {
+		if env := readEnvelope(t, conn); env.Type == typ {
+			return env
+		}
+	}
+}
+
+func TestProtocolSlashCommands(t *testing.T) {
+	ps := newProtocolServer(t)
+	ps.pipeline.SetCommands(messaging.CommandConfig{
+		Store: fakeCommands{"deploy": {ID: "c1", BotID: "bob", BotUsername: "bob", Name: "deploy"}},
+	})
+
+	alice := ps.mustDial(t, "alice", generalRoom, protocol.V1)
+	bot := ps.mustDial(t, "bob", generalRoom, protocol.V1)
+
+	// Comando embutido: a resposta vai só para quem invocou.
+	send(t, alice, map[string]any{"type": "message.send", "id": "req-1", "payload": map[string]string{"content": "/help"}})
+	env := readUntil(t, alice, "ephemeral")
+	var help models.Message
+	json.Unmarshal(env.Payload, &help)
+	if !strings.Contains(help.Content, "/topic") {
+		t.Fatalf("/help sem a lista de comandos: %q", help.Content)
+	}
+	readUntil(t, alice, protocol.TypeAck)
+
+	// Comando de bot pelo WebSocket: o bot recebe "command" e responde.
+	send(t, alice, map[string]any{"type": "message.send", "id": "req-2", "payload": map[string]string{"content": "/deploy prod"}})
+	env = readUntil(t, bot, "command")
+	var invocation models.Message
+	json.Unmarshal(env.Payload, &invocation)
+	if invocation.Content != "/deploy prod" || invocation.Username != "alice" {
+		t.Fatalf("invocação inesperada: %+v", invocation)
+	}
+
+	send(t, bot, map[string]any{"type": "command.respond", "id": "r-1", "payload": map[string]any{
+		"invocationId": env.ID, "text": "deploy iniciado", "ephemeral": true,
+	}})
+	readUntil(t, bot, protocol.TypeAck)
+
+	env = readUntil(t, alice, "ephemeral")
+	var reply models.Message
+	json.Unmarshal(env.Payload, &reply)
+	if reply.Content != "deploy iniciado" || reply.Username != "bob" || !reply.Bot {
+		t.Fatalf("resposta inesperada: %+v", reply)
+	}
+	readUntil(t, alice, protocol.TypeAck)
+
+	// "//" escapa a barra e a mensagem segue para a sala.
+	send(t, alice, map[string]any{"type": "message.send", "id": "req-3", "payload": map[string]string{"content": "//deploy não é comando"}})
+	env = readUntil(t, bot, "message")
+	var msg models.Message
+	json.Unmarshal(env.Payload, &msg)
+	if msg.Content != "/deploy não é comando" {
+		t.Fatalf("conteúdo = %q", msg.Content)
+	}
+
+	// Respostas fora de uma invocação pendente são recusadas.
+	send(t, bot, map[string]any{"type": "command.respond", "id": "r-2", "payload": map[string]any{"invocationId": "x", "text": "?"}})
+	expectError(t, bot, "r-2", protocol.CodeValidation)
+}
+
+func TestProtocolEphemeral(t *testing.T) {
+	ps := newProtocolServer(t)
+
+	alice := ps.mustDial(t, "alice", generalRoom, protocol.V1)
+	alicePrivate := ps.mustDial(t, "alice", privateRoom, protocol.V1)
+	bob := ps.mustDial(t, "bob", generalRoom, protocol.V1)
+	bot := ps.mustDial(t, "robo", generalRoom, protocol.V1)
+
+	// Só o destinatário recebe, e só na sala da mensagem.
+	send(t, bot, map[string]any{"type": "ephemeral.send", "id": "e-1", "payload": map[string]any{
+		"userIds": []string{"alice"}, "content": "só para você",
+	}})
+	readUntil(t, bot, protocol.TypeAck)
+
+	env := readUntil(t, alice, "ephemeral")
+	var msg models.Message
+	json.Unmarshal(env.Payload, &msg)
+	if msg.Content != "só para você" || msg.Username != "robo" || !msg.Bot {
+		t.Fatalf("mensagem efêmera inesperada: %+v", msg)
+	}
+
+	// Usuários comuns não enviam mensagens efêmeras.
+	send(t, bob, map[string]any{"type": "ephemeral.send", "id": "e-2", "payload": map[string]any{
+		"userIds": []string{"alice"}, "content": "psst",
+	}})
+	expectError(t, bob, "e-2", protocol.CodeForbidden)
+
+	// Avisos do sistema alcançam todas as conexões do usuário.
+	if n := ps.pipeline.Notify(generalRoom, []string{"alice"}, "aviso"); n != 2 {
+		t.Fatalf("Notify alcançou %d conexões, esperado 2", n)
+	}
+	for _, conn := range []*websocket.Conn{alice, alicePrivate} {
+		env := readUntil(t, conn, "ephemeral")
+		json.Unmarshal(env.Payload, &msg)
+		if msg.Content != "aviso" || msg.RoomID != generalRoom {
+			t.Fatalf("aviso inesperado: %+v", msg)
+		}
+	}
+
+	// bob só vê o próprio erro: nenhuma das mensagens acima chegou a ele.
+	send(t, bob, map[string]any{"type": "ping", "id": "p"})
+	if env := readEnvelope(t, bob); env.Type != protocol.TypePong {
+		t.Fatalf("bob recebeu %q antes do pong", env.Type)
+	}
+}
+
+// fakePolls guarda as enquetes em memória com a mesma semântica do
+// repositório: escolha única troca o voto e enquetes fechadas não mudam.
+type fakePolls struct {
+	mu     sync.Mutex
+	polls  map[string]*models.Poll
+	votes  map[string]map[string][]int64 // enquete → usuário → opções
+	nextID int64
+}
+
+func (f *fakePolls) Create(ctx context.Context, poll *models.Poll, msg *models.Message, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll.ID = "poll-" + msg.ID
+	poll.MessageID, poll.RoomID, poll.CreatedBy = msg.ID, msg.RoomID, userID
+	for i := range poll.Options {
+		f.nextID++
+		poll.Options[i].ID = f.nextID
+	}
+	stored := *poll
+	stored.Options = append([]models.PollOption(nil), poll.Options...)
+	f.polls[poll.ID] = &stored
+	f.votes[poll.ID] = make(map[string][]int64)
+	return nil
+}
+
+func (f *fakePolls) Get(ctx context.Context, pollID, viewerID string) (*models.Poll, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored := f.polls[pollID]
+	if stored == nil {
+		return nil, nil
+	}
+
+	poll := *stored
+	poll.Options = append([]models.PollOption(nil), stored.Options...)
+	poll.Voters = 0
+	for userID, options := range f.votes[pollID] {
+		if len(options) > 0 {
+			poll.Voters++
+		}
+		for _, id := range options {
+			for i := range poll.Options {
+				if poll.Options[i].ID == id {
+					poll.Options[i].Votes++
+					if !poll.Anonymous {
+						poll.Options[i].VotedBy = append(poll.Options[i].VotedBy, models.PollVoter{UserID: userID, Username: userID})
+					}
+				}
+			}
+		}
+	}
+	if viewerID != "" {
+		poll.MyVotes = f.votes[pollID][viewerID]
+	}
+	return &poll, nil
+}
+
+func (f *fakePolls) Vote(ctx context.Context, pollID, userID string, optionIDs []int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll := f.polls[pollID]
+	if poll == nil || poll.Closed(time.Now()) {
+		return false, nil
+	}
+	if poll.Multiple {
+		f.votes[pollID][userID] = append(f.votes[pollID][userID], optionIDs...)
+	} else {
+		f.votes[pollID][userID] = optionIDs
+	}
+	return true, nil
+}
+
+func (f *fakePolls) Unvote(ctx context.Context, pollID, userID string, optionID int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	before := len(f.votes[pollID][userID])
+	var kept []int64
+	for _, id := range f.votes[pollID][userID] {
+		if optionID != 0 && id != optionID {
+			kept = append(kept, id)
+		}
+	}
+	f.votes[pollID][userID] = kept
+	return len(kept) != before, nil
+}
+
+func (f *fakePolls) Close(ctx context.Context, pollID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll := f.polls[pollID]
+	if poll == nil || poll.ClosedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	poll.ClosedAt = &now
+	return true, nil
+}
+
+func (f *fakePolls) CloseDue(ctx context.Context) ([]string, error) { return nil, nil }
+
+func TestProtocolPolls(t *testing.T) {
+	ps := newProtocolServer(t)
+	ps.pipeline.SetCommands(messaging.CommandConfig{})
+	ps.pipeline.SetPolls(&fakePolls{polls: make(map[string]*models.Poll), votes: make(map[string]map[string][]int64)})
+
+	alice := ps.mustDial(t, "alice", generalRoom, protocol.V1)
+	bob := ps.mustDial(t, "bob", generalRoom, protocol.V1)
+
+	// /poll publica uma mensagem "poll" para a sala inteira.
+	send(t, alice, map[string]any{"type": "message.send", "id": "p-1", "payload": map[string]string{
+		"content": "/poll Almoço? | Pizza | Sushi | Salada",
+	}})
+	env := readUntil(t, bob, "poll")
+	var msg models.Message
+	json.Unmarshal(env.Payload, &msg)
+	if msg.Poll == nil || msg.Poll.Question != "Almoço?" || len(msg.Poll.Options) != 3 || msg.Poll.Multiple {
+		t.Fatalf("enquete inesperada: %+v", msg.Poll)
+	}
+	readUntil(t, alice, protocol.TypeAck)
+	poll := msg.Poll
+	pizza, sushi := poll.Options[0].ID, poll.Options[1].ID
+
+	bobSender := messaging.Sender{UserID: "bob", Username: "bob"}
+	ctx := context.Background()
+
+	// Cada voto gera "poll_updated" com os resultados, sem os votos pessoais.
+	if _, err := ps.pipeline.Vote(ctx, bobSender, poll.ID, []int64{pizza}); err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+	updated := readPollUpdate(t, alice)
+	if updated.Options[0].Votes != 1 || updated.Voters != 1 || len(updated.MyVotes) != 0 {
+		t.Fatalf("resultado após o voto: %+v", updated)
+	}
+	if len(updated.Options[0].VotedBy) != 1 || updated.Options[0].VotedBy[0].UserID != "bob" {
+		t.Fatalf("enquete pública sem o votante: %+v", updated.Options[0])
+	}
+
+	// Escolha única: o novo voto substitui o anterior.
+	result, err := ps.pipeline.Vote(ctx, bobSender, poll.ID, []int64{sushi})
+	if err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+	if len(result.MyVotes) != 1 || result.MyVotes[0] != sushi {
+		t.Fatalf("myVotes = %v, esperado [%d]", result.MyVotes, sushi)
+	}
+	updated = readPollUpdate(t, alice)
+	if updated.Options[0].Votes != 0 || updated.Options[1].Votes != 1 {
+		t.Fatalf("voto não foi trocado: %+v", updated.Options)
+	}
+	if _, err := ps.pipeline.Vote(ctx, bobSender, poll.ID, []int64{pizza, sushi}); err != messaging.ErrPollOption {
+		t.Fatalf("dois votos em escolha única: err = %v", err)
+	}
+
+	if _, err := ps.pipeline.Unvote(ctx, bobSender, poll.ID, 0); err != nil {
+		t.Fatalf("Unvote: %v", err)
+	}
+	if updated = readPollUpdate(t, alice); updated.Voters != 0 {
+		t.Fatalf("voto não foi retirado: %+v", updated)
+	}
+
+	// Só quem criou encerra; depois disso ninguém vota.
+	if _, err := ps.pipeline.ClosePoll(ctx, bobSender, poll.ID); err == nil {
+		t.Fatal("bob encerrou a enquete de alice")
+	}
+	if _, err := ps.pipeline.ClosePoll(ctx, messaging.Sender{UserID: "alice", Username: "alice"}, poll.ID); err != nil {
+		t.Fatalf("ClosePoll: %v", err)
+	}
+	// bob ainda tem os eventos dos votos na fila; o último traz closedAt.
+	for updated = readPollUpdate(t, bob); updated.ClosedAt == nil; updated = readPollUpdate(t, bob) {
+	}
+	if _, err := ps.pipeline.Vote(ctx, bobSender, poll.ID, []int64{pizza}); err != messaging.ErrPollClosed {
+		t.Fatalf("voto em enquete encerrada: err = %v", err)
+	}
+
+	// Erros de sintaxe voltam só para quem invocou.
+	send(t, alice, map[string]any{"type": "message.send", "id": "p-2", "payload": map[string]string{"content": "/poll Só pergunta"}})
+	env = readUntil(t, alice, "ephemeral")
+	json.Unmarshal(env.Payload, &msg)
+	if !strings.HasPrefix(msg.Content, "Uso: /poll") {
+		t.Fatalf("resposta = %q", msg.Content)
+	}
+}
+
+func readPollUpdate(t *testing.T, conn *websocket.Conn) *models.Poll {
+	t.Helper()
+	env := readUntil(t, conn, "poll_updated")
+	var msg models.Message
+	if err := json.Unmarshal(env.Payload, &msg); err != nil || msg.Poll == nil {
+		t.Fatalf("poll_updated sem enquete: %s", env.Payload)
+	}
+	return msg.Poll
+}
+
+// fakeScheduled é a fila de agendamentos em memória; due adianta todos os
+// pendentes para agora.
+type fakeScheduled struct {
+	mu    sync.Mutex
+	items map[string]*models.ScheduledMessage
+}
+
+func (f *fakeScheduled) Create(ctx context.Context, s *models.ScheduledMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s.ID = uuid.New().String()
+	s.Status = models.ScheduledPending
+	s.CreatedAt = time.Now()
+	item := *s
+	f.items[s.ID] = &item
+	return nil
+}
+
+func (f *fakeScheduled) CountPending(ctx context.Context, userID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, item := range f.items {
+		if item.UserID == userID && item.Status == models.ScheduledPending {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeScheduled) List(ctx context.Context, userID, status string) ([]models.ScheduledMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var items []models.ScheduledMessage
+	for _, item := range f.items {
+		if item.UserID == userID && (status == "" || item.Status == status) {
+			items = append(items, *item)
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeScheduled) Get(ctx context.Context, id, userID string) (*models.ScheduledMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if item := f.items[id]; item != nil && item.UserID == userID {
+		found := *item
+		return &found, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeScheduled) Update(ctx context.Context, id, userID, content string, sendAt time.Time, timezone string) (*models.ScheduledMessage, error) {
+	f.mu.Lock()
+	item := f.items[id]
+	if item == nil || item.UserID != userID || item.Status != models.ScheduledPending {
+		f.mu.Unlock()
+		return nil, nil
+	}
+	item.Content, item.SendAt, item.Timezone = content, sendAt, timezone
+	f.mu.Unlock()
+	return f.Get(ctx, id, userID)
+}
+
+func (f *fakeScheduled) Cancel(ctx context.Context, id, userID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item := f.items[id]
+	if item == nil || item.UserID != userID || item.Status != models.ScheduledPending {
+		return false, nil
+	}
+	item.Status = models.ScheduledCanceled
+	return true, nil
+}
+
+func (f *fakeScheduled) Claim(ctx context.Context, limit int, lease time.Duration) ([]models.ScheduledMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var items []models.ScheduledMessage
+	for _, item := range f.items {
+		if item.Status == models.ScheduledPending && !item.SendAt.After(time.Now()) && len(items) < limit {
+			items = append(items, *item)
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeScheduled) Complete(ctx context.Context, id, status, lastError string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[id].Status, f.items[id].LastError = status, lastError
+	return nil
+}
+
+func (f *fakeScheduled) Retry(ctx context.Context, id string, at time.Time, lastError string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[id].SendAt, f.items[id].LastError = at, lastError
+	return nil
+}
+
+func (f *fakeScheduled) MessageRoom(ctx context.Context, messageID string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeScheduled) due() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, item := range f.items {
+		item.SendAt = time.Now().Add(-time.Second)
+	}
+}
+
+func (f *fakeScheduled) status(id string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.items[id].Status
+}
+
+func TestProtocolScheduled(t *testing.T) {
+	ps := newProtocolServer(t)
+	ps.pipeline.SetCommands(messaging.CommandConfig{})
+	store := &fakeScheduled{items: make(map[string]*models.ScheduledMessage)}
+	ps.pipeline.SetScheduler(store, ps.users)
+
+	alice := ps.mustDial(t, "alice", generalRoom, protocol.V1)
+	bob := ps.mustDial(t, "bob", generalRoom, protocol.V1)
+	ctx := context.Background()
+
+	// Os comandos respondem só para quem agendou.
+	send(t, alice, map[string]any{"type": "message.send", "id": "s-1", "payload": map[string]string{"content": "/schedule 2h bom dia"}})
+	env := readUntil(t, alice, "ephemeral")
+	var msg models.Message
+	json.Unmarshal(env.Payload, &msg)
+	if !strings.HasPrefix(msg.Content, "Mensagem agendada para") {
+		t.Fatalf("resposta = %q", msg.Content)
+	}
+	send(t, alice, map[string]any{"type": "message.send", "id": "s-2", "payload": map[string]string{"content": "/remind amanhã 09:00 ligar para o banco"}})
+	env = readUntil(t, alice, "ephemeral")
+	json.Unmarshal(env.Payload, &msg)
+	if !strings.HasPrefix(msg.Content, "Lembrete criado") {
+		t.Fatalf("resposta = %q", msg.Content)
+	}
+
+	items, err := ps.pipeline.ScheduledList(ctx, "alice", models.ScheduledPending)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("ScheduledList = %d itens, err %v", len(items), err)
+	}
+	var scheduledID string
+	for _, item := range items {
+		if item.Kind == models.ScheduledKindMessage {
+			scheduledID = item.ID
+		}
+	}
+
+	// Na hora, a mensagem sai pela sala com o ID do agendamento e o lembrete
+	// chega só para alice.
+	store.due()
+	if n, err := ps.pipeline.DeliverDue(ctx); n != 2 || err != nil {
+		t.Fatalf("DeliverDue = %d, %v", n, err)
+	}
+	env = readUntil(t, bob, "message")
+	json.Unmarshal(env.Payload, &msg)
+	if msg.ID != scheduledID || msg.Content != "bom dia" || msg.Username != "alice" {
+		t.Fatalf("mensagem agendada inesperada: %+v", msg)
+	}
+	env = readUntil(t, alice, "ephemeral")
+	json.Unmarshal(env.Payload, &msg)
+	if msg.Content != "Lembrete: ligar para o banco" {
+		t.Fatalf("lembrete = %q", msg.Content)
+	}
+	if store.status(scheduledID) != models.ScheduledSent {
+		t.Fatalf("status = %q", store.status(scheduledID))
+	}
+
+	// Editar e cancelar só valem enquanto está pendente.
+	aliceSender := messaging.Sender{UserID: "alice", Username: "alice"}
+	item, err := ps.pipeline.Schedule(ctx, aliceSender, privateRoom, messaging.ScheduleSpec{Content: "oi", When: "1h"})
+	if err != nil {
+		t.Fatalf("Schedule: %v", err)
+	}
+	if item, err = ps.pipeline.UpdateScheduled(ctx, aliceSender, item.ID, messaging.ScheduleSpec{Content: "olá"}); err != nil || item.Content != "olá" {
+		t.Fatalf("UpdateScheduled = %+v, %v", item, err)
+	}
+	if _, err := ps.pipeline.UpdateScheduled(ctx, aliceSender, scheduledID, messaging.ScheduleSpec{Content: "tarde"}); err != messaging.ErrScheduledBusy {
+		t.Fatalf("edição de agendamento enviado: err = %v", err)
+	}
+	if err := ps.pipeline.CancelScheduled(ctx, "bob", item.ID); err != messaging.ErrScheduledNotFound {
+		t.Fatalf("bob cancelou o agendamento de alice: err = %v", err)
+	}
+	if _, err := ps.pipeline.Schedule(ctx, aliceSender, privateRoom, messaging.ScheduleSpec{Content: "/topic x", When: "1h"}); err == nil {
+		t.Fatal("comando foi agendado")
+	}
+
+	// Quem saiu da sala não publica: o agendamento falha e o autor é avisado.
+	ps.rooms.setMember(privateRoom, "alice", false)
+	store.due()
+	ps.pipeline.DeliverDue(ctx)
+	if store.status(item.ID) != models.ScheduledFailed {
+		t.Fatalf("status = %q, esperado failed", store.status(item.ID))
+	}
+	env = readUntil(t, alice, "ephemeral")
+	json.Unmarshal(env.Payload, &msg)
+	if !strings.HasPrefix(msg.Content, "Sua mensagem agendada não foi enviada") {
+		t.Fatalf("aviso = %q", msg.Content)
+	}
+}
+
+// oneExpired vence uma única mensagem da sala geral; o lote e os eventos
+// são testados em internal/messaging.
+type oneExpired struct {
+	purged bool
+}
+
+func (f *oneExpired) Cutoffs(ctx context.Context, defaultDays int) (map[string]time.Time, error) {
+	return map[string]time.Time{generalRoom: time.Now()}, nil
+}
+
+func (f *oneExpired) Purge(ctx context.Context, roomID string, before time.Time, limit int) ([]string, error) {
+	if f.purged {
+		return nil, nil
+	}
+	f.purged = true
+	return []string{"m1"}, nil
+}
+
+func TestProtocolRetention(t *testing.T) {
+	ps := newProtocolServer(t)
+	ps.pipeline.SetRetention(&oneExpired{}, messaging.RetentionConfig{BatchSize: 10})
+
+	bob := ps.mustDial(t, "bob", generalRoom, protocol.V1)
+	if _, err := ps.pipeline.PurgeExpired(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if env := readUntil(t, bob, "message_expired"); env.ID != "m1" {
+		t.Fatalf("message_expired %q, esperado m1", env.ID)
+	}
+}
+
+func TestProtocolEncrypted(t *testing.T) {
+	ps := newProtocolServer(t)
+	ps.rooms.mu.Lock()
+	ps.rooms.rooms[privateRoom].Encrypted = true
+	ps.rooms.mu.Unlock()
+	ps.rooms.setMember(privateRoom, "bob", true)
+
+	alice := ps.mustDial(t, "alice", privateRoom, protocol.V1)
+	bob := ps.mustDial(t, "bob", privateRoom, protocol.V1)
+
+	// Texto em claro não entra numa sala criptografada.
+	send(t, alice, map[string]any{"type": "message.send", "id": "e1", "payload": map[string]string{"content": "olá"}})
+	expectError(t, alice, "e1", protocol.CodeValidation)
+
+	send(t, alice, map[string]any{"type": "message.send", "id": "e2", "payload": map[string]string{"content": "x", "encryption": "rot13"}})
+	expectError(t, alice, "e2", protocol.CodeValidation)
+
+	// Comandos não são interpretados: o conteúdo é opaco.
+	ciphertext := "/me c2VncmVkbw=="
+	send(t, alice, map[string]any{"type": "message.send", "id": "e3", "payload": map[string]string{"content": ciphertext, "encryption": models.EncryptionMegolmV1}})
+	if env := readUntil(t, alice, protocol.TypeAck); env.ID != "e3" {
+		t.Fatalf("ack com id %q", env.ID)
+	}
+	env := readUntil(t, bob, "message")
+	var msg models.Message
+	json.Unmarshal(env.Payload, &msg)
+	if msg.Content != ciphertext || msg.Encryption != models.EncryptionMegolmV1 {
+		t.Fatalf("mensagem cifrada alterada: %+v", msg)
+	}
+
+	ps.pipeline.SetPolls(&fakePolls{polls: make(map[string]*models.Poll), votes: make(map[string]map[string][]int64)})
+	_, err := ps.pipeline.CreatePoll(context.Background(), messaging.Sender{UserID: "alice", Username: "alice"}, privateRoom,
+		messaging.PollSpec{Question: "Almoço?", Options: []string{"sim", "não"}})
+	if err != messaging.ErrEncryptedRoom {
+		t.Fatalf("CreatePoll numa sala criptografada: %v", err)
+	}
+
+	// E o marcador não vale fora das salas criptografadas.
+	general := ps.mustDial(t, "alice", generalRoom, protocol.V1)
+	send(t, general, map[string]any{"type": "message.send", "id": "e4", "payload": map[string]string{"content": "x", "encryption": models.EncryptionMegolmV1}})
+	expectError(t, general, "e4", protocol.CodeValidation)
+}
+
+func TestProtocolPing(t *testing.T) {
+	ps := newProtocolServer(t)
+	conn := ps.mustDial(t, "alice", generalRoom, protocol.V1)
+
+	send(t, conn, map[string]any{"type": "ping", "id": "p1"})
+	env := readEnvelope(t, conn)
+	if env.Type != protocol.TypePong || env.ID != "p1" {
+		t.Fatalf("esperado pong/p1, recebido %q/%q", env.Type, env.ID)
+	}
+}
+
+func TestProtocolRateLimited(t *testing.T) {
+	ps := newProtocolServer(t)
+	ps.pipeline.SetFloodGuard(ratelimit.NewFloodGuard(ratelimit.FloodConfig{
+		UserRate:  0.01,
+		UserBurst: 2,
+	}))
+	conn := ps.mustDial(t, "alice", generalRoom, protocol.V1)
+
+	for i, id := range []string{"r1", "r2", "r3"} {
+		send(t, conn, map[string]any{"type": "message.send", "id": id, "payload": map[string]string{"content": "oi"}})
+
+		var env envelope
+		for env.Type != protocol.TypeAck && env.Type != protocol.TypeError {
+			env = readEnvelope(t, conn)
+		}
+		if i < 2 {
+			if env.Type != protocol.TypeAck {
+				t.Fatalf("envio %s: esperado ack, recebido %q", id, env.Type)
+			}
+			continue
+		}
+
+		var payload protocol.ErrorPayload
+		json.Unmarshal(env.Payload, &payload)
+		if env.Type != protocol.TypeError || payload.Code != protocol.CodeRateLimited {
+			t.Fatalf("envio %s: esperado rate_limited, recebido %q %+v", id, env.Type, payload)
+		}
+		if payload.RetryAfterMs <= 0 {
+			t.Fatalf("rate_limited sem retryAfterMs: %+v", payload)
+		}
+	}
+}
+
+func TestProtocolValidationErrors(t *testing.T) {
+	ps := newProtocolServer(t)
+	conn := ps.mustDial(t, "alice", generalRoom, protocol.V1)
+
+	cases := []struct {
+		name  string
+		frame string
+		id    string
+	}{
+		{"json malformado", `{"type":`, ""},
+		{"tipo desconhecido", `{"type":"message.explode","id":"a"}`, "a"},
+		{"payload inválido", `{"type":"message.send","id":"b","payload":{"content":42}}`, "b"},
+		{"conteúdo vazio", `{"type":"message.send","id":"c","payload":{"content":"   "}}`, "c"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tc.frame)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			expectError(t, conn, tc.id, protocol.CodeValidation)
+		})
+	}
+}
+
+func TestProtocolTooLarge(t *testing.T) {
+	ps := newProtocolServer(t)
+	conn := ps.mustDial(t, "alice", generalRoom, protocol.V1)
+
+	big := strings.Repeat("x", 10*1024)
+	send(t, conn, map[string]any{"type": "message.send", "id": "big", "payload": map[string]string{"content": big}})
+	expectError(t, conn, "", protocol.CodeTooLarge)
+
+	long := strings.Repeat("y", messaging.MaxContentLength+1)
+	send(t, conn, map[string]any{"type": "message.send", "id": "long", "payload": map[string]string{"content": long}})
+	expectError(t, conn, "long", protocol.CodeTooLarge)
+
+	// A conexão continua utilizável depois do erro.
+	send(t, conn, map[string]any{"type": "ping", "id": "after"})
+	if env := readEnvelope(t, conn); env.Type != protocol.TypePong {
+		t.Fatalf("conexão não sobreviveu ao too_large: %q", env.Type)
+	}
+}
+
+func TestProtocolForbidden(t *testing.T) {
+	ps := newProtocolServer(t)
+
+	_, resp, err := ps.dial(t, "bob", privateRoom, protocol.V1)
+	if err == nil {
+		t.Fatal("não-membro conectou em sala privada")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("status = %v, esperado 403", resp)
+	}
+
+	conn := ps.mustDial(t, "alice", privateRoom, protocol.V1)
+	ps.rooms.setMember(privateRoom, "alice", false)
+
+	send(t, conn, map[string]any{"type": "message.send", "id": "f1", "payload": map[string]string{"content": "oi"}})
+	expectError(t, conn, "f1", protocol.CodeForbidden)
+}
+
+func TestLegacyClient(t *testing.T) {
+	ps := newProtocolServer(t)
+	conn := ps.mustDial(t, "alice", generalRoom)
+
+	send(t, conn, map[string]string{"content": "legado"})
+
+	var msg models.Message
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if msg.Type != "count" {
+			break
+		}
+	}
+	if msg.Type != "message" || msg.Content != "legado" || msg.ID == "" {
+		t.Fatalf("mensagem legada inesperada: %+v", msg)
+	}
+
+	send(t, conn, map[string]string{"content": ""})
+
+	var errFrame struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Content string `json:"content"`
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&errFrame); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if errFrame.Type != "error" || errFrame.Code != protocol.CodeValidation || errFrame.Content == "" {
+		t.Fatalf("erro legado inesperado: %+v", errFrame)
+	}
+}
+
+func TestProtocolBinaryEncodings(t *testing.T) {
+	for _, subprotocol := range []string{protocol.V1Msgpack, protocol.V1Protobuf} {
+		t.Run(subprotocol, func(t *testing.T) {
+			ps := newProtocolServer(t)
+			conn := ps.mustDial(t, "alice", generalRoom, subprotocol)
+			if conn.Subprotocol() != subprotocol {
+				t.Fatalf("subprotocolo = %q, esperado %q", conn.Subprotocol(), subprotocol)
+			}
+			codec := protocol.CodecFor(subprotocol)
+
+			read := func() protocol.Request {
+				t.Helper()
+				for {
+					conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+					frameType, data, err := conn.ReadMessage()
+					if err != nil {
+						t.Fatalf("read: %v", err)
+					}
+					if frameType != websocket.BinaryMessage {
+						t.Fatalf("frame de texto em codec binário: %s", data)
+					}
+					req, err := codec.DecodeRequest(data)
+					if err != nil {
+						t.Fatalf("frame não decodifica: %v", err)
+					}
+					if req.Type != "count" {
+						return req
+					}
+				}
+			}
+
+			data, err := codec.EncodeEnvelope(protocol.Envelope{
+				Type:    protocol.TypeSend,
+				ID:      "bin-1",
+				Payload: protocol.SendPayload{Content: "binário"},
+			})
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+
+			seen := map[string]string{}
+			for i := 0; i < 2; i++ {
+				req := read()
+				seen[req.Type] = req.ID
+			}
+			if seen[protocol.TypeAck] != "bin-1" {
+				t.Fatalf("ack ausente ou com id errado: %v", seen)
+			}
+			if seen["message"] == "" {
+				t.Fatalf("mensagem não recebida: %v", seen)
+			}
+
+			data, _ = codec.EncodeEnvelope(protocol.Envelope{Type: protocol.TypeSend, ID: "bin-2", Payload: protocol.SendPayload{}})
+			conn.WriteMessage(websocket.BinaryMessage, data)
+			if req := read(); req.Type != protocol.TypeError || req.ID != "bin-2" {
+				t.Fatalf("esperado error/bin-2, recebido %q/%q", req.Type, req.ID)
+			}
+		})
+	}
+}
+
+func TestProtocolCompression(t *testing.T) {
+	ps := newProtocolServer(t)
+
+	u := "ws" + strings.TrimPrefix(ps.srv.URL, "http") + "/ws?token=" + url.QueryEscape(ps.tokens["alice"])
+	dialer := websocket.Dialer{EnableCompression: true, Subprotocols: []string{protocol.V1}}
+	conn, resp, err := dialer.Dial(u, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+		t.Fatalf("permessage-deflate não negociado: %q", ext)
+	}
+
+	send(t, conn, map[string]any{"type": "ping", "id": "z"})
+	if env := readEnvelope(t, conn); env.Type != protocol.TypePong {
+		t.Fatalf("esperado pong com compressão, recebido %q", env.Type)
+	}
+}
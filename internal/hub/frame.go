@@ -0,0 +1,77 @@
+package hub
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// Codec serializa um evento para um formato de fio específico.
+type Codec interface {
+	Name() string
+	EncodeMessage(msg models.Message) ([]byte, error)
+}
+
+// Frame é a unidade entregue aos clientes. O mesmo Frame é compartilhado por
+// todos os clientes da sala, então cada formato é serializado uma única vez,
+// não importa quantos clientes o usem.
+type Frame struct {
+	Message models.Message
+
+	mu    sync.Mutex
+	cache map[string]cachedValue
+}
+
+type cachedValue struct {
+	value any
+	err   error
+}
+
+func NewFrame(message models.Message) *Frame {
+	return &Frame{Message: message}
+}
+
+// Memo calcula build uma única vez por chave e devolve o resultado guardado
+// nas chamadas seguintes. Transportes usam para guardar representações
+// derivadas (ex.: mensagens WebSocket já comprimidas). build não pode chamar
+// Memo nem Encode no mesmo Frame.
+func (f *Frame) Memo(key string, build func() (any, error)) (any, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if c, ok := f.cache[key]; ok {
+		return c.value, c.err
+	}
+
+	value, err := build()
+	if f.cache == nil {
+		f.cache = make(map[string]cachedValue, 1)
+	}
+	f.cache[key] = cachedValue{value: value, err: err}
+	return value, err
+}
+
+func (f *Frame) Encode(codec Codec) ([]byte, error) {
+	v, err := f.Memo(codec.Name(), func() (any, error) {
+		return codec.EncodeMessage(f.Message)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// JSON devolve o models.Message serializado como JSON puro, formato usado
+// pelo SSE, long-polling e clientes WebSocket sem subprotocolo.
+func (f *Frame) JSON() ([]byte, error) {
+	return f.Encode(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "legacy" }
+
+func (jsonCodec) EncodeMessage(msg models.Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
@@ -1,81 +1,357 @@
 package hub
 
 import (
+	"context"
+	"hash/fnv"
+	"runtime"
+	"slices"
+	"sync"
+
 	"github.com/lucaspanzera1/chat/internal/models"
 )
 
+const shardQueueSize = 4096
+
 type ClientInterface interface {
 	GetRoomID() string
-	GetSendChannel() chan models.Message
+	GetSendChannel() chan *Frame
+	GetUserID() string
+	GetUsername() string
+	GetAvatarURL() string
 }
 
+// Hub distribui as salas entre shards. Cada shard tem sua própria goroutine e
+// fila, então um broadcast para uma sala grande não trava salas de outros shards.
+// A sala vai para o shard pelo hash (FNV) do id, então o isolamento é só
+// estatístico: as salas que caem no shard de uma sala grande esperam o
+// fan-out dela.
 type Hub struct {
-	Rooms      map[string]map[ClientInterface]bool
-	Broadcast  chan models.Message
-	Register   chan ClientInterface
-	Unregister chan ClientInterface
+	shards   []*shard
+	presence PresenceConfig
 }
 
 func NewHub() *Hub {
-	return &Hub{
-		Rooms:      make(map[string]map[ClientInterface]bool),
-		Broadcast:  make(chan models.Message),
-		Register:   make(chan ClientInterface),
-		Unregister: make(chan ClientInterface),
+	return NewShardedHub(max(4, 2*runtime.NumCPU()))
+}
+
+func NewShardedHub(shards int) *Hub {
+	if shards < 1 {
+		shards = 1
+	}
+
+	h := &Hub{
+		shards:   make([]*shard, shards),
+		presence: PresenceConfig{Debounce: defaultPresenceDebounce},
 	}
+	for i := range h.shards {
+		h.shards[i] = newShard(h)
+	}
+	return h
 }
 
 func (h *Hub) Run() {
-	for {
+	var wg sync.WaitGroup
+	for _, s := range h.shards {
+		wg.Add(1)
+		go func(s *shard) {
+			defer wg.Done()
+			s.run()
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (h *Hub) Register(client ClientInterface) {
+	h.shardFor(client.GetRoomID()).ops <- op{kind: opRegister, client: client}
+}
+
+func (h *Hub) Unregister(client ClientInterface) {
+	h.shardFor(client.GetRoomID()).ops <- op{kind: opUnregister, client: client}
+}
+
+// Broadcast enfileira a mensagem no shard da sala e retorna imediatamente;
+// só bloqueia se a fila do shard estiver cheia.
+func (h *Hub) Broadcast(message models.Message) {
+	h.shardFor(message.RoomID).ops <- op{kind: opBroadcast, message: message}
+}
+
+// Direct entrega a mensagem só às conexões dos usuários indicados na sala,
+// sem persistir nada, e devolve quantas conexões a receberam.
+func (h *Hub) Direct(roomID string, userIDs []string, message models.Message) int {
+	reached := make(chan int, 1)
+	h.shardFor(roomID).ops <- op{kind: opDirect, message: message, userIDs: userIDs, reached: reached}
+	return <-reached
+}
+
+// SendToUsers entrega a mensagem a todas as conexões dos usuários, em
+// qualquer sala, sem persistir nada. Cada shard atende a sua parte e o total
+// de conexões alcançadas é devolvido.
+func (h *Hub) SendToUsers(userIDs []string, message models.Message) int {
+	if len(userIDs) == 0 {
+		return 0
+	}
+	userIDs = slices.Compact(slices.Sorted(slices.Values(userIDs)))
+
+	reached := make(chan int, len(h.shards))
+	for _, s := range h.shards {
+		s.ops <- op{kind: opUsers, message: message, userIDs: userIDs, reached: reached}
+	}
+	total := 0
+	for range h.shards {
+		total += <-reached
+	}
+	return total
+}
+
+// BroadcastLeave agenda o evento "user_left" do cliente que está saindo. O
+// evento só é emitido se o usuário continuar sem conexões na sala após o
+// debounce, então reconexões rápidas não geram ruído.
+func (h *Hub) BroadcastLeave(client ClientInterface) {
+	h.shardFor(client.GetRoomID()).ops <- op{kind: opLeave, client: client}
+}
+
+// Shutdown avisa todos os clientes com um evento "server_restarting" e fecha
+// seus canais, o que faz cada WritePump enviar o close frame. Registros
+// posteriores são recusados.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	done := make(chan struct{}, len(h.shards))
+	for _, s := range h.shards {
 		select {
-		case client := <-h.Register:
-			roomID := client.GetRoomID()
-			if h.Rooms[roomID] == nil {
-				h.Rooms[roomID] = make(map[ClientInterface]bool)
+		case s.ops <- op{kind: opShutdown, done: done}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for range h.shards {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (h *Hub) shardFor(roomID string) *shard {
+	if len(h.shards) == 1 {
+		return h.shards[0]
+	}
+	f := fnv.New32a()
+	f.Write([]byte(roomID))
+	return h.shards[f.Sum32()%uint32(len(h.shards))]
+}
+
+type opKind int
+
+const (
+	opRegister opKind = iota
+	opUnregister
+	opBroadcast
+	opShutdown
+	opLeave
+	opLeaveTimeout
+	opDirect
+	opUsers
+)
+
+// Registro, saída e broadcast passam pela mesma fila para preservar a ordem
+// de eventos de um mesmo cliente.
+type op struct {
+	kind    opKind
+	client  ClientInterface
+	message models.Message
+	done    chan struct{}
+	leave   *pendingLeave
+	userIDs []string
+	reached chan int
+}
+
+type shard struct {
+	hub    *Hub
+	rooms  map[string]map[ClientInterface]bool
+	users  map[string]map[string]int
+	conns  map[string]map[ClientInterface]bool
+	leaves map[string]*pendingLeave
+	ops    chan op
+	closed bool
+}
+
+func newShard(h *Hub) *shard {
+	return &shard{
+		hub:    h,
+		rooms:  make(map[string]map[ClientInterface]bool),
+		users:  make(map[string]map[string]int),
+		conns:  make(map[string]map[ClientInterface]bool),
+		leaves: make(map[string]*pendingLeave),
+		ops:    make(chan op, shardQueueSize),
+	}
+}
+
+func (s *shard) run() {
+	for o := range s.ops {
+		switch o.kind {
+		case opRegister:
+			if s.closed {
+				close(o.client.GetSendChannel())
+				continue
 			}
-			h.Rooms[roomID][client] = true
-			h.broadcastCountToRoom(roomID)
-
-		case client := <-h.Unregister:
-			roomID := client.GetRoomID()
-			if clients, ok := h.Rooms[roomID]; ok {
-				if _, exists := clients[client]; exists {
-					delete(clients, client)
-					close(client.GetSendChannel())
-					h.broadcastCountToRoom(roomID)
-				}
+			roomID := o.client.GetRoomID()
+			if s.rooms[roomID] == nil {
+				s.rooms[roomID] = make(map[ClientInterface]bool)
+			}
+			s.rooms[roomID][o.client] = true
+			if s.users[roomID] == nil {
+				s.users[roomID] = make(map[string]int)
+			}
+			s.users[roomID][o.client.GetUserID()]++
+			if s.conns[o.client.GetUserID()] == nil {
+				s.conns[o.client.GetUserID()] = make(map[ClientInterface]bool)
+			}
+			s.conns[o.client.GetUserID()][o.client] = true
+			if s.users[roomID][o.client.GetUserID()] == 1 {
+				s.userArrived(o.client)
 			}
+			s.broadcastCount(roomID)
 
-		case message := <-h.Broadcast:
-			if clients, ok := h.Rooms[message.RoomID]; ok {
-				message.OnlineCount = len(clients)
-				for client := range clients {
-					select {
-					case client.GetSendChannel() <- message:
-					default:
-						close(client.GetSendChannel())
-						delete(clients, client)
-					}
+		case opUnregister:
+			roomID := o.client.GetRoomID()
+			if clients, ok := s.rooms[roomID]; ok {
+				if _, exists := clients[o.client]; exists {
+					s.remove(roomID, o.client)
+					s.broadcastCount(roomID)
 				}
 			}
+
+		case opBroadcast:
+			if clients, ok := s.rooms[o.message.RoomID]; ok {
+				o.message.OnlineCount = len(clients)
+				s.fanOut(o.message.RoomID, NewFrame(o.message), true)
+			}
+
+		case opShutdown:
+			s.shutdown()
+			o.done <- struct{}{}
+
+		case opLeave:
+			if !s.closed {
+				s.scheduleLeave(o.client)
+			}
+
+		case opLeaveTimeout:
+			s.leaveTimeout(o.client, o.leave)
+
+		case opDirect:
+			o.reached <- s.direct(o.message, o.userIDs)
+
+		case opUsers:
+			o.reached <- s.toUsers(o.message, o.userIDs)
 		}
 	}
 }
 
-func (h *Hub) broadcastCountToRoom(roomID string) {
-	if clients, ok := h.Rooms[roomID]; ok {
-		countMsg := models.Message{
+func (s *shard) broadcastCount(roomID string) {
+	if clients, ok := s.rooms[roomID]; ok {
+		s.fanOut(roomID, NewFrame(models.Message{
 			RoomID:      roomID,
 			Type:        "count",
 			OnlineCount: len(clients),
+		}), false)
+	}
+}
+
+// fanOut nunca bloqueia: clientes com o buffer cheio são descartados quando
+// dropSlow é verdadeiro, ou apenas perdem o frame caso contrário.
+func (s *shard) fanOut(roomID string, frame *Frame, dropSlow bool) {
+	for client := range s.rooms[roomID] {
+		select {
+		case client.GetSendChannel() <- frame:
+		default:
+			if dropSlow {
+				s.remove(roomID, client)
+			}
+		}
+	}
+}
+
+func (s *shard) direct(message models.Message, userIDs []string) int {
+	frame := NewFrame(message)
+	reached := 0
+	for client := range s.rooms[message.RoomID] {
+		if !slices.Contains(userIDs, client.GetUserID()) {
+			continue
 		}
+		select {
+		case client.GetSendChannel() <- frame:
+			reached++
+		default:
+		}
+	}
+	return reached
+}
+
+// toUsers usa o índice por usuário, então o custo não depende do tamanho
+// das salas. O frame é marcado com a sala de cada conexão quando a mensagem
+// não traz uma.
+func (s *shard) toUsers(message models.Message, userIDs []string) int {
+	var shared *Frame
+	if message.RoomID != "" {
+		shared = NewFrame(message)
+	}
+
+	reached := 0
+	for _, userID := range userIDs {
+		for client := range s.conns[userID] {
+			frame := shared
+			if frame == nil {
+				msg := message
+				msg.RoomID = client.GetRoomID()
+				frame = NewFrame(msg)
+			}
+			select {
+			case client.GetSendChannel() <- frame:
+				reached++
+			default:
+			}
+		}
+	}
+	return reached
+}
+
+func (s *shard) shutdown() {
+	s.closed = true
+	for roomID, clients := range s.rooms {
+		frame := NewFrame(models.Message{RoomID: roomID, Type: "server_restarting"})
 		for client := range clients {
 			select {
-			case client.GetSendChannel() <- countMsg:
+			case client.GetSendChannel() <- frame:
 			default:
 			}
+			s.remove(roomID, client)
 		}
 	}
 }
 
-func (h *Hub) BroadcastLeave(username string) {}
+func (s *shard) remove(roomID string, client ClientInterface) {
+	clients := s.rooms[roomID]
+	delete(clients, client)
+	close(client.GetSendChannel())
+	if len(clients) == 0 {
+		delete(s.rooms, roomID)
+	}
+
+	userConns := s.conns[client.GetUserID()]
+	delete(userConns, client)
+	if len(userConns) == 0 {
+		delete(s.conns, client.GetUserID())
+	}
+
+	users := s.users[roomID]
+	users[client.GetUserID()]--
+	if users[client.GetUserID()] <= 0 {
+		delete(users, client.GetUserID())
+	}
+	if len(users) == 0 {
+		delete(s.users, roomID)
+	}
+}
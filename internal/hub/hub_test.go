@@ -0,0 +1,274 @@
+package hub
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+type fakeClient struct {
+	roomID   string
+	userID   string
+	send     chan *Frame
+	received atomic.Int64
+}
+
+func (c *fakeClient) GetRoomID() string           { return c.roomID }
+func (c *fakeClient) GetSendChannel() chan *Frame { return c.send }
+func (c *fakeClient) GetUserID() string {
+	if c.userID != "" {
+		return c.userID
+	}
+	return fmt.Sprintf("%p", c)
+}
+func (c *fakeClient) GetUsername() string  { return "bench" }
+func (c *fakeClient) GetAvatarURL() string { return "" }
+
+// drain simula o WritePump: consome frames e força a serialização.
+func (c *fakeClient) drain(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for frame := range c.send {
+		if frame.Message.Type == "count" {
+			continue
+		}
+		frame.JSON()
+		c.received.Add(1)
+	}
+}
+
+func startClients(b *testing.B, h *Hub, total, rooms int) ([]*fakeClient, *sync.WaitGroup) {
+	b.Helper()
+
+	var wg sync.WaitGroup
+	clients := make([]*fakeClient, total)
+	for i := range clients {
+		c := &fakeClient{
+			roomID: fmt.Sprintf("room-%d", i%rooms),
+			send:   make(chan *Frame, 1024),
+		}
+		clients[i] = c
+		wg.Add(1)
+		go c.drain(&wg)
+		h.Register(c)
+	}
+	return clients, &wg
+}
+
+func waitDelivered(b *testing.B, clients []*fakeClient, perClient int64) int64 {
+	b.Helper()
+
+	deadline := time.Now().Add(time.Minute)
+	for {
+		var total, done int64
+		for _, c := range clients {
+			n := c.received.Load()
+			total += n
+			if n >= perClient {
+				done++
+			}
+		}
+		if done == int64(len(clients)) || time.Now().After(deadline) {
+			return total
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func benchmarkBroadcast(b *testing.B, total, rooms int) {
+	h := NewHub()
+	go h.Run()
+
+	clients, wg := startClients(b, h, total, rooms)
+	defer func() {
+		for _, c := range clients {
+			h.Unregister(c)
+		}
+		wg.Wait()
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for r := 0; r < rooms; r++ {
+			h.Broadcast(models.Message{
+				RoomID:  fmt.Sprintf("room-%d", r),
+				Content: "benchmark",
+				Type:    "message",
+			})
+		}
+		// Mantém o produtor no ritmo dos consumidores para não medir descartes.
+		if i%512 == 511 {
+			waitDelivered(b, clients, int64(i+1))
+		}
+	}
+
+	delivered := waitDelivered(b, clients, int64(b.N))
+	b.StopTimer()
+
+	b.ReportMetric(float64(delivered)/b.Elapsed().Seconds(), "deliveries/s")
+}
+
+// 10k conexões na mesma sala: mede o custo do fan-out de uma sala grande.
+func BenchmarkBroadcast10kSingleRoom(b *testing.B) {
+	benchmarkBroadcast(b, 10000, 1)
+}
+
+// 10k conexões espalhadas em 100 salas: mede o paralelismo entre shards.
+func BenchmarkBroadcast10kHundredRooms(b *testing.B) {
+	benchmarkBroadcast(b, 10000, 100)
+}
+
+// Broadcast em salas pequenas enquanto uma sala de 10k recebe tráfego. Só
+// entram na medida as salas de outros shards, que não devem esperar o
+// fan-out da sala grande; as que caem no mesmo shard esperam (veja Hub).
+func BenchmarkBroadcastSmallRoomsUnderLoad(b *testing.B) {
+	h := NewHub()
+	go h.Run()
+
+	busy := h.shardFor("room-0")
+	var isolated []string
+	for r := 1; r < 100; r++ {
+		if room := fmt.Sprintf("room-%d", r); h.shardFor(room) != busy {
+			isolated = append(isolated, room)
+		}
+	}
+	if len(isolated) == 0 {
+		b.Fatal("todas as salas pequenas caíram no shard da sala grande")
+	}
+
+	clients, wg := startClients(b, h, 10000, 1)
+	small, smallWG := startClients(b, h, 100, 100)
+	defer func() {
+		for _, c := range append(clients, small...) {
+			h.Unregister(c)
+		}
+		wg.Wait()
+		smallWG.Wait()
+	}()
+
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				h.Broadcast(models.Message{RoomID: "room-0", Content: "flood", Type: "message"})
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+	}()
+	defer close(stop)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			h.Broadcast(models.Message{
+				RoomID:  isolated[i%len(isolated)],
+				Content: "benchmark",
+				Type:    "message",
+			})
+			i++
+		}
+	})
+}
+
+func TestBroadcastReachesOnlyRoom(t *testing.T) {
+	h := NewShardedHub(4)
+	go h.Run()
+
+	a := &fakeClient{roomID: "a", send: make(chan *Frame, 16)}
+	b := &fakeClient{roomID: "b", send: make(chan *Frame, 16)}
+	h.Register(a)
+	h.Register(b)
+
+	h.Broadcast(models.Message{RoomID: "a", Content: "oi", Type: "message"})
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case frame := <-a.send:
+			if frame.Message.Type == "count" {
+				continue
+			}
+			if frame.Message.OnlineCount != 1 {
+				t.Fatalf("onlineCount = %d, esperado 1", frame.Message.OnlineCount)
+			}
+			data, err := frame.JSON()
+			if err != nil || len(data) == 0 {
+				t.Fatalf("serialização falhou: %v", err)
+			}
+			select {
+			case frame := <-b.send:
+				if frame.Message.Type != "count" {
+					t.Fatalf("sala b recebeu mensagem da sala a: %+v", frame.Message)
+				}
+			default:
+			}
+			return
+		case <-deadline:
+			t.Fatal("mensagem não entregue")
+		}
+	}
+}
+
+func TestSendToUsersReachesEveryConnection(t *testing.T) {
+	h := NewShardedHub(4)
+	go h.Run()
+
+	// ana em três salas (shards diferentes), beto em uma delas.
+	var ana []*fakeClient
+	for _, room := range []string{"a", "b", "c"} {
+		c := &fakeClient{roomID: room, userID: "ana", send: make(chan *Frame, 16)}
+		h.Register(c)
+		ana = append(ana, c)
+	}
+	beto := &fakeClient{roomID: "a", userID: "beto", send: make(chan *Frame, 16)}
+	h.Register(beto)
+
+	if n := h.SendToUsers([]string{"ana", "ana"}, models.Message{Type: "ephemeral", Content: "oi"}); n != 3 {
+		t.Fatalf("SendToUsers alcançou %d conexões, esperado 3", n)
+	}
+
+	for _, c := range ana {
+		frame := nextNonCount(t, c)
+		if frame.Message.Content != "oi" || frame.Message.RoomID != c.roomID {
+			t.Fatalf("frame inesperado na sala %s: %+v", c.roomID, frame.Message)
+		}
+	}
+	for len(beto.send) > 0 {
+		if frame := <-beto.send; frame.Message.Type != "count" {
+			t.Fatalf("beto recebeu %+v", frame.Message)
+		}
+	}
+
+	// Depois de sair, a conexão deixa o índice por usuário.
+	h.Unregister(ana[0])
+	if n := h.SendToUsers([]string{"ana"}, models.Message{RoomID: "x", Type: "ephemeral"}); n != 2 {
+		t.Fatalf("após Unregister alcançou %d conexões, esperado 2", n)
+	}
+}
+
+func nextNonCount(t *testing.T, c *fakeClient) *Frame {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case frame := <-c.send:
+			if frame.Message.Type != "count" {
+				return frame
+			}
+		case <-deadline:
+			t.Fatal("frame não entregue")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+const defaultPresenceDebounce = 5 * time.Second
+
+// PresenceConfig controla os eventos "user_joined" e "user_left". Settings
+// informa se a sala quer os eventos (nil desativa para todas) e Persist, se
+// definido, grava os eventos no histórico de grupos que optaram por isso.
+type PresenceConfig struct {
+	Debounce time.Duration
+	Settings func(ctx context.Context, roomID string) (*models.RoomSettings, error)
+	Persist  func(ctx context.Context, msg *models.Message, userID string) error
+}
+
+// SetPresence deve ser chamado antes de Run.
+func (h *Hub) SetPresence(cfg PresenceConfig) {
+	if cfg.Debounce <= 0 {
+		cfg.Debounce = defaultPresenceDebounce
+	}
+	h.presence = cfg
+}
+
+func presenceKey(roomID, userID string) string {
+	return roomID + ":" + userID
+}
+
+type pendingLeave struct {
+	timer *time.Timer
+}
+
+// userArrived é chamado quando o usuário abre sua primeira conexão na sala.
+// Se ainda havia uma saída pendente, foi só uma reconexão e nada é emitido.
+func (s *shard) userArrived(client ClientInterface) {
+	key := presenceKey(client.GetRoomID(), client.GetUserID())
+	if pending, ok := s.leaves[key]; ok {
+		pending.timer.Stop()
+		delete(s.leaves, key)
+		return
+	}
+	s.emitPresence("user_joined", client)
+}
+
+func (s *shard) scheduleLeave(client ClientInterface) {
+	key := presenceKey(client.GetRoomID(), client.GetUserID())
+	if pending, ok := s.leaves[key]; ok {
+		pending.timer.Stop()
+	}
+
+	pending := &pendingLeave{}
+	pending.timer = time.AfterFunc(s.hub.presence.Debounce, func() {
+		s.ops <- op{kind: opLeaveTimeout, client: client, leave: pending}
+	})
+	s.leaves[key] = pending
+}
+
+func (s *shard) leaveTimeout(client ClientInterface, pending *pendingLeave) {
+	key := presenceKey(client.GetRoomID(), client.GetUserID())
+	if s.leaves[key] != pending {
+		return
+	}
+	delete(s.leaves, key)
+
+	if s.closed || s.users[client.GetRoomID()][client.GetUserID()] > 0 {
+		return
+	}
+	s.emitPresence("user_left", client)
+}
+
+// emitPresence consulta as configurações da sala fora da goroutine do shard
+// para não bloquear a fila com acesso ao banco.
+func (s *shard) emitPresence(kind string, client ClientInterface) {
+	cfg := s.hub.presence
+	if cfg.Settings == nil {
+		return
+	}
+
+	msg := models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    client.GetRoomID(),
+		Username:  client.GetUsername(),
+		AvatarURL: client.GetAvatarURL(),
+		Timestamp: time.Now(),
+		Type:      kind,
+	}
+	if kind == "user_joined" {
+		msg.Content = fmt.Sprintf("%s entrou na sala", msg.Username)
+	} else {
+		msg.Content = fmt.Sprintf("%s saiu da sala", msg.Username)
+	}
+	userID := client.GetUserID()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		settings, err := cfg.Settings(ctx, msg.RoomID)
+		if err != nil {
+			log.Printf("Erro ao buscar configurações de presença: %v", err)
+			return
+		}
+		if settings == nil || !settings.PresenceEvents {
+			return
+		}
+
+		if settings.PersistPresence && settings.RoomType == "group" && cfg.Persist != nil {
+			if err := cfg.Persist(ctx, &msg, userID); err != nil {
+				log.Printf("Erro ao salvar evento de presença: %v", err)
+			}
+		}
+
+		s.ops <- op{kind: opBroadcast, message: msg}
+	}()
+}
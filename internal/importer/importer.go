@@ -0,0 +1,400 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// Origens aceitas.
+const (
+	SourceSlack    = "slack"
+	SourceWhatsApp = "whatsapp"
+)
+
+// copyBatch é o tamanho de cada CopyFrom.
+const copyBatch = 5000
+
+// namespace gera os IDs determinísticos: a mesma mensagem, sala ou pessoa
+// do arquivo de origem vira sempre o mesmo UUID, e importar de novo não
+// duplica nada.
+var namespace = uuid.MustParse("6f1c2a9e-3d4b-4e7a-9b52-0c8d1f7e5a31")
+
+// User é uma pessoa do arquivo de origem. Name é o nome de usuário
+// sugerido para a conta provisória.
+type User struct {
+	ExternalID string
+	Name       string
+	Email      string
+	AvatarURL  string
+	Bot        bool
+}
+
+// Message é uma mensagem do arquivo de origem. UserID é o ExternalID do
+// autor; vazio em mensagens do sistema e de integrações, que usam
+// Username. Menções vêm como <@ExternalID> e viram @usuário local.
+type Message struct {
+	ExternalID  string
+	UserID      string
+	Username    string
+	Content     string
+	Type        string
+	Timestamp   time.Time
+	EditedAt    *time.Time
+	Bot         bool
+	Attachments []models.Attachment
+}
+
+// Room é uma conversa do arquivo de origem. Load lê as mensagens só na hora
+// de importar a sala, para que o arquivo inteiro não fique em memória.
+type Room struct {
+	ExternalID string
+	Name       string
+	Type       string
+	Topic      string
+	CreatedBy  string
+	CreatedAt  time.Time
+	Members    []string
+	Load       func() ([]Message, error)
+}
+
+// Archive é o que um parser extrai. Source separa os IDs determinísticos
+// de cada origem (ex.: "slack:T0123").
+type Archive struct {
+	Source string
+	Users  []User
+	Rooms  []Room
+}
+
+// Record é a linha gravada em messages.
+type Record struct {
+	models.Message
+	UserID string
+}
+
+// NewRoom é a sala a criar. Members só entram quando a sala é criada.
+type NewRoom struct {
+	ID        string
+	Name      string
+	Type      string
+	Topic     string
+	CreatedBy string
+	CreatedAt time.Time
+	Members   []string
+}
+
+// Store grava a importação. EnsurePlaceholder cria a conta provisória com
+// o ID dado ou devolve a que já existe; EnsureRoom não mexe em salas que já
+// existem; CopyMessages ignora IDs já gravados e devolve quantas linhas
+// entraram. PrivateRoom ignora conversas criptografadas, que não recebem
+// texto em claro.
+type Store interface {
+	UserByEmail(ctx context.Context, email string) (id, username string, err error)
+	EnsurePlaceholder(ctx context.Context, id, username, email, avatarURL string, bot bool) (name string, created bool, err error)
+	PrivateRoom(ctx context.Context, user1ID, user2ID string) (string, error)
+	EnsureRoom(ctx context.Context, room NewRoom) (bool, error)
+	CopyMessages(ctx context.Context, records []Record) (int64, error)
+}
+
+// Options: Emails associa pessoas sem email no arquivo (WhatsApp) a contas,
+// pelo ExternalID ou pelo nome; CreatedBy administra os grupos cujo criador
+// não veio no arquivo.
+type Options struct {
+	Emails    map[string]string
+	CreatedBy string
+}
+
+// Report resume uma importação. Inserted conta só as mensagens novas: numa
+// nova execução do mesmo arquivo, fica zero.
+type Report struct {
+	Source       string `json:"source"`
+	UsersMatched int    `json:"usersMatched"`
+	UsersCreated int    `json:"usersCreated"`
+	RoomsCreated int    `json:"roomsCreated"`
+	Rooms        int    `json:"rooms"`
+	Messages     int    `json:"messages"`
+	Inserted     int64  `json:"inserted"`
+}
+
+// ParseEmails lê a associação de pessoas a emails em JSON ({"Ana":
+// "ana@x.com"}) ou em linhas "Ana=ana@x.com".
+func ParseEmails(text string) (map[string]string, error) {
+	text = strings.TrimSpace(text)
+	emails := make(map[string]string)
+	if text == "" {
+		return emails, nil
+	}
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &emails); err != nil {
+			return nil, fmt.Errorf("emails: %w", err)
+		}
+		return emails, nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, email, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(name) == "" || !strings.Contains(email, "@") {
+			return nil, fmt.Errorf("emails: linha inválida %q (use Nome=email)", line)
+		}
+		emails[strings.TrimSpace(name)] = strings.TrimSpace(email)
+	}
+	return emails, nil
+}
+
+type Importer struct {
+	store Store
+}
+
+func NewImporter(store Store) *Importer {
+	return &Importer{store: store}
+}
+
+func (a *Archive) id(kind, externalID string) string {
+	return uuid.NewSHA1(namespace, []byte(a.Source+":"+kind+":"+externalID)).String()
+}
+
+type localUser struct {
+	id       string
+	username string
+	bot      bool
+}
+
+// Import grava o arquivo: associa as pessoas às contas pelo email (ou cria
+// contas provisórias), cria as salas e insere as mensagens com os horários
+// originais.
+func (imp *Importer) Import(ctx context.Context, archive *Archive, opts Options) (*Report, error) {
+	report := &Report{Source: archive.Source}
+
+	users := make(map[string]localUser, len(archive.Users))
+	for _, u := range archive.Users {
+		local, matched, created, err := imp.resolveUser(ctx, archive, u, opts)
+		if err != nil {
+			return report, fmt.Errorf("usuário %s: %w", u.Name, err)
+		}
+		users[u.ExternalID] = local
+		if matched {
+			report.UsersMatched++
+		}
+		if created {
+			report.UsersCreated++
+		}
+	}
+
+	for _, room := range archive.Rooms {
+		if err := imp.importRoom(ctx, archive, room, users, opts, report); err != nil {
+			return report, fmt.Errorf("sala %s: %w", room.Name, err)
+		}
+	}
+	return report, nil
+}
+
+func (imp *Importer) resolveUser(ctx context.Context, archive *Archive, u User, opts Options) (local localUser, matched, created bool, err error) {
+	email := strings.ToLower(strings.TrimSpace(u.Email))
+	if email == "" {
+		email = strings.ToLower(strings.TrimSpace(opts.Emails[u.ExternalID]))
+	}
+	if email == "" {
+		email = strings.ToLower(strings.TrimSpace(opts.Emails[u.Name]))
+	}
+
+	if email != "" {
+		id, username, err := imp.store.UserByEmail(ctx, email)
+		if err != nil {
+			return local, false, false, err
+		}
+		if id != "" {
+			return localUser{id: id, username: username, bot: u.Bot}, true, false, nil
+		}
+	}
+
+	// Sem conta: uma provisória, sem senha. Com o email real, a pessoa
+	// assume a conta pela recuperação de senha ou pelo login externo.
+	id := archive.id("user", u.ExternalID)
+	if email == "" {
+		email = id + "@import.invalid"
+	}
+	username, created, err := imp.store.EnsurePlaceholder(ctx, id, Username(u.Name), email, u.AvatarURL, u.Bot)
+	if err != nil {
+		return local, false, false, err
+	}
+	return localUser{id: id, username: username, bot: u.Bot}, false, created, nil
+}
+
+func (imp *Importer) importRoom(ctx context.Context, archive *Archive, room Room, users map[string]localUser, opts Options, report *Report) error {
+	var members []string
+	for _, ext := range room.Members {
+		if u, ok := users[ext]; ok && !slices.Contains(members, u.id) {
+			members = append(members, u.id)
+		}
+	}
+
+	roomID := ""
+	if room.Type == "private" && len(members) == 2 {
+		// Uma conversa privada que já existe entre os dois recebe o histórico;
+		// se ela for criptografada, o histórico vai para uma nova.
+		existing, err := imp.store.PrivateRoom(ctx, members[0], members[1])
+		if err != nil {
+			return err
+		}
+		roomID = existing
+	}
+	if roomID == "" {
+		roomType := room.Type
+		if roomType == "private" && len(members) != 2 {
+			roomType = "group"
+		}
+		createdBy := ""
+		if roomType == "group" {
+			createdBy = opts.CreatedBy
+			if u, ok := users[room.CreatedBy]; ok {
+				createdBy = u.id
+			}
+		}
+		createdAt := room.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+
+		roomID = archive.id("room", room.ExternalID)
+		created, err := imp.store.EnsureRoom(ctx, NewRoom{
+			ID: roomID, Name: room.Name, Type: roomType, Topic: room.Topic,
+			CreatedBy: createdBy, CreatedAt: createdAt, Members: members,
+		})
+		if err != nil {
+			return err
+		}
+		if created {
+			report.RoomsCreated++
+		}
+	}
+	report.Rooms++
+
+	messages, err := room.Load()
+	if err != nil {
+		return err
+	}
+
+	batch := make([]Record, 0, min(len(messages), copyBatch))
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := imp.store.CopyMessages(ctx, batch)
+		if err != nil {
+			return err
+		}
+		report.Inserted += n
+		batch = batch[:0]
+		return nil
+	}
+
+	for _, m := range messages {
+		rec := Record{Message: models.Message{
+			ID:          archive.id("message", room.ExternalID+":"+m.ExternalID),
+			RoomID:      roomID,
+			Username:    m.Username,
+			Content:     replaceMentions(m.Content, users),
+			Type:        m.Type,
+			Timestamp:   m.Timestamp,
+			EditedAt:    m.EditedAt,
+			Bot:         m.Bot,
+			Attachments: m.Attachments,
+		}}
+		if rec.Type == "" {
+			rec.Type = "message"
+		}
+		if u, ok := users[m.UserID]; ok {
+			rec.UserID, rec.Username, rec.Bot = u.id, u.username, rec.Bot || u.bot
+		}
+		if rec.Username == "" {
+			rec.Username = "importado"
+		}
+		if strings.TrimSpace(rec.Content) == "" && len(rec.Attachments) == 0 {
+			continue
+		}
+
+		batch = append(batch, rec)
+		report.Messages++
+		if len(batch) == copyBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	log.Printf("✓ Importação %s: sala %s (%d mensagens)", archive.Source, room.Name, len(messages))
+	return nil
+}
+
+var mentionPattern = regexp.MustCompile(`<@([A-Za-z0-9._-]+)(?:\|[^>]*)?>`)
+
+func replaceMentions(text string, users map[string]localUser) string {
+	return mentionPattern.ReplaceAllStringFunc(text, func(m string) string {
+		ext := mentionPattern.FindStringSubmatch(m)[1]
+		if u, ok := users[ext]; ok {
+			return "@" + u.username
+		}
+		return "@" + ext
+	})
+}
+
+// Username transforma um nome qualquer num nome de usuário válido: 3 a 20
+// caracteres entre letras minúsculas, dígitos, "_", "-" e ".".
+func Username(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)), r == '_', r == '-', r == '.':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune('.')
+		default:
+			if base := unaccent(r); base != 0 {
+				b.WriteRune(base)
+			}
+		}
+	}
+	s := strings.Trim(b.String(), ".-_")
+	if len(s) > 20 {
+		s = strings.Trim(s[:20], ".-_")
+	}
+	for len(s) < 3 {
+		s += "_"
+	}
+	return s
+}
+
+func unaccent(r rune) rune {
+	switch r {
+	case 'á', 'à', 'â', 'ã', 'ä':
+		return 'a'
+	case 'é', 'è', 'ê', 'ë':
+		return 'e'
+	case 'í', 'ì', 'î', 'ï':
+		return 'i'
+	case 'ó', 'ò', 'ô', 'õ', 'ö':
+		return 'o'
+	case 'ú', 'ù', 'û', 'ü':
+		return 'u'
+	case 'ç':
+		return 'c'
+	case 'ñ':
+		return 'n'
+	}
+	return 0
+}
@@ -0,0 +1,356 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memStore grava contas, salas e mensagens em memória, ignorando IDs
+// repetidos e conversas criptografadas como o banco.
+type memStore struct {
+	emails    map[string]string // email -> id
+	users     map[string]string // id -> username
+	rooms     map[string]NewRoom
+	private   map[[2]string]string
+	encrypted map[string]bool
+	messages  map[string]Record
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		emails:    map[string]string{"ana@example.com": "local-ana"},
+		users:     map[string]string{"local-ana": "ana.silva"},
+		rooms:     make(map[string]NewRoom),
+		private:   make(map[[2]string]string),
+		encrypted: make(map[string]bool),
+		messages:  make(map[string]Record),
+	}
+}
+
+func (s *memStore) UserByEmail(ctx context.Context, email string) (string, string, error) {
+	id := s.emails[email]
+	return id, s.users[id], nil
+}
+
+func (s *memStore) EnsurePlaceholder(ctx context.Context, id, username, email, avatarURL string, bot bool) (string, bool, error) {
+	if name, ok := s.users[id]; ok {
+		return name, false, nil
+	}
+	s.users[id] = username
+	s.emails[email] = id
+	return username, true, nil
+}
+
+func (s *memStore) PrivateRoom(ctx context.Context, user1ID, user2ID string) (string, error) {
+	id, ok := s.private[[2]string{user1ID, user2ID}]
+	if !ok {
+		id = s.private[[2]string{user2ID, user1ID}]
+	}
+	if s.encrypted[id] {
+		return "", nil
+	}
+	return id, nil
+}
+
+func (s *memStore) EnsureRoom(ctx context.Context, room NewRoom) (bool, error) {
+	if _, ok := s.rooms[room.ID]; ok {
+		return false, nil
+	}
+	s.rooms[room.ID] = room
+	return true, nil
+}
+
+func (s *memStore) CopyMessages(ctx context.Context, records []Record) (int64, error) {
+	var n int64
+	for _, rec := range records {
+		if _, ok := s.messages[rec.ID]; !ok {
+			s.messages[rec.ID] = rec
+			n++
+		}
+	}
+	return n, nil
+}
+
+func slackZip(t *testing.T) *zip.Reader {
+	t.Helper()
+	files := map[string]string{
+		"export/users.json": `[
+			{"id": "U1", "team_id": "T9", "name": "ana", "profile": {"email": "Ana@Example.com"}},
+			{"id": "U2", "team_id": "T9", "name": "Bruno Conceição", "profile": {"email": "bruno@example.com"}}
+		]`,
+		"export/channels.json": `[{"id": "C1", "name": "geral", "created": 1697720000, "creator": "U1",
+			"members": ["U1", "U2"], "topic": {"value": ""}, "purpose": {"value": "Conversa geral"}}]`,
+		"export/dms.json": `[{"id": "D1", "members": ["U1", "U2"]}]`,
+		"export/geral/2023-10-19.json": `[
+			{"type": "message", "ts": "1697720400.000200", "user": "U1", "text": "oi <@U2>, veja <https://example.com|o site>"},
+			{"type": "message", "subtype": "channel_join", "ts": "1697720500.000000", "user": "U2", "text": "<@U2> entrou no canal"},
+			{"type": "message", "subtype": "bot_message", "ts": "1697720600.000000", "username": "deploy", "text": "deploy ok"},
+			{"type": "message", "subtype": "message_deleted", "ts": "1697720700.000000"}
+		]`,
+		"export/geral/2023-10-20.json": `[
+			{"type": "message", "ts": "1697806800.000000", "user": "U2", "text": "", "edited": {"ts": "1697806900.000000"},
+			 "files": [{"name": "plano.pdf", "permalink": "https://files.example.com/plano.pdf"}]}
+		]`,
+		"export/D1/2023-10-19.json": `[{"type": "message", "ts": "1697720450.000000", "user": "U2", "text": "oi &amp; tchau"}]`,
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestParseSlack(t *testing.T) {
+	archive, err := ParseSlack(slackZip(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.Source != "slack:T9" || len(archive.Users) != 2 || len(archive.Rooms) != 2 {
+		t.Fatalf("arquivo: %+v", archive)
+	}
+
+	geral := archive.Rooms[0]
+	if geral.Name != "geral" || geral.Type != "group" || geral.Topic != "Conversa geral" || geral.CreatedBy != "U1" {
+		t.Fatalf("canal: %+v", geral)
+	}
+	messages, err := geral.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("mensagens: %d, quer 4", len(messages))
+	}
+	if got := messages[0].Content; got != "oi <@U2>, veja o site (https://example.com)" {
+		t.Errorf("texto: %q", got)
+	}
+	if got := messages[0].Timestamp; !got.Equal(time.Unix(1697720400, 200000)) {
+		t.Errorf("horário: %v", got)
+	}
+	if messages[1].Type != "system" || messages[1].UserID != "" {
+		t.Errorf("entrada no canal: %+v", messages[1])
+	}
+	if !messages[2].Bot || messages[2].Username != "deploy" {
+		t.Errorf("bot: %+v", messages[2])
+	}
+	if messages[3].EditedAt == nil || len(messages[3].Attachments) != 1 || messages[3].Attachments[0].Title != "plano.pdf" {
+		t.Errorf("arquivo: %+v", messages[3])
+	}
+
+	dm := archive.Rooms[1]
+	if dm.Type != "private" || dm.Name != "" {
+		t.Fatalf("conversa privada: %+v", dm)
+	}
+	if messages, _ := dm.Load(); len(messages) != 1 || messages[0].Content != "oi & tchau" {
+		t.Errorf("mensagens da conversa privada: %+v", messages)
+	}
+}
+
+func TestParseWhatsAppAndroid(t *testing.T) {
+	chat := "19/10/2026 09:15 - As mensagens e ligações são protegidas com a criptografia de ponta a ponta.\n" +
+		"19/10/2026 09:16 - Ana: Bom dia\n" +
+		"continuando na linha de baixo\n" +
+		"19/10/2026 09:16 - Bruno: oi <Mensagem editada>\n" +
+		"19/10/2026 09:17 - Bruno: IMG-001.jpg (arquivo anexado)\n" +
+		"19/10/2026 09:18 - Carla: 13:00: almoço?\n"
+
+	sp, _ := time.LoadLocation("America/Sao_Paulo")
+	archive, err := ParseWhatsApp(strings.NewReader(chat), "Conversa do WhatsApp com Time.txt", WhatsAppOptions{Location: sp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room := archive.Rooms[0]
+	if room.Name != "Time" || room.Type != "group" || len(archive.Users) != 3 {
+		t.Fatalf("sala: %+v, pessoas: %+v", room, archive.Users)
+	}
+
+	messages, _ := room.Load()
+	if len(messages) != 5 {
+		t.Fatalf("mensagens: %d, quer 5", len(messages))
+	}
+	if messages[0].Type != "system" {
+		t.Errorf("aviso: %+v", messages[0])
+	}
+	if messages[1].Content != "Bom dia\ncontinuando na linha de baixo" {
+		t.Errorf("continuação: %q", messages[1].Content)
+	}
+	if want := time.Date(2026, 10, 19, 9, 16, 0, 0, sp); !messages[1].Timestamp.Equal(want) {
+		t.Errorf("horário: %v, quer %v", messages[1].Timestamp, want)
+	}
+	if messages[2].Content != "oi" || messages[2].EditedAt == nil {
+		t.Errorf("editada: %+v", messages[2])
+	}
+	if messages[3].Content != "" || len(messages[3].Attachments) != 1 || messages[3].Attachments[0].Title != "IMG-001.jpg" {
+		t.Errorf("anexo: %+v", messages[3])
+	}
+	if messages[4].UserID != "Carla" || messages[4].Content != "13:00: almoço?" {
+		t.Errorf("autor: %+v", messages[4])
+	}
+}
+
+func TestParseWhatsAppIOS(t *testing.T) {
+	chat := "\u200e[10/19/26, 9:05:01 PM] Ana: oi\n" +
+		"[10/19/26, 9:06:00 PM] Bruno: \u200e<attached: 00000012-PHOTO.jpg>\n"
+
+	archive, err := ParseWhatsApp(strings.NewReader(chat), "_chat.txt", WhatsAppOptions{Name: "Ana e Bruno", Location: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room := archive.Rooms[0]
+	if room.Name != "Ana e Bruno" || room.Type != "private" {
+		t.Fatalf("sala: %+v", room)
+	}
+	messages, _ := room.Load()
+	if want := time.Date(2026, 10, 19, 21, 5, 1, 0, time.UTC); !messages[0].Timestamp.Equal(want) {
+		t.Errorf("horário: %v, quer %v", messages[0].Timestamp, want)
+	}
+	if len(messages[1].Attachments) != 1 || messages[1].Attachments[0].Title != "00000012-PHOTO.jpg" {
+		t.Errorf("anexo: %+v", messages[1])
+	}
+}
+
+func TestParseWhatsAppInvalid(t *testing.T) {
+	if _, err := ParseWhatsApp(strings.NewReader("não é uma conversa\n"), "x.txt", WhatsAppOptions{}); err == nil {
+		t.Fatal("esperava erro para arquivo sem mensagens")
+	}
+}
+
+func TestImportIdempotent(t *testing.T) {
+	archive, err := ParseSlack(slackZip(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMemStore()
+	imp := NewImporter(store)
+
+	report, err := imp.Import(context.Background(), archive, Options{CreatedBy: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.UsersMatched != 1 || report.UsersCreated != 1 || report.RoomsCreated != 2 || report.Inserted != 5 {
+		t.Fatalf("relatório: %+v", report)
+	}
+
+	// Ana já tinha conta; Bruno ganhou uma provisória.
+	bruno := archive.id("user", "U2")
+	if store.users[bruno] != "bruno.conceicao" || store.emails["bruno@example.com"] != bruno {
+		t.Errorf("conta provisória: %v", store.users)
+	}
+
+	geral := store.rooms[archive.id("room", "C1")]
+	if geral.Name != "geral" || geral.CreatedBy != "local-ana" || len(geral.Members) != 2 {
+		t.Errorf("canal: %+v", geral)
+	}
+	first := store.messages[archive.id("message", "C1:1697720400.000200")]
+	if first.UserID != "local-ana" || first.Username != "ana.silva" || first.Content != "oi @bruno.conceicao, veja o site (https://example.com)" {
+		t.Errorf("mensagem: %+v", first)
+	}
+
+	again, err := imp.Import(context.Background(), archive, Options{CreatedBy: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.UsersCreated != 0 || again.RoomsCreated != 0 || again.Inserted != 0 || again.Messages != 5 {
+		t.Errorf("segunda importação: %+v", again)
+	}
+	if len(store.messages) != 5 || len(store.rooms) != 2 {
+		t.Errorf("duplicou: %d mensagens, %d salas", len(store.messages), len(store.rooms))
+	}
+}
+
+func TestImportExistingPrivateRoom(t *testing.T) {
+	chat := "19/10/2026 09:16 - Ana: oi\n19/10/2026 09:17 - Bia: olá\n"
+	archive, err := ParseWhatsApp(strings.NewReader(chat), "ana.txt", WhatsAppOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMemStore()
+	store.emails["bia@example.com"] = "local-bia"
+	store.users["local-bia"] = "bia"
+	store.private[[2]string{"local-ana", "local-bia"}] = "dm"
+
+	emails, err := ParseEmails("Ana=ana@example.com\nBia = bia@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewImporter(store).Import(context.Background(), archive, Options{Emails: emails})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.UsersMatched != 2 || report.RoomsCreated != 0 || report.Inserted != 2 {
+		t.Fatalf("relatório: %+v", report)
+	}
+	for _, m := range store.messages {
+		if m.RoomID != "dm" {
+			t.Errorf("mensagem fora da conversa existente: %+v", m)
+		}
+	}
+}
+
+func TestImportSkipsEncryptedPrivateRoom(t *testing.T) {
+	chat := "19/10/2026 09:16 - Ana: oi\n19/10/2026 09:17 - Bia: olá\n"
+	archive, err := ParseWhatsApp(strings.NewReader(chat), "ana.txt", WhatsAppOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMemStore()
+	store.emails["bia@example.com"] = "local-bia"
+	store.users["local-bia"] = "bia"
+	store.private[[2]string{"local-ana", "local-bia"}] = "dm"
+	store.encrypted["dm"] = true
+
+	emails, err := ParseEmails("Ana=ana@example.com\nBia = bia@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewImporter(store).Import(context.Background(), archive, Options{Emails: emails})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.RoomsCreated != 1 || report.Inserted != 2 {
+		t.Fatalf("relatório: %+v", report)
+	}
+	for _, m := range store.messages {
+		if m.RoomID == "dm" {
+			t.Fatalf("histórico em claro na conversa criptografada: %+v", m)
+		}
+		room := store.rooms[m.RoomID]
+		if room.Type != "private" || !slices.Equal(room.Members, []string{"local-ana", "local-bia"}) {
+			t.Errorf("sala do histórico = %+v", room)
+		}
+	}
+}
+
+func TestUsername(t *testing.T) {
+	tests := map[string]string{
+		"Bruno Conceição":                 "bruno.conceicao",
+		"jo":                              "jo_",
+		"  Ana  ":                         "ana",
+		"Maria Aparecida dos Santos Lima": "maria.aparecida.dos",
+		"📱":                               "___",
+	}
+	for in, want := range tests {
+		if got := Username(in); got != want {
+			t.Errorf("Username(%q) = %q, quer %q", in, got, want)
+		}
+	}
+}
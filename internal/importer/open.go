@@ -0,0 +1,80 @@
+package importer
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Open lê o arquivo de uma origem. A exportação do Slack é um zip; a do
+// WhatsApp, o .txt da conversa ou o zip que o celular gera com ele. O
+// Closer só pode ser fechado depois de Import, que ainda lê as mensagens.
+func Open(source, file string, wa WhatsAppOptions) (*Archive, io.Closer, error) {
+	switch source {
+	case SourceSlack:
+		zr, err := zip.OpenReader(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("exportação do Slack deve ser um zip: %w", err)
+		}
+		archive, err := ParseSlack(&zr.Reader)
+		if err != nil {
+			zr.Close()
+			return nil, nil, err
+		}
+		return archive, zr, nil
+
+	case SourceWhatsApp:
+		archive, err := openWhatsApp(file, wa)
+		if err != nil {
+			return nil, nil, err
+		}
+		return archive, nopCloser{}, nil
+
+	default:
+		return nil, nil, fmt.Errorf("origem desconhecida %q (use %s ou %s)", source, SourceSlack, SourceWhatsApp)
+	}
+}
+
+func openWhatsApp(file string, opts WhatsAppOptions) (*Archive, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseWhatsApp(f, file, opts)
+	}
+	defer zr.Close()
+
+	// O iOS chama a conversa de _chat.txt; o Android, pelo nome do contato.
+	var chat *zip.File
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, ".txt") && (chat == nil || path.Base(f.Name) == "_chat.txt") {
+			chat = f
+		}
+	}
+	if chat == nil {
+		return nil, errors.New("nenhuma conversa (.txt) no zip do WhatsApp")
+	}
+	name := chat.Name
+	if path.Base(name) == "_chat.txt" {
+		name = strings.TrimSuffix(path.Base(file), path.Ext(file))
+	}
+
+	rc, err := chat.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ParseWhatsApp(rc, name, opts)
+}
+
+// nopCloser: a conversa do WhatsApp já foi lida inteira por Open.
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
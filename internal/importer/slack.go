@@ -0,0 +1,312 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+type slackUser struct {
+	ID      string `json:"id"`
+	TeamID  string `json:"team_id"`
+	Name    string `json:"name"`
+	IsBot   bool   `json:"is_bot"`
+	Profile struct {
+		Email       string `json:"email"`
+		DisplayName string `json:"display_name"`
+		Image72     string `json:"image_72"`
+	} `json:"profile"`
+}
+
+type slackChannel struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Created int64    `json:"created"`
+	Creator string   `json:"creator"`
+	Members []string `json:"members"`
+	Topic   struct {
+		Value string `json:"value"`
+	} `json:"topic"`
+	Purpose struct {
+		Value string `json:"value"`
+	} `json:"purpose"`
+}
+
+type slackMessage struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype"`
+	TS       string `json:"ts"`
+	User     string `json:"user"`
+	Username string `json:"username"`
+	BotID    string `json:"bot_id"`
+	Text     string `json:"text"`
+	Edited   *struct {
+		TS string `json:"ts"`
+	} `json:"edited"`
+	Files []struct {
+		Name      string `json:"name"`
+		Title     string `json:"title"`
+		Permalink string `json:"permalink"`
+	} `json:"files"`
+	Attachments []struct {
+		Title     string `json:"title"`
+		TitleLink string `json:"title_link"`
+		Text      string `json:"text"`
+		Fallback  string `json:"fallback"`
+		ImageURL  string `json:"image_url"`
+	} `json:"attachments"`
+	BotProfile *struct {
+		Name string `json:"name"`
+	} `json:"bot_profile"`
+}
+
+var slackLink = regexp.MustCompile(`<([^|>]+)(?:\|([^>]+))?>`)
+
+// slackSkipped são subtipos que não são mensagens de ninguém: alterações e
+// remoções já vêm aplicadas nas mensagens originais.
+var slackSkipped = map[string]bool{
+	"message_changed": true,
+	"message_deleted": true,
+	"tombstone":       true,
+}
+
+// ParseSlack lê a exportação de um workspace do Slack: users.json, os
+// canais (channels.json, groups.json, mpims.json, dms.json, os que vierem)
+// e uma pasta por canal com um JSON por dia. Canais e conversas em grupo
+// viram grupos; mensagens diretas, conversas privadas.
+func ParseSlack(zr *zip.Reader) (*Archive, error) {
+	// A exportação pode vir dentro de uma pasta: vale o users.json mais
+	// próximo da raiz.
+	files := make(map[string]*zip.File, len(zr.File))
+	prefix, found := "", false
+	for _, f := range zr.File {
+		files[f.Name] = f
+		if path.Base(f.Name) == "users.json" && (!found || len(f.Name) < len(prefix)+len("users.json")) {
+			prefix, found = strings.TrimSuffix(f.Name, "users.json"), true
+		}
+	}
+	if !found {
+		return nil, errors.New("users.json não encontrado: o arquivo não parece uma exportação do Slack")
+	}
+
+	// Os arquivos diários de cada pasta, do mais antigo ao mais novo.
+	days := make(map[string][]*zip.File)
+	for _, f := range zr.File {
+		dir, day := path.Split(f.Name)
+		if strings.HasPrefix(dir, prefix) && dir != prefix && strings.HasSuffix(day, ".json") {
+			days[dir] = append(days[dir], f)
+		}
+	}
+	for _, list := range days {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+
+	var users []slackUser
+	if err := readZipJSON(files[prefix+"users.json"], &users); err != nil {
+		return nil, fmt.Errorf("users.json: %w", err)
+	}
+
+	archive := &Archive{Source: SourceSlack}
+	for _, u := range users {
+		if u.TeamID != "" && archive.Source == SourceSlack {
+			archive.Source = SourceSlack + ":" + u.TeamID
+		}
+		name := u.Name
+		if name == "" {
+			name = u.Profile.DisplayName
+		}
+		archive.Users = append(archive.Users, User{
+			ExternalID: u.ID,
+			Name:       name,
+			Email:      u.Profile.Email,
+			AvatarURL:  u.Profile.Image72,
+			Bot:        u.IsBot,
+		})
+	}
+
+	sources := []struct {
+		file     string
+		roomType string
+		byID     bool
+	}{
+		{"channels.json", "group", false},
+		{"groups.json", "group", false},
+		{"mpims.json", "group", false},
+		{"dms.json", "private", true},
+	}
+	for _, src := range sources {
+		f := files[prefix+src.file]
+		if f == nil {
+			continue
+		}
+		var channels []slackChannel
+		if err := readZipJSON(f, &channels); err != nil {
+			return nil, fmt.Errorf("%s: %w", src.file, err)
+		}
+
+		for _, ch := range channels {
+			// Mensagens diretas ficam numa pasta com o ID; os demais, com o nome.
+			dir := ch.Name
+			if src.byID || dir == "" {
+				dir = ch.ID
+			}
+			name := ch.Name
+			if src.roomType == "private" {
+				name = ""
+			}
+			topic := ch.Topic.Value
+			if topic == "" {
+				topic = ch.Purpose.Value
+			}
+			room := Room{
+				ExternalID: ch.ID,
+				Name:       name,
+				Type:       src.roomType,
+				Topic:      topic,
+				CreatedBy:  ch.Creator,
+				Members:    ch.Members,
+			}
+			if ch.Created > 0 {
+				room.CreatedAt = time.Unix(ch.Created, 0)
+			}
+			room.Load = func() ([]Message, error) {
+				return loadSlackChannel(days[prefix+dir+"/"])
+			}
+			archive.Rooms = append(archive.Rooms, room)
+		}
+	}
+	return archive, nil
+}
+
+func readZipJSON(f *zip.File, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v)
+}
+
+func loadSlackChannel(days []*zip.File) ([]Message, error) {
+	var messages []Message
+	for _, day := range days {
+		var raw []slackMessage
+		if err := readZipJSON(day, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", day.Name, err)
+		}
+		for _, m := range raw {
+			if msg, ok := slackToMessage(m); ok {
+				messages = append(messages, msg)
+			}
+		}
+	}
+	return messages, nil
+}
+
+func slackToMessage(m slackMessage) (Message, bool) {
+	if m.Type != "message" || slackSkipped[m.Subtype] {
+		return Message{}, false
+	}
+	ts, ok := slackTime(m.TS)
+	if !ok {
+		return Message{}, false
+	}
+
+	msg := Message{
+		ExternalID: m.TS,
+		UserID:     m.User,
+		Content:    slackText(m.Text),
+		Type:       "message",
+		Timestamp:  ts,
+	}
+	switch {
+	case strings.HasPrefix(m.Subtype, "channel_"), strings.HasPrefix(m.Subtype, "group_"):
+		// Entradas, saídas e trocas de tópico: avisos do sistema.
+		msg.Type, msg.UserID = "system", ""
+	case m.Subtype == "bot_message" || (m.User == "" && m.BotID != ""):
+		msg.Bot, msg.UserID = true, ""
+		msg.Username = m.Username
+		if msg.Username == "" && m.BotProfile != nil {
+			msg.Username = m.BotProfile.Name
+		}
+		if msg.Username == "" {
+			msg.Username = "bot"
+		}
+	}
+	if m.Edited != nil {
+		if edited, ok := slackTime(m.Edited.TS); ok {
+			msg.EditedAt = &edited
+		}
+	}
+
+	for _, f := range m.Files {
+		title := f.Title
+		if title == "" {
+			title = f.Name
+		}
+		msg.Attachments = append(msg.Attachments, models.Attachment{Title: title, URL: f.Permalink})
+	}
+	for _, a := range m.Attachments {
+		text := a.Text
+		if text == "" {
+			text = a.Fallback
+		}
+		msg.Attachments = append(msg.Attachments, models.Attachment{
+			Title: a.Title, URL: a.TitleLink, Text: slackText(text), ImageURL: a.ImageURL,
+		})
+	}
+	return msg, true
+}
+
+// slackTime lê o ts do Slack ("1697720400.000200", segundos e micros).
+func slackTime(ts string) (time.Time, bool) {
+	sec, frac, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var micros int64
+	if frac != "" {
+		if micros, err = strconv.ParseInt((frac + "000000")[:6], 10, 64); err != nil {
+			return time.Time{}, false
+		}
+	}
+	return time.Unix(s, micros*1000), true
+}
+
+// slackText converte a marcação do Slack em texto simples. Menções de
+// usuários (<@U123>) ficam para a importação, que conhece os nomes locais.
+func slackText(text string) string {
+	text = slackLink.ReplaceAllStringFunc(text, func(m string) string {
+		parts := slackLink.FindStringSubmatch(m)
+		target, label := parts[1], parts[2]
+		switch {
+		case strings.HasPrefix(target, "@"):
+			return m
+		case strings.HasPrefix(target, "#"):
+			if label != "" {
+				return "#" + label
+			}
+			return target
+		case strings.HasPrefix(target, "!"):
+			if label != "" {
+				return label
+			}
+			return "@" + strings.TrimPrefix(target, "!")
+		case label != "" && label != target:
+			return label + " (" + target + ")"
+		default:
+			return target
+		}
+	})
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(text)
+}
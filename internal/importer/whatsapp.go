@@ -0,0 +1,198 @@
+package importer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// WhatsAppOptions: Name é o nome da sala (padrão: o do arquivo); Location
+// é o fuso do celular que exportou, já que os horários vêm sem fuso.
+type WhatsAppOptions struct {
+	Name     string
+	Location *time.Location
+}
+
+// Cabeçalho de cada mensagem nos formatos do Android ("19/10/2026 12:00 -
+// Ana: oi") e do iOS ("[19/10/2026, 12:00:00] Ana: oi"), com ou sem AM/PM.
+var whatsAppLine = regexp.MustCompile(`^\[?(\d{1,2})[/.-](\d{1,2})[/.-](\d{2,4}),? (\d{1,2}):(\d{2})(?::(\d{2}))? ?([AaPp])?\.? ?[Mm]?\.?\]?(?: -)? (.*)$`)
+
+var (
+	whatsAppAttached = regexp.MustCompile(`^<(?:anexado|attached): ([^>]+)>$`)
+	whatsAppFile     = regexp.MustCompile(`^(.+\.\w{2,5}) \((?:arquivo anexado|file attached)\)$`)
+	whatsAppEdited   = []string{"<Mensagem editada>", "<This message was edited>"}
+	// Marcas de direção e espaços especiais que o iOS põe nas linhas.
+	whatsAppInvisible = strings.NewReplacer("\u200e", "", "\u200f", "", "\ufeff", "", "\u202f", " ", "\u00a0", " ")
+	whatsAppName      = regexp.MustCompile(`^(?:Conversa do WhatsApp com |WhatsApp Chat with |WhatsApp Chat - )?(.+?)(?:\.txt)?$`)
+)
+
+type whatsAppHeader struct {
+	a, b, year, hour, minute, second int
+	ampm                             string
+	rest                             string
+}
+
+// ParseWhatsApp lê a conversa exportada pelo WhatsApp ("Exportar
+// conversa"). Não há emails nem IDs: cada autor é identificado pelo nome, e
+// Options.Emails liga os nomes às contas. Com dois autores a conversa vira
+// privada; com mais, um grupo.
+func ParseWhatsApp(r io.Reader, filename string, opts WhatsAppOptions) (*Archive, error) {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	name := strings.TrimSpace(opts.Name)
+	if name == "" {
+		name = whatsAppName.FindStringSubmatch(path.Base(filename))[1]
+	}
+
+	type entry struct {
+		header whatsAppHeader
+		lines  []string
+	}
+	var entries []entry
+	dayFirst, monthFirst := false, false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := whatsAppInvisible.Replace(scanner.Text())
+		h, ok := parseWhatsAppHeader(line)
+		if !ok {
+			// Continuação da mensagem anterior (texto com quebras de linha).
+			if len(entries) > 0 {
+				last := &entries[len(entries)-1]
+				last.lines = append(last.lines, line)
+			}
+			continue
+		}
+		dayFirst = dayFirst || h.a > 12
+		monthFirst = monthFirst || h.b > 12
+		entries = append(entries, entry{header: h, lines: []string{h.rest}})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("nenhuma mensagem reconhecida: o arquivo não parece uma conversa exportada do WhatsApp")
+	}
+	if dayFirst && monthFirst {
+		return nil, errors.New("datas em formatos diferentes no mesmo arquivo")
+	}
+
+	archive := &Archive{Source: SourceWhatsApp}
+	seen := make(map[string]int)
+	known := make(map[string]bool)
+	var authors []string
+	var messages []Message
+	for _, e := range entries {
+		ts := e.header.time(!monthFirst, loc)
+		author, text := splitWhatsAppAuthor(strings.Join(e.lines, "\n"))
+
+		msg := Message{Type: "message", Timestamp: ts, UserID: author, Username: author}
+		if author == "" {
+			msg.Type, msg.Username = "system", "WhatsApp"
+		} else if !known[author] {
+			known[author] = true
+			authors = append(authors, author)
+		}
+
+		for _, suffix := range whatsAppEdited {
+			if trimmed, ok := strings.CutSuffix(text, suffix); ok {
+				text = strings.TrimSpace(trimmed)
+				edited := ts
+				msg.EditedAt = &edited
+			}
+		}
+		if m := whatsAppAttached.FindStringSubmatch(text); m != nil {
+			msg.Attachments = []models.Attachment{{Title: m[1]}}
+			text = ""
+		} else if m := whatsAppFile.FindStringSubmatch(text); m != nil {
+			msg.Attachments = []models.Attachment{{Title: m[1]}}
+			text = ""
+		}
+		msg.Content = text
+
+		// Sem IDs no arquivo: horário, autor e a ordem entre as mensagens do
+		// mesmo minuto identificam cada uma, e se mantêm numa exportação
+		// posterior da mesma conversa.
+		key := strconv.FormatInt(ts.Unix(), 10) + ":" + author
+		seen[key]++
+		msg.ExternalID = fmt.Sprintf("%s:%d", key, seen[key])
+		messages = append(messages, msg)
+	}
+
+	roomType := "group"
+	if len(authors) == 2 {
+		roomType = "private"
+	}
+	for _, a := range authors {
+		archive.Users = append(archive.Users, User{ExternalID: a, Name: a})
+	}
+	archive.Rooms = []Room{{
+		ExternalID: name,
+		Name:       name,
+		Type:       roomType,
+		CreatedAt:  messages[0].Timestamp,
+		Members:    authors,
+		Load:       func() ([]Message, error) { return messages, nil },
+	}}
+	return archive, nil
+}
+
+func parseWhatsAppHeader(line string) (whatsAppHeader, bool) {
+	m := whatsAppLine.FindStringSubmatch(line)
+	if m == nil {
+		return whatsAppHeader{}, false
+	}
+	var h whatsAppHeader
+	h.a, _ = strconv.Atoi(m[1])
+	h.b, _ = strconv.Atoi(m[2])
+	h.year, _ = strconv.Atoi(m[3])
+	h.hour, _ = strconv.Atoi(m[4])
+	h.minute, _ = strconv.Atoi(m[5])
+	h.second, _ = strconv.Atoi(m[6])
+	h.ampm = strings.ToLower(m[7])
+	h.rest = m[8]
+	if h.a == 0 || h.b == 0 || h.a > 31 || h.b > 31 || h.hour > 23 || h.minute > 59 {
+		return whatsAppHeader{}, false
+	}
+	return h, true
+}
+
+func (h whatsAppHeader) time(dayFirst bool, loc *time.Location) time.Time {
+	day, month := h.a, h.b
+	if !dayFirst {
+		day, month = h.b, h.a
+	}
+	year := h.year
+	if year < 100 {
+		year += 2000
+	}
+	hour := h.hour
+	switch {
+	case h.ampm == "p" && hour < 12:
+		hour += 12
+	case h.ampm == "a" && hour == 12:
+		hour = 0
+	}
+	return time.Date(year, time.Month(month), day, hour, h.minute, h.second, 0, loc)
+}
+
+// splitWhatsAppAuthor separa "Ana Silva: texto". Linhas sem autor são avisos
+// do sistema ("Ana adicionou Bia").
+func splitWhatsAppAuthor(text string) (author, rest string) {
+	i := strings.Index(text, ": ")
+	if i <= 0 || i > 80 || strings.ContainsAny(text[:i], "\n\"“") {
+		return "", text
+	}
+	return text[:i], text[i+2:]
+}
@@ -0,0 +1,583 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+	"github.com/lucaspanzera1/chat/internal/safehttp"
+	"github.com/lucaspanzera1/chat/internal/webhook"
+)
+
+const (
+	// CommandTimeout é quanto um bot tem para responder a um comando.
+	CommandTimeout = 3 * time.Second
+	// LateReplyWindow é quanto uma resposta atrasada de bot ainda é aceita.
+	LateReplyWindow = 15 * time.Minute
+
+	defaultMute = 10 * time.Minute
+	maxMute     = 7 * 24 * time.Hour
+	maxTopic    = 250
+)
+
+var (
+	commandPattern = regexp.MustCompile(`^/([a-z][a-z0-9_-]{0,31})(?:\s+((?s).*))?$`)
+
+	// CommandNamePattern valida nomes de comandos registrados por bots.
+	CommandNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+	ErrUnknownInvocation = protocol.NewError(protocol.CodeValidation, "Invocação desconhecida ou expirada")
+
+	errCommandLate = errors.New("bot não respondeu a tempo")
+)
+
+// ParseCommand reconhece "/nome argumentos". Nomes são minúsculos, como no
+// Slack; "/Nome" ou "/ texto" seguem como mensagem comum.
+func ParseCommand(content string) (name, args string, ok bool) {
+	m := commandPattern.FindStringSubmatch(strings.TrimSpace(content))
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], strings.TrimSpace(m[2]), true
+}
+
+type CommandStore interface {
+	GetCommand(ctx context.Context, name string) (*models.BotCommand, error)
+}
+
+type CommandRooms interface {
+	SetTopic(ctx context.Context, roomID, topic string) error
+	AddUserToGroup(ctx context.Context, roomID, userID string) error
+	Mute(ctx context.Context, roomID, userID, mutedBy string, until time.Time) error
+	Unmute(ctx context.Context, roomID, userID string) error
+	MutedUntil(ctx context.Context, roomID, userID string) (*time.Time, error)
+}
+
+type CommandUsers interface {
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+}
+
+// CommandConfig traz o que os comandos precisam além do pipeline. Campos
+// nulos desativam os comandos que dependem deles.
+type CommandConfig struct {
+	Store  CommandStore
+	Rooms  CommandRooms
+	Users  CommandUsers
+	Client *http.Client
+}
+
+// CommandReply é a resposta de um bot. Ephemeral só aparece para quem
+// invocou; do contrário vira uma mensagem do bot na sala.
+type CommandReply struct {
+	Text      string `json:"text"`
+	Ephemeral bool   `json:"ephemeral"`
+}
+
+// Invocation é o que o bot recebe ao ser chamado.
+type Invocation struct {
+	ID       string `json:"invocationId"`
+	Command  string `json:"command"`
+	Text     string `json:"text"`
+	RoomID   string `json:"roomId"`
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+}
+
+type builtinCommand struct {
+	usage string
+	help  string
+	run   func(p *Pipeline, ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error)
+}
+
+// builtins é preenchido nos init com RegisterCommand; a ordem dos arquivos
+// não importa.
+var builtins = map[string]builtinCommand{}
+
+func init() {
+	RegisterCommand("help", "/help", "lista os comandos", (*Pipeline).cmdHelp)
+	RegisterCommand("me", "/me <ação>", "envia uma ação em terceira pessoa", (*Pipeline).cmdMe)
+	RegisterCommand("topic", "/topic [texto]", "mostra ou muda o tópico da sala", (*Pipeline).cmdTopic)
+	RegisterCommand("invite", "/invite @usuário", "adiciona alguém ao grupo (criador)", (*Pipeline).cmdInvite)
+	RegisterCommand("mute", "/mute @usuário [duração]", "silencia alguém no grupo, 10m por padrão (criador)", (*Pipeline).cmdMute)
+	RegisterCommand("unmute", "/unmute @usuário", "encerra o silêncio (criador)", (*Pipeline).cmdUnmute)
+}
+
+// RegisterCommand acrescenta um comando embutido. Serve para recursos que
+// vivem fora deste arquivo (ex.: /poll).
+func RegisterCommand(name, usage, help string, run func(p *Pipeline, ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error)) {
+	builtins[name] = builtinCommand{usage: usage, help: help, run: run}
+}
+
+type pendingInvocation struct {
+	botID string
+	reply chan CommandReply
+}
+
+// lateInvocation guarda a invocação que passou do CommandTimeout para que
+// a resposta atrasada ainda chegue a quem chamou.
+type lateInvocation struct {
+	cmd     *models.BotCommand
+	roomID  string
+	userID  string
+	expires time.Time
+}
+
+type commandState struct {
+	CommandConfig
+
+	mu      sync.Mutex
+	pending map[string]*pendingInvocation
+	late    map[string]*lateInvocation
+}
+
+// SetCommands ativa os comandos de barra. Sem eles todo conteúdo é
+// mensagem comum. O cliente padrão não alcança a rede interna: a URL do
+// comando é escolhida por quem criou o bot.
+func (p *Pipeline) SetCommands(cfg CommandConfig) {
+	if cfg.Client == nil {
+		cfg.Client = safehttp.NewClient(safehttp.Options{Timeout: CommandTimeout})
+	}
+	p.commands = &commandState{
+		CommandConfig: cfg,
+		pending:       make(map[string]*pendingInvocation),
+		late:          make(map[string]*lateInvocation),
+	}
+}
+
+// checkMuted recusa quem foi silenciado na sala com /mute.
+func (p *Pipeline) checkMuted(ctx context.Context, roomID, userID string) error {
+	if p.commands == nil || p.commands.Rooms == nil {
+		return nil
+	}
+	until, err := p.commands.Rooms.MutedUntil(ctx, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if until != nil {
+		return protocol.RateLimited("Você foi silenciado nesta sala até "+until.Format("15:04"), time.Until(*until))
+	}
+	return nil
+}
+
+func (p *Pipeline) runCommand(ctx context.Context, sender Sender, room *models.Room, name, args string) (*models.Message, error) {
+	if cmd, ok := builtins[name]; ok {
+		return cmd.run(p, ctx, sender, room, args)
+	}
+	return p.runBotCommand(ctx, sender, room, name, args)
+}
+
+// Ephemeral monta uma resposta que só o destinatário vê: não é gravada nem
+// vai para a sala.
+func Ephemeral(roomID, text string) *models.Message {
+	return &models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		Username:  "sistema",
+		Content:   text,
+		Timestamp: time.Now(),
+		Type:      "ephemeral",
+	}
+}
+
+// systemMessage grava e transmite um aviso da sala (tópico, convite).
+func (p *Pipeline) systemMessage(ctx context.Context, sender Sender, roomID, content string) (*models.Message, error) {
+	msg := models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		Username:  sender.Username,
+		Content:   content,
+		Timestamp: time.Now(),
+		Type:      "system",
+	}
+	if err := p.deliver(ctx, &msg, sender.UserID); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (p *Pipeline) cmdHelp(ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error) {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("Comandos disponíveis:")
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n%s - %s", builtins[name].usage, builtins[name].help)
+	}
+	b.WriteString("\nBots da sala podem registrar outros comandos. Use // para enviar uma mensagem começando com /.")
+	return Ephemeral(room.ID, b.String()), nil
+}
+
+func (p *Pipeline) cmdMe(ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error) {
+	if args == "" {
+		return Ephemeral(room.ID, "Uso: /me <ação>"), nil
+	}
+
+	msg := models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    room.ID,
+		Username:  sender.Username,
+		AvatarURL: sender.AvatarURL,
+		Content:   args,
+		Timestamp: time.Now(),
+		Type:      "action",
+		Bot:       sender.IsBot,
+	}
+	if err := p.deliver(ctx, &msg, sender.UserID); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (p *Pipeline) cmdTopic(ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error) {
+	if args == "" {
+		if room.Topic == "" {
+			return Ephemeral(room.ID, "Esta sala não tem tópico"), nil
+		}
+		return Ephemeral(room.ID, "Tópico: "+room.Topic), nil
+	}
+	if p.commands.Rooms == nil {
+		return Ephemeral(room.ID, "Comando indisponível"), nil
+	}
+
+	switch {
+	case room.Type == "general":
+		return Ephemeral(room.ID, "A sala geral não tem tópico"), nil
+	case room.Type == "group" && room.CreatedBy != sender.UserID:
+		return Ephemeral(room.ID, "Apenas o criador do grupo muda o tópico"), nil
+	case len(args) > maxTopic:
+		return Ephemeral(room.ID, fmt.Sprintf("Tópico deve ter até %d caracteres", maxTopic)), nil
+	}
+
+	if err := p.commands.Rooms.SetTopic(ctx, room.ID, args); err != nil {
+		return nil, err
+	}
+	return p.systemMessage(ctx, sender, room.ID, sender.Username+" mudou o tópico para: "+args)
+}
+
+// targetUser resolve o "@usuário" dos comandos de moderação, que só valem
+// para o criador do grupo. Devolve a resposta efêmera quando não pode seguir.
+func (p *Pipeline) targetUser(ctx context.Context, sender Sender, room *models.Room, arg, usage string) (*models.User, *models.Message, error) {
+	if room.Type != "group" || room.CreatedBy != sender.UserID {
+		return nil, Ephemeral(room.ID, "Apenas o criador do grupo pode usar este comando"), nil
+	}
+	username := strings.TrimPrefix(arg, "@")
+	if username == "" || p.commands.Users == nil || p.commands.Rooms == nil {
+		return nil, Ephemeral(room.ID, "Uso: "+usage), nil
+	}
+
+	user, err := p.commands.Users.GetByUsername(ctx, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, Ephemeral(room.ID, "Usuário não encontrado: "+username), nil
+	}
+	return user, nil, nil
+}
+
+func (p *Pipeline) cmdInvite(ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error) {
+	user, reply, err := p.targetUser(ctx, sender, room, args, builtins["invite"].usage)
+	if user == nil {
+		return reply, err
+	}
+
+	isMember, err := p.rooms.IsMember(ctx, room.ID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if isMember {
+		return Ephemeral(room.ID, user.Username+" já participa do grupo"), nil
+	}
+
+	if err := p.commands.Rooms.AddUserToGroup(ctx, room.ID, user.ID); err != nil {
+		return nil, err
+	}
+	p.publish(ctx, models.EventMemberJoined, room.ID, models.MemberEvent{
+		RoomID: room.ID, UserID: user.ID, Username: user.Username, ActorID: sender.UserID,
+	})
+	p.Notify(room.ID, []string{user.ID}, sender.Username+" adicionou você ao grupo "+room.Name)
+	return p.systemMessage(ctx, sender, room.ID, sender.Username+" adicionou "+user.Username+" ao grupo")
+}
+
+func (p *Pipeline) cmdMute(ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error) {
+	target, rest, _ := strings.Cut(args, " ")
+	user, reply, err := p.targetUser(ctx, sender, room, target, builtins["mute"].usage)
+	if user == nil {
+		return reply, err
+	}
+	if user.ID == sender.UserID {
+		return Ephemeral(room.ID, "Você não pode silenciar a si mesmo"), nil
+	}
+
+	duration := defaultMute
+	if rest = strings.TrimSpace(rest); rest != "" {
+		d, err := time.ParseDuration(rest)
+		if err != nil || d <= 0 || d > maxMute {
+			return Ephemeral(room.ID, "Duração inválida (ex.: 30m, 2h; máximo 168h)"), nil
+		}
+		duration = d
+	}
+
+	until := time.Now().Add(duration)
+	if err := p.commands.Rooms.Mute(ctx, room.ID, user.ID, sender.UserID, until); err != nil {
+		return nil, err
+	}
+	p.Notify(room.ID, []string{user.ID}, fmt.Sprintf("%s silenciou você em %s até %s", sender.Username, room.Name, until.Format("02/01 15:04")))
+	return Ephemeral(room.ID, fmt.Sprintf("%s silenciado até %s", user.Username, until.Format("02/01 15:04"))), nil
+}
+
+func (p *Pipeline) cmdUnmute(ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error) {
+	user, reply, err := p.targetUser(ctx, sender, room, args, builtins["unmute"].usage)
+	if user == nil {
+		return reply, err
+	}
+	if err := p.commands.Rooms.Unmute(ctx, room.ID, user.ID); err != nil {
+		return nil, err
+	}
+	p.Notify(room.ID, []string{user.ID}, "Você pode falar de novo em "+room.Name)
+	return Ephemeral(room.ID, user.Username+" pode falar de novo"), nil
+}
+
+// runBotCommand despacha para o bot que registrou o comando. O bot precisa
+// participar da sala (a geral é de todos).
+func (p *Pipeline) runBotCommand(ctx context.Context, sender Sender, room *models.Room, name, args string) (*models.Message, error) {
+	unknown := Ephemeral(room.ID, "Comando desconhecido: /"+name+". Use /help para ver a lista.")
+	if p.commands.Store == nil {
+		return unknown, nil
+	}
+
+	cmd, err := p.commands.Store.GetCommand(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if cmd == nil {
+		return unknown, nil
+	}
+	if room.Type != "general" {
+		isMember, err := p.rooms.IsMember(ctx, room.ID, cmd.BotID)
+		if err != nil {
+			return nil, err
+		}
+		if !isMember {
+			return Ephemeral(room.ID, "O bot "+cmd.BotUsername+" não participa desta sala"), nil
+		}
+	}
+
+	inv := Invocation{
+		ID:       uuid.New().String(),
+		Command:  name,
+		Text:     args,
+		RoomID:   room.ID,
+		UserID:   sender.UserID,
+		Username: sender.Username,
+	}
+
+	var reply *CommandReply
+	if cmd.URL != "" {
+		reply, err = p.callCommandURL(ctx, cmd, inv)
+	} else {
+		reply, err = p.callCommandSocket(ctx, cmd, inv)
+	}
+	if errors.Is(err, errCommandLate) {
+		return Ephemeral(room.ID, "O bot "+cmd.BotUsername+" ainda está processando /"+name+"; a resposta aparece aqui"), nil
+	}
+	if err != nil {
+		log.Printf("Erro no comando /%s do bot %s: %v", name, cmd.BotUsername, err)
+		return Ephemeral(room.ID, "O bot "+cmd.BotUsername+" não respondeu"), nil
+	}
+	return p.commandReply(ctx, cmd, room.ID, reply)
+}
+
+func (p *Pipeline) commandReply(ctx context.Context, cmd *models.BotCommand, roomID string, reply *CommandReply) (*models.Message, error) {
+	if reply == nil || strings.TrimSpace(reply.Text) == "" {
+		return Ephemeral(roomID, "/"+cmd.Name+" enviado para "+cmd.BotUsername), nil
+	}
+	if len(reply.Text) > MaxContentLength {
+		reply.Text = reply.Text[:MaxContentLength]
+	}
+
+	msg := models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		Username:  cmd.BotUsername,
+		Content:   reply.Text,
+		Timestamp: time.Now(),
+		Type:      "message",
+		Bot:       true,
+	}
+	if reply.Ephemeral {
+		msg.Type = "ephemeral"
+		return &msg, nil
+	}
+	if err := p.deliver(ctx, &msg, cmd.BotID); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// callCommandURL faz o POST assinado como nos webhooks de saída. O corpo da
+// resposta pode ser {"text", "ephemeral"}, o formato do Slack
+// ({"text", "response_type"}) ou texto puro (efêmero).
+func (p *Pipeline) callCommandURL(ctx context.Context, cmd *models.BotCommand, inv Invocation) (*CommandReply, error) {
+	body, err := json.Marshal(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, CommandTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cmd.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chat-commands/1")
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(cmd.Secret, time.Now().Unix(), body))
+
+	resp, err := p.commands.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" {
+		return &CommandReply{Text: string(data), Ephemeral: true}, nil
+	}
+
+	var raw struct {
+		Text         string `json:"text"`
+		Ephemeral    *bool  `json:"ephemeral"`
+		ResponseType string `json:"response_type"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	reply := &CommandReply{Text: raw.Text, Ephemeral: raw.ResponseType != "in_channel"}
+	if raw.Ephemeral != nil {
+		reply.Ephemeral = *raw.Ephemeral
+	}
+	return reply, nil
+}
+
+// callCommandSocket entrega a invocação às conexões do bot na sala como um
+// evento "command" e espera a resposta (RespondCommand) por até
+// CommandTimeout. Depois disso a invocação fica em late por
+// LateReplyWindow e devolve errCommandLate.
+func (p *Pipeline) callCommandSocket(ctx context.Context, cmd *models.BotCommand, inv Invocation) (*CommandReply, error) {
+	if p.targeted == nil {
+		return nil, fmt.Errorf("bot sem URL e entrega direta desativada")
+	}
+
+	pending := &pendingInvocation{botID: cmd.BotID, reply: make(chan CommandReply, 1)}
+	p.commands.mu.Lock()
+	p.commands.pending[inv.ID] = pending
+	p.commands.mu.Unlock()
+	defer func() {
+		p.commands.mu.Lock()
+		delete(p.commands.pending, inv.ID)
+		p.commands.mu.Unlock()
+	}()
+
+	event := models.Message{
+		ID:        inv.ID,
+		RoomID:    inv.RoomID,
+		Username:  inv.Username,
+		Content:   strings.TrimSpace("/" + inv.Command + " " + inv.Text),
+		Timestamp: time.Now(),
+		Type:      "command",
+	}
+	if p.targeted.Direct(inv.RoomID, []string{cmd.BotID}, event) == 0 {
+		return nil, fmt.Errorf("bot não está conectado à sala")
+	}
+
+	timer := time.NewTimer(CommandTimeout)
+	defer timer.Stop()
+	select {
+	case reply := <-pending.reply:
+		return &reply, nil
+	case <-timer.C:
+		p.commands.mu.Lock()
+		now := time.Now()
+		for id, late := range p.commands.late {
+			if now.After(late.expires) {
+				delete(p.commands.late, id)
+			}
+		}
+		p.commands.late[inv.ID] = &lateInvocation{cmd: cmd, roomID: inv.RoomID, userID: inv.UserID, expires: now.Add(LateReplyWindow)}
+		p.commands.mu.Unlock()
+		return nil, errCommandLate
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// RespondCommand recebe a resposta de um bot a um evento "command", pelo
+// WebSocket ou pela API. Respostas que chegam depois do CommandTimeout são
+// entregues a quem invocou (efêmeras) ou à sala.
+func (p *Pipeline) RespondCommand(ctx context.Context, botID, invocationID string, reply CommandReply) error {
+	if p.commands == nil {
+		return ErrUnknownInvocation
+	}
+
+	p.commands.mu.Lock()
+	pending, ok := p.commands.pending[invocationID]
+	if ok && pending.botID == botID {
+		delete(p.commands.pending, invocationID)
+	}
+	late, isLate := p.commands.late[invocationID]
+	if isLate && late.cmd.BotID == botID {
+		delete(p.commands.late, invocationID)
+	}
+	p.commands.mu.Unlock()
+
+	switch {
+	case ok && pending.botID == botID:
+		pending.reply <- reply
+		return nil
+	case isLate && late.cmd.BotID == botID && time.Now().Before(late.expires):
+		msg, err := p.commandReply(ctx, late.cmd, late.roomID, &reply)
+		if err != nil {
+			return err
+		}
+		if msg.Type == "ephemeral" && p.targeted != nil {
+			p.targeted.Direct(late.roomID, []string{late.userID}, *msg)
+		}
+		return nil
+	default:
+		return ErrUnknownInvocation
+	}
+}
+
+// IsBuiltinCommand diz se o nome já pertence a um comando embutido, que bots
+// não podem registrar.
+func IsBuiltinCommand(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
@@ -0,0 +1,476 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+	"github.com/lucaspanzera1/chat/internal/safehttp"
+	"github.com/lucaspanzera1/chat/internal/webhook"
+)
+
+const (
+	generalRoom = "00000000-0000-0000-0000-000000000001"
+	groupRoom   = "00000000-0000-0000-0000-0000000000bb"
+)
+
+type fakeMessages struct {
+	mu       sync.Mutex
+	messages []models.Message
+	fail     error
+}
+
+func (f *fakeMessages) Create(ctx context.Context, msg *models.Message, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail != nil {
+		return f.fail
+	}
+	f.messages = append(f.messages, *msg)
+	return nil
+}
+
+func (f *fakeMessages) Update(ctx context.Context, roomID, id, userID, content, encryption string) (*models.Message, error) {
+	return nil, nil
+}
+
+func (f *fakeMessages) Delete(ctx context.Context, roomID, id, userID string) (bool, error) {
+	return false, nil
+}
+
+type fakeRooms struct {
+	mu      sync.Mutex
+	rooms   map[string]*models.Room
+	members map[string]bool
+}
+
+func (f *fakeRooms) GetByID(ctx context.Context, roomID string) (*models.Room, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rooms[roomID], nil
+}
+
+func (f *fakeRooms) IsMember(ctx context.Context, roomID, userID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.members[roomID+":"+userID], nil
+}
+
+type fakeCommands struct {
+	commands map[string]*models.BotCommand
+}
+
+func (f *fakeCommands) GetCommand(ctx context.Context, name string) (*models.BotCommand, error) {
+	return f.commands[name], nil
+}
+
+// testPipeline monta o pipeline com a sala geral e um grupo de alice, do
+// qual bob também participa. broadcasts recebe tudo o que iria para o hub.
+type testPipeline struct {
+	*Pipeline
+	messages *fakeMessages
+	rooms    *fakeRooms
+
+	mu         sync.Mutex
+	broadcasts []models.Message
+}
+
+func newTestPipeline(t *testing.T) *testPipeline {
+	t.Helper()
+	tp := &testPipeline{
+		messages: &fakeMessages{},
+		rooms: &fakeRooms{
+			rooms: map[string]*models.Room{
+				generalRoom: {ID: generalRoom, Type: "general", Name: "Geral"},
+				groupRoom:   {ID: groupRoom, Type: "group", Name: "Time", CreatedBy: "alice"},
+			},
+			members: map[string]bool{groupRoom + ":alice": true, groupRoom + ":bob": true},
+		},
+	}
+	tp.Pipeline = NewPipeline(tp.messages, tp.rooms, func(m models.Message) {
+		tp.mu.Lock()
+		defer tp.mu.Unlock()
+		tp.broadcasts = append(tp.broadcasts, m)
+	})
+	return tp
+}
+
+func (tp *testPipeline) stored() []models.Message {
+	tp.messages.mu.Lock()
+	defer tp.messages.mu.Unlock()
+	return append([]models.Message(nil), tp.messages.messages...)
+}
+
+func (tp *testPipeline) sent() []models.Message {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return append([]models.Message(nil), tp.broadcasts...)
+}
+
+var (
+	alice = Sender{UserID: "alice", Username: "alice"}
+	bob   = Sender{UserID: "bob", Username: "bob"}
+)
+
+// fakeCommandRooms guarda tópicos e silêncios em memória.
+type fakeCommandRooms struct {
+	mu     sync.Mutex
+	topics map[string]string
+	added  []string
+	muted  map[string]time.Time
+}
+
+func (f *fakeCommandRooms) SetTopic(ctx context.Context, roomID, topic string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.topics[roomID] = topic
+	return nil
+}
+
+func (f *fakeCommandRooms) AddUserToGroup(ctx context.Context, roomID, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.added = append(f.added, roomID+":"+userID)
+	return nil
+}
+
+func (f *fakeCommandRooms) Mute(ctx context.Context, roomID, userID, mutedBy string, until time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.muted[roomID+":"+userID] = until
+	return nil
+}
+
+func (f *fakeCommandRooms) Unmute(ctx context.Context, roomID, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.muted, roomID+":"+userID)
+	return nil
+}
+
+func (f *fakeCommandRooms) MutedUntil(ctx context.Context, roomID, userID string) (*time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if until, ok := f.muted[roomID+":"+userID]; ok && time.Now().Before(until) {
+		return &until, nil
+	}
+	return nil, nil
+}
+
+type fakeCommandUsers struct {
+	users map[string]*models.User
+}
+
+func (f *fakeCommandUsers) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	return f.users[username], nil
+}
+
+// fakeTargeted registra as entregas diretas; onDirect simula o bot
+// conectado respondendo ao evento "command".
+type fakeTargeted struct {
+	mu       sync.Mutex
+	direct   []models.Message
+	toUsers  []models.Message
+	onDirect func(userIDs []string, msg models.Message)
+}
+
+func (f *fakeTargeted) Direct(roomID string, userIDs []string, msg models.Message) int {
+	f.mu.Lock()
+	f.direct = append(f.direct, msg)
+	f.mu.Unlock()
+	if f.onDirect != nil {
+		f.onDirect(userIDs, msg)
+	}
+	return len(userIDs)
+}
+
+func (f *fakeTargeted) SendToUsers(userIDs []string, msg models.Message) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.toUsers = append(f.toUsers, msg)
+	return len(userIDs)
+}
+
+// withCommands liga os comandos com as fakes acima e um cliente HTTP que
+// alcança o httptest.
+func (tp *testPipeline) withCommands(commands map[string]*models.BotCommand) *fakeCommandRooms {
+	rooms := &fakeCommandRooms{topics: make(map[string]string), muted: make(map[string]time.Time)}
+	tp.SetCommands(CommandConfig{
+		Store: &fakeCommands{commands: commands},
+		Rooms: rooms,
+		Users: &fakeCommandUsers{users: map[string]*models.User{
+			"alice": {ID: "alice", Username: "alice"},
+			"bob":   {ID: "bob", Username: "bob"},
+			"carol": {ID: "carol", Username: "carol"},
+		}},
+		Client: safehttp.NewClient(safehttp.Options{Allow: func(ip netip.Addr) bool { return ip.IsLoopback() }}),
+	})
+	return rooms
+}
+
+func TestCommandURLRefusesLoopback(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"text": "segredo interno", "ephemeral": true}`))
+	}))
+	defer srv.Close()
+
+	tp := newTestPipeline(t)
+	tp.SetCommands(CommandConfig{Store: &fakeCommands{commands: map[string]*models.BotCommand{
+		"deploy": {ID: "c1", BotID: "robo", BotUsername: "robo", Name: "deploy", URL: srv.URL + "/admin", Secret: "s"},
+	}}})
+
+	reply, err := tp.Send(context.Background(), alice, generalRoom, "/deploy agora")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits.Load() != 0 {
+		t.Errorf("o servidor local recebeu %d requisições", hits.Load())
+	}
+	if reply.Type != "ephemeral" || strings.Contains(reply.Content, "segredo") {
+		t.Errorf("resposta = %+v, quer o aviso de que o bot não respondeu", reply)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		in         string
+		name, args string
+		ok         bool
+	}{
+		{"/help", "help", "", true},
+		{"  /me  dança  ", "me", "dança", true},
+		{"/topic linha 1\nlinha 2", "topic", "linha 1\nlinha 2", true},
+		{"/mute @bob 2h", "mute", "@bob 2h", true},
+		{"/deploy-prod_2 v1", "deploy-prod_2", "v1", true},
+		{"/Help", "", "", false},
+		{"/ texto", "", "", false},
+		{"//help", "", "", false},
+		{"olá /help", "", "", false},
+		{"/" + strings.Repeat("a", 33), "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		name, args, ok := ParseCommand(tt.in)
+		if name != tt.name || args != tt.args || ok != tt.ok {
+			t.Errorf("ParseCommand(%q) = %q, %q, %v; quer %q, %q, %v", tt.in, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
+
+func TestSendDispatchesBuiltins(t *testing.T) {
+	tp := newTestPipeline(t)
+	tp.withCommands(nil)
+	ctx := context.Background()
+
+	reply, err := tp.Send(ctx, alice, generalRoom, "/help")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != "ephemeral" || !strings.Contains(reply.Content, "/me <ação>") {
+		t.Errorf("/help = %+v", reply)
+	}
+
+	reply, err = tp.Send(ctx, alice, generalRoom, "/nada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != "ephemeral" || !strings.Contains(reply.Content, "Comando desconhecido: /nada") {
+		t.Errorf("/nada = %+v", reply)
+	}
+	if len(tp.stored()) != 0 || len(tp.sent()) != 0 {
+		t.Fatalf("respostas efêmeras foram gravadas ou transmitidas: %v %v", tp.stored(), tp.sent())
+	}
+
+	reply, err = tp.Send(ctx, alice, generalRoom, "/me dança")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != "action" || reply.Content != "dança" || len(tp.stored()) != 1 || len(tp.sent()) != 1 {
+		t.Errorf("/me = %+v, gravadas %d", reply, len(tp.stored()))
+	}
+}
+
+func TestSendEscapesDoubleSlash(t *testing.T) {
+	tp := newTestPipeline(t)
+	tp.withCommands(nil)
+
+	msg, err := tp.Send(context.Background(), alice, generalRoom, "//help não é comando")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "message" || msg.Content != "/help não é comando" {
+		t.Errorf("mensagem = %+v", msg)
+	}
+	if stored := tp.stored(); len(stored) != 1 || stored[0].Content != "/help não é comando" {
+		t.Errorf("gravadas = %+v", stored)
+	}
+}
+
+func TestSendWithoutCommandsKeepsSlash(t *testing.T) {
+	tp := newTestPipeline(t)
+
+	msg, err := tp.Send(context.Background(), alice, generalRoom, "/help")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "message" || msg.Content != "/help" {
+		t.Errorf("mensagem = %+v", msg)
+	}
+}
+
+func TestModerationCommands(t *testing.T) {
+	tp := newTestPipeline(t)
+	rooms := tp.withCommands(nil)
+	targeted := &fakeTargeted{}
+	tp.SetTargeted(targeted)
+	ctx := context.Background()
+
+	reply, err := tp.Send(ctx, bob, groupRoom, "/topic do bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != "ephemeral" || rooms.topics[groupRoom] != "" {
+		t.Errorf("/topic de quem não criou o grupo: %+v, tópico %q", reply, rooms.topics[groupRoom])
+	}
+
+	reply, err = tp.Send(ctx, alice, groupRoom, "/topic Planejamento")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != "system" || rooms.topics[groupRoom] != "Planejamento" {
+		t.Errorf("/topic = %+v, tópico %q", reply, rooms.topics[groupRoom])
+	}
+
+	reply, err = tp.Send(ctx, alice, groupRoom, "/invite @carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != "system" || len(rooms.added) != 1 || rooms.added[0] != groupRoom+":carol" {
+		t.Errorf("/invite = %+v, adicionados %v", reply, rooms.added)
+	}
+
+	if reply, _ := tp.Send(ctx, alice, groupRoom, "/mute @bob 1000h"); reply == nil || !strings.Contains(reply.Content, "Duração inválida") {
+		t.Errorf("/mute acima do máximo = %+v", reply)
+	}
+	if reply, _ := tp.Send(ctx, alice, groupRoom, "/mute @bob 5m"); reply == nil || reply.Type != "ephemeral" {
+		t.Fatalf("/mute = %+v", reply)
+	}
+	_, err = tp.Send(ctx, bob, groupRoom, "posso falar?")
+	var perr *protocol.Error
+	if !errors.As(err, &perr) || perr.Code != protocol.CodeRateLimited {
+		t.Fatalf("mensagem de quem foi silenciado: err = %v", err)
+	}
+	if len(targeted.toUsers) == 0 {
+		t.Error("o silenciado não foi avisado")
+	}
+
+	if _, err := tp.Send(ctx, alice, groupRoom, "/unmute @bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tp.Send(ctx, bob, groupRoom, "voltei"); err != nil {
+		t.Errorf("depois do /unmute: %v", err)
+	}
+}
+
+func TestBotCommandOverHTTP(t *testing.T) {
+	var got Invocation
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify("segredo", r.Header.Get(webhook.SignatureHeader), body, time.Minute); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &got)
+		switch got.Text {
+		case "sala":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"text": "deploy iniciado", "response_type": "in_channel"}`))
+		default:
+			w.Write([]byte("só para você"))
+		}
+	}))
+	defer srv.Close()
+
+	tp := newTestPipeline(t)
+	tp.withCommands(map[string]*models.BotCommand{
+		"deploy": {ID: "c1", BotID: "robo", BotUsername: "robo", Name: "deploy", URL: srv.URL, Secret: "segredo"},
+	})
+	ctx := context.Background()
+
+	reply, err := tp.Send(ctx, alice, generalRoom, "/deploy sala")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Command != "deploy" || got.UserID != "alice" || got.RoomID != generalRoom {
+		t.Errorf("invocação = %+v", got)
+	}
+	if reply.Type != "message" || !reply.Bot || reply.Username != "robo" || reply.Content != "deploy iniciado" {
+		t.Errorf("resposta in_channel = %+v", reply)
+	}
+	if len(tp.stored()) != 1 {
+		t.Errorf("gravadas = %d, quer 1", len(tp.stored()))
+	}
+
+	reply, err = tp.Send(ctx, alice, generalRoom, "/deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != "ephemeral" || reply.Content != "só para você" || len(tp.stored()) != 1 {
+		t.Errorf("resposta em texto puro = %+v", reply)
+	}
+
+	// Num grupo sem o bot o comando não é despachado.
+	reply, err = tp.Send(ctx, alice, groupRoom, "/deploy sala")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != "ephemeral" || !strings.Contains(reply.Content, "não participa") {
+		t.Errorf("bot fora da sala = %+v", reply)
+	}
+}
+
+func TestBotCommandOverSocket(t *testing.T) {
+	tp := newTestPipeline(t)
+	tp.withCommands(map[string]*models.BotCommand{
+		"ping": {ID: "c1", BotID: "robo", BotUsername: "robo", Name: "ping"},
+	})
+	targeted := &fakeTargeted{}
+	targeted.onDirect = func(userIDs []string, ev models.Message) {
+		if ev.Type != "command" || len(userIDs) != 1 || userIDs[0] != "robo" {
+			return
+		}
+		go func() {
+			// Outro bot não responde pela invocação alheia.
+			if err := tp.RespondCommand(context.Background(), "intruso", ev.ID, CommandReply{Text: "falso"}); err == nil {
+				t.Error("resposta de outro bot aceita")
+			}
+			tp.RespondCommand(context.Background(), "robo", ev.ID, CommandReply{Text: "pong " + ev.Content, Ephemeral: true})
+		}()
+	}
+	tp.SetTargeted(targeted)
+
+	reply, err := tp.Send(context.Background(), alice, generalRoom, "/ping agora")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Type != "ephemeral" || reply.Content != "pong /ping agora" {
+		t.Errorf("resposta = %+v", reply)
+	}
+	if err := tp.RespondCommand(context.Background(), "robo", "inexistente", CommandReply{}); err != ErrUnknownInvocation {
+		t.Errorf("invocação desconhecida: err = %v", err)
+	}
+}
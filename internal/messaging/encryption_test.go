@@ -0,0 +1,105 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+)
+
+const encryptedRoom = "00000000-0000-0000-0000-0000000000ee"
+
+// newEncryptedPipeline acrescenta ao pipeline de teste uma conversa
+// privada criptografada entre alice e bob.
+func newEncryptedPipeline(t *testing.T) *testPipeline {
+	t.Helper()
+	tp := newTestPipeline(t)
+	tp.rooms.rooms[encryptedRoom] = &models.Room{ID: encryptedRoom, Type: "private", Encrypted: true}
+	tp.rooms.members[encryptedRoom+":alice"] = true
+	tp.rooms.members[encryptedRoom+":bob"] = true
+	return tp
+}
+
+func TestSendEncryptionRules(t *testing.T) {
+	tp := newEncryptedPipeline(t)
+	tp.withCommands(nil)
+	ctx := context.Background()
+
+	if _, err := tp.Send(ctx, alice, encryptedRoom, "em claro"); err != ErrEncryptionRequired {
+		t.Errorf("texto em claro na sala criptografada: %v", err)
+	}
+	if _, err := tp.SendEncrypted(ctx, alice, generalRoom, "cifra", "megolm.v1"); err != ErrNotEncrypted {
+		t.Errorf("cifra fora da sala criptografada: %v", err)
+	}
+	for _, scheme := range []string{"", "rot13", "MEGOLM.V1"} {
+		if _, err := tp.SendEncrypted(ctx, alice, encryptedRoom, "cifra", scheme); err != ErrUnknownEncryption {
+			t.Errorf("esquema %q: %v", scheme, err)
+		}
+	}
+	if len(tp.stored()) != 0 || len(tp.sent()) != 0 {
+		t.Fatalf("envio recusado gravou ou transmitiu: %v %v", tp.stored(), tp.sent())
+	}
+
+	// O conteúdo é opaco: "/help" cifrado é gravado como veio, sem virar
+	// comando.
+	for _, scheme := range models.EncryptionSchemes {
+		msg, err := tp.SendEncrypted(ctx, bob, encryptedRoom, "/help", scheme)
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		if msg.Type != "message" || msg.Content != "/help" || msg.Encryption != scheme {
+			t.Errorf("%s: mensagem = %+v", scheme, msg)
+		}
+	}
+	stored := tp.stored()
+	if len(stored) != len(models.EncryptionSchemes) {
+		t.Fatalf("gravadas = %d, quer %d", len(stored), len(models.EncryptionSchemes))
+	}
+	for _, m := range stored {
+		if !m.Encrypted() || m.Content != "/help" {
+			t.Errorf("gravada = %+v", m)
+		}
+	}
+
+	if _, err := tp.SendEncrypted(ctx, Sender{UserID: "carol"}, encryptedRoom, "cifra", "megolm.v1"); err != ErrNotMember {
+		t.Errorf("quem não participa: %v", err)
+	}
+}
+
+func TestEncryptedRoomRefusesServerSideContent(t *testing.T) {
+	tp, _ := newPollPipeline(t)
+	tp.rooms.rooms[encryptedRoom] = &models.Room{ID: encryptedRoom, Type: "private", Encrypted: true}
+	tp.rooms.members[encryptedRoom+":alice"] = true
+	schedules := newFakeSchedules(tp)
+	schedules.messageRoom["00000000-0000-0000-0000-00000000c1f0"] = encryptedRoom
+	tp.SetScheduler(schedules, nil)
+	ctx := context.Background()
+	later := time.Now().Add(time.Hour)
+
+	refused := map[string]error{}
+	_, refused["webhook de entrada"] = tp.Post(ctx, encryptedRoom, "hook", models.Message{Username: "CI", Content: "build ok"})
+	_, refused["enquete"] = tp.CreatePoll(ctx, alice, encryptedRoom, PollSpec{Question: "Almoço?", Options: []string{"pizza", "sushi"}})
+	_, refused["mensagem agendada"] = tp.Schedule(ctx, alice, encryptedRoom, ScheduleSpec{Content: "bom dia", SendAt: &later})
+	_, refused["lembrete com nota"] = tp.Remind(ctx, alice, "", "00000000-0000-0000-0000-00000000c1f0", ScheduleSpec{Content: "responder", SendAt: &later})
+	for name, err := range refused {
+		var perr *protocol.Error
+		if err != ErrEncryptedRoom || !errors.As(err, &perr) || perr.Code != protocol.CodeForbidden {
+			t.Errorf("%s: %v, quer ErrEncryptedRoom", name, err)
+		}
+	}
+	if len(tp.stored()) != 0 || len(tp.sent()) != 0 || len(schedules.items) != 0 {
+		t.Fatalf("recurso recusado deixou rastro: %v %v %v", tp.stored(), tp.sent(), schedules.items)
+	}
+
+	// Um lembrete sem nota só aponta para a mensagem e não guarda texto.
+	item, err := tp.Remind(ctx, alice, "", "00000000-0000-0000-0000-00000000c1f0", ScheduleSpec{SendAt: &later})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.RoomID != encryptedRoom || item.Content != "" || len(schedules.items) != 1 {
+		t.Errorf("lembrete = %+v", item)
+	}
+}
@@ -0,0 +1,100 @@
+package messaging
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+)
+
+// MaxEphemeralTargets limita os destinatários de uma mensagem efêmera.
+const MaxEphemeralTargets = 100
+
+var (
+	ErrNoTargets = protocol.NewError(protocol.CodeValidation, "Informe ao menos um destinatário")
+	ErrBotsOnly  = protocol.NewError(protocol.CodeForbidden, "Apenas bots enviam mensagens efêmeras")
+)
+
+// Targeted entrega eventos só a alguns usuários, sem gravar nada. Direct se
+// restringe às conexões na sala; SendToUsers alcança todas as conexões de
+// cada usuário. O hub implementa as duas.
+type Targeted interface {
+	Direct(roomID string, userIDs []string, msg models.Message) int
+	SendToUsers(userIDs []string, msg models.Message) int
+}
+
+// SetTargeted liga as entregas direcionadas (respostas de comandos, avisos
+// de moderação e mensagens efêmeras de bots).
+func (p *Pipeline) SetTargeted(t Targeted) {
+	p.targeted = t
+}
+
+// Notify manda um aviso do sistema a todas as conexões dos usuários, em
+// qualquer sala. roomID diz a que sala o aviso se refere. Devolve quantas
+// conexões o receberam.
+func (p *Pipeline) Notify(roomID string, userIDs []string, text string) int {
+	if p.targeted == nil {
+		return 0
+	}
+	return p.targeted.SendToUsers(userIDs, *Ephemeral(roomID, text))
+}
+
+// SendEphemeral é a versão para bots: entrega o conteúdo só às conexões dos
+// destinatários na sala. O bot e os destinatários precisam participar da
+// sala; nada é gravado e os limites de envio valem como numa mensagem comum.
+func (p *Pipeline) SendEphemeral(ctx context.Context, sender Sender, roomID string, userIDs []string, content string) (*models.Message, int, error) {
+	if !sender.IsBot {
+		return nil, 0, ErrBotsOnly
+	}
+	if strings.TrimSpace(content) == "" {
+		return nil, 0, ErrEmptyContent
+	}
+	if len(content) > MaxContentLength {
+		return nil, 0, ErrTooLong
+	}
+	if len(userIDs) == 0 {
+		return nil, 0, ErrNoTargets
+	}
+	if len(userIDs) > MaxEphemeralTargets {
+		return nil, 0, protocol.NewError(protocol.CodeValidation, "Destinatários demais")
+	}
+
+	room, err := p.accessibleRoom(ctx, roomID, sender.UserID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if room.Type != "general" {
+		for _, userID := range userIDs {
+			isMember, err := p.rooms.IsMember(ctx, roomID, userID)
+			if err != nil {
+				return nil, 0, err
+			}
+			if !isMember {
+				return nil, 0, protocol.NewError(protocol.CodeValidation, "Destinatário fora da sala: "+userID)
+			}
+		}
+	}
+	if err := p.checkFlood(room, sender.UserID); err != nil {
+		return nil, 0, err
+	}
+
+	msg := models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		Username:  sender.Username,
+		AvatarURL: sender.AvatarURL,
+		Content:   content,
+		Timestamp: time.Now(),
+		Type:      "ephemeral",
+		Bot:       sender.IsBot,
+	}
+
+	reached := 0
+	if p.targeted != nil {
+		reached = p.targeted.Direct(roomID, userIDs, msg)
+	}
+	return &msg, reached, nil
+}
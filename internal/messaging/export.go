@@ -0,0 +1,43 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+)
+
+// ExportRequester enfileira exportações do histórico (export.Exporter).
+type ExportRequester interface {
+	Request(ctx context.Context, userID, roomID, format, timezone string) (*models.Export, error)
+}
+
+func init() {
+	RegisterCommand("export", "/export [jsonl|html|txt]", "exporta o histórico desta sala; o link chega quando ficar pronto", (*Pipeline).cmdExport)
+}
+
+// SetExports ativa o comando /export.
+func (p *Pipeline) SetExports(e ExportRequester) {
+	p.exports = e
+}
+
+func (p *Pipeline) cmdExport(ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error) {
+	if p.exports == nil {
+		return Ephemeral(room.ID, "Exportações desativadas"), nil
+	}
+	if strings.Contains(args, " ") {
+		return Ephemeral(room.ID, "Uso: "+builtins["export"].usage), nil
+	}
+
+	_, err := p.exports.Request(ctx, sender.UserID, room.ID, strings.ToLower(args), "")
+	var perr *protocol.Error
+	if errors.As(err, &perr) {
+		return Ephemeral(room.ID, perr.Message), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Ephemeral(room.ID, "Exportação iniciada; você recebe o link aqui quando ficar pronta"), nil
+}
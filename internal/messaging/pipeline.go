@@ -0,0 +1,377 @@
+package messaging
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+	"github.com/lucaspanzera1/chat/internal/ratelimit"
+)
+
+const MaxContentLength = 4000
+
+// MaxCiphertextLength limita o conteúdo cifrado, que cresce com o base64 e,
+// em signal.v1, com uma cópia por dispositivo destinatário.
+const MaxCiphertextLength = 64000
+
+var (
+	ErrEmptyContent = protocol.NewError(protocol.CodeValidation, "Mensagem vazia")
+	ErrTooLong      = protocol.NewError(protocol.CodeTooLarge, "Mensagem muito longa")
+	ErrRoomNotFound = protocol.NewError(protocol.CodeForbidden, "Sala não encontrada")
+	ErrNotMember    = protocol.NewError(protocol.CodeForbidden, "Você não participa desta sala")
+	ErrNotAuthor    = protocol.NewError(protocol.CodeForbidden, "Mensagem não encontrada ou de outro usuário")
+
+	ErrEncryptionRequired = protocol.NewError(protocol.CodeValidation, "Conversa criptografada: envie o conteúdo cifrado")
+	ErrNotEncrypted       = protocol.NewError(protocol.CodeValidation, "Esta sala não usa criptografia de ponta a ponta")
+	ErrUnknownEncryption  = protocol.NewError(protocol.CodeValidation, "Esquema de criptografia desconhecido")
+	ErrEncryptedRoom      = protocol.NewError(protocol.CodeForbidden, "Recurso indisponível em conversas criptografadas")
+)
+
+type MessageStore interface {
+	Create(ctx context.Context, msg *models.Message, userID string) error
+	Update(ctx context.Context, roomID, id, userID, content, encryption string) (*models.Message, error)
+	Delete(ctx context.Context, roomID, id, userID string) (bool, error)
+}
+
+type RoomStore interface {
+	GetByID(ctx context.Context, roomID string) (*models.Room, error)
+	IsMember(ctx context.Context, roomID, userID string) (bool, error)
+}
+
+type BroadcastFunc func(msg models.Message)
+
+// EventPublisher recebe os eventos de mensagens para os webhooks de saída.
+type EventPublisher interface {
+	Publish(ctx context.Context, event models.Event)
+}
+
+// LinkPreviewer gera em segundo plano as prévias dos links das mensagens
+// (preview.Previewer), que chegam à sala depois em "message_preview".
+type LinkPreviewer interface {
+	Enqueue(msg models.Message)
+}
+
+// Sender identifica quem está enviando, independente do transporte
+// (WebSocket, SSE ou long-polling).
+type Sender struct {
+	UserID    string
+	Username  string
+	AvatarURL string
+	IsBot     bool
+}
+
+// Pipeline concentra o caminho de uma mensagem recebida: validação,
+// permissão, persistência e broadcast pelo hub. Erros de regra são
+// *protocol.Error para que cada transporte os repasse ao cliente.
+type Pipeline struct {
+	store     MessageStore
+	rooms     RoomStore
+	broadcast BroadcastFunc
+	flood     *ratelimit.FloodGuard
+	events    EventPublisher
+	commands  *commandState
+	targeted  Targeted
+	polls     PollStore
+
+	scheduled     ScheduleStore
+	scheduleUsers ScheduleUsers
+	retention     *retentionState
+	exports       ExportRequester
+	previews      LinkPreviewer
+}
+
+func NewPipeline(store MessageStore, rooms RoomStore, broadcast BroadcastFunc) *Pipeline {
+	return &Pipeline{
+		store:     store,
+		rooms:     rooms,
+		broadcast: broadcast,
+	}
+}
+
+// SetFloodGuard ativa os limites de envio. Sem ele o pipeline não limita.
+func (p *Pipeline) SetFloodGuard(g *ratelimit.FloodGuard) {
+	p.flood = g
+}
+
+// SetEvents liga a publicação de eventos (webhooks de saída).
+func (p *Pipeline) SetEvents(e EventPublisher) {
+	p.events = e
+}
+
+// SetPreviews liga as prévias de links nas mensagens novas e editadas.
+func (p *Pipeline) SetPreviews(lp LinkPreviewer) {
+	p.previews = lp
+}
+
+func (p *Pipeline) enqueuePreviews(msg models.Message) {
+	if p.previews != nil {
+		p.previews.Enqueue(msg)
+	}
+}
+
+func (p *Pipeline) publish(ctx context.Context, eventType, roomID string, data any) {
+	if p.events != nil {
+		p.events.Publish(ctx, models.Event{Type: eventType, RoomID: roomID, Data: data})
+	}
+}
+
+// CanAccess verifica se o usuário pode ler e escrever na sala. A sala geral
+// é aberta a todos; salas privadas e grupos exigem participação.
+func (p *Pipeline) CanAccess(ctx context.Context, roomID, userID string) error {
+	_, err := p.accessibleRoom(ctx, roomID, userID)
+	return err
+}
+
+func (p *Pipeline) accessibleRoom(ctx context.Context, roomID, userID string) (*models.Room, error) {
+	room, err := p.rooms.GetByID(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if room.Type == "general" {
+		return room, nil
+	}
+
+	isMember, err := p.rooms.IsMember(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, ErrNotMember
+	}
+	return room, nil
+}
+
+// checkFlood aplica os limites por usuário e por sala e o modo lento. O
+// criador do grupo não está sujeito ao modo lento da própria sala.
+func (p *Pipeline) checkFlood(room *models.Room, userID string) error {
+	slowMode := time.Duration(room.SlowModeSeconds) * time.Second
+	if room.CreatedBy == userID {
+		slowMode = 0
+	}
+
+	v := p.flood.Check(userID, room.ID, slowMode)
+	if v.JustMuted && userID != "" {
+		// O erro só chega à conexão que enviou; o aviso alcança as outras.
+		p.Notify(room.ID, []string{userID}, "Você foi silenciado por "+v.RetryAfter.Round(time.Second).String()+" por excesso de mensagens")
+	}
+	switch {
+	case v.Allowed:
+		return nil
+	case v.Muted:
+		return protocol.RateLimited("Você foi silenciado temporariamente por excesso de mensagens", v.RetryAfter)
+	case v.SlowMode:
+		return protocol.RateLimited("Modo lento ativo nesta sala", v.RetryAfter)
+	default:
+		return protocol.RateLimited("Muitas mensagens, aguarde um pouco", v.RetryAfter)
+	}
+}
+
+// checkContent valida o tamanho; conteúdo cifrado tem um limite maior.
+func checkContent(content, encryption string) error {
+	if strings.TrimSpace(content) == "" {
+		return ErrEmptyContent
+	}
+	limit := MaxContentLength
+	if encryption != "" {
+		limit = MaxCiphertextLength
+	}
+	if len(content) > limit {
+		return ErrTooLong
+	}
+	return nil
+}
+
+// checkEncryption exige conteúdo cifrado nas salas criptografadas e só
+// nelas.
+func checkEncryption(room *models.Room, encryption string) error {
+	switch {
+	case room.Encrypted && encryption == "":
+		return ErrEncryptionRequired
+	case !room.Encrypted && encryption != "":
+		return ErrNotEncrypted
+	case encryption != "" && !models.ValidEncryption(encryption):
+		return ErrUnknownEncryption
+	}
+	return nil
+}
+
+// Send trata o conteúdo digitado pelo usuário. Com os comandos ativos,
+// "/nome ..." é executado em vez de gravado; o resultado pode ser uma
+// mensagem da sala ou uma resposta "ephemeral", que só o remetente recebe e
+// o transporte deve entregar a ele.
+func (p *Pipeline) Send(ctx context.Context, sender Sender, roomID, content string) (*models.Message, error) {
+	return p.send(ctx, sender, roomID, content, "")
+}
+
+// SendEncrypted envia o texto cifrado de uma sala criptografada. O
+// conteúdo é opaco: não há comandos e ele é gravado como veio.
+func (p *Pipeline) SendEncrypted(ctx context.Context, sender Sender, roomID, ciphertext, encryption string) (*models.Message, error) {
+	if encryption == "" {
+		return nil, ErrUnknownEncryption
+	}
+	return p.send(ctx, sender, roomID, ciphertext, encryption)
+}
+
+func (p *Pipeline) send(ctx context.Context, sender Sender, roomID, content, encryption string) (*models.Message, error) {
+	if err := checkContent(content, encryption); err != nil {
+		return nil, err
+	}
+
+	room, err := p.accessibleRoom(ctx, roomID, sender.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEncryption(room, encryption); err != nil {
+		return nil, err
+	}
+	if err := p.checkMuted(ctx, roomID, sender.UserID); err != nil {
+		return nil, err
+	}
+	if err := p.checkFlood(room, sender.UserID); err != nil {
+		return nil, err
+	}
+
+	if p.commands != nil && encryption == "" {
+		if strings.HasPrefix(content, "//") {
+			content = content[1:]
+		} else if name, args, ok := ParseCommand(content); ok {
+			return p.runCommand(ctx, sender, room, name, args)
+		}
+	}
+
+	msg := models.Message{
+		ID:         uuid.New().String(),
+		RoomID:     roomID,
+		Username:   sender.Username,
+		AvatarURL:  sender.AvatarURL,
+		Content:    content,
+		Timestamp:  time.Now(),
+		Type:       "message",
+		Bot:        sender.IsBot,
+		Encryption: encryption,
+	}
+
+	if err := p.deliver(ctx, &msg, sender.UserID); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// deliver grava, transmite para a sala, publica message.created e agenda as
+// prévias dos links.
+func (p *Pipeline) deliver(ctx context.Context, msg *models.Message, userID string) error {
+	if err := p.store.Create(ctx, msg, userID); err != nil {
+		return err
+	}
+
+	p.broadcast(*msg)
+	p.publish(ctx, models.EventMessageCreated, msg.RoomID, *msg)
+	p.enqueuePreviews(*msg)
+	return nil
+}
+
+// Post publica uma mensagem de integração (webhook de entrada), que não tem
+// usuário por trás: não há checagem de participação e os limites de envio
+// valem para floodKey. Basta texto ou anexos.
+func (p *Pipeline) Post(ctx context.Context, roomID, floodKey string, msg models.Message) (*models.Message, error) {
+	if strings.TrimSpace(msg.Content) == "" && len(msg.Attachments) == 0 {
+		return nil, ErrEmptyContent
+	}
+	if len(msg.Content) > MaxContentLength {
+		return nil, ErrTooLong
+	}
+
+	room, err := p.rooms.GetByID(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room == nil {
+		return nil, ErrRoomNotFound
+	}
+	if room.Encrypted {
+		return nil, ErrEncryptedRoom
+	}
+	if err := p.checkFlood(room, floodKey); err != nil {
+		return nil, err
+	}
+
+	msg.ID = uuid.New().String()
+	msg.RoomID = roomID
+	msg.Timestamp = time.Now()
+	msg.Type = "message"
+	msg.Bot = true
+
+	if err := p.deliver(ctx, &msg, ""); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Edit troca o conteúdo de uma mensagem do próprio autor. A sala recebe a
+// mensagem inteira com tipo "message_edited".
+func (p *Pipeline) Edit(ctx context.Context, sender Sender, roomID, messageID, content string) (*models.Message, error) {
+	return p.edit(ctx, sender, roomID, messageID, content, "")
+}
+
+// EditEncrypted é o Edit das salas criptografadas.
+func (p *Pipeline) EditEncrypted(ctx context.Context, sender Sender, roomID, messageID, ciphertext, encryption string) (*models.Message, error) {
+	if encryption == "" {
+		return nil, ErrUnknownEncryption
+	}
+	return p.edit(ctx, sender, roomID, messageID, ciphertext, encryption)
+}
+
+func (p *Pipeline) edit(ctx context.Context, sender Sender, roomID, messageID, content, encryption string) (*models.Message, error) {
+	if err := checkContent(content, encryption); err != nil {
+		return nil, err
+	}
+	room, err := p.accessibleRoom(ctx, roomID, sender.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEncryption(room, encryption); err != nil {
+		return nil, err
+	}
+
+	msg, err := p.store.Update(ctx, roomID, messageID, sender.UserID, content, encryption)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, ErrNotAuthor
+	}
+
+	p.publish(ctx, models.EventMessageEdited, msg.RoomID, *msg)
+
+	event := *msg
+	event.Type = "message_edited"
+	p.broadcast(event)
+	p.enqueuePreviews(*msg)
+	return msg, nil
+}
+
+// Delete apaga uma mensagem do próprio autor e avisa a sala com
+// "message_deleted", que só carrega o ID.
+func (p *Pipeline) Delete(ctx context.Context, sender Sender, roomID, messageID string) error {
+	if err := p.CanAccess(ctx, roomID, sender.UserID); err != nil {
+		return err
+	}
+
+	deleted, err := p.store.Delete(ctx, roomID, messageID, sender.UserID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrNotAuthor
+	}
+
+	p.broadcast(models.Message{ID: messageID, RoomID: roomID, Type: "message_deleted", Timestamp: time.Now()})
+	p.publish(ctx, models.EventMessageDeleted, roomID, map[string]string{"id": messageID, "roomId": roomID})
+	return nil
+}
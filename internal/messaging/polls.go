@@ -0,0 +1,354 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+)
+
+const (
+	MaxPollOptions    = 10
+	maxPollQuestion   = 300
+	maxPollOption     = 200
+	maxPollDuration   = 30 * 24 * time.Hour
+	pollCloseInterval = 15 * time.Second
+)
+
+var (
+	ErrPollNotFound = protocol.NewError(protocol.CodeForbidden, "Enquete não encontrada")
+	ErrPollClosed   = protocol.NewError(protocol.CodeValidation, "Enquete encerrada")
+	ErrPollOption   = protocol.NewError(protocol.CodeValidation, "Opção inválida")
+	ErrPollsOff     = protocol.NewError(protocol.CodeValidation, "Enquetes desativadas")
+)
+
+// PollStore guarda enquetes, opções e votos em tabelas próprias. Vote e
+// Unvote devolvem false quando nada mudou (enquete fechada, opção de outra
+// enquete, voto inexistente).
+type PollStore interface {
+	Create(ctx context.Context, poll *models.Poll, msg *models.Message, userID string) error
+	Get(ctx context.Context, pollID, viewerID string) (*models.Poll, error)
+	Vote(ctx context.Context, pollID, userID string, optionIDs []int64) (bool, error)
+	Unvote(ctx context.Context, pollID, userID string, optionID int64) (bool, error)
+	Close(ctx context.Context, pollID string) (bool, error)
+	CloseDue(ctx context.Context) ([]string, error)
+}
+
+// PollSpec é o pedido de criação de uma enquete.
+type PollSpec struct {
+	Question  string     `json:"question"`
+	Options   []string   `json:"options"`
+	Multiple  bool       `json:"multiple"`
+	Anonymous bool       `json:"anonymous"`
+	ClosesAt  *time.Time `json:"closesAt"`
+}
+
+func init() {
+	RegisterCommand("poll", "/poll [--multi] [--anon] [--closes 2h] pergunta | opção | opção",
+		"cria uma enquete", (*Pipeline).cmdPoll)
+}
+
+// SetPolls ativa as enquetes.
+func (p *Pipeline) SetPolls(store PollStore) {
+	p.polls = store
+}
+
+func (s *PollSpec) validate(now time.Time) error {
+	s.Question = strings.TrimSpace(s.Question)
+	if s.Question == "" {
+		return protocol.NewError(protocol.CodeValidation, "A enquete precisa de uma pergunta")
+	}
+	if len(s.Question) > maxPollQuestion {
+		return protocol.NewError(protocol.CodeTooLarge, "Pergunta muito longa")
+	}
+
+	options := make([]string, 0, len(s.Options))
+	for _, option := range s.Options {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		if len(option) > maxPollOption {
+			return protocol.NewError(protocol.CodeTooLarge, "Opção muito longa")
+		}
+		if slices.Contains(options, option) {
+			return protocol.NewError(protocol.CodeValidation, "Opção repetida: "+option)
+		}
+		options = append(options, option)
+	}
+	if len(options) < 2 || len(options) > MaxPollOptions {
+		return protocol.NewError(protocol.CodeValidation, fmt.Sprintf("A enquete precisa de 2 a %d opções", MaxPollOptions))
+	}
+	s.Options = options
+
+	if s.ClosesAt != nil && (!s.ClosesAt.After(now) || s.ClosesAt.Sub(now) > maxPollDuration) {
+		return protocol.NewError(protocol.CodeValidation, "Encerramento deve ser no futuro e em até 30 dias")
+	}
+	return nil
+}
+
+// CreatePoll publica uma enquete como mensagem "poll". Valem as mesmas
+// regras de Send: participação, silêncio e limites de envio.
+func (p *Pipeline) CreatePoll(ctx context.Context, sender Sender, roomID string, spec PollSpec) (*models.Message, error) {
+	if p.polls == nil {
+		return nil, ErrPollsOff
+	}
+	now := time.Now()
+	if err := spec.validate(now); err != nil {
+		return nil, err
+	}
+
+	room, err := p.accessibleRoom(ctx, roomID, sender.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if room.Encrypted {
+		return nil, ErrEncryptedRoom
+	}
+	if err := p.checkMuted(ctx, roomID, sender.UserID); err != nil {
+		return nil, err
+	}
+	if err := p.checkFlood(room, sender.UserID); err != nil {
+		return nil, err
+	}
+	return p.createPoll(ctx, sender, roomID, spec, now)
+}
+
+// createPoll grava e transmite uma enquete já validada.
+func (p *Pipeline) createPoll(ctx context.Context, sender Sender, roomID string, spec PollSpec, now time.Time) (*models.Message, error) {
+	poll := &models.Poll{
+		Question:  spec.Question,
+		Multiple:  spec.Multiple,
+		Anonymous: spec.Anonymous,
+		ClosesAt:  spec.ClosesAt,
+		Options:   make([]models.PollOption, len(spec.Options)),
+	}
+	for i, text := range spec.Options {
+		poll.Options[i].Text = text
+	}
+
+	msg := models.Message{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		Username:  sender.Username,
+		AvatarURL: sender.AvatarURL,
+		Content:   spec.Question,
+		Timestamp: now,
+		Type:      "poll",
+		Bot:       sender.IsBot,
+		Poll:      poll,
+	}
+	if err := p.polls.Create(ctx, poll, &msg, sender.UserID); err != nil {
+		return nil, err
+	}
+
+	p.broadcast(msg)
+	p.publish(ctx, models.EventMessageCreated, roomID, msg)
+	return &msg, nil
+}
+
+// Poll devolve a enquete com os votos de quem consulta, se essa pessoa tem
+// acesso à sala.
+func (p *Pipeline) Poll(ctx context.Context, userID, pollID string) (*models.Poll, error) {
+	if p.polls == nil {
+		return nil, ErrPollsOff
+	}
+	poll, err := p.polls.Get(ctx, pollID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if poll == nil {
+		return nil, ErrPollNotFound
+	}
+	if err := p.CanAccess(ctx, poll.RoomID, userID); err != nil {
+		if err == ErrNotMember {
+			return nil, ErrPollNotFound
+		}
+		return nil, err
+	}
+	return poll, nil
+}
+
+// Vote registra as escolhas e transmite o resultado atualizado para a sala.
+// Em escolha única o novo voto substitui o anterior.
+func (p *Pipeline) Vote(ctx context.Context, sender Sender, pollID string, optionIDs []int64) (*models.Poll, error) {
+	poll, err := p.Poll(ctx, sender.UserID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll.Closed(time.Now()) {
+		return nil, ErrPollClosed
+	}
+
+	optionIDs = slices.Compact(slices.Sorted(slices.Values(optionIDs)))
+	if len(optionIDs) == 0 || (!poll.Multiple && len(optionIDs) > 1) {
+		return nil, ErrPollOption
+	}
+	for _, id := range optionIDs {
+		if !slices.ContainsFunc(poll.Options, func(o models.PollOption) bool { return o.ID == id }) {
+			return nil, ErrPollOption
+		}
+	}
+
+	ok, err := p.polls.Vote(ctx, pollID, sender.UserID, optionIDs)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrPollClosed
+	}
+	return p.pollChanged(ctx, pollID, sender.UserID)
+}
+
+// Unvote retira o voto numa opção, ou todos quando optionID é 0.
+func (p *Pipeline) Unvote(ctx context.Context, sender Sender, pollID string, optionID int64) (*models.Poll, error) {
+	poll, err := p.Poll(ctx, sender.UserID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll.Closed(time.Now()) {
+		return nil, ErrPollClosed
+	}
+
+	changed, err := p.polls.Unvote(ctx, pollID, sender.UserID, optionID)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return poll, nil
+	}
+	return p.pollChanged(ctx, pollID, sender.UserID)
+}
+
+// ClosePoll encerra a enquete antes do prazo. Pode quem a criou e, em
+// grupos, o criador do grupo.
+func (p *Pipeline) ClosePoll(ctx context.Context, sender Sender, pollID string) (*models.Poll, error) {
+	poll, err := p.Poll(ctx, sender.UserID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll.CreatedBy != sender.UserID {
+		room, err := p.rooms.GetByID(ctx, poll.RoomID)
+		if err != nil {
+			return nil, err
+		}
+		if room == nil || room.Type != "group" || room.CreatedBy != sender.UserID {
+			return nil, protocol.NewError(protocol.CodeForbidden, "Apenas quem criou a enquete pode encerrá-la")
+		}
+	}
+
+	closed, err := p.polls.Close(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if !closed {
+		return nil, ErrPollClosed
+	}
+	return p.pollChanged(ctx, pollID, sender.UserID)
+}
+
+// pollChanged transmite "poll_updated" com os resultados (sem os votos de
+// ninguém em particular) e devolve a enquete vista por userID.
+func (p *Pipeline) pollChanged(ctx context.Context, pollID, userID string) (*models.Poll, error) {
+	poll, err := p.polls.Get(ctx, pollID, "")
+	if err != nil {
+		return nil, err
+	}
+	if poll == nil {
+		return nil, ErrPollNotFound
+	}
+
+	p.broadcast(models.Message{
+		ID:        poll.MessageID,
+		RoomID:    poll.RoomID,
+		Timestamp: time.Now(),
+		Type:      "poll_updated",
+		Poll:      poll,
+	})
+
+	if userID == "" {
+		return poll, nil
+	}
+	return p.polls.Get(ctx, pollID, userID)
+}
+
+// RunPollCloser encerra as enquetes cujo prazo venceu e avisa as salas. O
+// prazo fica no banco, então enquetes vencidas durante um restart são
+// encerradas na primeira passada.
+func (p *Pipeline) RunPollCloser(ctx context.Context) {
+	if p.polls == nil {
+		return
+	}
+
+	ticker := time.NewTicker(pollCloseInterval)
+	defer ticker.Stop()
+	for {
+		ids, err := p.polls.CloseDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Erro ao encerrar enquetes: %v", err)
+		}
+		for _, id := range ids {
+			if _, err := p.pollChanged(ctx, id, ""); err != nil {
+				log.Printf("Erro ao transmitir enquete encerrada %s: %v", id, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cmdPoll cria uma enquete a partir de
+// "/poll [--multi] [--anon] [--closes 2h] pergunta | opção | opção".
+func (p *Pipeline) cmdPoll(ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error) {
+	usage := Ephemeral(room.ID, "Uso: "+builtins["poll"].usage)
+	if p.polls == nil {
+		return Ephemeral(room.ID, "Enquetes desativadas"), nil
+	}
+
+	var spec PollSpec
+	rest := strings.TrimSpace(args)
+	for strings.HasPrefix(rest, "--") {
+		flag, tail, _ := strings.Cut(rest, " ")
+		rest = strings.TrimSpace(tail)
+		switch flag {
+		case "--multi":
+			spec.Multiple = true
+		case "--anon":
+			spec.Anonymous = true
+		case "--closes":
+			value, tail, _ := strings.Cut(rest, " ")
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return Ephemeral(room.ID, "Duração inválida em --closes (ex.: 30m, 2h)"), nil
+			}
+			closesAt := time.Now().Add(d)
+			spec.ClosesAt = &closesAt
+			rest = strings.TrimSpace(tail)
+		default:
+			return usage, nil
+		}
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 3 {
+		return usage, nil
+	}
+	spec.Question, spec.Options = parts[0], parts[1:]
+
+	// Send já conferiu sala, silêncio e limites.
+	now := time.Now()
+	if err := spec.validate(now); err != nil {
+		return Ephemeral(room.ID, err.(*protocol.Error).Message), nil
+	}
+	return p.createPoll(ctx, sender, room.ID, spec, now)
+}
@@ -0,0 +1,385 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+)
+
+// fakePolls segue as regras do PollRepository: votos só em enquetes
+// abertas, escolha única substitui o voto anterior e Close vale uma vez.
+type fakePolls struct {
+	mu     sync.Mutex
+	polls  map[string]*models.Poll
+	votes  map[string]map[string][]int64 // enquete -> usuário -> opções
+	nextID int64
+	due    []string
+}
+
+func newFakePolls() *fakePolls {
+	return &fakePolls{polls: make(map[string]*models.Poll), votes: make(map[string]map[string][]int64)}
+}
+
+func (f *fakePolls) Create(ctx context.Context, poll *models.Poll, msg *models.Message, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll.ID = fmt.Sprintf("poll-%d", len(f.polls)+1)
+	poll.MessageID = msg.ID
+	poll.RoomID = msg.RoomID
+	poll.CreatedBy = userID
+	for i := range poll.Options {
+		f.nextID++
+		poll.Options[i].ID = f.nextID
+	}
+	stored := *poll
+	stored.Options = slices.Clone(poll.Options)
+	f.polls[poll.ID] = &stored
+	f.votes[poll.ID] = make(map[string][]int64)
+	return nil
+}
+
+func (f *fakePolls) Get(ctx context.Context, pollID, viewerID string) (*models.Poll, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored, ok := f.polls[pollID]
+	if !ok {
+		return nil, nil
+	}
+	poll := *stored
+	poll.Options = slices.Clone(stored.Options)
+	for i := range poll.Options {
+		poll.Options[i].Votes, poll.Options[i].VotedBy = 0, nil
+	}
+	for userID, options := range f.votes[pollID] {
+		if len(options) > 0 {
+			poll.Voters++
+		}
+		for i := range poll.Options {
+			if slices.Contains(options, poll.Options[i].ID) {
+				poll.Options[i].Votes++
+				if !poll.Anonymous {
+					poll.Options[i].VotedBy = append(poll.Options[i].VotedBy, models.PollVoter{UserID: userID})
+				}
+			}
+		}
+	}
+	if viewerID != "" {
+		poll.MyVotes = slices.Clone(f.votes[pollID][viewerID])
+	}
+	return &poll, nil
+}
+
+func (f *fakePolls) Vote(ctx context.Context, pollID, userID string, optionIDs []int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll := f.polls[pollID]
+	if poll == nil || poll.Closed(time.Now()) || (!poll.Multiple && len(optionIDs) != 1) {
+		return false, nil
+	}
+	if poll.Multiple {
+		for _, id := range optionIDs {
+			if !slices.Contains(f.votes[pollID][userID], id) {
+				f.votes[pollID][userID] = append(f.votes[pollID][userID], id)
+			}
+		}
+	} else {
+		f.votes[pollID][userID] = slices.Clone(optionIDs)
+	}
+	return true, nil
+}
+
+func (f *fakePolls) Unvote(ctx context.Context, pollID, userID string, optionID int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	before := f.votes[pollID][userID]
+	after := slices.DeleteFunc(slices.Clone(before), func(id int64) bool { return optionID == 0 || id == optionID })
+	f.votes[pollID][userID] = after
+	return len(after) != len(before), nil
+}
+
+func (f *fakePolls) Close(ctx context.Context, pollID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll := f.polls[pollID]
+	if poll == nil || poll.ClosedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	poll.ClosedAt = &now
+	return true, nil
+}
+
+func (f *fakePolls) CloseDue(ctx context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := f.due
+	f.due = nil
+	for _, id := range ids {
+		closedAt := *f.polls[id].ClosesAt
+		f.polls[id].ClosedAt = &closedAt
+	}
+	return ids, nil
+}
+
+func (f *fakePolls) expire(pollID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	past := time.Now().Add(-time.Second)
+	f.polls[pollID].ClosesAt = &past
+	f.due = append(f.due, pollID)
+}
+
+func newPollPipeline(t *testing.T) (*testPipeline, *fakePolls) {
+	t.Helper()
+	tp := newTestPipeline(t)
+	polls := newFakePolls()
+	tp.SetPolls(polls)
+	return tp, polls
+}
+
+func votes(poll *models.Poll) []int {
+	counts := make([]int, len(poll.Options))
+	for i, o := range poll.Options {
+		counts[i] = o.Votes
+	}
+	return counts
+}
+
+func TestCreatePollValidation(t *testing.T) {
+	tp, _ := newPollPipeline(t)
+	ctx := context.Background()
+	past := time.Now().Add(-time.Minute)
+	tooFar := time.Now().Add(31 * 24 * time.Hour)
+
+	invalid := map[string]PollSpec{
+		"sem pergunta":      {Question: "  ", Options: []string{"a", "b"}},
+		"uma opção":         {Question: "?", Options: []string{"a", " "}},
+		"opção repetida":    {Question: "?", Options: []string{"a", " a "}},
+		"opções demais":     {Question: "?", Options: strings.Split("a b c d e f g h i j k", " ")},
+		"pergunta longa":    {Question: strings.Repeat("x", maxPollQuestion+1), Options: []string{"a", "b"}},
+		"prazo no passado":  {Question: "?", Options: []string{"a", "b"}, ClosesAt: &past},
+		"prazo muito longo": {Question: "?", Options: []string{"a", "b"}, ClosesAt: &tooFar},
+	}
+	for name, spec := range invalid {
+		var perr *protocol.Error
+		if _, err := tp.CreatePoll(ctx, alice, generalRoom, spec); !errors.As(err, &perr) {
+			t.Errorf("%s: err = %v, quer erro de validação", name, err)
+		}
+	}
+
+	if _, err := tp.CreatePoll(ctx, Sender{UserID: "carol"}, groupRoom, PollSpec{Question: "?", Options: []string{"a", "b"}}); err != ErrNotMember {
+		t.Errorf("fora do grupo: err = %v, quer ErrNotMember", err)
+	}
+
+	msg, err := tp.CreatePoll(ctx, alice, generalRoom, PollSpec{Question: " Almoço? ", Options: []string{"pizza", "", "sushi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "poll" || msg.Content != "Almoço?" || len(msg.Poll.Options) != 2 || msg.Poll.Options[1].Text != "sushi" {
+		t.Errorf("enquete = %+v %+v", msg, msg.Poll)
+	}
+	if sent := tp.sent(); len(sent) != 1 || sent[0].Type != "poll" {
+		t.Errorf("transmitidas = %+v", sent)
+	}
+}
+
+func TestVoteSingleChoice(t *testing.T) {
+	tp, _ := newPollPipeline(t)
+	ctx := context.Background()
+	msg, err := tp.CreatePoll(ctx, alice, generalRoom, PollSpec{Question: "?", Options: []string{"a", "b", "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pollID, opts := msg.Poll.ID, msg.Poll.Options
+
+	for name, ids := range map[string][]int64{
+		"nenhuma":          nil,
+		"duas":             {opts[0].ID, opts[1].ID},
+		"de outra enquete": {999},
+		"válida e falsa":   {opts[0].ID, 999},
+	} {
+		if _, err := tp.Vote(ctx, bob, pollID, ids); err != ErrPollOption {
+			t.Errorf("%s: err = %v, quer ErrPollOption", name, err)
+		}
+	}
+
+	if _, err := tp.Vote(ctx, bob, pollID, []int64{opts[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	// Repetir a mesma opção conta como uma só.
+	poll, err := tp.Vote(ctx, bob, pollID, []int64{opts[1].ID, opts[1].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := votes(poll); !slices.Equal(got, []int{0, 1, 0}) || poll.Voters != 1 {
+		t.Errorf("votos = %v (votantes %d), quer o segundo voto no lugar do primeiro", got, poll.Voters)
+	}
+	if !slices.Equal(poll.MyVotes, []int64{opts[1].ID}) {
+		t.Errorf("MyVotes = %v", poll.MyVotes)
+	}
+
+	sent := tp.sent()
+	last := sent[len(sent)-1]
+	if last.Type != "poll_updated" || last.ID != msg.ID || last.Poll.MyVotes != nil {
+		t.Errorf("evento = %+v, quer poll_updated sem os votos de quem votou", last)
+	}
+}
+
+func TestVoteMultipleAndUnvote(t *testing.T) {
+	tp, _ := newPollPipeline(t)
+	ctx := context.Background()
+	msg, err := tp.CreatePoll(ctx, alice, generalRoom, PollSpec{Question: "?", Options: []string{"a", "b", "c"}, Multiple: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pollID, opts := msg.Poll.ID, msg.Poll.Options
+
+	if _, err := tp.Vote(ctx, bob, pollID, []int64{opts[2].ID, opts[0].ID, opts[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	poll, err := tp.Vote(ctx, alice, pollID, []int64{opts[0].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := votes(poll); !slices.Equal(got, []int{2, 0, 1}) || poll.Voters != 2 {
+		t.Errorf("votos = %v (votantes %d)", got, poll.Voters)
+	}
+
+	poll, err = tp.Unvote(ctx, bob, pollID, opts[2].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := votes(poll); !slices.Equal(got, []int{2, 0, 0}) {
+		t.Errorf("depois de retirar uma opção: %v", got)
+	}
+
+	// Retirar o que não foi votado não transmite nada.
+	before := len(tp.sent())
+	if _, err := tp.Unvote(ctx, bob, pollID, opts[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(tp.sent()) != before {
+		t.Error("Unvote sem mudança transmitiu poll_updated")
+	}
+
+	poll, err = tp.Unvote(ctx, bob, pollID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := votes(poll); !slices.Equal(got, []int{1, 0, 0}) || poll.Voters != 1 {
+		t.Errorf("depois de retirar tudo: %v (votantes %d)", got, poll.Voters)
+	}
+}
+
+func TestClosePollRules(t *testing.T) {
+	tp, _ := newPollPipeline(t)
+	ctx := context.Background()
+
+	general, err := tp.CreatePoll(ctx, alice, generalRoom, PollSpec{Question: "?", Options: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var perr *protocol.Error
+	if _, err := tp.ClosePoll(ctx, bob, general.Poll.ID); !errors.As(err, &perr) || perr.Code != protocol.CodeForbidden {
+		t.Errorf("bob encerrando enquete de alice na geral: err = %v", err)
+	}
+
+	// No grupo, quem o criou encerra a enquete de qualquer participante.
+	group, err := tp.CreatePoll(ctx, bob, groupRoom, PollSpec{Question: "?", Options: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll, err := tp.ClosePoll(ctx, alice, group.Poll.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if poll.ClosedAt == nil {
+		t.Error("enquete não encerrada")
+	}
+	if _, err := tp.ClosePoll(ctx, bob, group.Poll.ID); err != ErrPollClosed {
+		t.Errorf("encerrar de novo: err = %v, quer ErrPollClosed", err)
+	}
+	if _, err := tp.Vote(ctx, bob, group.Poll.ID, []int64{group.Poll.Options[0].ID}); err != ErrPollClosed {
+		t.Errorf("voto em enquete encerrada: err = %v, quer ErrPollClosed", err)
+	}
+	if _, err := tp.Unvote(ctx, bob, group.Poll.ID, 0); err != ErrPollClosed {
+		t.Errorf("retirar voto de enquete encerrada: err = %v, quer ErrPollClosed", err)
+	}
+
+	// Quem não participa do grupo nem enxerga a enquete.
+	if _, err := tp.Vote(ctx, Sender{UserID: "carol"}, group.Poll.ID, []int64{group.Poll.Options[0].ID}); err != ErrPollNotFound {
+		t.Errorf("voto de fora do grupo: err = %v, quer ErrPollNotFound", err)
+	}
+	if _, err := tp.Vote(ctx, bob, "inexistente", []int64{1}); err != ErrPollNotFound {
+		t.Errorf("enquete inexistente: err = %v, quer ErrPollNotFound", err)
+	}
+}
+
+func TestPollDeadline(t *testing.T) {
+	tp, polls := newPollPipeline(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	closesAt := time.Now().Add(time.Hour)
+	msg, err := tp.CreatePoll(ctx, alice, generalRoom, PollSpec{Question: "?", Options: []string{"a", "b"}, ClosesAt: &closesAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	polls.expire(msg.Poll.ID)
+
+	if _, err := tp.Vote(ctx, bob, msg.Poll.ID, []int64{msg.Poll.Options[0].ID}); err != ErrPollClosed {
+		t.Errorf("voto depois do prazo: err = %v, quer ErrPollClosed", err)
+	}
+
+	// A primeira passada do RunPollCloser encerra e avisa a sala.
+	go tp.RunPollCloser(ctx)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sent := tp.sent()
+		if last := sent[len(sent)-1]; last.Type == "poll_updated" {
+			if last.Poll.ClosedAt == nil || last.RoomID != generalRoom {
+				t.Errorf("evento = %+v", last)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("nenhum poll_updated do RunPollCloser")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPollCommand(t *testing.T) {
+	tp, _ := newPollPipeline(t)
+	tp.withCommands(nil)
+	ctx := context.Background()
+
+	msg, err := tp.Send(ctx, alice, generalRoom, "/poll --multi --anon --closes 2h Almoço? | pizza | sushi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "poll" || !msg.Poll.Multiple || !msg.Poll.Anonymous || msg.Poll.ClosesAt == nil || msg.Poll.Question != "Almoço?" {
+		t.Fatalf("enquete = %+v %+v", msg, msg.Poll)
+	}
+	if d := time.Until(*msg.Poll.ClosesAt); d < 119*time.Minute || d > 2*time.Hour {
+		t.Errorf("prazo em %s, quer 2h", d)
+	}
+
+	for _, content := range []string{"/poll só a pergunta", "/poll --closes nunca ? | a | b", "/poll --todos ? | a | b", "/poll ? | a | a"} {
+		reply, err := tp.Send(ctx, alice, generalRoom, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Type != "ephemeral" {
+			t.Errorf("%q: resposta = %+v, quer aviso efêmero", content, reply)
+		}
+	}
+}
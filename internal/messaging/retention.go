@@ -0,0 +1,104 @@
+package messaging
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// RetentionStore apaga as mensagens vencidas. Cutoffs diz, por sala, até
+// quando as mensagens vencem; Purge apaga um lote e devolve os IDs.
+type RetentionStore interface {
+	Cutoffs(ctx context.Context, defaultDays int) (map[string]time.Time, error)
+	Purge(ctx context.Context, roomID string, before time.Time, limit int) ([]string, error)
+}
+
+// RetentionConfig: DefaultDays vale para as salas sem retenção própria
+// (zero guarda para sempre); Interval é o intervalo entre as passadas e
+// BatchSize o tamanho de cada DELETE.
+type RetentionConfig struct {
+	DefaultDays int
+	Interval    time.Duration
+	BatchSize   int
+}
+
+func (c *RetentionConfig) defaults() {
+	if c.Interval <= 0 {
+		c.Interval = time.Minute
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 500
+	}
+}
+
+type retentionState struct {
+	store RetentionStore
+	cfg   RetentionConfig
+}
+
+// SetRetention ativa a limpeza de mensagens vencidas.
+func (p *Pipeline) SetRetention(store RetentionStore, cfg RetentionConfig) {
+	cfg.defaults()
+	p.retention = &retentionState{store: store, cfg: cfg}
+}
+
+// RunRetention apaga as mensagens vencidas a cada intervalo até o contexto
+// ser cancelado.
+func (p *Pipeline) RunRetention(ctx context.Context) {
+	if p.retention == nil {
+		return
+	}
+
+	ticker := time.NewTicker(p.retention.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := p.PurgeExpired(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Erro ao aplicar retenção: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeExpired faz uma passada: apaga em lotes tudo o que venceu em cada
+// sala, avisa a sala com "message_expired" por mensagem e publica
+// message.expired por lote. Devolve quantas mensagens foram apagadas.
+func (p *Pipeline) PurgeExpired(ctx context.Context) (int, error) {
+	cutoffs, err := p.retention.store.Cutoffs(ctx, p.retention.cfg.DefaultDays)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for roomID, before := range cutoffs {
+		for ctx.Err() == nil {
+			ids, err := p.retention.store.Purge(ctx, roomID, before, p.retention.cfg.BatchSize)
+			if err != nil {
+				return total, err
+			}
+			total += len(ids)
+			p.expired(ctx, roomID, ids)
+			if len(ids) < p.retention.cfg.BatchSize {
+				break
+			}
+		}
+	}
+	return total, ctx.Err()
+}
+
+func (p *Pipeline) expired(ctx context.Context, roomID string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, id := range ids {
+		p.broadcast(models.Message{ID: id, RoomID: roomID, Type: "message_expired", Timestamp: now})
+	}
+	p.publish(ctx, models.EventMessageExpired, roomID, map[string]any{"roomId": roomID, "ids": ids})
+}
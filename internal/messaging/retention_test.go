@@ -0,0 +1,152 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// fakeRetention guarda as mensagens vencidas de cada sala e registra o
+// tamanho de cada lote pedido a Purge.
+type fakeRetention struct {
+	mu          sync.Mutex
+	expired     map[string][]string
+	defaultDays int
+	calls       map[string][]int
+	fail        error
+}
+
+func (f *fakeRetention) Cutoffs(ctx context.Context, defaultDays int) (map[string]time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.defaultDays = defaultDays
+	cutoffs := make(map[string]time.Time)
+	for roomID := range f.expired {
+		cutoffs[roomID] = time.Now().Add(-24 * time.Hour)
+	}
+	return cutoffs, nil
+}
+
+func (f *fakeRetention) Purge(ctx context.Context, roomID string, before time.Time, limit int) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail != nil {
+		return nil, f.fail
+	}
+	ids := f.expired[roomID][:min(limit, len(f.expired[roomID]))]
+	f.expired[roomID] = f.expired[roomID][len(ids):]
+	f.calls[roomID] = append(f.calls[roomID], len(ids))
+	return ids, nil
+}
+
+type fakeEvents struct {
+	mu     sync.Mutex
+	events []models.Event
+}
+
+func (f *fakeEvents) Publish(ctx context.Context, event models.Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, event)
+}
+
+func messageIDs(prefix string, n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%s-%d", prefix, i)
+	}
+	return ids
+}
+
+func TestPurgeExpiredBatches(t *testing.T) {
+	tp := newTestPipeline(t)
+	events := &fakeEvents{}
+	tp.SetEvents(events)
+	store := &fakeRetention{
+		expired: map[string][]string{
+			generalRoom: messageIDs("g", 5),
+			groupRoom:   messageIDs("p", 4),
+		},
+		calls: make(map[string][]int),
+	}
+	tp.SetRetention(store, RetentionConfig{DefaultDays: 30, BatchSize: 2})
+
+	n, err := tp.PurgeExpired(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 9 {
+		t.Errorf("apagadas = %d, quer 9", n)
+	}
+	if store.defaultDays != 30 {
+		t.Errorf("Cutoffs recebeu %d dias, quer 30", store.defaultDays)
+	}
+	// Um lote menor que BatchSize encerra a sala; um lote cheio pede outro.
+	if got := store.calls[generalRoom]; !slices.Equal(got, []int{2, 2, 1}) {
+		t.Errorf("lotes da geral = %v, quer [2 2 1]", got)
+	}
+	if got := store.calls[groupRoom]; !slices.Equal(got, []int{2, 2, 0}) {
+		t.Errorf("lotes do grupo = %v, quer [2 2 0]", got)
+	}
+
+	// Cada mensagem apagada vira um message_expired para a sala dela.
+	expired := make(map[string][]string)
+	for _, m := range tp.sent() {
+		if m.Type != "message_expired" {
+			t.Errorf("evento inesperado: %+v", m)
+		}
+		expired[m.RoomID] = append(expired[m.RoomID], m.ID)
+	}
+	if !slices.Equal(expired[generalRoom], messageIDs("g", 5)) || !slices.Equal(expired[groupRoom], messageIDs("p", 4)) {
+		t.Errorf("message_expired = %v", expired)
+	}
+
+	// Um message.expired por lote não vazio.
+	if len(events.events) != 5 {
+		t.Fatalf("eventos = %d, quer 5 (um por lote com mensagens)", len(events.events))
+	}
+	for _, e := range events.events {
+		data := e.Data.(map[string]any)
+		if e.Type != models.EventMessageExpired || data["roomId"] != e.RoomID || len(data["ids"].([]string)) == 0 {
+			t.Errorf("evento = %+v", e)
+		}
+	}
+
+	// Sem nada vencido a passada seguinte não transmite nada.
+	before := len(tp.sent())
+	if n, err := tp.PurgeExpired(context.Background()); err != nil || n != 0 {
+		t.Errorf("segunda passada = %d, %v", n, err)
+	}
+	if len(tp.sent()) != before {
+		t.Error("passada vazia transmitiu eventos")
+	}
+}
+
+func TestPurgeExpiredStops(t *testing.T) {
+	tp := newTestPipeline(t)
+	store := &fakeRetention{expired: map[string][]string{generalRoom: messageIDs("g", 10)}, calls: make(map[string][]int)}
+	tp.SetRetention(store, RetentionConfig{BatchSize: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n, err := tp.PurgeExpired(ctx); !errors.Is(err, context.Canceled) || n != 0 {
+		t.Errorf("contexto cancelado: %d, %v", n, err)
+	}
+	if len(store.calls[generalRoom]) != 0 {
+		t.Errorf("Purge chamado com o contexto cancelado: %v", store.calls)
+	}
+
+	store.fail = errors.New("banco fora")
+	if _, err := tp.PurgeExpired(context.Background()); !errors.Is(err, store.fail) {
+		t.Errorf("erro do Purge: %v", err)
+	}
+	if len(tp.sent()) != 0 {
+		t.Errorf("transmitidas = %v", tp.sent())
+	}
+}
@@ -0,0 +1,597 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/protocol"
+)
+
+const (
+	MaxScheduledPerUser  = 100
+	maxScheduleAhead     = 365 * 24 * time.Hour
+	scheduleBatch        = 50
+	scheduleLease        = time.Minute
+	scheduleMaxAttempts  = 5
+	scheduleRetryBackoff = 30 * time.Second
+	reminderRetry        = time.Minute
+)
+
+var (
+	ErrScheduledNotFound = protocol.NewError(protocol.CodeForbidden, "Agendamento não encontrado")
+	ErrScheduledBusy     = protocol.NewError(protocol.CodeValidation, "O agendamento já foi enviado ou está sendo enviado")
+	ErrSchedulerOff      = protocol.NewError(protocol.CodeValidation, "Agendamentos desativados")
+	ErrMessageNotFound   = protocol.NewError(protocol.CodeForbidden, "Mensagem não encontrada")
+	errNoWhen            = protocol.NewError(protocol.CodeValidation, "Horário inválido (ex.: 30m, 2h, 09:00, amanhã 09:00, 2026-10-20 09:00)")
+)
+
+// ScheduleStore é a fila durável de agendamentos. Claim precisa ser seguro
+// com várias réplicas: cada item reservado fica invisível às outras até o
+// lease expirar. Retry sem lastError só adia, sem contar tentativa.
+type ScheduleStore interface {
+	Create(ctx context.Context, s *models.ScheduledMessage) error
+	CountPending(ctx context.Context, userID string) (int, error)
+	List(ctx context.Context, userID, status string) ([]models.ScheduledMessage, error)
+	Get(ctx context.Context, id, userID string) (*models.ScheduledMessage, error)
+	Update(ctx context.Context, id, userID, content string, sendAt time.Time, timezone string) (*models.ScheduledMessage, error)
+	Cancel(ctx context.Context, id, userID string) (bool, error)
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]models.ScheduledMessage, error)
+	Complete(ctx context.Context, id, status, lastError string) error
+	Retry(ctx context.Context, id string, at time.Time, lastError string) error
+	MessageRoom(ctx context.Context, messageID string) (string, error)
+}
+
+// ScheduleUsers traz o fuso de quem agenda e, na hora do envio, o nome e o
+// avatar atuais do autor.
+type ScheduleUsers interface {
+	GetByID(ctx context.Context, id string) (*models.User, error)
+}
+
+// ScheduleSpec diz o que e quando enviar. SendAt é um instante exato; When
+// é um texto como os de ParseWhen, lido no fuso Timezone (ou no do usuário).
+type ScheduleSpec struct {
+	Content  string     `json:"content"`
+	SendAt   *time.Time `json:"sendAt"`
+	When     string     `json:"when"`
+	Timezone string     `json:"timezone"`
+}
+
+func init() {
+	RegisterCommand("schedule", "/schedule <quando> texto", "agenda uma mensagem nesta sala", (*Pipeline).cmdSchedule)
+	RegisterCommand("remind", "/remind <quando> texto", "cria um lembrete que só você recebe", (*Pipeline).cmdRemind)
+}
+
+// SetScheduler ativa as mensagens agendadas e os lembretes.
+func (p *Pipeline) SetScheduler(store ScheduleStore, users ScheduleUsers) {
+	p.scheduled = store
+	p.scheduleUsers = users
+}
+
+// ParseWhen interpreta quando enviar: uma duração ("30m", "2h"), um horário
+// ("09:00", o próximo a chegar), um dia e horário ("amanhã 09:00",
+// "2026-10-20 09:00") ou RFC 3339. Horários são do fuso loc.
+func ParseWhen(text string, now time.Time, loc *time.Location) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04", text, loc); err == nil {
+		return t, nil
+	}
+	text = strings.ToLower(text)
+	if d, err := time.ParseDuration(text); err == nil {
+		if d <= 0 {
+			return time.Time{}, errNoWhen
+		}
+		return now.Add(d), nil
+	}
+
+	day, clock, hasDay := strings.Cut(text, " ")
+	if !hasDay {
+		day, clock = "", day
+	}
+	hm, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return time.Time{}, errNoWhen
+	}
+
+	local := now.In(loc)
+	switch day {
+	case "":
+		t := time.Date(local.Year(), local.Month(), local.Day(), hm.Hour(), hm.Minute(), 0, 0, loc)
+		if !t.After(now) {
+			t = time.Date(local.Year(), local.Month(), local.Day()+1, hm.Hour(), hm.Minute(), 0, 0, loc)
+		}
+		return t, nil
+	case "hoje", "today":
+	case "amanhã", "amanha", "tomorrow":
+		local = local.AddDate(0, 0, 1)
+	default:
+		if local, err = time.ParseInLocation("2006-01-02", day, loc); err != nil {
+			return time.Time{}, errNoWhen
+		}
+	}
+	return time.Date(local.Year(), local.Month(), local.Day(), hm.Hour(), hm.Minute(), 0, 0, loc), nil
+}
+
+// userLocation resolve o fuso: o pedido, o do usuário ou o do servidor.
+func (p *Pipeline) userLocation(ctx context.Context, userID, timezone string) (*time.Location, error) {
+	if timezone == "" && p.scheduleUsers != nil {
+		user, err := p.scheduleUsers.GetByID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			timezone = user.Timezone
+		}
+	}
+	if timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "Local" {
+		return nil, protocol.NewError(protocol.CodeValidation, "Fuso horário inválido: "+timezone)
+	}
+	return loc, nil
+}
+
+// scheduleTime devolve o instante de envio e o nome do fuso usado.
+func (p *Pipeline) scheduleTime(ctx context.Context, userID string, spec ScheduleSpec, now time.Time) (time.Time, string, error) {
+	loc, err := p.userLocation(ctx, userID, spec.Timezone)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	var at time.Time
+	switch {
+	case spec.SendAt != nil:
+		at = *spec.SendAt
+	case spec.When != "":
+		if at, err = ParseWhen(spec.When, now, loc); err != nil {
+			return time.Time{}, "", err
+		}
+	default:
+		return time.Time{}, "", protocol.NewError(protocol.CodeValidation, "Informe quando enviar (sendAt ou when)")
+	}
+	if !at.After(now) || at.Sub(now) > maxScheduleAhead {
+		return time.Time{}, "", protocol.NewError(protocol.CodeValidation, "O horário deve ser no futuro e em até um ano")
+	}
+	return at, loc.String(), nil
+}
+
+// scheduledContent valida o texto de uma mensagem agendada. Comandos não
+// são agendados; "//texto" vira "/texto" como em Send.
+func (p *Pipeline) scheduledContent(content string) (string, error) {
+	if strings.TrimSpace(content) == "" {
+		return "", ErrEmptyContent
+	}
+	if len(content) > MaxContentLength {
+		return "", ErrTooLong
+	}
+	if p.commands != nil {
+		if strings.HasPrefix(content, "//") {
+			content = content[1:]
+		} else if _, _, ok := ParseCommand(content); ok {
+			return "", protocol.NewError(protocol.CodeValidation, "Comandos não podem ser agendados")
+		}
+	}
+	return content, nil
+}
+
+func (p *Pipeline) createScheduled(ctx context.Context, s *models.ScheduledMessage) (*models.ScheduledMessage, error) {
+	pending, err := p.scheduled.CountPending(ctx, s.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if pending >= MaxScheduledPerUser {
+		return nil, protocol.NewError(protocol.CodeValidation, "Limite de agendamentos pendentes atingido")
+	}
+	if err := p.scheduled.Create(ctx, s); err != nil {
+		return nil, err
+	}
+	s.Localize()
+	return s, nil
+}
+
+// Schedule agenda uma mensagem para a sala. Na hora do envio ela segue o
+// caminho de Send (gravação, sala e webhooks), sem os limites de envio, e
+// é recusada se o autor já não participar da sala.
+func (p *Pipeline) Schedule(ctx context.Context, sender Sender, roomID string, spec ScheduleSpec) (*models.ScheduledMessage, error) {
+	if p.scheduled == nil {
+		return nil, ErrSchedulerOff
+	}
+	content, err := p.scheduledContent(spec.Content)
+	if err != nil {
+		return nil, err
+	}
+	room, err := p.accessibleRoom(ctx, roomID, sender.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if room.Encrypted {
+		// O texto ficaria guardado em claro até a hora do envio.
+		return nil, ErrEncryptedRoom
+	}
+	at, timezone, err := p.scheduleTime(ctx, sender.UserID, spec, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return p.createScheduled(ctx, &models.ScheduledMessage{
+		UserID:   sender.UserID,
+		RoomID:   roomID,
+		Kind:     models.ScheduledKindMessage,
+		Content:  content,
+		SendAt:   at,
+		Timezone: timezone,
+	})
+}
+
+// Remind cria um lembrete que só o próprio usuário recebe. Com messageID
+// ele aponta para aquela mensagem e Content é uma nota opcional.
+func (p *Pipeline) Remind(ctx context.Context, sender Sender, roomID, messageID string, spec ScheduleSpec) (*models.ScheduledMessage, error) {
+	if p.scheduled == nil {
+		return nil, ErrSchedulerOff
+	}
+	note := strings.TrimSpace(spec.Content)
+	if len(note) > MaxContentLength {
+		return nil, ErrTooLong
+	}
+	if messageID == "" && note == "" {
+		return nil, ErrEmptyContent
+	}
+
+	if messageID != "" {
+		if _, err := uuid.Parse(messageID); err != nil {
+			return nil, ErrMessageNotFound
+		}
+		msgRoom, err := p.scheduled.MessageRoom(ctx, messageID)
+		if err != nil {
+			return nil, err
+		}
+		if msgRoom == "" || (roomID != "" && msgRoom != roomID) {
+			return nil, ErrMessageNotFound
+		}
+		roomID = msgRoom
+	}
+	room, err := p.accessibleRoom(ctx, roomID, sender.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if room.Encrypted && note != "" {
+		return nil, ErrEncryptedRoom
+	}
+	at, timezone, err := p.scheduleTime(ctx, sender.UserID, spec, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return p.createScheduled(ctx, &models.ScheduledMessage{
+		UserID:    sender.UserID,
+		RoomID:    roomID,
+		Kind:      models.ScheduledKindReminder,
+		Content:   note,
+		MessageID: messageID,
+		SendAt:    at,
+		Timezone:  timezone,
+	})
+}
+
+// ScheduledList devolve os agendamentos do usuário; status vazio traz
+// todos.
+func (p *Pipeline) ScheduledList(ctx context.Context, userID, status string) ([]models.ScheduledMessage, error) {
+	if p.scheduled == nil {
+		return nil, ErrSchedulerOff
+	}
+	if status != "" && !slices.Contains([]string{models.ScheduledPending, models.ScheduledSent, models.ScheduledFailed, models.ScheduledCanceled}, status) {
+		return nil, protocol.NewError(protocol.CodeValidation, "Status inválido")
+	}
+
+	items, err := p.scheduled.List(ctx, userID, status)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].Localize()
+	}
+	return items, nil
+}
+
+// Scheduled devolve um agendamento do próprio usuário.
+func (p *Pipeline) Scheduled(ctx context.Context, userID, id string) (*models.ScheduledMessage, error) {
+	if p.scheduled == nil {
+		return nil, ErrSchedulerOff
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrScheduledNotFound
+	}
+	item, err := p.scheduled.Get(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, ErrScheduledNotFound
+	}
+	item.Localize()
+	return item, nil
+}
+
+// UpdateScheduled troca o texto e/ou o horário de um agendamento pendente.
+// Sem sendAt nem when o horário fica como está.
+func (p *Pipeline) UpdateScheduled(ctx context.Context, sender Sender, id string, spec ScheduleSpec) (*models.ScheduledMessage, error) {
+	item, err := p.Scheduled(ctx, sender.UserID, id)
+	if err != nil {
+		return nil, err
+	}
+	if item.Status != models.ScheduledPending {
+		return nil, ErrScheduledBusy
+	}
+
+	content := item.Content
+	if spec.Content != "" {
+		room, err := p.accessibleRoom(ctx, item.RoomID, sender.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if room.Encrypted {
+			return nil, ErrEncryptedRoom
+		}
+		if item.Kind == models.ScheduledKindReminder {
+			content = strings.TrimSpace(spec.Content)
+			if len(content) > MaxContentLength {
+				return nil, ErrTooLong
+			}
+		} else if content, err = p.scheduledContent(spec.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	at, timezone := item.SendAt, item.Timezone
+	if spec.SendAt != nil || spec.When != "" {
+		if spec.Timezone == "" {
+			spec.Timezone = item.Timezone
+		}
+		if at, timezone, err = p.scheduleTime(ctx, sender.UserID, spec, time.Now()); err != nil {
+			return nil, err
+		}
+	} else if spec.Timezone != "" {
+		loc, err := p.userLocation(ctx, sender.UserID, spec.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		timezone = loc.String()
+	}
+
+	updated, err := p.scheduled.Update(ctx, id, sender.UserID, content, at, timezone)
+	if err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		return nil, ErrScheduledBusy
+	}
+	updated.Localize()
+	return updated, nil
+}
+
+// CancelScheduled tira um agendamento pendente da fila.
+func (p *Pipeline) CancelScheduled(ctx context.Context, userID, id string) error {
+	if _, err := p.Scheduled(ctx, userID, id); err != nil {
+		return err
+	}
+	canceled, err := p.scheduled.Cancel(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+	if !canceled {
+		return ErrScheduledBusy
+	}
+	return nil
+}
+
+// RunScheduler entrega os agendamentos vencidos até o contexto ser
+// cancelado. O horário fica no banco, então o que venceu durante um
+// restart sai na primeira passada.
+func (p *Pipeline) RunScheduler(ctx context.Context, interval time.Duration) {
+	if p.scheduled == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := p.DeliverDue(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Erro ao entregar agendamentos: %v", err)
+			}
+			if err != nil || n < scheduleBatch || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue reserva um lote de agendamentos vencidos, entrega e devolve
+// quantos foram processados.
+func (p *Pipeline) DeliverDue(ctx context.Context) (int, error) {
+	items, err := p.scheduled.Claim(ctx, scheduleBatch, scheduleLease)
+	if err != nil {
+		return 0, err
+	}
+	for i := range items {
+		p.deliverScheduled(ctx, &items[i])
+	}
+	return len(items), nil
+}
+
+func (p *Pipeline) deliverScheduled(ctx context.Context, item *models.ScheduledMessage) {
+	now := time.Now()
+	var err error
+
+	if item.Kind == models.ScheduledKindReminder {
+		if p.Notify(item.RoomID, []string{item.UserID}, reminderText(item)) > 0 {
+			err = p.scheduled.Complete(ctx, item.ID, models.ScheduledSent, "")
+		} else {
+			// Ninguém conectado: o lembrete espera a pessoa aparecer.
+			err = p.scheduled.Retry(ctx, item.ID, now.Add(reminderRetry), "")
+		}
+	} else {
+		err = p.sendScheduled(ctx, item, now)
+	}
+	if err != nil {
+		log.Printf("Erro ao atualizar agendamento %s: %v", item.ID, err)
+	}
+}
+
+// sendScheduled publica a mensagem e registra o desfecho. Erros de regra
+// (saiu da sala) encerram o agendamento e avisam o autor; silêncio, modo
+// lento e limites de envio adiam pelo tempo pedido; os demais erros são
+// tentados de novo.
+func (p *Pipeline) sendScheduled(ctx context.Context, item *models.ScheduledMessage, now time.Time) error {
+	err := p.publishScheduled(ctx, item, now)
+
+	var perr *protocol.Error
+	switch {
+	case err == nil:
+		return p.scheduled.Complete(ctx, item.ID, models.ScheduledSent, "")
+	case errors.As(err, &perr) && perr.RetryAfter > 0:
+		return p.scheduled.Retry(ctx, item.ID, now.Add(perr.RetryAfter), "")
+	case perr != nil || item.Attempts+1 >= scheduleMaxAttempts:
+		p.Notify(item.RoomID, []string{item.UserID}, "Sua mensagem agendada não foi enviada: "+err.Error())
+		return p.scheduled.Complete(ctx, item.ID, models.ScheduledFailed, err.Error())
+	default:
+		return p.scheduled.Retry(ctx, item.ID, now.Add(time.Duration(item.Attempts+1)*scheduleRetryBackoff), err.Error())
+	}
+}
+
+// publishScheduled grava com o ID do agendamento, o que torna a entrega
+// idempotente se a reserva expirar no meio.
+func (p *Pipeline) publishScheduled(ctx context.Context, item *models.ScheduledMessage, now time.Time) error {
+	user, err := p.scheduleUsers.GetByID(ctx, item.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrNotMember
+	}
+	room, err := p.accessibleRoom(ctx, item.RoomID, item.UserID)
+	if err != nil {
+		return err
+	}
+	if room.Encrypted {
+		// A sala passou a ser criptografada depois do agendamento.
+		return ErrEncryptedRoom
+	}
+	if err := p.checkMuted(ctx, item.RoomID, item.UserID); err != nil {
+		return err
+	}
+	if err := p.checkFlood(room, item.UserID); err != nil {
+		return err
+	}
+
+	msg := models.Message{
+		ID:        item.ID,
+		RoomID:    item.RoomID,
+		Username:  user.Username,
+		Content:   item.Content,
+		Timestamp: now,
+		Type:      "message",
+		Bot:       user.IsBot,
+	}
+	if user.AvatarURL != nil {
+		msg.AvatarURL = *user.AvatarURL
+	}
+	return p.deliver(ctx, &msg, item.UserID)
+}
+
+func reminderText(item *models.ScheduledMessage) string {
+	if item.Message == nil {
+		return "Lembrete: " + item.Content
+	}
+	// Mensagens cifradas não têm trecho legível no servidor.
+	text := "Lembrete da mensagem de " + item.Message.Username
+	if !item.Message.Encrypted() {
+		excerpt := []rune(item.Message.Content)
+		if len(excerpt) > 120 {
+			excerpt = append(excerpt[:120], '…')
+		}
+		text += ": \"" + string(excerpt) + "\""
+	}
+	if item.Content != "" {
+		text += " (" + item.Content + ")"
+	}
+	return text
+}
+
+// splitWhen separa "<quando> texto" aceitando quando com uma ou duas
+// palavras ("2h", "amanhã 09:00").
+func splitWhen(args string, now time.Time, loc *time.Location) (when, text string, ok bool) {
+	fields := strings.Fields(args)
+	for n := min(2, len(fields)-1); n >= 1; n-- {
+		candidate := strings.Join(fields[:n], " ")
+		if _, err := ParseWhen(candidate, now, loc); err != nil {
+			continue
+		}
+		rest := args
+		for range n {
+			_, rest, _ = strings.Cut(strings.TrimSpace(rest), " ")
+		}
+		return candidate, strings.TrimSpace(rest), true
+	}
+	return "", "", false
+}
+
+// scheduledCommand é a parte comum de /schedule e /remind.
+func (p *Pipeline) scheduledCommand(ctx context.Context, sender Sender, room *models.Room, name, args string,
+	create func(ScheduleSpec) (*models.ScheduledMessage, error)) (*models.Message, error) {
+	if p.scheduled == nil {
+		return Ephemeral(room.ID, "Agendamentos desativados"), nil
+	}
+	loc, err := p.userLocation(ctx, sender.UserID, "")
+	if err != nil {
+		return nil, err
+	}
+	when, text, ok := splitWhen(args, time.Now(), loc)
+	if !ok {
+		return Ephemeral(room.ID, "Uso: "+builtins[name].usage+" (quando: 30m, 2h, 09:00, amanhã 09:00, 2026-10-20 09:00)"), nil
+	}
+
+	item, err := create(ScheduleSpec{Content: text, When: when})
+	var perr *protocol.Error
+	if errors.As(err, &perr) {
+		return Ephemeral(room.ID, perr.Message), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	what := "Mensagem agendada"
+	if item.Kind == models.ScheduledKindReminder {
+		what = "Lembrete criado"
+	}
+	return Ephemeral(room.ID, what+" para "+item.SendAt.Format("02/01 15:04")+" ("+item.Timezone+")"), nil
+}
+
+func (p *Pipeline) cmdSchedule(ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error) {
+	return p.scheduledCommand(ctx, sender, room, "schedule", args, func(spec ScheduleSpec) (*models.ScheduledMessage, error) {
+		return p.Schedule(ctx, sender, room.ID, spec)
+	})
+}
+
+func (p *Pipeline) cmdRemind(ctx context.Context, sender Sender, room *models.Room, args string) (*models.Message, error) {
+	return p.scheduledCommand(ctx, sender, room, "remind", args, func(spec ScheduleSpec) (*models.ScheduledMessage, error) {
+		return p.Remind(ctx, sender, room.ID, "", spec)
+	})
+}
@@ -0,0 +1,349 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/ratelimit"
+)
+
+// fakeSchedules é a fila de agendamentos em memória. Claim, Complete e
+// Retry seguem o ScheduledRepository: a reserva dura lease, e um item cuja
+// reserva venceu com a mensagem já gravada sob o ID dele é dado como
+// enviado em vez de reservado de novo. Os métodos não usados aqui ficam
+// com a interface embutida e entram em pânico se chamados.
+type fakeSchedules struct {
+	ScheduleStore
+
+	mu          sync.Mutex
+	items       map[string]*models.ScheduledMessage
+	leases      map[string]time.Time
+	messageRoom map[string]string
+	written     func(id string) bool
+	failUpdate  error
+}
+
+func newFakeSchedules(tp *testPipeline) *fakeSchedules {
+	return &fakeSchedules{
+		items:       make(map[string]*models.ScheduledMessage),
+		leases:      make(map[string]time.Time),
+		messageRoom: make(map[string]string),
+		written: func(id string) bool {
+			for _, m := range tp.stored() {
+				if m.ID == id {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+func (f *fakeSchedules) Create(ctx context.Context, s *models.ScheduledMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s.ID = uuid.New().String()
+	s.Status = models.ScheduledPending
+	item := *s
+	f.items[s.ID] = &item
+	return nil
+}
+
+func (f *fakeSchedules) CountPending(ctx context.Context, userID string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, item := range f.items {
+		if item.UserID == userID && item.Status == models.ScheduledPending {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeSchedules) Claim(ctx context.Context, limit int, lease time.Duration) ([]models.ScheduledMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for id, until := range f.leases {
+		item := f.items[id]
+		if until.Before(now) && item.Status == models.ScheduledPending && item.Kind == models.ScheduledKindMessage && f.written(id) {
+			item.Status = models.ScheduledSent
+			delete(f.leases, id)
+		}
+	}
+
+	var items []models.ScheduledMessage
+	for id, item := range f.items {
+		until, leased := f.leases[id]
+		if item.Status != models.ScheduledPending || item.SendAt.After(now) || (leased && !until.Before(now)) {
+			continue
+		}
+		if len(items) < limit {
+			f.leases[id] = now.Add(lease)
+			items = append(items, *item)
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeSchedules) Complete(ctx context.Context, id, status, lastError string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failUpdate != nil {
+		return f.failUpdate
+	}
+	f.items[id].Status, f.items[id].LastError = status, lastError
+	delete(f.leases, id)
+	return nil
+}
+
+func (f *fakeSchedules) Retry(ctx context.Context, id string, at time.Time, lastError string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failUpdate != nil {
+		return f.failUpdate
+	}
+	item := f.items[id]
+	item.SendAt, item.LastError = at, lastError
+	if lastError != "" {
+		item.Attempts++
+	}
+	delete(f.leases, id)
+	return nil
+}
+
+func (f *fakeSchedules) MessageRoom(ctx context.Context, messageID string) (string, error) {
+	return f.messageRoom[messageID], nil
+}
+
+// add enfileira uma mensagem de userID na sala, vencida há sendAgo.
+func (f *fakeSchedules) add(userID, roomID, content string, sendAgo time.Duration) string {
+	item := &models.ScheduledMessage{UserID: userID, RoomID: roomID, Kind: models.ScheduledKindMessage, Content: content, SendAt: time.Now().Add(-sendAgo)}
+	f.Create(context.Background(), item)
+	return item.ID
+}
+
+func (f *fakeSchedules) get(id string) models.ScheduledMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return *f.items[id]
+}
+
+// due adianta o item para agora, como se o adiamento tivesse passado.
+func (f *fakeSchedules) due(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[id].SendAt = time.Now().Add(-time.Second)
+}
+
+// expireLeases simula a réplica que reservou e caiu sem concluir.
+func (f *fakeSchedules) expireLeases() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id := range f.leases {
+		f.leases[id] = time.Now().Add(-time.Second)
+	}
+}
+
+type fakeScheduleUsers map[string]*models.User
+
+func (f fakeScheduleUsers) GetByID(ctx context.Context, id string) (*models.User, error) {
+	return f[id], nil
+}
+
+func newSchedulerPipeline(t *testing.T) (*testPipeline, *fakeSchedules, *fakeTargeted) {
+	t.Helper()
+	tp := newTestPipeline(t)
+	store := newFakeSchedules(tp)
+	tp.SetScheduler(store, fakeScheduleUsers{
+		"alice": {ID: "alice", Username: "alice"},
+		"bob":   {ID: "bob", Username: "bob"},
+	})
+	targeted := &fakeTargeted{}
+	tp.SetTargeted(targeted)
+	return tp, store, targeted
+}
+
+func TestParseWhen(t *testing.T) {
+	loc, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Skip("tzdata indisponível")
+	}
+	now := time.Date(2026, 10, 19, 14, 30, 0, 0, loc)
+
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2h", now.Add(2 * time.Hour)},
+		{"09:00", time.Date(2026, 10, 20, 9, 0, 0, 0, loc)},
+		{"18:00", time.Date(2026, 10, 19, 18, 0, 0, 0, loc)},
+		{"amanhã 09:00", time.Date(2026, 10, 20, 9, 0, 0, 0, loc)},
+		{"2026-12-24 20:00", time.Date(2026, 12, 24, 20, 0, 0, 0, loc)},
+		{"2026-12-24T20:00", time.Date(2026, 12, 24, 20, 0, 0, 0, loc)},
+		{"2026-12-24T20:00:00Z", time.Date(2026, 12, 24, 20, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseWhen(tt.in, now, loc)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseWhen(%q) = %v, %v; esperado %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "depois", "-1h", "25:00", "ontem 09:00"} {
+		if _, err := ParseWhen(in, now, loc); err == nil {
+			t.Errorf("ParseWhen(%q) deveria falhar", in)
+		}
+	}
+}
+
+func TestDeliverDueRespectsLease(t *testing.T) {
+	tp, store, _ := newSchedulerPipeline(t)
+	ctx := context.Background()
+
+	first := store.add("alice", generalRoom, "primeira", 2*time.Minute)
+	second := store.add("bob", groupRoom, "segunda", time.Minute)
+	later := store.add("alice", generalRoom, "depois", -time.Hour)
+
+	// Outra réplica reservou um item: ele fica de fora até o lease vencer.
+	claimed, err := store.Claim(ctx, 1, scheduleLease)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("Claim = %v, %v", claimed, err)
+	}
+	other := first
+	if claimed[0].ID == first {
+		other = second
+	}
+	if n, err := tp.DeliverDue(ctx); n != 1 || err != nil {
+		t.Fatalf("DeliverDue = %d, %v", n, err)
+	}
+	if stored := tp.stored(); len(stored) != 1 || stored[0].ID != other {
+		t.Fatalf("gravadas = %+v, quer só %s", stored, other)
+	}
+	if n, _ := tp.DeliverDue(ctx); n != 0 {
+		t.Fatalf("item reservado entregue de novo: %d", n)
+	}
+
+	// A réplica caiu antes de gravar: vencido o lease, o item volta à fila.
+	store.expireLeases()
+	if n, err := tp.DeliverDue(ctx); n != 1 || err != nil {
+		t.Fatalf("DeliverDue depois do lease = %d, %v", n, err)
+	}
+	for _, id := range []string{first, second} {
+		if got := store.get(id); got.Status != models.ScheduledSent {
+			t.Errorf("%s: status = %q", id, got.Status)
+		}
+	}
+	if got := store.get(later); got.Status != models.ScheduledPending {
+		t.Errorf("item futuro: status = %q", got.Status)
+	}
+
+	sent := tp.sent()
+	if len(sent) != 2 || sent[1].ID != claimed[0].ID || sent[1].Username != claimed[0].UserID {
+		t.Fatalf("transmitidas = %+v", sent)
+	}
+}
+
+func TestScheduledRetryBackoff(t *testing.T) {
+	tp, store, targeted := newSchedulerPipeline(t)
+	ctx := context.Background()
+	tp.messages.fail = errors.New("banco indisponível")
+
+	id := store.add("alice", generalRoom, "bom dia", time.Second)
+	for attempt := 1; attempt < scheduleMaxAttempts; attempt++ {
+		before := time.Now()
+		tp.DeliverDue(ctx)
+		after := time.Now()
+
+		got := store.get(id)
+		wait := time.Duration(attempt) * scheduleRetryBackoff
+		if got.Status != models.ScheduledPending || got.Attempts != attempt || got.LastError != "banco indisponível" {
+			t.Fatalf("tentativa %d: %+v", attempt, got)
+		}
+		if got.SendAt.Before(before.Add(wait)) || got.SendAt.After(after.Add(wait)) {
+			t.Fatalf("tentativa %d: nova hora %v, quer daqui a %v", attempt, got.SendAt, wait)
+		}
+		store.due(id)
+	}
+	if len(targeted.toUsers) != 0 {
+		t.Fatalf("aviso antes da última tentativa: %+v", targeted.toUsers)
+	}
+
+	// A última tentativa desiste e avisa o autor.
+	tp.DeliverDue(ctx)
+	if got := store.get(id); got.Status != models.ScheduledFailed || got.LastError != "banco indisponível" {
+		t.Fatalf("depois da última tentativa: %+v", got)
+	}
+	if len(targeted.toUsers) != 1 || !strings.HasPrefix(targeted.toUsers[0].Content, "Sua mensagem agendada não foi enviada") {
+		t.Fatalf("avisos = %+v", targeted.toUsers)
+	}
+	if len(tp.sent()) != 0 {
+		t.Fatalf("transmitidas = %+v", tp.sent())
+	}
+}
+
+func TestScheduledAlreadyWritten(t *testing.T) {
+	tp, store, _ := newSchedulerPipeline(t)
+	ctx := context.Background()
+
+	// A mensagem é gravada sob o ID do agendamento, mas a réplica perde a
+	// conexão antes de concluir: o item segue pendente e reservado.
+	id := store.add("alice", generalRoom, "bom dia", time.Second)
+	store.failUpdate = errors.New("conexão perdida")
+	if n, _ := tp.DeliverDue(ctx); n != 1 {
+		t.Fatalf("DeliverDue = %d", n)
+	}
+	if stored := tp.stored(); len(stored) != 1 || stored[0].ID != id {
+		t.Fatalf("gravadas = %+v", stored)
+	}
+	store.failUpdate = nil
+	if got := store.get(id); got.Status != models.ScheduledPending {
+		t.Fatalf("status = %q", got.Status)
+	}
+
+	// Vencido o lease, o item não é entregue de novo: já consta como
+	// enviado.
+	store.expireLeases()
+	if n, err := tp.DeliverDue(ctx); n != 0 || err != nil {
+		t.Fatalf("DeliverDue depois do lease = %d, %v", n, err)
+	}
+	if got := store.get(id); got.Status != models.ScheduledSent {
+		t.Errorf("status = %q, quer sent", got.Status)
+	}
+	if len(tp.stored()) != 1 || len(tp.sent()) != 1 {
+		t.Errorf("mensagem duplicada: %d gravadas, %d transmitidas", len(tp.stored()), len(tp.sent()))
+	}
+}
+
+func TestScheduledSlowModePostpones(t *testing.T) {
+	tp, store, targeted := newSchedulerPipeline(t)
+	ctx := context.Background()
+	tp.SetFloodGuard(ratelimit.NewFloodGuard(ratelimit.FloodConfig{}))
+	tp.rooms.rooms[generalRoom].SlowModeSeconds = 60
+
+	if _, err := tp.Send(ctx, alice, generalRoom, "oi"); err != nil {
+		t.Fatal(err)
+	}
+
+	// O modo lento só adia: sem contar tentativa, sem erro e sem aviso.
+	id := store.add("alice", generalRoom, "bom dia", time.Second)
+	before := time.Now()
+	tp.DeliverDue(ctx)
+
+	got := store.get(id)
+	if got.Status != models.ScheduledPending || got.Attempts != 0 || got.LastError != "" {
+		t.Fatalf("agendamento = %+v", got)
+	}
+	if wait := got.SendAt.Sub(before); wait < 59*time.Second || wait > time.Minute {
+		t.Errorf("adiado por %v, quer o que falta do modo lento", wait)
+	}
+	if len(tp.stored()) != 1 || len(targeted.toUsers) != 0 {
+		t.Errorf("gravadas = %+v, avisos = %+v", tp.stored(), targeted.toUsers)
+	}
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Escopos de um token de API.
+const (
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+	ScopeRealtime      = "realtime"
+)
+
+var APITokenScopes = []string{ScopeMessagesRead, ScopeMessagesWrite, ScopeRealtime}
+
+// APIToken é um token de longa duração de um usuário ou bot. O segredo só é
+// mostrado na criação; depois só o Prefix identifica o token.
+type APIToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"userId"`
+	Username   string     `json:"username"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	RoomIDs    []string   `json:"roomIds"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// AllowsRoom: sem salas definidas o token vale para todas as salas que o
+// usuário acessa.
+func (t *APIToken) AllowsRoom(roomID string) bool {
+	return len(t.RoomIDs) == 0 || slices.Contains(t.RoomIDs, roomID)
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// BotCommand é um comando de barra registrado por um bot. Com URL, a
+// invocação é um POST assinado para ela; sem URL, vai pelo WebSocket do bot.
+type BotCommand struct {
+	ID          string    `json:"id"`
+	BotID       string    `json:"botId"`
+	BotUsername string    `json:"botUsername"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Secret      string    `json:"-"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Tipos de evento entregues aos webhooks de saída.
+const (
+	EventMessageCreated = "message.created"
+	EventMessageEdited  = "message.edited"
+	EventMessageDeleted = "message.deleted"
+	EventMessageExpired = "message.expired"
+	EventMemberJoined   = "member.joined"
+	EventMemberLeft     = "member.left"
+	EventRoomCreated    = "room.created"
+)
+
+var EventTypes = []string{
+	EventMessageCreated, EventMessageEdited, EventMessageDeleted, EventMessageExpired,
+	EventMemberJoined, EventMemberLeft, EventRoomCreated,
+}
+
+// Event é o corpo enviado aos webhooks de saída. Data depende do tipo:
+// Message para mensagens, {"roomId", "ids"} para message.expired, MemberEvent para membros e Room para salas.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	RoomID    string    `json:"roomId"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+type MemberEvent struct {
+	RoomID   string `json:"roomId"`
+	UserID   string `json:"userId"`
+	Username string `json:"username,omitempty"`
+	// ActorID é quem adicionou ou removeu; igual a UserID quando o próprio
+	// usuário saiu.
+	ActorID string `json:"actorId,omitempty"`
+}
+
+// OutgoingWebhook assina eventos de uma sala (ou de todas, quando RoomID é
+// vazio, o que só administradores podem criar). O segredo assina o corpo
+// com HMAC-SHA256 e só aparece na criação.
+type OutgoingWebhook struct {
+	ID        string    `json:"id"`
+	RoomID    string    `json:"roomId,omitempty"`
+	CreatedBy string    `json:"createdBy"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Estados de uma entrega. "dead" é a fila de mensagens mortas: esgotou as
+// tentativas e só volta com um retry manual.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      string          `json:"webhookId"`
+	EventID        string          `json:"eventId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt"`
+	LastError      string          `json:"lastError,omitempty"`
+	ResponseStatus int             `json:"responseStatus,omitempty"`
+	DeliveredAt    *time.Time      `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+// WebhookAttempt é o log de cada tentativa de entrega.
+type WebhookAttempt struct {
+	ID          int64     `json:"id"`
+	DeliveryID  int64     `json:"deliveryId"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int       `json:"durationMs"`
+	AttemptedAt time.Time `json:"attemptedAt"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Formatos de exportação.
+const (
+	ExportJSONL = "jsonl"
+	ExportHTML  = "html"
+	ExportText  = "txt"
+)
+
+var ExportFormats = []string{ExportJSONL, ExportHTML, ExportText}
+
+func ValidExportFormat(format string) bool {
+	return slices.Contains(ExportFormats, format)
+}
+
+// Situações de uma exportação.
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// Export é o pedido de exportação do histórico de uma sala. O arquivo é
+// gerado em segundo plano e fica disponível para download até ExpiresAt.
+type Export struct {
+	ID       string `json:"id"`
+	UserID   string `json:"userId"`
+	RoomID   string `json:"roomId"`
+	Format   string `json:"format"`
+	Timezone string `json:"timezone"`
+	Status   string `json:"status"`
+	// File é o nome do arquivo no diretório de exportações.
+	File       string     `json:"-"`
+	Size       int64      `json:"size,omitempty"`
+	Messages   int        `json:"messages,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	// DownloadURL só vem quando o arquivo está pronto.
+	DownloadURL string `json:"downloadUrl,omitempty"`
+}
+
+// ExportRoom descreve a sala no cabeçalho do arquivo.
+type ExportRoom struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Members []string `json:"members,omitempty"`
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Esquemas de cifra aceitos em Message.Encryption. O servidor não decifra
+// nada: o marcador só diz ao cliente como ler Content.
+const (
+	// EncryptionSignalV1: X3DH + Double Ratchet por dispositivo; Content
+	// leva as cifras de cada dispositivo destinatário.
+	EncryptionSignalV1 = "signal.v1"
+	// EncryptionMegolmV1: sessão de grupo compartilhada pelos dispositivos
+	// da sala.
+	EncryptionMegolmV1 = "megolm.v1"
+)
+
+var EncryptionSchemes = []string{EncryptionSignalV1, EncryptionMegolmV1}
+
+func ValidEncryption(scheme string) bool {
+	return slices.Contains(EncryptionSchemes, scheme)
+}
+
+// PreKey é uma chave pública assinada ou de uso único, em base64.
+type PreKey struct {
+	KeyID     int64  `json:"keyId"`
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// DeviceKeys é a entrada de um dispositivo no diretório de chaves. Só
+// chaves públicas passam pelo servidor.
+type DeviceKeys struct {
+	UserID       string    `json:"userId"`
+	DeviceID     string    `json:"deviceId"`
+	IdentityKey  string    `json:"identityKey"`
+	SignedPreKey PreKey    `json:"signedPreKey"`
+	OneTimeKeys  int       `json:"oneTimeKeys"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// KeyUpload é o corpo de PUT /api/keys/devices/{deviceId}. As chaves de uso
+// único se somam às que o dispositivo ainda tem.
+type KeyUpload struct {
+	IdentityKey  string   `json:"identityKey"`
+	SignedPreKey PreKey   `json:"signedPreKey"`
+	OneTimeKeys  []PreKey `json:"oneTimeKeys"`
+}
+
+// KeyBundle é o que um cliente precisa para abrir uma sessão com um
+// dispositivo. OneTimeKey só vem ao reivindicar e some do servidor.
+type KeyBundle struct {
+	UserID       string  `json:"userId"`
+	DeviceID     string  `json:"deviceId"`
+	IdentityKey  string  `json:"identityKey"`
+	SignedPreKey PreKey  `json:"signedPreKey"`
+	OneTimeKey   *PreKey `json:"oneTimeKey,omitempty"`
+}
@@ -11,4 +11,53 @@ type Message struct {
 	Timestamp   time.Time `json:"timestamp"`
 	Type        string    `json:"type"`
 	OnlineCount int       `json:"onlineCount,omitempty"`
+	// Bot marca mensagens de bots e integrações (webhooks), que a interface
+	// destaca para não serem confundidas com as de um usuário.
+	Bot         bool         `json:"bot,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	EditedAt    *time.Time   `json:"editedAt,omitempty"`
+	// Poll acompanha mensagens "poll" e os eventos "poll_updated".
+	Poll *Poll `json:"poll,omitempty"`
+	// Encryption é o esquema de cifra (models.EncryptionSchemes) das
+	// mensagens de salas criptografadas; nelas Content é texto cifrado
+	// opaco, que o servidor só guarda e repassa.
+	Encryption string `json:"encryption,omitempty"`
+	// Previews chega depois, no evento "message_preview", e no histórico.
+	Previews []LinkPreview `json:"previews,omitempty"`
+}
+
+// Encrypted diz se o conteúdo é cifrado e, portanto, não serve para busca,
+// comandos ou prévias de links.
+func (m *Message) Encrypted() bool {
+	return m.Encryption != ""
+}
+
+// Attachment é um bloco de conteúdo rico enviado por integrações, no
+// formato dos attachments do Slack.
+type Attachment struct {
+	Title    string            `json:"title,omitempty"`
+	URL      string            `json:"url,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	Color    string            `json:"color,omitempty"`
+	ImageURL string            `json:"imageUrl,omitempty"`
+	Fields   []AttachmentField `json:"fields,omitempty"`
+}
+
+type AttachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// IncomingWebhook publica mensagens numa sala. O segredo vai na URL e só o
+// hash fica no banco; Username e AvatarURL são os padrões quando o payload
+// não os informa.
+type IncomingWebhook struct {
+	ID         string     `json:"id"`
+	RoomID     string     `json:"roomId"`
+	CreatedBy  string     `json:"createdBy"`
+	Name       string     `json:"name"`
+	Username   string     `json:"username"`
+	AvatarURL  string     `json:"avatarUrl,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
 }
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Poll é uma enquete publicada como mensagem do tipo "poll". Pergunta,
+// opções e votos ficam em tabelas próprias; o conteúdo da mensagem guarda só
+// a pergunta, para históricos e buscas.
+type Poll struct {
+	ID        string       `json:"id"`
+	MessageID string       `json:"messageId"`
+	RoomID    string       `json:"roomId"`
+	CreatedBy string       `json:"createdBy,omitempty"`
+	Question  string       `json:"question"`
+	Multiple  bool         `json:"multiple"`
+	Anonymous bool         `json:"anonymous"`
+	ClosesAt  *time.Time   `json:"closesAt,omitempty"`
+	ClosedAt  *time.Time   `json:"closedAt,omitempty"`
+	Options   []PollOption `json:"options"`
+	// Voters conta quem votou, não os votos: em enquetes de múltipla
+	// escolha a soma das opções pode passar dele.
+	Voters int `json:"voters"`
+	// MyVotes são as opções escolhidas por quem consulta; não vai nos
+	// eventos da sala.
+	MyVotes   []int64   `json:"myVotes,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type PollOption struct {
+	ID    int64  `json:"id"`
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+	// VotedBy só é preenchido em enquetes públicas.
+	VotedBy []PollVoter `json:"votedBy,omitempty"`
+}
+
+type PollVoter struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+}
+
+// Closed diz se a enquete já não aceita votos.
+func (p *Poll) Closed(now time.Time) bool {
+	return p.ClosedAt != nil || (p.ClosesAt != nil && !now.Before(*p.ClosesAt))
+}
@@ -0,0 +1,11 @@
+package models
+
+// LinkPreview é a prévia de um link de uma mensagem, montada com os
+// metadados OpenGraph ou oEmbed da página.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"imageUrl,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+}
@@ -9,6 +9,14 @@ type Room struct {
 	Users     []string  `json:"users"`
 	CreatedBy string    `json:"createdBy,omitempty"`
 	CreatedAt time.Time `json:"createdAt"`
+	// SlowModeSeconds é o intervalo mínimo entre mensagens de um mesmo
+	// usuário na sala; zero desativa.
+	SlowModeSeconds int `json:"slowModeSeconds"`
+	// Topic é definido com /topic e exibido no cabeçalho da sala.
+	Topic string `json:"topic,omitempty"`
+	// Encrypted liga a criptografia de ponta a ponta (só conversas
+	// privadas); depois de ligada não volta atrás.
+	Encrypted bool `json:"encrypted,omitempty"`
 }
 
 type RoomUser struct {
@@ -21,3 +29,21 @@ type CreateGroupRequest struct {
 	Name    string   `json:"name"`
 	UserIDs []string `json:"userIds"` // IDs dos usuários a adicionar (mínimo 2)
 }
+
+type RoomSettings struct {
+	RoomType        string `json:"roomType"`
+	PresenceEvents  bool   `json:"presenceEvents"`
+	PersistPresence bool   `json:"persistPresence"`
+	SlowModeSeconds int    `json:"slowModeSeconds"`
+	// RetentionDays apaga as mensagens depois de N dias; zero segue o
+	// padrão do servidor.
+	RetentionDays int `json:"retentionDays"`
+	// DisappearingHours (só conversas privadas) apaga as mensagens depois
+	// de N horas e tem precedência sobre RetentionDays.
+	DisappearingHours int `json:"disappearingHours"`
+	// Encrypted: a sala só aceita mensagens cifradas pelos clientes.
+	Encrypted bool `json:"encrypted"`
+	// DefaultRetentionDays é o padrão do servidor (MESSAGE_RETENTION_DAYS),
+	// só informativo; zero guarda para sempre.
+	DefaultRetentionDays int `json:"defaultRetentionDays"`
+}
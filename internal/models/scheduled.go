@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Tipos de agendamento.
+const (
+	ScheduledKindMessage  = "message"
+	ScheduledKindReminder = "reminder"
+)
+
+// Situações de um agendamento.
+const (
+	ScheduledPending  = "pending"
+	ScheduledSent     = "sent"
+	ScheduledFailed   = "failed"
+	ScheduledCanceled = "canceled"
+)
+
+// ScheduledMessage é uma mensagem a publicar mais tarde ou um lembrete, que
+// só o próprio usuário recebe. Lembretes podem apontar para uma mensagem
+// (MessageID); Message traz o trecho dela na listagem.
+type ScheduledMessage struct {
+	ID        string   `json:"id"`
+	UserID    string   `json:"userId"`
+	RoomID    string   `json:"roomId"`
+	Kind      string   `json:"kind"`
+	Content   string   `json:"content"`
+	MessageID string   `json:"messageId,omitempty"`
+	Message   *Message `json:"message,omitempty"`
+	// SendAt vai no fuso de Timezone, o do usuário quando agendou.
+	SendAt    time.Time  `json:"sendAt"`
+	Timezone  string     `json:"timezone"`
+	Status    string     `json:"status"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"lastError,omitempty"`
+	SentAt    *time.Time `json:"sentAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// Localize passa SendAt para o fuso do agendamento, para que o JSON mostre
+// o horário que o usuário escolheu.
+func (s *ScheduledMessage) Localize() {
+	if loc, err := time.LoadLocation(s.Timezone); err == nil {
+		s.SendAt = s.SendAt.In(loc)
+	}
+}
@@ -3,15 +3,19 @@ package models
 import "time"
 
 type User struct {
-	ID           string     `json:"id"`
-	Username     string     `json:"username"`
-	Email        string     `json:"email"`
-	PasswordHash string     `json:"-"`
-	GoogleID     *string    `json:"googleId,omitempty"`
-	AvatarURL    *string    `json:"avatarUrl,omitempty"`
-	IsOnline     bool       `json:"isOnline"`
-	LastSeen     *time.Time `json:"lastSeen,omitempty"`
-	CreatedAt    time.Time  `json:"createdAt"`
+	ID            string     `json:"id"`
+	Username      string     `json:"username"`
+	Email         string     `json:"email"`
+	PasswordHash  string     `json:"-"`
+	AvatarURL     *string    `json:"avatarUrl,omitempty"`
+	IsOnline      bool       `json:"isOnline"`
+	EmailVerified bool       `json:"emailVerified"`
+	TOTPEnabled   bool       `json:"totpEnabled"`
+	HasPassword   bool       `json:"hasPassword"`
+	IsBot         bool       `json:"isBot"`
+	Timezone      string     `json:"timezone,omitempty"`
+	LastSeen      *time.Time `json:"lastSeen,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
 }
 
 type RegisterRequest struct {
@@ -29,3 +33,22 @@ type AuthResponse struct {
 	Token string `json:"token"`
 	User  User   `json:"user"`
 }
+
+// MFAChallenge é a resposta do login quando a conta tem 2FA: o MFAToken só
+// serve para concluir o login com o código.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfaRequired"`
+	MFAToken    string `json:"mfaToken"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+}
+
+// Identity é um login externo (OIDC/OAuth2) vinculado à conta.
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,125 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/safehttp"
+)
+
+const (
+	maxRedirects    = 3
+	maxOEmbedBytes  = 64 << 10
+	maxHeaderBytes  = 64 << 10
+	defaultAgent    = "ChatLinkPreview/1.0 (+https://github.com/lucaspanzera1/chat)"
+	htmlAcceptValue = "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1"
+)
+
+var (
+	// ErrBlocked é a tentativa de acessar um endereço interno.
+	ErrBlocked = safehttp.ErrBlocked
+	// ErrNoMetadata é uma página sem título nem imagem para mostrar.
+	ErrNoMetadata = errors.New("página sem metadados para prévia")
+)
+
+// fetcher baixa páginas com o cliente restrito de safehttp, que confere o
+// endereço na hora de conectar, inclusive nos redirecionamentos.
+type fetcher struct {
+	client    *http.Client
+	maxBytes  int64
+	userAgent string
+}
+
+func newFetcher(cfg Config, allow func(netip.Addr) bool) *fetcher {
+	return &fetcher{
+		client: safehttp.NewClient(safehttp.Options{
+			Timeout:        cfg.Timeout,
+			MaxRedirects:   maxRedirects,
+			MaxHeaderBytes: maxHeaderBytes,
+			Allow:          allow,
+		}),
+		maxBytes:  cfg.MaxBytes,
+		userAgent: cfg.UserAgent,
+	}
+}
+
+// Fetch monta a prévia de um link: OpenGraph e Twitter Cards do HTML,
+// completados pelo oEmbed que a página anunciar. Um link direto para uma
+// imagem vira uma prévia só com a imagem.
+func (f *fetcher) Fetch(ctx context.Context, rawURL string) (*models.LinkPreview, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return nil, fmt.Errorf("%w: url inválida", ErrBlocked)
+	}
+
+	resp, err := f.get(ctx, u.String(), htmlAcceptValue)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	final := resp.Request.URL
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return &models.LinkPreview{URL: rawURL, ImageURL: final.String()}, nil
+	case mediaType != "text/html" && mediaType != "application/xhtml+xml":
+		return nil, ErrNoMetadata
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes))
+	if err != nil {
+		return nil, err
+	}
+	p := parseHTML(body, final)
+	if p.oembed != "" && (p.preview.Title == "" || p.preview.ImageURL == "") {
+		// O oEmbed só completa: se falhar, vale o que veio do HTML.
+		if data, err := f.read(ctx, p.oembed, "application/json", maxOEmbedBytes); err == nil {
+			mergeOEmbed(&p.preview, data, final)
+		}
+	}
+	if p.preview.Title == "" && p.preview.ImageURL == "" {
+		return nil, ErrNoMetadata
+	}
+	p.preview.URL = rawURL
+	if p.preview.SiteName == "" {
+		p.preview.SiteName = final.Hostname()
+	}
+	return &p.preview, nil
+}
+
+func (f *fetcher) get(ctx context.Context, rawURL, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	req.Header.Set("Accept", accept)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (f *fetcher) read(ctx context.Context, rawURL, accept string, limit int64) ([]byte, error) {
+	resp, err := f.get(ctx, rawURL, accept)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, limit))
+}
@@ -0,0 +1,187 @@
+package preview
+
+import (
+	"encoding/json"
+	"html"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// MaxLinks é quantos links de uma mensagem ganham prévia.
+const MaxLinks = 3
+
+const (
+	maxURLLength   = 2048
+	maxTitle       = 300
+	maxDescription = 500
+)
+
+var (
+	urlPattern   = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+	tagPattern   = regexp.MustCompile(`(?is)<(meta|link)\b([^>]*)>`)
+	attrPattern  = regexp.MustCompile(`(?s)([a-zA-Z_:.-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	headEnd      = regexp.MustCompile(`(?i)</head\s*>`)
+	spaces       = regexp.MustCompile(`\s+`)
+)
+
+// URLs devolve os links http(s) do texto, sem repetição e na ordem em que
+// aparecem, até MaxLinks.
+func URLs(text string) []string {
+	var urls []string
+	for _, raw := range urlPattern.FindAllString(text, -1) {
+		// Pontuação no fim costuma ser da frase, não do link.
+		raw = strings.TrimRight(raw, ".,;:!?)]}*_~")
+		if len(raw) > maxURLLength {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" || u.User != nil {
+			continue
+		}
+		if !slices.Contains(urls, raw) {
+			urls = append(urls, raw)
+		}
+		if len(urls) == MaxLinks {
+			break
+		}
+	}
+	return urls
+}
+
+// page é o que interessa do HTML: a prévia e o endereço oEmbed anunciado.
+type page struct {
+	preview models.LinkPreview
+	oembed  string
+}
+
+// parseHTML lê as tags <meta> e <link> do <head>. Não é um parser de HTML
+// completo, só o bastante para OpenGraph, Twitter Cards e oEmbed; o corpo
+// já chega truncado em Config.MaxBytes.
+func parseHTML(body []byte, base *url.URL) page {
+	doc := string(body)
+	if loc := headEnd.FindStringIndex(doc); loc != nil {
+		doc = doc[:loc[0]]
+	}
+
+	meta := make(map[string]string)
+	var p page
+	for _, tag := range tagPattern.FindAllStringSubmatch(doc, -1) {
+		attrs := make(map[string]string)
+		for _, a := range attrPattern.FindAllStringSubmatch(tag[2], -1) {
+			attrs[strings.ToLower(a[1])] = a[2] + a[3] + a[4]
+		}
+
+		if strings.EqualFold(tag[1], "link") {
+			if strings.EqualFold(attrs["type"], "application/json+oembed") && p.oembed == "" {
+				p.oembed = resolve(base, attrs["href"])
+			}
+			continue
+		}
+		key := strings.ToLower(attrs["property"])
+		if key == "" {
+			key = strings.ToLower(attrs["name"])
+		}
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = text(attrs["content"])
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := meta[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	p.preview = models.LinkPreview{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		ImageURL:    resolve(base, first("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src")),
+		SiteName:    first("og:site_name", "application-name"),
+	}
+	if p.preview.Title == "" {
+		if m := titlePattern.FindStringSubmatch(doc); m != nil {
+			p.preview.Title = text(m[1])
+		}
+	}
+	p.preview.Title = truncate(p.preview.Title, maxTitle)
+	p.preview.Description = truncate(p.preview.Description, maxDescription)
+	return p
+}
+
+// oembedResponse traz os campos usados do oEmbed. O html (iframes e
+// scripts) nunca é repassado ao cliente.
+type oembedResponse struct {
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	URL          string `json:"url"`
+}
+
+// mergeOEmbed completa a prévia com o que faltou no HTML.
+func mergeOEmbed(p *models.LinkPreview, data []byte, base *url.URL) error {
+	var o oembedResponse
+	if err := json.Unmarshal(data, &o); err != nil {
+		return err
+	}
+	if p.Title == "" {
+		p.Title = truncate(text(o.Title), maxTitle)
+	}
+	if p.Description == "" && o.AuthorName != "" {
+		p.Description = truncate(text(o.AuthorName), maxDescription)
+	}
+	if p.SiteName == "" {
+		p.SiteName = text(o.ProviderName)
+	}
+	if p.ImageURL == "" {
+		image := o.ThumbnailURL
+		if o.Type == "photo" && o.URL != "" {
+			image = o.URL
+		}
+		p.ImageURL = resolve(base, image)
+	}
+	return nil
+}
+
+// resolve torna ref absoluto em relação à página; só http(s) é aceito.
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(html.UnescapeString(ref))
+	if ref == "" || len(ref) > maxURLLength {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+// text desfaz as entidades e junta os espaços. Páginas que não são UTF-8
+// são lidas como Latin-1, o caso mais comum entre as antigas.
+func text(s string) string {
+	if !utf8.ValidString(s) {
+		runes := make([]rune, len(s))
+		for i := 0; i < len(s); i++ {
+			runes[i] = rune(s[i])
+		}
+		s = string(runes)
+	}
+	return strings.TrimSpace(spaces.ReplaceAllString(html.UnescapeString(s), " "))
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(r[:n-1])) + "…"
+}
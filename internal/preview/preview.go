@@ -0,0 +1,204 @@
+// Package preview gera as prévias dos links das mensagens em segundo plano:
+// detecta os links, busca os metadados OpenGraph/oEmbed com limites de
+// tempo e tamanho, sem acessar a rede interna, guarda o resultado em cache
+// e avisa a sala com o evento "message_preview".
+package preview
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/safehttp"
+)
+
+// Entry é uma linha de link_previews. Failed marca os links sem prévia
+// (erro, endereço bloqueado, página sem metadados), que também ficam em
+// cache para não serem buscados a cada mensagem.
+type Entry struct {
+	models.LinkPreview
+	Failed    bool
+	FetchedAt time.Time
+}
+
+// Store é o cache de prévias, por URL.
+type Store interface {
+	Lookup(ctx context.Context, urls []string) (map[string]Entry, error)
+	Save(ctx context.Context, entry Entry) error
+}
+
+type Config struct {
+	// Timeout vale para cada link, somando DNS, redirecionamentos, corpo e
+	// oEmbed.
+	Timeout time.Duration
+	// MaxBytes é quanto do HTML é lido; os metadados ficam no <head>.
+	MaxBytes   int64
+	CacheTTL   time.Duration
+	FailureTTL time.Duration
+	Workers    int
+	QueueSize  int
+	UserAgent  string
+}
+
+func (c *Config) defaults() {
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = 512 << 10
+	}
+	if c.CacheTTL <= 0 {
+		c.CacheTTL = 24 * time.Hour
+	}
+	if c.FailureTTL <= 0 {
+		c.FailureTTL = time.Hour
+	}
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 256
+	}
+	if c.UserAgent == "" {
+		c.UserAgent = defaultAgent
+	}
+}
+
+type job struct {
+	messageID string
+	roomID    string
+	urls      []string
+}
+
+// Previewer recebe as mensagens novas (Enqueue) e, em Run, busca as prévias
+// e as transmite para a sala.
+type Previewer struct {
+	store     Store
+	broadcast func(models.Message)
+	cfg       Config
+	fetcher   *fetcher
+	queue     chan job
+	now       func() time.Time
+}
+
+func NewPreviewer(store Store, broadcast func(models.Message), cfg Config) *Previewer {
+	cfg.defaults()
+	return &Previewer{
+		store:     store,
+		broadcast: broadcast,
+		cfg:       cfg,
+		fetcher:   newFetcher(cfg, safehttp.PublicAddr),
+		queue:     make(chan job, cfg.QueueSize),
+		now:       time.Now,
+	}
+}
+
+// Enqueue agenda as prévias de uma mensagem, sem bloquear o envio: com a
+// fila cheia a mensagem fica sem prévia. Mensagens cifradas são ignoradas,
+// já que o servidor não lê o conteúdo.
+func (p *Previewer) Enqueue(msg models.Message) {
+	if msg.Encrypted() || msg.Type != "message" {
+		return
+	}
+	urls := URLs(msg.Content)
+	if len(urls) == 0 {
+		return
+	}
+
+	select {
+	case p.queue <- job{messageID: msg.ID, roomID: msg.RoomID, urls: urls}:
+	default:
+		log.Printf("Fila de prévias cheia, mensagem %s sem prévia", msg.ID)
+	}
+}
+
+// Run processa a fila com Config.Workers buscas simultâneas até o contexto
+// ser cancelado.
+func (p *Previewer) Run(ctx context.Context) {
+	done := make(chan struct{})
+	for range p.cfg.Workers {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for {
+				select {
+				case j := <-p.queue:
+					p.process(ctx, j)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	for range p.cfg.Workers {
+		<-done
+	}
+}
+
+// process monta as prévias de uma mensagem, do cache ou da rede, e avisa a
+// sala se alguma deu certo.
+func (p *Previewer) process(ctx context.Context, j job) {
+	cached, err := p.store.Lookup(ctx, j.urls)
+	if err != nil {
+		log.Printf("Erro ao consultar cache de prévias: %v", err)
+	}
+
+	var previews []models.LinkPreview
+	for _, u := range j.urls {
+		entry, ok := cached[u]
+		if !ok || p.expired(entry) {
+			if entry, ok = p.fetch(ctx, u); !ok {
+				continue
+			}
+		}
+		if !entry.Failed {
+			previews = append(previews, entry.LinkPreview)
+		}
+	}
+	if len(previews) == 0 {
+		return
+	}
+
+	p.broadcast(models.Message{
+		ID:        j.messageID,
+		RoomID:    j.roomID,
+		Timestamp: p.now(),
+		Type:      "message_preview",
+		Previews:  previews,
+	})
+}
+
+func (p *Previewer) expired(e Entry) bool {
+	ttl := p.cfg.CacheTTL
+	if e.Failed {
+		ttl = p.cfg.FailureTTL
+	}
+	return p.now().Sub(e.FetchedAt) > ttl
+}
+
+// fetch busca e grava um link. ok é false só quando o servidor está
+// encerrando: aí nada vai para o cache.
+func (p *Previewer) fetch(ctx context.Context, rawURL string) (Entry, bool) {
+	fetchCtx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	entry := Entry{LinkPreview: models.LinkPreview{URL: rawURL}, FetchedAt: p.now()}
+	preview, err := p.fetcher.Fetch(fetchCtx, rawURL)
+	if ctx.Err() != nil {
+		return Entry{}, false
+	}
+	if err != nil {
+		entry.Failed = true
+		if !errors.Is(err, ErrNoMetadata) {
+			log.Printf("Prévia de %s indisponível: %v", rawURL, err)
+		}
+	} else {
+		entry.LinkPreview = *preview
+	}
+
+	if err := p.store.Save(ctx, entry); err != nil {
+		log.Printf("Erro ao gravar prévia: %v", err)
+	}
+	return entry, true
+}
@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// Request é um frame recebido já identificado; o payload é decodificado sob
+// demanda pelo mesmo codec que leu o frame.
+type Request struct {
+	Type string
+	ID   string
+
+	payload   []byte
+	unmarshal func([]byte, any) error
+}
+
+func (r Request) DecodePayload(v any) error {
+	if len(r.payload) == 0 {
+		return nil
+	}
+	return r.unmarshal(r.payload, v)
+}
+
+// Codec traduz entre frames WebSocket e o modelo interno. O hub usa Name e
+// EncodeMessage para serializar cada evento uma vez por codec.
+type Codec interface {
+	Name() string
+	FrameType() int
+	EncodeMessage(msg models.Message) ([]byte, error)
+	EncodeEnvelope(env Envelope) ([]byte, error)
+	DecodeRequest(data []byte) (Request, error)
+}
+
+// CodecFor devolve o codec do subprotocolo negociado.
+func CodecFor(subprotocol string) Codec {
+	switch subprotocol {
+	case V1:
+		return JSONCodec{}
+	case V1Msgpack:
+		return MsgpackCodec{}
+	case V1Protobuf:
+		return ProtobufCodec{}
+	default:
+		return LegacyCodec{}
+	}
+}
+
+// LegacyCodec mantém o formato anterior ao versionamento.
+type LegacyCodec struct{}
+
+func (LegacyCodec) Name() string   { return "legacy" }
+func (LegacyCodec) FrameType() int { return websocket.TextMessage }
+
+func (LegacyCodec) EncodeMessage(msg models.Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+// EncodeEnvelope só tem equivalente legado para erros; ack e pong retornam
+// nil e não são enviados.
+func (LegacyCodec) EncodeEnvelope(env Envelope) ([]byte, error) {
+	payload, ok := env.Payload.(ErrorPayload)
+	if env.Type != TypeError || !ok {
+		return nil, nil
+	}
+	return json.Marshal(struct {
+		Type         string `json:"type"`
+		Code         string `json:"code"`
+		Content      string `json:"content"`
+		RetryAfterMs int64  `json:"retryAfterMs,omitempty"`
+	}{
+		Type:         TypeError,
+		Code:         payload.Code,
+		Content:      payload.Message,
+		RetryAfterMs: payload.RetryAfterMs,
+	})
+}
+
+func (LegacyCodec) DecodeRequest(data []byte) (Request, error) {
+	var probe SendPayload
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return Request{}, err
+	}
+	return Request{Type: TypeSend, payload: data, unmarshal: json.Unmarshal}, nil
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string   { return V1 }
+func (JSONCodec) FrameType() int { return websocket.TextMessage }
+
+func (JSONCodec) EncodeMessage(msg models.Message) ([]byte, error) {
+	return json.Marshal(EventEnvelope(msg))
+}
+
+func (JSONCodec) EncodeEnvelope(env Envelope) ([]byte, error) {
+	return json.Marshal(env)
+}
+
+func (JSONCodec) DecodeRequest(data []byte) (Request, error) {
+	var raw struct {
+		Type    string          `json:"type"`
+		ID      string          `json:"id"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Request{}, err
+	}
+	return Request{Type: raw.Type, ID: raw.ID, payload: raw.Payload, unmarshal: json.Unmarshal}, nil
+}
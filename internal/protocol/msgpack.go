@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"bytes"
+
+	"github.com/gorilla/websocket"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackCodec usa o mesmo envelope do JSONCodec, com os mesmos nomes de
+// campo, serializado em MessagePack.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Name() string   { return V1Msgpack }
+func (MsgpackCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (MsgpackCodec) EncodeMessage(msg models.Message) ([]byte, error) {
+	return msgpackMarshal(EventEnvelope(msg))
+}
+
+func (MsgpackCodec) EncodeEnvelope(env Envelope) ([]byte, error) {
+	return msgpackMarshal(env)
+}
+
+func (MsgpackCodec) DecodeRequest(data []byte) (Request, error) {
+	var raw struct {
+		Type    string             `json:"type"`
+		ID      string             `json:"id"`
+		Payload msgpack.RawMessage `json:"payload"`
+	}
+	if err := msgpackUnmarshal(data, &raw); err != nil {
+		return Request{}, err
+	}
+	return Request{Type: raw.Type, ID: raw.ID, payload: raw.Payload, unmarshal: msgpackUnmarshal}, nil
+}
+
+func msgpackMarshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func msgpackUnmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
@@ -0,0 +1,494 @@
+package protocol
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Números de campo de chat.proto.
+const (
+	envType      = 1
+	envID        = 2
+	envMessage   = 3
+	envSend      = 4
+	envAck       = 5
+	envError     = 6
+	envCommand   = 7
+	envEphemeral = 8
+
+	msgID          = 1
+	msgRoomID      = 2
+	msgUsername    = 3
+	msgAvatarURL   = 4
+	msgContent     = 5
+	msgTimestamp   = 6
+	msgType        = 7
+	msgOnlineCount = 8
+	msgBot         = 9
+	msgAttachments = 10
+	msgEditedAt    = 11
+	msgPoll        = 12
+	msgEncryption  = 13
+	msgPreviews    = 14
+
+	attTitle    = 1
+	attURL      = 2
+	attText     = 3
+	attColor    = 4
+	attImageURL = 5
+	attFields   = 6
+
+	prevURL         = 1
+	prevTitle       = 2
+	prevDescription = 3
+	prevImageURL    = 4
+	prevSiteName    = 5
+
+	pollID        = 1
+	pollMessageID = 2
+	pollQuestion  = 3
+	pollMultiple  = 4
+	pollAnonymous = 5
+	pollClosesAt  = 6
+	pollClosedAt  = 7
+	pollOptions   = 8
+	pollVoters    = 9
+	pollMyVotes   = 10
+)
+
+// ProtobufCodec implementa chat.proto sem código gerado: o esquema é pequeno
+// e protowire evita depender do protoc no build.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Name() string   { return V1Protobuf }
+func (ProtobufCodec) FrameType() int { return websocket.BinaryMessage }
+
+func (ProtobufCodec) EncodeMessage(msg models.Message) ([]byte, error) {
+	return ProtobufCodec{}.EncodeEnvelope(EventEnvelope(msg))
+}
+
+func (ProtobufCodec) EncodeEnvelope(env Envelope) ([]byte, error) {
+	var b []byte
+	b = appendString(b, envType, env.Type)
+	b = appendString(b, envID, env.ID)
+
+	switch p := env.Payload.(type) {
+	case nil:
+	case models.Message:
+		b = protowire.AppendTag(b, envMessage, protowire.BytesType)
+		b = protowire.AppendBytes(b, encodeProtoMessage(p))
+	case SendPayload:
+		b = protowire.AppendTag(b, envSend, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendString(appendString(nil, 1, p.Content), 2, p.Encryption))
+	case AckPayload:
+		b = protowire.AppendTag(b, envAck, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendString(nil, 1, p.MessageID))
+	case ErrorPayload:
+		var e []byte
+		e = appendString(e, 1, p.Code)
+		e = appendString(e, 2, p.Message)
+		if p.RetryAfterMs != 0 {
+			e = protowire.AppendTag(e, 3, protowire.VarintType)
+			e = protowire.AppendVarint(e, uint64(p.RetryAfterMs))
+		}
+		b = protowire.AppendTag(b, envError, protowire.BytesType)
+		b = protowire.AppendBytes(b, e)
+	default:
+		return nil, fmt.Errorf("payload sem representação protobuf: %T", env.Payload)
+	}
+	return b, nil
+}
+
+func (ProtobufCodec) DecodeRequest(data []byte) (Request, error) {
+	req := Request{unmarshal: protobufUnmarshalPayload}
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch num {
+		case envType:
+			req.Type = string(value)
+		case envID:
+			req.ID = string(value)
+		case envSend, envCommand, envEphemeral:
+			req.payload = value
+		}
+		return nil
+	})
+	return req, err
+}
+
+func protobufUnmarshalPayload(data []byte, v any) error {
+	switch p := v.(type) {
+	case *SendPayload:
+		return walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+			switch num {
+			case 1:
+				p.Content = string(value)
+			case 2:
+				p.Encryption = string(value)
+			}
+			return nil
+		})
+	case *CommandRespondPayload:
+		return walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+			switch num {
+			case 1:
+				p.InvocationID = string(value)
+			case 2:
+				p.Text = string(value)
+			case 3:
+				v, _ := protowire.ConsumeVarint(value)
+				p.Ephemeral = v != 0
+			}
+			return nil
+		})
+	case *EphemeralSendPayload:
+		return walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+			switch num {
+			case 1:
+				p.UserIDs = append(p.UserIDs, string(value))
+			case 2:
+				p.Content = string(value)
+			}
+			return nil
+		})
+	default:
+		return fmt.Errorf("payload sem representação protobuf: %T", v)
+	}
+}
+
+func encodeProtoMessage(msg models.Message) []byte {
+	var b []byte
+	b = appendString(b, msgID, msg.ID)
+	b = appendString(b, msgRoomID, msg.RoomID)
+	b = appendString(b, msgUsername, msg.Username)
+	b = appendString(b, msgAvatarURL, msg.AvatarURL)
+	b = appendString(b, msgContent, msg.Content)
+	if !msg.Timestamp.IsZero() {
+		b = protowire.AppendTag(b, msgTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(msg.Timestamp.UnixMilli()))
+	}
+	b = appendString(b, msgType, msg.Type)
+	if msg.OnlineCount != 0 {
+		b = protowire.AppendTag(b, msgOnlineCount, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(msg.OnlineCount))
+	}
+	if msg.Bot {
+		b = protowire.AppendTag(b, msgBot, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	for _, a := range msg.Attachments {
+		var ab []byte
+		ab = appendString(ab, attTitle, a.Title)
+		ab = appendString(ab, attURL, a.URL)
+		ab = appendString(ab, attText, a.Text)
+		ab = appendString(ab, attColor, a.Color)
+		ab = appendString(ab, attImageURL, a.ImageURL)
+		for _, f := range a.Fields {
+			ab = protowire.AppendTag(ab, attFields, protowire.BytesType)
+			ab = protowire.AppendBytes(ab, appendString(appendString(nil, 1, f.Title), 2, f.Value))
+		}
+		b = protowire.AppendTag(b, msgAttachments, protowire.BytesType)
+		b = protowire.AppendBytes(b, ab)
+	}
+	if msg.EditedAt != nil {
+		b = protowire.AppendTag(b, msgEditedAt, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(msg.EditedAt.UnixMilli()))
+	}
+	if msg.Poll != nil {
+		b = protowire.AppendTag(b, msgPoll, protowire.BytesType)
+		b = protowire.AppendBytes(b, encodeProtoPoll(msg.Poll))
+	}
+	b = appendString(b, msgEncryption, msg.Encryption)
+	for _, p := range msg.Previews {
+		var pb []byte
+		pb = appendString(pb, prevURL, p.URL)
+		pb = appendString(pb, prevTitle, p.Title)
+		pb = appendString(pb, prevDescription, p.Description)
+		pb = appendString(pb, prevImageURL, p.ImageURL)
+		pb = appendString(pb, prevSiteName, p.SiteName)
+		b = protowire.AppendTag(b, msgPreviews, protowire.BytesType)
+		b = protowire.AppendBytes(b, pb)
+	}
+	return b
+}
+
+func encodeProtoPoll(p *models.Poll) []byte {
+	var b []byte
+	b = appendString(b, pollID, p.ID)
+	b = appendString(b, pollMessageID, p.MessageID)
+	b = appendString(b, pollQuestion, p.Question)
+	b = appendBool(b, pollMultiple, p.Multiple)
+	b = appendBool(b, pollAnonymous, p.Anonymous)
+	b = appendTime(b, pollClosesAt, p.ClosesAt)
+	b = appendTime(b, pollClosedAt, p.ClosedAt)
+	for _, o := range p.Options {
+		var ob []byte
+		ob = protowire.AppendTag(ob, 1, protowire.VarintType)
+		ob = protowire.AppendVarint(ob, uint64(o.ID))
+		ob = appendString(ob, 2, o.Text)
+		ob = protowire.AppendTag(ob, 3, protowire.VarintType)
+		ob = protowire.AppendVarint(ob, uint64(o.Votes))
+		for _, v := range o.VotedBy {
+			ob = protowire.AppendTag(ob, 4, protowire.BytesType)
+			ob = protowire.AppendBytes(ob, appendString(appendString(nil, 1, v.UserID), 2, v.Username))
+		}
+		b = protowire.AppendTag(b, pollOptions, protowire.BytesType)
+		b = protowire.AppendBytes(b, ob)
+	}
+	b = protowire.AppendTag(b, pollVoters, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(p.Voters))
+	for _, id := range p.MyVotes {
+		b = protowire.AppendTag(b, pollMyVotes, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(id))
+	}
+	return b
+}
+
+func decodeProtoPoll(data []byte) (*models.Poll, error) {
+	p := &models.Poll{Options: []models.PollOption{}}
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch num {
+		case pollID:
+			p.ID = string(value)
+		case pollMessageID:
+			p.MessageID = string(value)
+		case pollQuestion:
+			p.Question = string(value)
+		case pollMultiple:
+			v, _ := protowire.ConsumeVarint(value)
+			p.Multiple = v != 0
+		case pollAnonymous:
+			v, _ := protowire.ConsumeVarint(value)
+			p.Anonymous = v != 0
+		case pollClosesAt:
+			v, _ := protowire.ConsumeVarint(value)
+			t := time.UnixMilli(int64(v))
+			p.ClosesAt = &t
+		case pollClosedAt:
+			v, _ := protowire.ConsumeVarint(value)
+			t := time.UnixMilli(int64(v))
+			p.ClosedAt = &t
+		case pollOptions:
+			var o models.PollOption
+			err := walkFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				switch num {
+				case 1:
+					v, _ := protowire.ConsumeVarint(value)
+					o.ID = int64(v)
+				case 2:
+					o.Text = string(value)
+				case 3:
+					v, _ := protowire.ConsumeVarint(value)
+					o.Votes = int(v)
+				case 4:
+					var voter models.PollVoter
+					err := walkFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+						switch num {
+						case 1:
+							voter.UserID = string(value)
+						case 2:
+							voter.Username = string(value)
+						}
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+					o.VotedBy = append(o.VotedBy, voter)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			p.Options = append(p.Options, o)
+		case pollVoters:
+			v, _ := protowire.ConsumeVarint(value)
+			p.Voters = int(v)
+		case pollMyVotes:
+			v, _ := protowire.ConsumeVarint(value)
+			p.MyVotes = append(p.MyVotes, int64(v))
+		}
+		return nil
+	})
+	return p, err
+}
+
+// DecodeProtoMessage é o inverso de encodeProtoMessage, útil para clientes
+// Go e testes.
+func DecodeProtoMessage(data []byte) (models.Message, error) {
+	var msg models.Message
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch num {
+		case msgID:
+			msg.ID = string(value)
+		case msgRoomID:
+			msg.RoomID = string(value)
+		case msgUsername:
+			msg.Username = string(value)
+		case msgAvatarURL:
+			msg.AvatarURL = string(value)
+		case msgContent:
+			msg.Content = string(value)
+		case msgTimestamp:
+			v, _ := protowire.ConsumeVarint(value)
+			msg.Timestamp = time.UnixMilli(int64(v))
+		case msgType:
+			msg.Type = string(value)
+		case msgOnlineCount:
+			v, _ := protowire.ConsumeVarint(value)
+			msg.OnlineCount = int(v)
+		case msgBot:
+			v, _ := protowire.ConsumeVarint(value)
+			msg.Bot = v != 0
+		case msgAttachments:
+			a, err := decodeProtoAttachment(value)
+			if err != nil {
+				return err
+			}
+			msg.Attachments = append(msg.Attachments, a)
+		case msgEditedAt:
+			v, _ := protowire.ConsumeVarint(value)
+			t := time.UnixMilli(int64(v))
+			msg.EditedAt = &t
+		case msgPoll:
+			poll, err := decodeProtoPoll(value)
+			if err != nil {
+				return err
+			}
+			msg.Poll = poll
+		case msgEncryption:
+			msg.Encryption = string(value)
+		case msgPreviews:
+			p, err := decodeProtoPreview(value)
+			if err != nil {
+				return err
+			}
+			msg.Previews = append(msg.Previews, p)
+		}
+		return nil
+	})
+	return msg, err
+}
+
+func decodeProtoPreview(data []byte) (models.LinkPreview, error) {
+	var p models.LinkPreview
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch num {
+		case prevURL:
+			p.URL = string(value)
+		case prevTitle:
+			p.Title = string(value)
+		case prevDescription:
+			p.Description = string(value)
+		case prevImageURL:
+			p.ImageURL = string(value)
+		case prevSiteName:
+			p.SiteName = string(value)
+		}
+		return nil
+	})
+	return p, err
+}
+
+func decodeProtoAttachment(data []byte) (models.Attachment, error) {
+	var a models.Attachment
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch num {
+		case attTitle:
+			a.Title = string(value)
+		case attURL:
+			a.URL = string(value)
+		case attText:
+			a.Text = string(value)
+		case attColor:
+			a.Color = string(value)
+		case attImageURL:
+			a.ImageURL = string(value)
+		case attFields:
+			var f models.AttachmentField
+			err := walkFields(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				switch num {
+				case 1:
+					f.Title = string(value)
+				case 2:
+					f.Value = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			a.Fields = append(a.Fields, f)
+		}
+		return nil
+	})
+	return a, err
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func appendTime(b []byte, num protowire.Number, t *time.Time) []byte {
+	if t == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(t.UnixMilli()))
+}
+
+// walkFields percorre os campos de uma mensagem. Para campos varint, value
+// contém o varint ainda codificado; para bytes, o conteúdo já sem o tamanho.
+func walkFields(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var value []byte
+		switch typ {
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(data)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			value, n = v, m
+		case protowire.VarintType:
+			_, m := protowire.ConsumeVarint(data)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			value, n = data[:m], m
+		default:
+			m := protowire.ConsumeFieldValue(num, typ, data)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			n = m
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Package protocol define o protocolo versionado do WebSocket.
+//
+// O cliente negocia a versão pelo header Sec-WebSocket-Protocol. Com "chat.v1"
+// todo frame, nos dois sentidos, é um envelope:
+//
+//	{"type": "...", "id": "...", "payload": {...}}
+//
+// Cliente → servidor:
+//
+//	message.send     payload {"content": "..."}  responde "ack" ou "error" com o mesmo id
+//	                 nas salas criptografadas: {"content": "<cifra>", "encryption": "megolm.v1"}
+//	command.respond  payload {"invocationId": "...", "text": "...", "ephemeral": true}
+//	                 resposta de um bot a um evento "command"
+//	ephemeral.send   payload {"userIds": ["..."], "content": "..."}
+//	                 mensagem que só os usuários indicados recebem, sem
+//	                 ser gravada; responde "ack" com o id da mensagem
+//	ping             sem payload                  responde "pong" com o mesmo id
+//
+// Servidor → cliente:
+//
+//	ack       payload {"messageId": "..."}
+//	pong      sem payload
+//	error     payload {"code": "...", "message": "...", "retryAfterMs": 0}
+//	<evento>  type é o tipo do evento (message, action, system, poll,
+//	          poll_updated, count, user_joined, user_left, message_edited,
+//	          message_deleted, message_expired, message_preview,
+//	          server_restarting) e payload é o models.Message completo
+//	ephemeral mensagem que só este usuário recebe e não é gravada: respostas
+//	          de comandos, avisos de moderação e mensagens de bots
+//	command   invocação de um comando registrado pelo bot; id é o
+//	          invocationId e content traz "/nome argumentos"
+//
+// Códigos de erro: validation, forbidden, rate_limited, too_large. Em
+// rate_limited, retryAfterMs diz quanto esperar antes de tentar de novo.
+//
+// Os subprotocolos "chat.v1.msgpack" e "chat.v1.protobuf" usam o mesmo
+// envelope em frames binários (MessagePack com os mesmos nomes de campo, ou
+// as mensagens de chat.proto).
+//
+// Sem subprotocolo o cliente usa o formato legado: envia {"content": "..."} e
+// recebe models.Message cru; erros chegam como {"type": "error", "content": "..."}.
+package protocol
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+const (
+	V1         = "chat.v1"
+	V1Msgpack  = "chat.v1.msgpack"
+	V1Protobuf = "chat.v1.protobuf"
+)
+
+const (
+	TypeSend           = "message.send"
+	TypeCommandRespond = "command.respond"
+	TypeEphemeralSend  = "ephemeral.send"
+	TypePing           = "ping"
+	TypeAck            = "ack"
+	TypePong           = "pong"
+	TypeError          = "error"
+)
+
+const (
+	CodeValidation  = "validation"
+	CodeForbidden   = "forbidden"
+	CodeRateLimited = "rate_limited"
+	CodeTooLarge    = "too_large"
+	CodeInternal    = "internal"
+)
+
+// Subprotocols lista, em ordem de preferência, os subprotocolos aceitos no
+// upgrade.
+var Subprotocols = []string{V1, V1Msgpack, V1Protobuf}
+
+type Envelope struct {
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	Payload any    `json:"payload,omitempty"`
+}
+
+type SendPayload struct {
+	Content string `json:"content"`
+	// Encryption é o esquema de cifra de Content; obrigatório nas salas
+	// criptografadas e proibido nas demais.
+	Encryption string `json:"encryption,omitempty"`
+}
+
+type CommandRespondPayload struct {
+	InvocationID string `json:"invocationId"`
+	Text         string `json:"text"`
+	Ephemeral    bool   `json:"ephemeral"`
+}
+
+type EphemeralSendPayload struct {
+	UserIDs []string `json:"userIds"`
+	Content string   `json:"content"`
+}
+
+type AckPayload struct {
+	MessageID string `json:"messageId"`
+}
+
+type ErrorPayload struct {
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	RetryAfterMs int64  `json:"retryAfterMs,omitempty"`
+}
+
+func EventEnvelope(msg models.Message) Envelope {
+	return Envelope{Type: msg.Type, ID: msg.ID, Payload: msg}
+}
+
+func ErrorEnvelope(id string, err *Error) Envelope {
+	return Envelope{
+		Type: TypeError,
+		ID:   id,
+		Payload: ErrorPayload{
+			Code:         err.Code,
+			Message:      err.Message,
+			RetryAfterMs: err.RetryAfter.Milliseconds(),
+		},
+	}
+}
+
+// Error é o erro exposto ao cliente. Qualquer outro erro vira CodeInternal
+// sem revelar a mensagem original.
+type Error struct {
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func NewError(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func RateLimited(message string, retryAfter time.Duration) *Error {
+	return &Error{Code: CodeRateLimited, Message: message, RetryAfter: retryAfter}
+}
+
+func HTTPStatus(code string) int {
+	switch code {
+	case CodeValidation:
+		return http.StatusBadRequest
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodeTooLarge:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,153 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type FloodConfig struct {
+	UserRate  float64
+	UserBurst int
+	RoomRate  float64
+	RoomBurst int
+	// Após MuteAfter violações dentro de MuteWindow o usuário fica
+	// silenciado por MuteFor. MuteAfter zero desativa o silêncio automático.
+	MuteAfter  int
+	MuteWindow time.Duration
+	MuteFor    time.Duration
+}
+
+// Verdict é o resultado de FloodGuard.Check.
+type Verdict struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	Muted      bool
+	SlowMode   bool
+	// JustMuted marca a violação que acabou de silenciar o usuário.
+	JustMuted bool
+}
+
+// FloodGuard combina os limites por usuário e por sala, o modo lento de cada
+// sala e o silêncio temporário de quem insiste em estourar os limites.
+type FloodGuard struct {
+	cfg   FloodConfig
+	users *Limiter
+	rooms *Limiter
+
+	mu         sync.Mutex
+	lastSent   map[string]time.Time
+	violations map[string][]time.Time
+	mutedUntil map[string]time.Time
+}
+
+func NewFloodGuard(cfg FloodConfig) *FloodGuard {
+	g := &FloodGuard{
+		cfg:        cfg,
+		users:      NewLimiter(cfg.UserRate, cfg.UserBurst),
+		rooms:      NewLimiter(cfg.RoomRate, cfg.RoomBurst),
+		lastSent:   make(map[string]time.Time),
+		violations: make(map[string][]time.Time),
+		mutedUntil: make(map[string]time.Time),
+	}
+
+	go g.cleanup()
+
+	return g
+}
+
+func (g *FloodGuard) Check(userID, roomID string, slowMode time.Duration) Verdict {
+	if g == nil {
+		return Verdict{Allowed: true}
+	}
+
+	now := time.Now()
+
+	g.mu.Lock()
+	if until, ok := g.mutedUntil[userID]; ok {
+		if now.Before(until) {
+			g.mu.Unlock()
+			return Verdict{RetryAfter: until.Sub(now), Muted: true}
+		}
+		delete(g.mutedUntil, userID)
+	}
+
+	slowKey := roomID + ":" + userID
+	if slowMode > 0 {
+		if last, ok := g.lastSent[slowKey]; ok && now.Sub(last) < slowMode {
+			g.mu.Unlock()
+			return g.violation(userID, Verdict{RetryAfter: slowMode - now.Sub(last), SlowMode: true})
+		}
+	}
+	g.mu.Unlock()
+
+	if ok, wait := g.users.Allow(userID); !ok {
+		return g.violation(userID, Verdict{RetryAfter: wait})
+	}
+	if ok, wait := g.rooms.Allow(roomID); !ok {
+		// A sala estourou por causa de todos: não conta como violação de
+		// quem está dentro do próprio limite, e o token dele volta.
+		g.users.Refund(userID)
+		return Verdict{RetryAfter: wait}
+	}
+
+	if slowMode > 0 {
+		g.mu.Lock()
+		g.lastSent[slowKey] = now
+		g.mu.Unlock()
+	}
+
+	return Verdict{Allowed: true}
+}
+
+// violation registra a violação e silencia o usuário se ele passou do limite.
+func (g *FloodGuard) violation(userID string, v Verdict) Verdict {
+	if g.cfg.MuteAfter <= 0 {
+		return v
+	}
+
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	recent := g.violations[userID][:0]
+	for _, t := range g.violations[userID] {
+		if now.Sub(t) < g.cfg.MuteWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+
+	if len(recent) >= g.cfg.MuteAfter {
+		delete(g.violations, userID)
+		g.mutedUntil[userID] = now.Add(g.cfg.MuteFor)
+		return Verdict{RetryAfter: g.cfg.MuteFor, Muted: true, JustMuted: true}
+	}
+
+	g.violations[userID] = recent
+	return v
+}
+
+func (g *FloodGuard) cleanup() {
+	ticker := time.NewTicker(idleCleanup)
+	for range ticker.C {
+		now := time.Now()
+		g.mu.Lock()
+		for key, t := range g.lastSent {
+			if now.Sub(t) > time.Hour {
+				delete(g.lastSent, key)
+			}
+		}
+		for userID, list := range g.violations {
+			if len(list) == 0 || now.Sub(list[len(list)-1]) > g.cfg.MuteWindow {
+				delete(g.violations, userID)
+			}
+		}
+		for userID, until := range g.mutedUntil {
+			if now.After(until) {
+				delete(g.mutedUntil, userID)
+			}
+		}
+		g.mu.Unlock()
+	}
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// Taxas baixas o bastante para nenhum bucket se encher de novo no teste.
+const slow = 0.001
+
+func TestFloodGuardUserLimitMutes(t *testing.T) {
+	g := NewFloodGuard(FloodConfig{
+		UserRate: slow, UserBurst: 2,
+		MuteAfter: 3, MuteWindow: time.Minute, MuteFor: 5 * time.Minute,
+	})
+
+	for range 2 {
+		if v := g.Check("ana", "sala", 0); !v.Allowed {
+			t.Fatalf("dentro do limite: %+v", v)
+		}
+	}
+	for i := range 2 {
+		v := g.Check("ana", "sala", 0)
+		if v.Allowed || v.Muted || v.RetryAfter <= 0 {
+			t.Fatalf("violação %d = %+v", i+1, v)
+		}
+	}
+	v := g.Check("ana", "sala", 0)
+	if !v.Muted || !v.JustMuted || v.RetryAfter != 5*time.Minute {
+		t.Fatalf("terceira violação = %+v, quer silêncio de 5m", v)
+	}
+	if v := g.Check("ana", "outra", 0); !v.Muted || v.JustMuted || v.RetryAfter <= 0 {
+		t.Errorf("silenciado em outra sala = %+v", v)
+	}
+	if v := g.Check("beto", "sala", 0); !v.Allowed {
+		t.Errorf("outro usuário = %+v", v)
+	}
+}
+
+func TestFloodGuardRoomLimitIsNotAViolation(t *testing.T) {
+	g := NewFloodGuard(FloodConfig{
+		UserRate: slow, UserBurst: 2,
+		RoomRate: slow, RoomBurst: 3,
+		MuteAfter: 1, MuteWindow: time.Minute, MuteFor: time.Minute,
+	})
+
+	for _, user := range []string{"beto", "carla", "davi"} {
+		if v := g.Check(user, "sala", 0); !v.Allowed {
+			t.Fatalf("%s: %+v", user, v)
+		}
+	}
+
+	// A sala esgotou por causa dos outros: ana é recusada, mas com o tempo
+	// da sala e sem silêncio, mesmo com MuteAfter 1.
+	for range 3 {
+		v := g.Check("ana", "sala", 0)
+		if v.Allowed || v.Muted || v.JustMuted || v.RetryAfter <= 0 {
+			t.Fatalf("sala cheia = %+v", v)
+		}
+	}
+
+	// Os tokens de ana voltaram: ela ainda manda as 2 mensagens dela em
+	// outra sala.
+	for i := range 2 {
+		if v := g.Check("ana", "outra", 0); !v.Allowed {
+			t.Fatalf("mensagem %d de ana em outra sala = %+v", i+1, v)
+		}
+	}
+}
+
+func TestFloodGuardSlowMode(t *testing.T) {
+	g := NewFloodGuard(FloodConfig{MuteAfter: 2, MuteWindow: time.Minute, MuteFor: time.Minute})
+
+	if v := g.Check("ana", "sala", time.Minute); !v.Allowed {
+		t.Fatalf("primeira mensagem = %+v", v)
+	}
+	v := g.Check("ana", "sala", time.Minute)
+	if v.Allowed || !v.SlowMode || v.RetryAfter <= 0 || v.RetryAfter > time.Minute {
+		t.Fatalf("modo lento = %+v", v)
+	}
+	if v := g.Check("ana", "outra", time.Minute); !v.Allowed {
+		t.Errorf("modo lento vale por sala: %+v", v)
+	}
+	if v := g.Check("beto", "sala", time.Minute); !v.Allowed {
+		t.Errorf("modo lento vale por usuário: %+v", v)
+	}
+	if v := g.Check("ana", "sala", time.Minute); !v.JustMuted {
+		t.Errorf("insistir no modo lento conta como violação: %+v", v)
+	}
+}
+
+func TestFloodGuardDisabled(t *testing.T) {
+	var g *FloodGuard
+	if v := g.Check("ana", "sala", time.Minute); !v.Allowed {
+		t.Errorf("guarda nil = %+v", v)
+	}
+
+	g = NewFloodGuard(FloodConfig{})
+	for range 100 {
+		if v := g.Check("ana", "sala", 0); !v.Allowed {
+			t.Fatalf("sem limites = %+v", v)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+const idleCleanup = time.Minute
+
+// Limiter é um token bucket por chave (usuário, sala, IP...). Cada chave
+// recebe `rate` tokens por segundo até o máximo de `burst`.
+type Limiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	mu      sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	l := &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+
+	go l.cleanup()
+
+	return l
+}
+
+// Allow consome um token da chave. Quando não há token disponível, retorna
+// quanto tempo falta para o próximo.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.rate <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// Refund devolve o token consumido por um Allow que acabou não valendo.
+func (l *Limiter) Refund(key string) {
+	if l == nil || l.rate <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = min(l.burst, b.tokens+1)
+	}
+}
+
+// cleanup descarta buckets que já se encheram de novo: recriá-los cheios é
+// equivalente e mantém o mapa pequeno.
+func (l *Limiter) cleanup() {
+	ticker := time.NewTicker(idleCleanup)
+	for range ticker.C {
+		now := time.Now()
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterBurstAndRetryAfter(t *testing.T) {
+	l := NewLimiter(1, 3)
+
+	for i := range 3 {
+		if ok, _ := l.Allow("ana"); !ok {
+			t.Fatalf("tentativa %d recusada dentro do burst", i+1)
+		}
+	}
+	ok, wait := l.Allow("ana")
+	if ok {
+		t.Fatal("quarta tentativa aceita com burst 3")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("RetryAfter = %v, quer até 1s (1 token/s)", wait)
+	}
+
+	// Cada chave tem o próprio bucket.
+	if ok, _ := l.Allow("beto"); !ok {
+		t.Error("outra chave recusada")
+	}
+}
+
+func TestLimiterRefills(t *testing.T) {
+	l := NewLimiter(100, 1)
+	if ok, _ := l.Allow("ana"); !ok {
+		t.Fatal("primeira tentativa recusada")
+	}
+	if ok, _ := l.Allow("ana"); ok {
+		t.Fatal("segunda tentativa aceita sem esperar")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if ok, _ := l.Allow("ana"); !ok {
+		t.Error("bucket não se encheu de novo")
+	}
+}
+
+func TestLimiterRefund(t *testing.T) {
+	l := NewLimiter(0.001, 2)
+	l.Allow("ana")
+	l.Allow("ana")
+	l.Refund("ana")
+	if ok, _ := l.Allow("ana"); !ok {
+		t.Error("token devolvido não foi reaproveitado")
+	}
+
+	// O reembolso não passa do burst.
+	l.Refund("beto")
+	l.Allow("beto")
+	l.Refund("beto")
+	l.Refund("beto")
+	for i := range 3 {
+		if ok, _ := l.Allow("beto"); ok != (i < 2) {
+			t.Errorf("tentativa %d de beto = %v", i+1, ok)
+		}
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	var nilLimiter *Limiter
+	for _, l := range []*Limiter{nilLimiter, NewLimiter(0, 1)} {
+		for range 10 {
+			if ok, wait := l.Allow("ana"); !ok || wait != 0 {
+				t.Fatalf("limite desativado recusou: %v %v", ok, wait)
+			}
+		}
+		l.Refund("ana")
+	}
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// APITokenPrefix marca os tokens de API, para diferenciá-los de um JWT.
+const APITokenPrefix = "chat_"
+
+// APITokenRepository guarda tokens de API de usuários e bots. Como em
+// user_tokens, só o hash SHA-256 vai para o banco; prefix guarda os primeiros
+// caracteres para o usuário reconhecer o token na listagem.
+type APITokenRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewAPITokenRepository(db *pgxpool.Pool) *APITokenRepository {
+	return &APITokenRepository{db: db}
+}
+
+// Create gera o token e devolve o valor em claro, que não é guardado.
+func (r *APITokenRepository) Create(ctx context.Context, userID, name string, scopes, roomIDs []string, expiresAt *time.Time) (*models.APIToken, string, error) {
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", err
+	}
+	secret := APITokenPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw))
+
+	token := &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    secret[:len(APITokenPrefix)+6],
+		Scopes:    scopes,
+		RoomIDs:   roomIDs,
+		ExpiresAt: expiresAt,
+	}
+
+	query := `INSERT INTO api_tokens (user_id, name, prefix, token_hash, scopes, room_ids, expires_at)
+			  VALUES ($1, $2, $3, $4, $5, $6::uuid[], $7)
+			  RETURNING id, created_at`
+
+	err := r.db.QueryRow(ctx, query, userID, name, token.Prefix, hashToken(secret), scopes, roomIDs, expiresAt).
+		Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	return token, secret, nil
+}
+
+// Authenticate devolve o token ativo correspondente ao valor recebido e
+// registra o uso. Tokens revogados, expirados ou que não têm o prefixo
+// retornam nil.
+func (r *APITokenRepository) Authenticate(ctx context.Context, secret string) (*models.APIToken, error) {
+	if !strings.HasPrefix(secret, APITokenPrefix) {
+		return nil, nil
+	}
+
+	query := `UPDATE api_tokens t SET last_used_at = NOW()
+			  FROM users u
+			  WHERE u.id = t.user_id AND t.token_hash = $1
+			  AND t.revoked_at IS NULL AND (t.expires_at IS NULL OR t.expires_at > NOW())
+			  RETURNING t.id, t.user_id, u.username, t.name, t.prefix, t.scopes, t.room_ids::text[],
+						t.expires_at, t.last_used_at, t.created_at`
+
+	token := &models.APIToken{}
+	err := r.db.QueryRow(ctx, query, hashToken(secret)).Scan(&token.ID, &token.UserID, &token.Username, &token.Name,
+		&token.Prefix, &token.Scopes, &token.RoomIDs, &token.ExpiresAt, &token.LastUsedAt, &token.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// ListByOwner lista os tokens ativos do usuário e dos bots que ele criou.
+func (r *APITokenRepository) ListByOwner(ctx context.Context, ownerID string) ([]models.APIToken, error) {
+	query := `SELECT t.id, t.user_id, u.username, t.name, t.prefix, t.scopes, t.room_ids::text[],
+					 t.expires_at, t.last_used_at, t.created_at
+			  FROM api_tokens t
+			  JOIN users u ON u.id = t.user_id
+			  WHERE (u.id = $1 OR u.bot_owner_id = $1) AND t.revoked_at IS NULL
+			  ORDER BY t.created_at DESC`
+
+	rows, err := r.db.Query(ctx, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		var token models.APIToken
+		if err := rows.Scan(&token.ID, &token.UserID, &token.Username, &token.Name, &token.Prefix, &token.Scopes,
+			&token.RoomIDs, &token.ExpiresAt, &token.LastUsedAt, &token.CreatedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// Revoke revoga o token se ele pertence ao usuário ou a um bot dele.
+func (r *APITokenRepository) Revoke(ctx context.Context, id, ownerID string) (bool, error) {
+	query := `UPDATE api_tokens t SET revoked_at = NOW()
+			  FROM users u
+			  WHERE u.id = t.user_id AND t.id = $1 AND (u.id = $2 OR u.bot_owner_id = $2)
+			  AND t.revoked_at IS NULL`
+
+	tag, err := r.db.Exec(ctx, query, id, ownerID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+var ErrCommandExists = errors.New("comando já registrado")
+
+type BotCommandRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewBotCommandRepository(db *pgxpool.Pool) *BotCommandRepository {
+	return &BotCommandRepository{db: db}
+}
+
+// Create registra o comando e devolve o segredo que assina as chamadas à URL.
+func (r *BotCommandRepository) Create(ctx context.Context, cmd *models.BotCommand) (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	cmd.Secret = "cmdsec_" + hex.EncodeToString(raw)
+
+	query := `INSERT INTO bot_commands (bot_id, name, description, url, secret)
+			  VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+			  RETURNING id, created_at, (SELECT username FROM users WHERE id = $1)`
+
+	err := r.db.QueryRow(ctx, query, cmd.BotID, cmd.Name, cmd.Description, cmd.URL, cmd.Secret).
+		Scan(&cmd.ID, &cmd.CreatedAt, &cmd.BotUsername)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return "", ErrCommandExists
+		}
+		return "", err
+	}
+	return cmd.Secret, nil
+}
+
+const botCommandColumns = `c.id, c.bot_id, u.username, c.name, c.description, COALESCE(c.url, ''), c.secret, c.created_at`
+
+func (r *BotCommandRepository) ListByBot(ctx context.Context, botID string) ([]models.BotCommand, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+botCommandColumns+` FROM bot_commands c JOIN users u ON u.id = c.bot_id
+								  WHERE c.bot_id = $1 ORDER BY c.name`, botID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	commands := []models.BotCommand{}
+	for rows.Next() {
+		var cmd models.BotCommand
+		if err := rows.Scan(&cmd.ID, &cmd.BotID, &cmd.BotUsername, &cmd.Name, &cmd.Description, &cmd.URL, &cmd.Secret, &cmd.CreatedAt); err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, rows.Err()
+}
+
+// GetCommand busca o comando pelo nome, sem a barra; nil se não existe.
+func (r *BotCommandRepository) GetCommand(ctx context.Context, name string) (*models.BotCommand, error) {
+	var cmd models.BotCommand
+	err := r.db.QueryRow(ctx, `SELECT `+botCommandColumns+` FROM bot_commands c JOIN users u ON u.id = c.bot_id
+							   WHERE c.name = $1`, name).
+		Scan(&cmd.ID, &cmd.BotID, &cmd.BotUsername, &cmd.Name, &cmd.Description, &cmd.URL, &cmd.Secret, &cmd.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cmd, nil
+}
+
+func (r *BotCommandRepository) Delete(ctx context.Context, botID, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM bot_commands WHERE id = $1 AND bot_id = $2`, id, botID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+type ExportRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewExportRepository(db *pgxpool.Pool) *ExportRepository {
+	return &ExportRepository{db: db}
+}
+
+const exportColumns = `id, user_id, room_id, format, timezone, status, COALESCE(file, ''), size_bytes, message_count, COALESCE(error, ''),
+					   created_at, finished_at, expires_at`
+
+func scanExport(row pgx.Row) (*models.Export, error) {
+	var e models.Export
+	err := row.Scan(&e.ID, &e.UserID, &e.RoomID, &e.Format, &e.Timezone, &e.Status, &e.File, &e.Size, &e.Messages, &e.Error,
+		&e.CreatedAt, &e.FinishedAt, &e.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (r *ExportRepository) Create(ctx context.Context, e *models.Export) error {
+	query := `INSERT INTO exports (user_id, room_id, format, timezone)
+			  VALUES ($1, $2, $3, $4)
+			  RETURNING id, status, created_at`
+
+	return r.db.QueryRow(ctx, query, e.UserID, e.RoomID, e.Format, e.Timezone).Scan(&e.ID, &e.Status, &e.CreatedAt)
+}
+
+func (r *ExportRepository) CountActive(ctx context.Context, userID string) (int, error) {
+	var n int
+	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM exports WHERE user_id = $1 AND status IN ('pending', 'running')`, userID).Scan(&n)
+	return n, err
+}
+
+func (r *ExportRepository) Get(ctx context.Context, id, userID string) (*models.Export, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, nil
+	}
+
+	e, err := scanExport(r.db.QueryRow(ctx, `SELECT `+exportColumns+` FROM exports WHERE id = $1 AND user_id = $2`, id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return e, err
+}
+
+// List devolve as exportações do usuário que ainda não venceram, das mais
+// novas para as mais antigas.
+func (r *ExportRepository) List(ctx context.Context, userID string) ([]models.Export, error) {
+	query := `SELECT ` + exportColumns + `
+			  FROM exports
+			  WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+			  ORDER BY created_at DESC
+			  LIMIT 50`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.Export{}
+	for rows.Next() {
+		e, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *e)
+	}
+	return items, rows.Err()
+}
+
+// Claim reserva até limit pedidos por lease. Um pedido em andamento cujo
+// lease expirou (réplica que caiu) volta a ser reservado e recomeça.
+func (r *ExportRepository) Claim(ctx context.Context, limit int, lease time.Duration) ([]models.Export, error) {
+	query := `UPDATE exports SET status = 'running', locked_until = NOW() + make_interval(secs => $2)
+			  WHERE id IN (
+				  SELECT id FROM exports
+				  WHERE status = 'pending' OR (status = 'running' AND locked_until < NOW())
+				  ORDER BY created_at
+				  LIMIT $1
+				  FOR UPDATE SKIP LOCKED
+			  )
+			  RETURNING ` + exportColumns
+
+	rows, err := r.db.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.Export
+	for rows.Next() {
+		e, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *e)
+	}
+	return items, rows.Err()
+}
+
+// Extend renova o lease de um pedido que ainda está sendo gerado.
+func (r *ExportRepository) Extend(ctx context.Context, id string, lease time.Duration) error {
+	_, err := r.db.Exec(ctx, `UPDATE exports SET locked_until = NOW() + make_interval(secs => $2) WHERE id = $1 AND status = 'running'`,
+		id, lease.Seconds())
+	return err
+}
+
+func (r *ExportRepository) Complete(ctx context.Context, id, file string, size int64, messages int, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx, `UPDATE exports
+							  SET status = 'done', file = $2, size_bytes = $3, message_count = $4, expires_at = $5,
+								  locked_until = NULL, finished_at = NOW()
+							  WHERE id = $1`, id, file, size, messages, expiresAt)
+	return err
+}
+
+func (r *ExportRepository) Fail(ctx context.Context, id, reason string, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx, `UPDATE exports
+							  SET status = 'failed', error = $2, expires_at = $3, locked_until = NULL, finished_at = NOW()
+							  WHERE id = $1`, id, reason, expiresAt)
+	return err
+}
+
+// PurgeExpired apaga os pedidos vencidos e devolve os arquivos que ficaram
+// órfãos no disco.
+func (r *ExportRepository) PurgeExpired(ctx context.Context) ([]string, error) {
+	rows, err := r.db.Query(ctx, `DELETE FROM exports WHERE expires_at < NOW() RETURNING COALESCE(file, '')`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []string
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			return nil, err
+		}
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, rows.Err()
+}
+
+func (r *ExportRepository) UserTimezone(ctx context.Context, userID string) (string, error) {
+	var tz string
+	err := r.db.QueryRow(ctx, `SELECT timezone FROM users WHERE id = $1`, userID).Scan(&tz)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return tz, err
+}
+
+// Room descreve a sala para o cabeçalho. A sala geral sai sem a lista de
+// membros; salas privadas, que não têm nome, levam o dos participantes.
+func (r *ExportRepository) Room(ctx context.Context, roomID string) (*models.ExportRoom, error) {
+	room := &models.ExportRoom{ID: roomID}
+	err := r.db.QueryRow(ctx, `SELECT COALESCE(name, ''), type FROM rooms WHERE id = $1`, roomID).Scan(&room.Name, &room.Type)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if room.Type == "general" {
+		return room, nil
+	}
+
+	rows, err := r.db.Query(ctx, `SELECT u.username FROM users u JOIN room_users ru ON ru.user_id = u.id
+								  WHERE ru.room_id = $1 ORDER BY u.username`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		room.Members = append(room.Members, username)
+	}
+	if room.Name == "" {
+		room.Name = strings.Join(room.Members, ", ")
+	}
+	return room, rows.Err()
+}
+
+// Messages devolve até limit mensagens da sala depois de (afterTime,
+// afterID), em ordem cronológica. Mensagens antigas da sala geral têm
+// room_id nulo.
+func (r *ExportRepository) Messages(ctx context.Context, roomID string, afterTime time.Time, afterID string, limit int) ([]models.Message, error) {
+	query := `SELECT m.id, COALESCE(m.room_id, '00000000-0000-0000-0000-000000000001'), m.username, m.content, m.type, m.created_at,
+					 COALESCE(m.avatar_url, u.avatar_url, ''), m.is_bot, COALESCE(m.attachments, '[]'), m.edited_at, COALESCE(m.encryption, '')
+			  FROM messages m
+			  LEFT JOIN users u ON m.username = u.username
+			  WHERE (m.room_id = $1::uuid OR ($1::uuid = '00000000-0000-0000-0000-000000000001' AND m.room_id IS NULL))
+				AND ($3::text = '' OR (m.created_at, m.id) > ($2, NULLIF($3::text, '')::uuid))
+			  ORDER BY m.created_at, m.id
+			  LIMIT $4`
+
+	rows, err := r.db.Query(ctx, query, roomID, afterTime, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	for rows.Next() {
+		var msg models.Message
+		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.Username, &msg.Content, &msg.Type, &msg.Timestamp, &msg.AvatarURL, &msg.Bot, &msg.Attachments,
+			&msg.EditedAt, &msg.Encryption); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+var ErrIdentityTaken = errors.New("identidade já vinculada a outra conta")
+
+type IdentityRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewIdentityRepository(db *pgxpool.Pool) *IdentityRepository {
+	return &IdentityRepository{db: db}
+}
+
+// GetUser devolve o dono da identidade (provider, subject), ou nil.
+func (r *IdentityRepository) GetUser(ctx context.Context, provider, subject string) (*models.User, error) {
+	user := &models.User{}
+	query := `SELECT u.id, u.username, u.email, u.avatar_url, u.email_verified, u.totp_enabled, u.created_at
+			  FROM user_identities i
+			  JOIN users u ON u.id = i.user_id
+			  WHERE i.provider = $1 AND i.subject = $2`
+
+	err := r.db.QueryRow(ctx, query, provider, subject).
+		Scan(&user.ID, &user.Username, &user.Email, &user.AvatarURL, &user.EmailVerified, &user.TOTPEnabled, &user.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// Link vincula a identidade ao usuário. Devolve ErrIdentityTaken se ela já
+// pertence a outra conta ou se o usuário já tem outra conta desse provedor.
+func (r *IdentityRepository) Link(ctx context.Context, userID, provider, subject, email string) error {
+	query := `INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)
+			  ON CONFLICT (provider, subject) DO UPDATE SET email = EXCLUDED.email
+			  WHERE user_identities.user_id = EXCLUDED.user_id`
+
+	tag, err := r.db.Exec(ctx, query, userID, provider, subject, email)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrIdentityTaken
+		}
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrIdentityTaken
+	}
+	return nil
+}
+
+func (r *IdentityRepository) ListByUser(ctx context.Context, userID string) ([]models.Identity, error) {
+	query := `SELECT provider, email, created_at FROM user_identities WHERE user_id = $1 ORDER BY created_at`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.Identity{}
+	for rows.Next() {
+		var identity models.Identity
+		if err := rows.Scan(&identity.Provider, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// Unlink remove o vínculo, mas nunca o último meio de login: sem senha, a
+// conta precisa manter ao menos uma identidade. Devolve false se nada foi
+// removido.
+func (r *IdentityRepository) Unlink(ctx context.Context, userID, provider string) (bool, error) {
+	query := `DELETE FROM user_identities
+			  WHERE user_id = $1 AND provider = $2
+			  AND (
+				  (SELECT COALESCE(password_hash, '') <> '' FROM users WHERE id = $1)
+				  OR (SELECT COUNT(*) FROM user_identities WHERE user_id = $1) > 1
+			  )`
+
+	tag, err := r.db.Exec(ctx, query, userID, provider)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
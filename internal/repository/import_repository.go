@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucaspanzera1/chat/internal/importer"
+)
+
+// ImportRepository grava as importações do Slack e do WhatsApp.
+type ImportRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewImportRepository(db *pgxpool.Pool) *ImportRepository {
+	return &ImportRepository{db: db}
+}
+
+func (r *ImportRepository) UserByEmail(ctx context.Context, email string) (string, string, error) {
+	var id, username string
+	err := r.db.QueryRow(ctx, `SELECT id, username FROM users WHERE email = $1`, email).Scan(&id, &username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", nil
+	}
+	return id, username, err
+}
+
+// EnsurePlaceholder cria a conta provisória (sem senha e com o email não
+// verificado) ou devolve o nome da que já tem esse ID. Se o nome estiver em
+// uso, tenta username2, username3...
+func (r *ImportRepository) EnsurePlaceholder(ctx context.Context, id, username, email, avatarURL string, bot bool) (string, bool, error) {
+	var existing string
+	err := r.db.QueryRow(ctx, `SELECT username FROM users WHERE id = $1`, id).Scan(&existing)
+	if err == nil {
+		return existing, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return "", false, err
+	}
+
+	for i := 1; i <= 100; i++ {
+		candidate := username
+		if i > 1 {
+			suffix := fmt.Sprint(i)
+			candidate = username[:min(len(username), 20-len(suffix))] + suffix
+		}
+
+		var created string
+		err := r.db.QueryRow(ctx, `INSERT INTO users (id, username, email, avatar_url, is_bot)
+								   VALUES ($1, $2, $3, NULLIF($4::text, ''), $5)
+								   ON CONFLICT DO NOTHING
+								   RETURNING username`, id, candidate, email, avatarURL, bot).Scan(&created)
+		if err == nil {
+			return created, true, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return "", false, err
+		}
+
+		// O conflito pode ter sido no email ou no ID (outra importação ao
+		// mesmo tempo); aí a conta já existe.
+		var owner string
+		err = r.db.QueryRow(ctx, `SELECT username FROM users WHERE id = $1 OR email = $2 LIMIT 1`, id, email).Scan(&owner)
+		if err == nil {
+			return owner, false, nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return "", false, err
+		}
+	}
+	return "", false, fmt.Errorf("nenhum nome de usuário livre para %s", username)
+}
+
+// PrivateRoom devolve a conversa privada sem criptografia entre os dois, ou
+// "". Uma conversa criptografada só guarda texto cifrado, então o histórico
+// em claro não entra nela.
+func (r *ImportRepository) PrivateRoom(ctx context.Context, user1ID, user2ID string) (string, error) {
+	query := `SELECT r.id FROM rooms r
+			  JOIN room_users ru1 ON ru1.room_id = r.id AND ru1.user_id = $1
+			  JOIN room_users ru2 ON ru2.room_id = r.id AND ru2.user_id = $2
+			  WHERE r.type = 'private' AND NOT r.encrypted
+			  ORDER BY r.created_at
+			  LIMIT 1`
+
+	var id string
+	err := r.db.QueryRow(ctx, query, user1ID, user2ID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return id, err
+}
+
+// EnsureRoom cria a sala com os membros numa transação. Uma sala que já
+// existe fica como está, inclusive os membros: quem saiu não volta numa
+// nova importação.
+func (r *ImportRepository) EnsureRoom(ctx context.Context, room importer.NewRoom) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `INSERT INTO rooms (id, name, type, created_by, topic, created_at)
+							  VALUES ($1, NULLIF($2::text, ''), $3, NULLIF($4::text, '')::uuid, NULLIF($5::text, ''), $6)
+							  ON CONFLICT (id) DO NOTHING`,
+		room.ID, room.Name, room.Type, room.CreatedBy, room.Topic, room.CreatedAt.In(time.Local))
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if len(room.Members) > 0 {
+		_, err := tx.Exec(ctx, `INSERT INTO room_users (room_id, user_id)
+								SELECT $1, unnest($2::uuid[])
+								ON CONFLICT DO NOTHING`, room.ID, room.Members)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit(ctx)
+}
+
+var importColumns = []string{"id", "room_id", "user_id", "username", "content", "type", "created_at", "edited_at", "is_bot", "attachments"}
+
+// CopyMessages grava um lote com COPY numa tabela temporária e passa para
+// messages o que ainda não existe. COPY não tem ON CONFLICT; é o INSERT ...
+// SELECT que torna a importação repetível.
+func (r *ImportRepository) CopyMessages(ctx context.Context, records []importer.Record) (int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `CREATE TEMP TABLE import_messages (
+							   id UUID, room_id UUID, user_id UUID, username VARCHAR(50), content TEXT, type VARCHAR(20),
+							   created_at TIMESTAMP, edited_at TIMESTAMPTZ, is_bot BOOLEAN, attachments JSONB
+						   ) ON COMMIT DROP`)
+	if err != nil {
+		return 0, err
+	}
+
+	rows := make([][]any, len(records))
+	for i, rec := range records {
+		var userID *string
+		if rec.UserID != "" {
+			userID = &rec.UserID
+		}
+		var attachments []byte
+		if len(rec.Attachments) > 0 {
+			if attachments, err = json.Marshal(rec.Attachments); err != nil {
+				return 0, err
+			}
+		}
+		// created_at é TIMESTAMP sem fuso, gravado no horário do servidor
+		// como nas mensagens enviadas pelo chat.
+		rows[i] = []any{rec.ID, rec.RoomID, userID, truncate(rec.Username, 50), rec.Content, rec.Type,
+			rec.Timestamp.In(time.Local), rec.EditedAt, rec.Bot, attachments}
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"import_messages"}, importColumns, pgx.CopyFromRows(rows)); err != nil {
+		return 0, err
+	}
+
+	tag, err := tx.Exec(ctx, `INSERT INTO messages (id, room_id, user_id, username, content, type, created_at, edited_at, is_bot, attachments)
+							  SELECT id, room_id, user_id, username, content, type, created_at, edited_at, is_bot, attachments FROM import_messages
+							  ON CONFLICT (id) DO NOTHING`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+var (
+	ErrTooManyDevices     = errors.New("limite de dispositivos atingido")
+	ErrTooManyOneTimeKeys = errors.New("limite de chaves de uso único atingido")
+)
+
+// KeyRepository é o diretório de chaves públicas dos dispositivos. As
+// assinaturas não são conferidas aqui: quem valida é o cliente, com a chave
+// de identidade.
+type KeyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewKeyRepository(db *pgxpool.Pool) *KeyRepository {
+	return &KeyRepository{db: db}
+}
+
+// Upload cria ou atualiza o dispositivo e soma as chaves de uso único. Se a
+// chave de identidade mudou (aplicativo reinstalado), as chaves de uso
+// único antigas são descartadas.
+func (r *KeyRepository) Upload(ctx context.Context, userID, deviceID string, up models.KeyUpload, maxDevices, maxOneTimeKeys int) (*models.DeviceKeys, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var current string
+	err = tx.QueryRow(ctx, `SELECT identity_key FROM device_keys WHERE user_id = $1 AND device_id = $2 FOR UPDATE`, userID, deviceID).Scan(&current)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		var devices int
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM device_keys WHERE user_id = $1`, userID).Scan(&devices); err != nil {
+			return nil, err
+		}
+		if devices >= maxDevices {
+			return nil, ErrTooManyDevices
+		}
+	case err != nil:
+		return nil, err
+	case current != up.IdentityKey:
+		if _, err := tx.Exec(ctx, `DELETE FROM one_time_prekeys WHERE user_id = $1 AND device_id = $2`, userID, deviceID); err != nil {
+			return nil, err
+		}
+	}
+
+	device := &models.DeviceKeys{UserID: userID, DeviceID: deviceID, IdentityKey: up.IdentityKey, SignedPreKey: up.SignedPreKey}
+	query := `INSERT INTO device_keys (user_id, device_id, identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature)
+			  VALUES ($1, $2, $3, $4, $5, $6)
+			  ON CONFLICT (user_id, device_id) DO UPDATE SET
+				  identity_key = EXCLUDED.identity_key,
+				  signed_prekey_id = EXCLUDED.signed_prekey_id,
+				  signed_prekey = EXCLUDED.signed_prekey,
+				  signed_prekey_signature = EXCLUDED.signed_prekey_signature,
+				  updated_at = NOW()
+			  RETURNING created_at, updated_at`
+	err = tx.QueryRow(ctx, query, userID, deviceID, up.IdentityKey, up.SignedPreKey.KeyID, up.SignedPreKey.PublicKey, up.SignedPreKey.Signature).
+		Scan(&device.CreatedAt, &device.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(up.OneTimeKeys) > 0 {
+		ids := make([]int64, len(up.OneTimeKeys))
+		keys := make([]string, len(up.OneTimeKeys))
+		for i, k := range up.OneTimeKeys {
+			ids[i], keys[i] = k.KeyID, k.PublicKey
+		}
+		// Reenviar uma chave já conhecida não a duplica nem a substitui.
+		_, err := tx.Exec(ctx, `INSERT INTO one_time_prekeys (user_id, device_id, key_id, public_key)
+								SELECT $1, $2, k.id, k.public_key FROM unnest($3::bigint[], $4::text[]) AS k(id, public_key)
+								ON CONFLICT DO NOTHING`, userID, deviceID, ids, keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM one_time_prekeys WHERE user_id = $1 AND device_id = $2`, userID, deviceID).Scan(&device.OneTimeKeys)
+	if err != nil {
+		return nil, err
+	}
+	if device.OneTimeKeys > maxOneTimeKeys {
+		return nil, ErrTooManyOneTimeKeys
+	}
+	return device, tx.Commit(ctx)
+}
+
+// Devices lista os dispositivos do usuário com quantas chaves de uso único
+// ainda restam em cada um.
+func (r *KeyRepository) Devices(ctx context.Context, userID string) ([]models.DeviceKeys, error) {
+	query := `SELECT d.user_id, d.device_id, d.identity_key, d.signed_prekey_id, d.signed_prekey, d.signed_prekey_signature,
+					 (SELECT COUNT(*) FROM one_time_prekeys o WHERE o.user_id = d.user_id AND o.device_id = d.device_id),
+					 d.created_at, d.updated_at
+			  FROM device_keys d
+			  WHERE d.user_id = $1
+			  ORDER BY d.created_at`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []models.DeviceKeys{}
+	for rows.Next() {
+		var d models.DeviceKeys
+		err := rows.Scan(&d.UserID, &d.DeviceID, &d.IdentityKey, &d.SignedPreKey.KeyID, &d.SignedPreKey.PublicKey, &d.SignedPreKey.Signature,
+			&d.OneTimeKeys, &d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// DeleteDevice tira o dispositivo do diretório junto com as chaves de uso
+// único; false se ele não existia.
+func (r *KeyRepository) DeleteDevice(ctx context.Context, userID, deviceID string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM device_keys WHERE user_id = $1 AND device_id = $2`, userID, deviceID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RoomBundles devolve as chaves públicas de todos os dispositivos dos
+// membros da sala, sem consumir chaves de uso único.
+func (r *KeyRepository) RoomBundles(ctx context.Context, roomID string) ([]models.KeyBundle, error) {
+	query := `SELECT d.user_id, d.device_id, d.identity_key, d.signed_prekey_id, d.signed_prekey, d.signed_prekey_signature
+			  FROM device_keys d
+			  JOIN room_users ru ON ru.user_id = d.user_id
+			  WHERE ru.room_id = $1
+			  ORDER BY d.user_id, d.created_at`
+
+	rows, err := r.db.Query(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bundles := []models.KeyBundle{}
+	for rows.Next() {
+		var b models.KeyBundle
+		if err := rows.Scan(&b.UserID, &b.DeviceID, &b.IdentityKey, &b.SignedPreKey.KeyID, &b.SignedPreKey.PublicKey, &b.SignedPreKey.Signature); err != nil {
+			return nil, err
+		}
+		bundles = append(bundles, b)
+	}
+	return bundles, rows.Err()
+}
+
+// ClaimRoom devolve os pacotes dos dispositivos da sala, menos o de quem
+// pede, cada um com uma chave de uso único, que é apagada na mesma
+// operação. SKIP LOCKED impede que dois pedidos levem a mesma chave; um
+// dispositivo sem chaves sobrando vem só com a chave pré-assinada.
+func (r *KeyRepository) ClaimRoom(ctx context.Context, roomID, userID, deviceID string) ([]models.KeyBundle, error) {
+	bundles, err := r.RoomBundles(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `WITH claimed AS (
+				  SELECT o.user_id, o.device_id, o.key_id
+				  FROM device_keys d
+				  JOIN room_users ru ON ru.user_id = d.user_id AND ru.room_id = $1
+				  CROSS JOIN LATERAL (
+					  SELECT user_id, device_id, key_id FROM one_time_prekeys
+					  WHERE user_id = d.user_id AND device_id = d.device_id
+					  ORDER BY key_id
+					  LIMIT 1
+					  FOR UPDATE SKIP LOCKED
+				  ) o
+				  WHERE NOT (d.user_id = $2::uuid AND d.device_id = $3::text)
+			  )
+			  DELETE FROM one_time_prekeys p USING claimed c
+			  WHERE p.user_id = c.user_id AND p.device_id = c.device_id AND p.key_id = c.key_id
+			  RETURNING p.user_id, p.device_id, p.key_id, p.public_key`
+
+	rows, err := r.db.Query(ctx, query, roomID, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claimed := make(map[[2]string]*models.PreKey)
+	for rows.Next() {
+		var owner, device string
+		var key models.PreKey
+		if err := rows.Scan(&owner, &device, &key.KeyID, &key.PublicKey); err != nil {
+			return nil, err
+		}
+		claimed[[2]string{owner, device}] = &key
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := bundles[:0]
+	for _, b := range bundles {
+		if b.UserID == userID && b.DeviceID == deviceID {
+			continue
+		}
+		b.OneTimeKey = claimed[[2]string{b.UserID, b.DeviceID}]
+		result = append(result, b)
+	}
+	return result, nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Resultados registrados em login_attempts.
+const (
+	LoginSuccess  = "success"
+	LoginFailure  = "failure"
+	LoginBlocked  = "blocked"
+	LoginUnlocked = "unlocked"
+)
+
+type LoginAttemptRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewLoginAttemptRepository(db *pgxpool.Pool) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+func (r *LoginAttemptRepository) Record(ctx context.Context, email, ip, outcome string) error {
+	query := `INSERT INTO login_attempts (email, ip, outcome) VALUES ($1, $2, $3)`
+	_, err := r.db.Exec(ctx, query, email, ip, outcome)
+	return err
+}
+
+// AccountFailures conta as falhas do email desde o último sucesso ou
+// desbloqueio, sem olhar antes de `since`, e devolve a data da última.
+func (r *LoginAttemptRepository) AccountFailures(ctx context.Context, email string, since time.Time) (int, time.Time, error) {
+	query := `SELECT COUNT(*), COALESCE(MAX(created_at), 'epoch')
+			  FROM login_attempts
+			  WHERE email = $1 AND outcome = 'failure'
+			    AND created_at > GREATEST($2, COALESCE(
+			      (SELECT MAX(created_at) FROM login_attempts
+			       WHERE email = $1 AND outcome IN ('success', 'unlocked')), 'epoch'))`
+
+	var count int
+	var last time.Time
+	err := r.db.QueryRow(ctx, query, email, since).Scan(&count, &last)
+	return count, last, err
+}
+
+// IPFailures conta as falhas do IP desde `since` ou do último desbloqueio.
+// Logins bem-sucedidos não zeram a contagem: do contrário bastaria entrar na
+// própria conta para continuar tentando as dos outros.
+func (r *LoginAttemptRepository) IPFailures(ctx context.Context, ip string, since time.Time) (int, error) {
+	query := `SELECT COUNT(*)
+			  FROM login_attempts
+			  WHERE ip = $1 AND outcome = 'failure'
+			    AND created_at > GREATEST($2, COALESCE(
+			      (SELECT MAX(created_at) FROM login_attempts
+			       WHERE ip = $1 AND outcome = 'unlocked'), 'epoch'))`
+
+	var count int
+	err := r.db.QueryRow(ctx, query, ip, since).Scan(&count)
+	return count, err
+}
+
+// Unlock zera as falhas de um email e/ou de um IP.
+func (r *LoginAttemptRepository) Unlock(ctx context.Context, email, ip string) error {
+	query := `INSERT INTO login_attempts (email, ip, outcome) VALUES ($1, $2, 'unlocked')`
+	_, err := r.db.Exec(ctx, query, email, ip)
+	return err
+}
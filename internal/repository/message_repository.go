@@ -2,14 +2,19 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log"
+	"sync"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lucaspanzera1/chat/internal/models"
 )
 
 type MessageRepository struct {
-	db *pgxpool.Pool
+	db       *pgxpool.Pool
+	inflight sync.WaitGroup
 }
 
 func NewMessageRepository(db *pgxpool.Pool) *MessageRepository {
@@ -17,15 +22,75 @@ func NewMessageRepository(db *pgxpool.Pool) *MessageRepository {
 }
 
 func (r *MessageRepository) Create(ctx context.Context, msg *models.Message, userID string) error {
-	query := `INSERT INTO messages (id, room_id, user_id, username, content, type, avatar_url, created_at) 
-			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	r.inflight.Add(1)
+	defer r.inflight.Done()
+
+	var attachments []byte
+	if len(msg.Attachments) > 0 {
+		var err error
+		if attachments, err = json.Marshal(msg.Attachments); err != nil {
+			return err
+		}
+	}
 
-	_, err := r.db.Exec(ctx, query, msg.ID, msg.RoomID, userID, msg.Username, msg.Content, msg.Type, msg.AvatarURL, msg.Timestamp)
+	// Mensagens de integrações não têm usuário: userID vazio vira NULL.
+	query := `INSERT INTO messages (id, room_id, user_id, username, content, type, avatar_url, created_at, is_bot, attachments, encryption) 
+			  VALUES ($1, $2, NULLIF($3::text, '')::uuid, $4, $5, $6, $7, $8, $9, $10, NULLIF($11::text, ''))`
+
+	_, err := r.db.Exec(ctx, query, msg.ID, msg.RoomID, userID, msg.Username, msg.Content, msg.Type, msg.AvatarURL, msg.Timestamp,
+		msg.Bot, attachments, msg.Encryption)
 	return err
 }
 
+// Update troca o conteúdo (e o esquema de cifra) de uma mensagem do próprio
+// autor. Devolve nil se ela não existe ou é de outro usuário.
+func (r *MessageRepository) Update(ctx context.Context, roomID, id, userID, content, encryption string) (*models.Message, error) {
+	query := `UPDATE messages SET content = $4, encryption = NULLIF($5::text, ''), edited_at = NOW()
+			  WHERE room_id = $1 AND id = $2 AND user_id = $3
+			  RETURNING id, COALESCE(room_id, '00000000-0000-0000-0000-000000000001'), username, content, type, created_at, COALESCE(avatar_url, ''), is_bot,
+						COALESCE(attachments, '[]'), edited_at, COALESCE(encryption, '')`
+
+	var msg models.Message
+	err := r.db.QueryRow(ctx, query, roomID, id, userID, content, encryption).Scan(&msg.ID, &msg.RoomID, &msg.Username, &msg.Content,
+		&msg.Type, &msg.Timestamp, &msg.AvatarURL, &msg.Bot, &msg.Attachments, &msg.EditedAt, &msg.Encryption)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Delete apaga uma mensagem do próprio autor; false se não havia o que
+// apagar.
+func (r *MessageRepository) Delete(ctx context.Context, roomID, id, userID string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM messages WHERE room_id = $1 AND id = $2 AND user_id = $3`, roomID, id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Wait bloqueia até que todas as escritas em andamento terminem ou o contexto expire.
+func (r *MessageRepository) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (r *MessageRepository) GetRecent(ctx context.Context, limit int) ([]models.Message, error) {
-	query := `SELECT m.id, COALESCE(m.room_id, '00000000-0000-0000-0000-000000000001'), m.username, m.content, m.type, m.created_at, COALESCE(m.avatar_url, u.avatar_url, '')
+	query := `SELECT m.id, COALESCE(m.room_id, '00000000-0000-0000-0000-000000000001'), m.username, m.content, m.type, m.created_at, COALESCE(m.avatar_url, u.avatar_url, ''),
+					 m.is_bot, COALESCE(m.attachments, '[]'), m.edited_at, COALESCE(m.encryption, '')
 			  FROM messages m
 			  LEFT JOIN users u ON m.username = u.username
 			  WHERE m.room_id = '00000000-0000-0000-0000-000000000001' OR m.room_id IS NULL
@@ -42,7 +107,8 @@ func (r *MessageRepository) GetRecent(ctx context.Context, limit int) ([]models.
 	var messages []models.Message
 	for rows.Next() {
 		var msg models.Message
-		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.Username, &msg.Content, &msg.Type, &msg.Timestamp, &msg.AvatarURL); err != nil {
+		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.Username, &msg.Content, &msg.Type, &msg.Timestamp, &msg.AvatarURL, &msg.Bot, &msg.Attachments, &msg.EditedAt,
+			&msg.Encryption); err != nil {
 			log.Printf("Erro ao fazer scan: %v", err)
 			return nil, err
 		}
@@ -62,7 +128,8 @@ func (r *MessageRepository) GetRecent(ctx context.Context, limit int) ([]models.
 }
 
 func (r *MessageRepository) GetRecentByRoom(ctx context.Context, roomID string, limit int) ([]models.Message, error) {
-	query := `SELECT m.id, COALESCE(m.room_id, '00000000-0000-0000-0000-000000000001'), m.username, m.content, m.type, m.created_at, COALESCE(m.avatar_url, u.avatar_url, '')
+	query := `SELECT m.id, COALESCE(m.room_id, '00000000-0000-0000-0000-000000000001'), m.username, m.content, m.type, m.created_at, COALESCE(m.avatar_url, u.avatar_url, ''),
+					 m.is_bot, COALESCE(m.attachments, '[]'), m.edited_at, COALESCE(m.encryption, '')
 			  FROM messages m
 			  LEFT JOIN users u ON m.username = u.username
 			  WHERE m.room_id = $1
@@ -78,7 +145,8 @@ func (r *MessageRepository) GetRecentByRoom(ctx context.Context, roomID string,
 	var messages []models.Message
 	for rows.Next() {
 		var msg models.Message
-		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.Username, &msg.Content, &msg.Type, &msg.Timestamp, &msg.AvatarURL); err != nil {
+		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.Username, &msg.Content, &msg.Type, &msg.Timestamp, &msg.AvatarURL, &msg.Bot, &msg.Attachments, &msg.EditedAt,
+			&msg.Encryption); err != nil {
 			return nil, err
 		}
 		messages = append(messages, msg)
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TOTPState struct {
+	Secret  string
+	Enabled bool
+}
+
+type MFARepository struct {
+	db *pgxpool.Pool
+}
+
+func NewMFARepository(db *pgxpool.Pool) *MFARepository {
+	return &MFARepository{db: db}
+}
+
+func (r *MFARepository) GetTOTP(ctx context.Context, userID string) (*TOTPState, error) {
+	query := `SELECT COALESCE(totp_secret, ''), totp_enabled FROM users WHERE id = $1`
+
+	state := &TOTPState{}
+	err := r.db.QueryRow(ctx, query, userID).Scan(&state.Secret, &state.Enabled)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return state, nil
+}
+
+// SetPendingSecret guarda o segredo de um cadastro ainda não confirmado.
+func (r *MFARepository) SetPendingSecret(ctx context.Context, userID, secret string) error {
+	query := `UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2 AND totp_enabled = FALSE`
+	_, err := r.db.Exec(ctx, query, secret, userID)
+	return err
+}
+
+// Enable ativa o 2FA e substitui os códigos de recuperação.
+func (r *MFARepository) Enable(ctx context.Context, userID string, recoveryCodes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET totp_enabled = TRUE WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *MFARepository) Disable(ctx context.Context, userID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0 WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *MFARepository) ReplaceRecoveryCodes(ctx context.Context, userID string, codes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID string, codes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		_, err := tx.Exec(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseTOTPStep registra o passo do código aceito. Retorna false se esse passo
+// (ou um posterior) já foi usado, o que impede reaproveitar um código.
+func (r *MFARepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	query := `UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1`
+	tag, err := r.db.Exec(ctx, query, step, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode consome um código de recuperação ainda não usado.
+func (r *MFARepository) UseRecoveryCode(ctx context.Context, userID, code string) (bool, error) {
+	query := `UPDATE recovery_codes SET used_at = NOW()
+			  WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	tag, err := r.db.Exec(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *MFARepository) RemainingRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	err := r.db.QueryRow(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// Códigos de recuperação têm 50 bits aleatórios, então SHA-256 basta; a
+// normalização aceita maiúsculas e o código sem o hífen.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/webhook"
+)
+
+// OutgoingWebhookRepository guarda as inscrições de webhooks de saída e a
+// fila de entregas usada por webhook.Dispatcher.
+type OutgoingWebhookRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewOutgoingWebhookRepository(db *pgxpool.Pool) *OutgoingWebhookRepository {
+	return &OutgoingWebhookRepository{db: db}
+}
+
+// Create grava a inscrição e devolve o segredo de assinatura. Ao contrário
+// dos tokens, ele fica em claro no banco: o servidor precisa dele para
+// calcular o HMAC de cada entrega.
+func (r *OutgoingWebhookRepository) Create(ctx context.Context, hook *models.OutgoingWebhook) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	secret := "whsec_" + hex.EncodeToString(raw)
+
+	query := `INSERT INTO outgoing_webhooks (room_id, created_by, url, secret, events)
+			  VALUES (NULLIF($1, '')::uuid, $2, $3, $4, $5)
+			  RETURNING id, active, created_at`
+
+	err := r.db.QueryRow(ctx, query, hook.RoomID, hook.CreatedBy, hook.URL, secret, hook.Events).
+		Scan(&hook.ID, &hook.Active, &hook.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+const outgoingColumns = `id, COALESCE(room_id::text, ''), created_by, url, events, active, created_at`
+
+func scanOutgoing(row pgx.Row) (*models.OutgoingWebhook, error) {
+	hook := &models.OutgoingWebhook{}
+	err := row.Scan(&hook.ID, &hook.RoomID, &hook.CreatedBy, &hook.URL, &hook.Events, &hook.Active, &hook.CreatedAt)
+	return hook, err
+}
+
+func (r *OutgoingWebhookRepository) ListByCreator(ctx context.Context, userID string) ([]models.OutgoingWebhook, error) {
+	rows, err := r.db.Query(ctx, `SELECT `+outgoingColumns+` FROM outgoing_webhooks WHERE created_by = $1 ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []models.OutgoingWebhook{}
+	for rows.Next() {
+		hook, err := scanOutgoing(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, rows.Err()
+}
+
+// GetByCreator devolve o webhook se ele pertence ao usuário, ou nil.
+func (r *OutgoingWebhookRepository) GetByCreator(ctx context.Context, id, userID string) (*models.OutgoingWebhook, error) {
+	hook, err := scanOutgoing(r.db.QueryRow(ctx, `SELECT `+outgoingColumns+` FROM outgoing_webhooks WHERE id = $1 AND created_by = $2`, id, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return hook, nil
+}
+
+func (r *OutgoingWebhookRepository) Delete(ctx context.Context, id, userID string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM outgoing_webhooks WHERE id = $1 AND created_by = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Enqueue cria uma entrega para cada webhook ativo inscrito no tipo do
+// evento, na sala dele ou em todas. Em salas privadas e grupos o criador do
+// webhook precisa continuar na sala: quem sai deixa de receber os eventos.
+func (r *OutgoingWebhookRepository) Enqueue(ctx context.Context, event models.Event, payload []byte) error {
+	query := `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+			  SELECT w.id, $1, $2::text, $3 FROM outgoing_webhooks w
+			  LEFT JOIN rooms r ON r.id = w.room_id
+			  WHERE w.active AND $2::text = ANY(w.events)
+			  AND (w.room_id IS NULL OR (w.room_id = NULLIF($4::text, '')::uuid
+				  AND (r.type NOT IN ('private', 'group')
+					   OR EXISTS (SELECT 1 FROM room_users ru WHERE ru.room_id = w.room_id AND ru.user_id = w.created_by))))`
+
+	_, err := r.db.Exec(ctx, query, event.ID, event.Type, payload, event.RoomID)
+	return err
+}
+
+// Claim reserva até limit entregas vencidas, empurrando next_attempt_at para
+// depois do lease. SKIP LOCKED deixa cada réplica pegar um lote diferente.
+func (r *OutgoingWebhookRepository) Claim(ctx context.Context, limit int, lease time.Duration) ([]webhook.Delivery, error) {
+	query := `UPDATE webhook_deliveries d SET next_attempt_at = NOW() + make_interval(secs => $2)
+			  FROM outgoing_webhooks w
+			  WHERE w.id = d.webhook_id AND d.id IN (
+				  SELECT id FROM webhook_deliveries
+				  WHERE status = 'pending' AND next_attempt_at <= NOW()
+				  ORDER BY next_attempt_at
+				  LIMIT $1
+				  FOR UPDATE SKIP LOCKED
+			  )
+			  RETURNING d.id, d.event_id, d.event_type, d.payload, d.attempts, w.url, w.secret`
+
+	rows, err := r.db.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []webhook.Delivery
+	for rows.Next() {
+		var d webhook.Delivery
+		if err := rows.Scan(&d.ID, &d.EventID, &d.EventType, &d.Payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Complete registra a tentativa no log e atualiza a entrega.
+func (r *OutgoingWebhookRepository) Complete(ctx context.Context, d webhook.Delivery, result webhook.Result) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `INSERT INTO webhook_attempts (delivery_id, status_code, error, duration_ms)
+						   VALUES ($1, NULLIF($2, 0), NULLIF($3, ''), $4)`,
+		d.ID, result.StatusCode, result.Error, result.Duration.Milliseconds())
+	if err != nil {
+		return err
+	}
+
+	status := models.DeliveryPending
+	switch {
+	case result.Delivered:
+		status = models.DeliveryDelivered
+	case result.Dead:
+		status = models.DeliveryDead
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE webhook_deliveries
+						   SET status = $2::text, attempts = attempts + 1, last_error = NULLIF($3, ''),
+							   response_status = NULLIF($4, 0),
+							   next_attempt_at = COALESCE($5, next_attempt_at),
+							   delivered_at = CASE WHEN $2::text = 'delivered' THEN NOW() END
+						   WHERE id = $1`,
+		d.ID, status, result.Error, result.StatusCode, nullTime(result.NextAttemptAt))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// ListDeliveries lista as entregas mais recentes do webhook; status vazio
+// traz todas.
+func (r *OutgoingWebhookRepository) ListDeliveries(ctx context.Context, webhookID, status string, limit int) ([]models.WebhookDelivery, error) {
+	query := `SELECT id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+					 COALESCE(last_error, ''), COALESCE(response_status, 0), delivered_at, created_at
+			  FROM webhook_deliveries
+			  WHERE webhook_id = $1 AND ($2::text = '' OR status = $2::text)
+			  ORDER BY id DESC
+			  LIMIT $3`
+
+	rows, err := r.db.Query(ctx, query, webhookID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var d models.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastError, &d.ResponseStatus, &d.DeliveredAt, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (r *OutgoingWebhookRepository) ListAttempts(ctx context.Context, webhookID string, deliveryID int64) ([]models.WebhookAttempt, error) {
+	query := `SELECT a.id, a.delivery_id, COALESCE(a.status_code, 0), COALESCE(a.error, ''), a.duration_ms, a.attempted_at
+			  FROM webhook_attempts a
+			  JOIN webhook_deliveries d ON d.id = a.delivery_id
+			  WHERE d.webhook_id = $1 AND a.delivery_id = $2
+			  ORDER BY a.id`
+
+	rows, err := r.db.Query(ctx, query, webhookID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []models.WebhookAttempt{}
+	for rows.Next() {
+		var a models.WebhookAttempt
+		if err := rows.Scan(&a.ID, &a.DeliveryID, &a.StatusCode, &a.Error, &a.DurationMs, &a.AttemptedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// Retry devolve à fila uma entrega morta (ou pendente), zerando as
+// tentativas.
+func (r *OutgoingWebhookRepository) Retry(ctx context.Context, webhookID string, deliveryID int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, `UPDATE webhook_deliveries
+								SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+								WHERE id = $1 AND webhook_id = $2 AND status <> 'delivered'`, deliveryID, webhookID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
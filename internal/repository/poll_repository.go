@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+type PollRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPollRepository(db *pgxpool.Pool) *PollRepository {
+	return &PollRepository{db: db}
+}
+
+// Create grava a mensagem "poll", a enquete e as opções numa transação.
+// Preenche os IDs de poll e das opções.
+func (r *PollRepository) Create(ctx context.Context, poll *models.Poll, msg *models.Message, userID string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `INSERT INTO messages (id, room_id, user_id, username, content, type, avatar_url, created_at, is_bot)
+						   VALUES ($1, $2, NULLIF($3::text, '')::uuid, $4, $5, $6, $7, $8, $9)`,
+		msg.ID, msg.RoomID, userID, msg.Username, msg.Content, msg.Type, msg.AvatarURL, msg.Timestamp, msg.Bot)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(ctx, `INSERT INTO polls (message_id, room_id, created_by, question, multiple, anonymous, closes_at)
+							VALUES ($1, $2, NULLIF($3::text, '')::uuid, $4, $5, $6, $7)
+							RETURNING id, created_at`,
+		msg.ID, msg.RoomID, userID, poll.Question, poll.Multiple, poll.Anonymous, poll.ClosesAt).
+		Scan(&poll.ID, &poll.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for i := range poll.Options {
+		err := tx.QueryRow(ctx, `INSERT INTO poll_options (poll_id, position, text) VALUES ($1, $2, $3) RETURNING id`,
+			poll.ID, i, poll.Options[i].Text).Scan(&poll.Options[i].ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	poll.MessageID = msg.ID
+	poll.RoomID = msg.RoomID
+	poll.CreatedBy = userID
+	return tx.Commit(ctx)
+}
+
+// Get devolve a enquete com a contagem de votos. Com viewerID, MyVotes traz
+// as escolhas dessa pessoa.
+func (r *PollRepository) Get(ctx context.Context, pollID, viewerID string) (*models.Poll, error) {
+	polls, err := r.load(ctx, `p.id = $1`, pollID, viewerID)
+	if err != nil || len(polls) == 0 {
+		return nil, err
+	}
+	return polls[0], nil
+}
+
+// ForMessages carrega as enquetes das mensagens do histórico, por ID da
+// mensagem.
+func (r *PollRepository) ForMessages(ctx context.Context, messageIDs []string, viewerID string) (map[string]*models.Poll, error) {
+	result := make(map[string]*models.Poll)
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+
+	polls, err := r.load(ctx, `p.message_id = ANY($1::uuid[])`, messageIDs, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	for _, poll := range polls {
+		result[poll.MessageID] = poll
+	}
+	return result, nil
+}
+
+func (r *PollRepository) load(ctx context.Context, where string, arg any, viewerID string) ([]*models.Poll, error) {
+	rows, err := r.db.Query(ctx, `SELECT p.id, p.message_id, p.room_id, COALESCE(p.created_by::text, ''), p.question, p.multiple,
+										 p.anonymous, p.closes_at, p.closed_at, p.created_at,
+										 (SELECT COUNT(DISTINCT v.user_id) FROM poll_votes v WHERE v.poll_id = p.id)
+								  FROM polls p WHERE `+where, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	var polls []*models.Poll
+	byID := make(map[string]*models.Poll)
+	for rows.Next() {
+		var p models.Poll
+		if err := rows.Scan(&p.ID, &p.MessageID, &p.RoomID, &p.CreatedBy, &p.Question, &p.Multiple,
+			&p.Anonymous, &p.ClosesAt, &p.ClosedAt, &p.CreatedAt, &p.Voters); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		p.Options = []models.PollOption{}
+		polls = append(polls, &p)
+		byID[p.ID] = &p
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(polls) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(polls))
+	for i, p := range polls {
+		ids[i] = p.ID
+	}
+
+	// Opções e contagens.
+	rows, err = r.db.Query(ctx, `SELECT o.poll_id, o.id, o.text, COUNT(v.user_id)
+								 FROM poll_options o LEFT JOIN poll_votes v ON v.option_id = o.id
+								 WHERE o.poll_id = ANY($1::uuid[])
+								 GROUP BY o.id ORDER BY o.poll_id, o.position`, ids)
+	if err != nil {
+		return nil, err
+	}
+	optionIndex := make(map[int64]*models.PollOption)
+	for rows.Next() {
+		var pollID string
+		var o models.PollOption
+		if err := rows.Scan(&pollID, &o.ID, &o.Text, &o.Votes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		p := byID[pollID]
+		p.Options = append(p.Options, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, p := range polls {
+		for i := range p.Options {
+			optionIndex[p.Options[i].ID] = &p.Options[i]
+		}
+	}
+
+	// Quem votou em cada opção, só nas enquetes públicas.
+	rows, err = r.db.Query(ctx, `SELECT v.option_id, v.user_id, u.username
+								 FROM poll_votes v
+								 JOIN polls p ON p.id = v.poll_id
+								 JOIN users u ON u.id = v.user_id
+								 WHERE v.poll_id = ANY($1::uuid[]) AND NOT p.anonymous
+								 ORDER BY v.voted_at`, ids)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var optionID int64
+		var voter models.PollVoter
+		if err := rows.Scan(&optionID, &voter.UserID, &voter.Username); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if o := optionIndex[optionID]; o != nil {
+			o.VotedBy = append(o.VotedBy, voter)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if viewerID == "" {
+		return polls, nil
+	}
+	rows, err = r.db.Query(ctx, `SELECT poll_id, option_id FROM poll_votes
+								 WHERE poll_id = ANY($1::uuid[]) AND user_id = $2
+								 ORDER BY option_id`, ids, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pollID string
+		var optionID int64
+		if err := rows.Scan(&pollID, &optionID); err != nil {
+			return nil, err
+		}
+		p := byID[pollID]
+		p.MyVotes = append(p.MyVotes, optionID)
+	}
+	return polls, rows.Err()
+}
+
+// Vote registra as escolhas do usuário. Em enquetes de escolha única o voto
+// anterior é trocado. Devolve false se a enquete já fechou ou alguma opção
+// não pertence a ela; a linha da enquete fica travada durante a troca para
+// que dois votos simultâneos não deixem duas escolhas.
+func (r *PollRepository) Vote(ctx context.Context, pollID, userID string, optionIDs []int64) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var multiple, closed bool
+	err = tx.QueryRow(ctx, `SELECT multiple, closed_at IS NOT NULL OR COALESCE(closes_at <= NOW(), FALSE)
+							FROM polls WHERE id = $1 FOR UPDATE`, pollID).Scan(&multiple, &closed)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if closed || (!multiple && len(optionIDs) != 1) {
+		return false, nil
+	}
+
+	var valid int
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM poll_options WHERE poll_id = $1 AND id = ANY($2::bigint[])`,
+		pollID, optionIDs).Scan(&valid)
+	if err != nil {
+		return false, err
+	}
+	if valid != len(optionIDs) {
+		return false, nil
+	}
+
+	if !multiple {
+		if _, err := tx.Exec(ctx, `DELETE FROM poll_votes WHERE poll_id = $1 AND user_id = $2`, pollID, userID); err != nil {
+			return false, err
+		}
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO poll_votes (poll_id, option_id, user_id)
+						   SELECT $1::uuid, unnest($2::bigint[]), $3::uuid
+						   ON CONFLICT (option_id, user_id) DO NOTHING`, pollID, optionIDs, userID)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// Unvote retira o voto numa opção, ou todos os votos do usuário quando
+// optionID é 0. Enquetes fechadas não mudam.
+func (r *PollRepository) Unvote(ctx context.Context, pollID, userID string, optionID int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM poll_votes v USING polls p
+								WHERE v.poll_id = p.id AND p.id = $1 AND v.user_id = $2
+								  AND ($3::bigint = 0 OR v.option_id = $3::bigint)
+								  AND p.closed_at IS NULL AND (p.closes_at IS NULL OR p.closes_at > NOW())`,
+		pollID, userID, optionID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Close encerra a enquete; false se ela já estava encerrada.
+func (r *PollRepository) Close(ctx context.Context, pollID string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `UPDATE polls SET closed_at = NOW() WHERE id = $1 AND closed_at IS NULL`, pollID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// CloseDue marca como encerradas as enquetes cujo prazo passou e devolve
+// seus IDs. O UPDATE é atômico, então com várias instâncias cada enquete é
+// devolvida uma vez só.
+func (r *PollRepository) CloseDue(ctx context.Context) ([]string, error) {
+	rows, err := r.db.Query(ctx, `UPDATE polls SET closed_at = closes_at
+								  WHERE closed_at IS NULL AND closes_at <= NOW()
+								  RETURNING id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
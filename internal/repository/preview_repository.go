@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucaspanzera1/chat/internal/preview"
+)
+
+// PreviewRepository é o cache das prévias de links (link_previews).
+type PreviewRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPreviewRepository(db *pgxpool.Pool) *PreviewRepository {
+	return &PreviewRepository{db: db}
+}
+
+func (r *PreviewRepository) Lookup(ctx context.Context, urls []string) (map[string]preview.Entry, error) {
+	entries := make(map[string]preview.Entry, len(urls))
+	if len(urls) == 0 {
+		return entries, nil
+	}
+
+	rows, err := r.db.Query(ctx, `SELECT url, title, description, image_url, site_name, failed, fetched_at
+								  FROM link_previews WHERE url = ANY($1)`, urls)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e preview.Entry
+		if err := rows.Scan(&e.URL, &e.Title, &e.Description, &e.ImageURL, &e.SiteName, &e.Failed, &e.FetchedAt); err != nil {
+			return nil, err
+		}
+		entries[e.URL] = e
+	}
+	return entries, rows.Err()
+}
+
+func (r *PreviewRepository) Save(ctx context.Context, e preview.Entry) error {
+	query := `INSERT INTO link_previews (url, title, description, image_url, site_name, failed, fetched_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7)
+			  ON CONFLICT (url) DO UPDATE SET
+				  title = EXCLUDED.title, description = EXCLUDED.description, image_url = EXCLUDED.image_url,
+				  site_name = EXCLUDED.site_name, failed = EXCLUDED.failed, fetched_at = EXCLUDED.fetched_at`
+
+	_, err := r.db.Exec(ctx, query, e.URL, e.Title, e.Description, e.ImageURL, e.SiteName, e.Failed, e.FetchedAt)
+	return err
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type RetentionRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewRetentionRepository(db *pgxpool.Pool) *RetentionRepository {
+	return &RetentionRepository{db: db}
+}
+
+// Cutoffs devolve, por sala, o instante antes do qual as mensagens vencem:
+// mensagens temporárias, depois a retenção da sala e por fim defaultDays.
+// Salas sem nenhuma regra ficam de fora.
+func (r *RetentionRepository) Cutoffs(ctx context.Context, defaultDays int) (map[string]time.Time, error) {
+	query := `SELECT id, NOW() - CASE
+					WHEN disappearing_hours > 0 THEN make_interval(hours => disappearing_hours)
+					WHEN retention_days > 0 THEN make_interval(days => retention_days)
+					ELSE make_interval(days => $1::int)
+				END
+			  FROM rooms
+			  WHERE disappearing_hours > 0 OR retention_days > 0 OR $1::int > 0`
+
+	rows, err := r.db.Query(ctx, query, defaultDays)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cutoffs := make(map[string]time.Time)
+	for rows.Next() {
+		var roomID string
+		var cutoff time.Time
+		if err := rows.Scan(&roomID, &cutoff); err != nil {
+			return nil, err
+		}
+		cutoffs[roomID] = cutoff
+	}
+	return cutoffs, rows.Err()
+}
+
+// Purge apaga até limit mensagens da sala criadas antes de before e devolve
+// os IDs. Anexos ficam na própria linha, e enquetes, lembretes e votos caem
+// junto pelas chaves estrangeiras. SKIP LOCKED evita que duas réplicas
+// disputem o mesmo lote.
+func (r *RetentionRepository) Purge(ctx context.Context, roomID string, before time.Time, limit int) ([]string, error) {
+	query := `DELETE FROM messages WHERE id IN (
+				  SELECT id FROM messages
+				  WHERE (room_id = $1::uuid OR ($1::uuid = '00000000-0000-0000-0000-000000000001' AND room_id IS NULL))
+					AND created_at < $2
+				  ORDER BY created_at
+				  LIMIT $3
+				  FOR UPDATE SKIP LOCKED
+			  )
+			  RETURNING id`
+
+	rows, err := r.db.Query(ctx, query, roomID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"log"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -19,9 +20,13 @@ func NewRoomRepository(db *pgxpool.Pool) *RoomRepository {
 	return &RoomRepository{db: db}
 }
 
-func (r *RoomRepository) GetOrCreatePrivateRoom(ctx context.Context, user1ID, user2ID string) (*models.Room, error) {
+// GetOrCreatePrivateRoom devolve a conversa privada entre os dois, criando-a
+// se preciso; created diz se ela é nova.
+func (r *RoomRepository) GetOrCreatePrivateRoom(ctx context.Context, user1ID, user2ID string) (room *models.Room, created bool, err error) {
 
-	query := `SELECT DISTINCT r.id, r.type, r.created_at
+	// Uma importação pode deixar o histórico numa segunda conversa entre os
+	// dois, sem criptografia; a criptografada, se houver, é a que abre.
+	query := `SELECT r.id, r.type, r.created_at
 			  FROM rooms r
 			  INNER JOIN room_users ru1 ON ru1.room_id = r.id
 			  INNER JOIN room_users ru2 ON ru2.room_id = r.id
@@ -31,20 +36,21 @@ func (r *RoomRepository) GetOrCreatePrivateRoom(ctx context.Context, user1ID, us
 				  OR
 				  (ru1.user_id = $2 AND ru2.user_id = $1)
 			  )
+			  ORDER BY r.encrypted DESC, r.created_at
 			  LIMIT 1`
 
-	room := &models.Room{}
-	err := r.db.QueryRow(ctx, query, user1ID, user2ID).Scan(&room.ID, &room.Type, &room.CreatedAt)
+	room = &models.Room{}
+	err = r.db.QueryRow(ctx, query, user1ID, user2ID).Scan(&room.ID, &room.Type, &room.CreatedAt)
 
 	if err == nil {
 		room.Users = []string{user1ID, user2ID}
 		log.Printf("✓ Sala privada existente: %s", room.ID)
-		return room, nil
+		return room, false, nil
 	}
 
 	if !errors.Is(err, pgx.ErrNoRows) {
 		log.Printf("✗ Erro ao buscar sala: %v", err)
-		return nil, err
+		return nil, false, err
 	}
 
 	roomID := uuid.New().String()
@@ -53,19 +59,19 @@ func (r *RoomRepository) GetOrCreatePrivateRoom(ctx context.Context, user1ID, us
 	err = r.db.QueryRow(ctx, insertRoom, roomID).Scan(&room.ID, &room.Type, &room.CreatedAt)
 	if err != nil {
 		log.Printf("✗ Erro ao criar sala: %v", err)
-		return nil, err
+		return nil, false, err
 	}
 
 	insertUsers := `INSERT INTO room_users (room_id, user_id) VALUES ($1, $2), ($1, $3)`
 	if _, err := r.db.Exec(ctx, insertUsers, roomID, user1ID, user2ID); err != nil {
 		log.Printf("✗ Erro ao adicionar usuários: %v", err)
-		return nil, err
+		return nil, false, err
 	}
 
 	room.Users = []string{user1ID, user2ID}
 	log.Printf("✓ Nova sala privada criada: %s", room.ID)
 
-	return room, nil
+	return room, true, nil
 }
 
 func (r *RoomRepository) GetUserRooms(ctx context.Context, userID string) ([]models.RoomUser, error) {
@@ -220,3 +226,90 @@ func (r *RoomRepository) RemoveUserFromGroup(ctx context.Context, roomID, userID
 	_, err := r.db.Exec(ctx, query, roomID, userID)
 	return err
 }
+
+func (r *RoomRepository) GetByID(ctx context.Context, roomID string) (*models.Room, error) {
+	if _, err := uuid.Parse(roomID); err != nil {
+		return nil, nil
+	}
+
+	query := `SELECT id, COALESCE(name, ''), type, COALESCE(created_by::text, ''), created_at, slow_mode_seconds, COALESCE(topic, ''), encrypted
+			  FROM rooms WHERE id = $1`
+
+	room := &models.Room{}
+	err := r.db.QueryRow(ctx, query, roomID).Scan(&room.ID, &room.Name, &room.Type, &room.CreatedBy, &room.CreatedAt, &room.SlowModeSeconds, &room.Topic,
+		&room.Encrypted)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return room, nil
+}
+
+func (r *RoomRepository) IsMember(ctx context.Context, roomID, userID string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM room_users WHERE room_id = $1 AND user_id = $2)`
+	err := r.db.QueryRow(ctx, query, roomID, userID).Scan(&exists)
+	return exists, err
+}
+
+func (r *RoomRepository) GetSettings(ctx context.Context, roomID string) (*models.RoomSettings, error) {
+	query := `SELECT type, presence_events, persist_presence, slow_mode_seconds, retention_days, disappearing_hours, encrypted
+			  FROM rooms WHERE id = $1`
+
+	settings := &models.RoomSettings{}
+	err := r.db.QueryRow(ctx, query, roomID).Scan(&settings.RoomType, &settings.PresenceEvents, &settings.PersistPresence, &settings.SlowModeSeconds,
+		&settings.RetentionDays, &settings.DisappearingHours, &settings.Encrypted)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return settings, nil
+}
+
+// UpdateSettings grava as configurações. A criptografia só liga: uma sala
+// criptografada continua assim mesmo que settings diga o contrário.
+func (r *RoomRepository) UpdateSettings(ctx context.Context, roomID string, settings models.RoomSettings) error {
+	query := `UPDATE rooms SET presence_events = $1, persist_presence = $2, slow_mode_seconds = $3, retention_days = $4, disappearing_hours = $5,
+						 encrypted = encrypted OR $7
+			  WHERE id = $6`
+	_, err := r.db.Exec(ctx, query, settings.PresenceEvents, settings.PersistPresence, settings.SlowModeSeconds, settings.RetentionDays,
+		settings.DisappearingHours, roomID, settings.Encrypted)
+	return err
+}
+
+func (r *RoomRepository) SetTopic(ctx context.Context, roomID, topic string) error {
+	_, err := r.db.Exec(ctx, `UPDATE rooms SET topic = NULLIF($2, '') WHERE id = $1`, roomID, topic)
+	return err
+}
+
+// Mute silencia o usuário na sala até until; um novo /mute substitui o prazo.
+func (r *RoomRepository) Mute(ctx context.Context, roomID, userID, mutedBy string, until time.Time) error {
+	query := `INSERT INTO room_mutes (room_id, user_id, muted_by, muted_until) VALUES ($1, $2, $3, $4)
+			  ON CONFLICT (room_id, user_id) DO UPDATE SET muted_by = EXCLUDED.muted_by, muted_until = EXCLUDED.muted_until`
+	_, err := r.db.Exec(ctx, query, roomID, userID, mutedBy, until)
+	return err
+}
+
+func (r *RoomRepository) Unmute(ctx context.Context, roomID, userID string) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM room_mutes WHERE room_id = $1 AND user_id = $2`, roomID, userID)
+	return err
+}
+
+// MutedUntil devolve o fim do silêncio do usuário na sala, ou nil se ele
+// pode falar.
+func (r *RoomRepository) MutedUntil(ctx context.Context, roomID, userID string) (*time.Time, error) {
+	var until time.Time
+	query := `SELECT muted_until FROM room_mutes WHERE room_id = $1 AND user_id = $2 AND muted_until > NOW()`
+	err := r.db.QueryRow(ctx, query, roomID, userID).Scan(&until)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &until, nil
+}
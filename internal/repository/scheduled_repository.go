@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+type ScheduledRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewScheduledRepository(db *pgxpool.Pool) *ScheduledRepository {
+	return &ScheduledRepository{db: db}
+}
+
+const scheduledColumns = `s.id, s.user_id, s.room_id, s.kind, s.content, COALESCE(s.message_id::text, ''), s.send_at, s.timezone,
+						  s.status, s.attempts, COALESCE(s.last_error, ''), s.sent_at, s.created_at, s.updated_at,
+						  m.username, m.content, m.created_at, COALESCE(m.encryption, '')`
+
+func scanScheduled(row pgx.Row) (*models.ScheduledMessage, error) {
+	var s models.ScheduledMessage
+	var username, content *string
+	var createdAt *time.Time
+	var encryption string
+	err := row.Scan(&s.ID, &s.UserID, &s.RoomID, &s.Kind, &s.Content, &s.MessageID, &s.SendAt, &s.Timezone,
+		&s.Status, &s.Attempts, &s.LastError, &s.SentAt, &s.CreatedAt, &s.UpdatedAt,
+		&username, &content, &createdAt, &encryption)
+	if err != nil {
+		return nil, err
+	}
+	if username != nil {
+		s.Message = &models.Message{ID: s.MessageID, RoomID: s.RoomID, Username: *username, Content: *content, Timestamp: *createdAt,
+			Encryption: encryption}
+	}
+	return &s, nil
+}
+
+func (r *ScheduledRepository) Create(ctx context.Context, s *models.ScheduledMessage) error {
+	query := `INSERT INTO scheduled_messages (user_id, room_id, kind, content, message_id, send_at, timezone)
+			  VALUES ($1, $2, $3, $4, NULLIF($5::text, '')::uuid, $6, $7)
+			  RETURNING id, status, created_at, updated_at`
+
+	return r.db.QueryRow(ctx, query, s.UserID, s.RoomID, s.Kind, s.Content, s.MessageID, s.SendAt, s.Timezone).
+		Scan(&s.ID, &s.Status, &s.CreatedAt, &s.UpdatedAt)
+}
+
+func (r *ScheduledRepository) CountPending(ctx context.Context, userID string) (int, error) {
+	var n int
+	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM scheduled_messages WHERE user_id = $1 AND status = 'pending'`, userID).Scan(&n)
+	return n, err
+}
+
+// List devolve os agendamentos do usuário; status vazio traz todos.
+func (r *ScheduledRepository) List(ctx context.Context, userID, status string) ([]models.ScheduledMessage, error) {
+	query := `SELECT ` + scheduledColumns + `
+			  FROM scheduled_messages s LEFT JOIN messages m ON m.id = s.message_id
+			  WHERE s.user_id = $1 AND ($2::text = '' OR s.status = $2::text)
+			  ORDER BY s.send_at
+			  LIMIT 200`
+
+	rows, err := r.db.Query(ctx, query, userID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.ScheduledMessage{}
+	for rows.Next() {
+		s, err := scanScheduled(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *s)
+	}
+	return items, rows.Err()
+}
+
+func (r *ScheduledRepository) Get(ctx context.Context, id, userID string) (*models.ScheduledMessage, error) {
+	query := `SELECT ` + scheduledColumns + `
+			  FROM scheduled_messages s LEFT JOIN messages m ON m.id = s.message_id
+			  WHERE s.id = $1 AND s.user_id = $2`
+
+	s, err := scanScheduled(r.db.QueryRow(ctx, query, id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return s, err
+}
+
+// Update troca conteúdo e horário de um agendamento pendente. Devolve nil
+// se ele já saiu da fila ou está reservado para envio neste momento.
+func (r *ScheduledRepository) Update(ctx context.Context, id, userID, content string, sendAt time.Time, timezone string) (*models.ScheduledMessage, error) {
+	query := `UPDATE scheduled_messages SET content = $3, send_at = $4, timezone = $5, attempts = 0, last_error = NULL, updated_at = NOW()
+			  WHERE id = $1 AND user_id = $2 AND status = 'pending' AND (locked_until IS NULL OR locked_until < NOW())
+			  RETURNING id`
+
+	err := r.db.QueryRow(ctx, query, id, userID, content, sendAt, timezone).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, id, userID)
+}
+
+// Cancel tira da fila um agendamento pendente que não esteja sendo enviado.
+func (r *ScheduledRepository) Cancel(ctx context.Context, id, userID string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `UPDATE scheduled_messages SET status = 'canceled', updated_at = NOW()
+								WHERE id = $1 AND user_id = $2 AND status = 'pending' AND (locked_until IS NULL OR locked_until < NOW())`,
+		id, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Claim reserva até limit agendamentos vencidos por lease. SKIP LOCKED deixa
+// cada réplica pegar um lote diferente; se a réplica cair, a reserva expira
+// e outra assume. Mensagens gravam com o ID do agendamento, então uma
+// reserva que expirou depois de gravar é só marcada como enviada.
+func (r *ScheduledRepository) Claim(ctx context.Context, limit int, lease time.Duration) ([]models.ScheduledMessage, error) {
+	_, err := r.db.Exec(ctx, `UPDATE scheduled_messages s SET status = 'sent', sent_at = NOW(), locked_until = NULL, updated_at = NOW()
+							  WHERE s.status = 'pending' AND s.kind = 'message' AND s.locked_until < NOW()
+								AND EXISTS (SELECT 1 FROM messages m WHERE m.id = s.id)`)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `WITH claimed AS (
+				  UPDATE scheduled_messages SET locked_until = NOW() + make_interval(secs => $2)
+				  WHERE id IN (
+					  SELECT id FROM scheduled_messages
+					  WHERE status = 'pending' AND send_at <= NOW() AND (locked_until IS NULL OR locked_until < NOW())
+					  ORDER BY send_at
+					  LIMIT $1
+					  FOR UPDATE SKIP LOCKED
+				  )
+				  RETURNING *
+			  )
+			  SELECT ` + scheduledColumns + `
+			  FROM claimed s LEFT JOIN messages m ON m.id = s.message_id
+			  ORDER BY s.send_at`
+
+	rows, err := r.db.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.ScheduledMessage
+	for rows.Next() {
+		s, err := scanScheduled(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *s)
+	}
+	return items, rows.Err()
+}
+
+// Complete encerra um agendamento reservado como enviado ou com falha.
+func (r *ScheduledRepository) Complete(ctx context.Context, id, status, lastError string) error {
+	_, err := r.db.Exec(ctx, `UPDATE scheduled_messages
+							  SET status = $2::text, last_error = NULLIF($3::text, ''), locked_until = NULL, updated_at = NOW(),
+								  sent_at = CASE WHEN $2::text = 'sent' THEN NOW() END
+							  WHERE id = $1`, id, status, lastError)
+	return err
+}
+
+// Retry devolve o agendamento à fila para at. Com lastError a tentativa
+// conta; sem ele é só um adiamento (lembrete de quem está offline).
+func (r *ScheduledRepository) Retry(ctx context.Context, id string, at time.Time, lastError string) error {
+	_, err := r.db.Exec(ctx, `UPDATE scheduled_messages
+							  SET send_at = $2, last_error = NULLIF($3::text, ''), locked_until = NULL, updated_at = NOW(),
+								  attempts = attempts + CASE WHEN $3::text = '' THEN 0 ELSE 1 END
+							  WHERE id = $1`, id, at, lastError)
+	return err
+}
+
+// MessageRoom devolve a sala de uma mensagem, ou "" se ela não existe.
+func (r *ScheduledRepository) MessageRoom(ctx context.Context, messageID string) (string, error) {
+	var roomID string
+	err := r.db.QueryRow(ctx, `SELECT COALESCE(room_id, '00000000-0000-0000-0000-000000000001') FROM messages WHERE id = $1`, messageID).Scan(&roomID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return roomID, err
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Finalidades de user_tokens.
+const (
+	TokenPasswordReset = "password_reset"
+	TokenEmailVerify   = "email_verify"
+)
+
+var ErrInvalidToken = errors.New("token inválido ou expirado")
+
+// TokenRepository guarda tokens de uso único enviados por email. Só o hash
+// SHA-256 vai para o banco; o valor em claro existe apenas no link enviado.
+type TokenRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewTokenRepository(db *pgxpool.Pool) *TokenRepository {
+	return &TokenRepository{db: db}
+}
+
+// Create invalida os tokens pendentes do usuário com a mesma finalidade e
+// devolve um novo.
+func (r *TokenRepository) Create(ctx context.Context, userID, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `UPDATE user_tokens SET used_at = NOW()
+						   WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, userID, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO user_tokens (token_hash, user_id, purpose, expires_at)
+						   VALUES ($1, $2, $3, $4)`, hashToken(token), userID, purpose, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit(ctx)
+}
+
+// Consume marca o token como usado e devolve o dono. Tokens usados, expirados
+// ou de outra finalidade retornam ErrInvalidToken.
+func (r *TokenRepository) Consume(ctx context.Context, token, purpose string) (string, error) {
+	query := `UPDATE user_tokens SET used_at = NOW()
+			  WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+			  RETURNING user_id`
+
+	var userID string
+	err := r.db.QueryRow(ctx, query, hashToken(token), purpose).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrInvalidToken
+	}
+	return userID, err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
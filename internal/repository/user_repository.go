@@ -3,13 +3,24 @@ package repository
 import (
 	"context"
 	"errors"
+	"strings"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lucaspanzera1/chat/internal/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrInvalidCredentials = errors.New("credenciais inválidas")
+	ErrUserExists         = errors.New("usuário ou email já cadastrado")
+)
+
+// dummyHash é comparado quando o email não existe ou a conta não tem senha,
+// para que a resposta leve o mesmo tempo nos dois casos.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
 type UserRepository struct {
 	db *pgxpool.Pool
 }
@@ -54,11 +65,15 @@ func (r *UserRepository) Register(ctx context.Context, req models.RegisterReques
 	user := &models.User{}
 	query := `INSERT INTO users (username, email, password_hash) 
 			  VALUES ($1, $2, $3) 
-			  RETURNING id, username, email, created_at`
+			  RETURNING id, username, email, email_verified, created_at`
 
 	err = r.db.QueryRow(ctx, query, req.Username, req.Email, string(hashedPassword)).
-		Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt)
+		Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified, &user.CreatedAt)
 	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrUserExists
+		}
 		return nil, err
 	}
 
@@ -67,19 +82,21 @@ func (r *UserRepository) Register(ctx context.Context, req models.RegisterReques
 
 func (r *UserRepository) Login(ctx context.Context, email, password string) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, email, password_hash, created_at FROM users WHERE email = $1`
+	query := `SELECT id, username, email, COALESCE(password_hash, ''), email_verified, totp_enabled, created_at FROM users WHERE email = $1`
 
 	err := r.db.QueryRow(ctx, query, email).
-		Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, errors.New("credenciais inválidas")
-		}
+		Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.EmailVerified, &user.TOTPEnabled, &user.CreatedAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		return nil, err
 	}
 
+	if err != nil || user.PasswordHash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		return nil, errors.New("credenciais inválidas")
+		return nil, ErrInvalidCredentials
 	}
 
 	return user, nil
@@ -87,9 +104,9 @@ func (r *UserRepository) Login(ctx context.Context, email, password string) (*mo
 
 func (r *UserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, email, avatar_url, created_at FROM users WHERE id = $1`
+	query := `SELECT id, username, email, avatar_url, email_verified, totp_enabled, COALESCE(password_hash, '') <> '', is_bot, timezone, created_at FROM users WHERE id = $1`
 
-	err := r.db.QueryRow(ctx, query, id).Scan(&user.ID, &user.Username, &user.Email, &user.AvatarURL, &user.CreatedAt)
+	err := r.db.QueryRow(ctx, query, id).Scan(&user.ID, &user.Username, &user.Email, &user.AvatarURL, &user.EmailVerified, &user.TOTPEnabled, &user.HasPassword, &user.IsBot, &user.Timezone, &user.CreatedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
@@ -101,15 +118,16 @@ func (r *UserRepository) GetByID(ctx context.Context, id string) (*models.User,
 
 func (r *UserRepository) GetByIDWithPassword(ctx context.Context, id string) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, email, password_hash, google_id, avatar_url, created_at FROM users WHERE id = $1`
+	query := `SELECT id, username, email, COALESCE(password_hash, ''), avatar_url, totp_enabled, created_at FROM users WHERE id = $1`
 
-	err := r.db.QueryRow(ctx, query, id).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.GoogleID, &user.AvatarURL, &user.CreatedAt)
+	err := r.db.QueryRow(ctx, query, id).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.AvatarURL, &user.TOTPEnabled, &user.CreatedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
+	user.HasPassword = user.PasswordHash != ""
 	return user, nil
 }
 
@@ -119,6 +137,12 @@ func (r *UserRepository) UpdatePassword(ctx context.Context, userID, hashedPassw
 	return err
 }
 
+func (r *UserRepository) SetEmailVerified(ctx context.Context, userID string) error {
+	query := `UPDATE users SET email_verified = TRUE WHERE id = $1`
+	_, err := r.db.Exec(ctx, query, userID)
+	return err
+}
+
 func (r *UserRepository) SetOnline(ctx context.Context, userID string) error {
 	query := `UPDATE users SET is_online = TRUE, last_seen = NOW() WHERE id = $1`
 	_, err := r.db.Exec(ctx, query, userID)
@@ -131,8 +155,14 @@ func (r *UserRepository) SetOffline(ctx context.Context, userID string) error {
 	return err
 }
 
+func (r *UserRepository) SetAllOffline(ctx context.Context) error {
+	query := `UPDATE users SET is_online = FALSE, last_seen = NOW() WHERE is_online = TRUE`
+	_, err := r.db.Exec(ctx, query)
+	return err
+}
+
 func (r *UserRepository) GetAllWithStatus(ctx context.Context, excludeUserID string) ([]models.User, error) {
-	query := `SELECT id, username, email, is_online, last_seen, avatar_url 
+	query := `SELECT id, username, email, is_online, last_seen, avatar_url, is_bot 
 			  FROM users 
 			  WHERE id != $1 AND username != ''
 			  ORDER BY is_online DESC, username`
@@ -146,7 +176,7 @@ func (r *UserRepository) GetAllWithStatus(ctx context.Context, excludeUserID str
 	var users []models.User
 	for rows.Next() {
 		var user models.User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.IsOnline, &user.LastSeen, &user.AvatarURL); err != nil {
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.IsOnline, &user.LastSeen, &user.AvatarURL, &user.IsBot); err != nil {
 			return nil, err
 		}
 		users = append(users, user)
@@ -155,53 +185,118 @@ func (r *UserRepository) GetAllWithStatus(ctx context.Context, excludeUserID str
 	return users, nil
 }
 
-func (r *UserRepository) GetByGoogleID(ctx context.Context, googleID string) (*models.User, error) {
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, email, google_id, avatar_url, created_at FROM users WHERE google_id = $1`
+	query := `SELECT id, username, email, COALESCE(password_hash, ''), avatar_url, email_verified, totp_enabled, created_at FROM users WHERE email = $1`
 
-	err := r.db.QueryRow(ctx, query, googleID).Scan(&user.ID, &user.Username, &user.Email, &user.GoogleID, &user.AvatarURL, &user.CreatedAt)
+	err := r.db.QueryRow(ctx, query, email).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.AvatarURL, &user.EmailVerified, &user.TOTPEnabled, &user.CreatedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
+	user.HasPassword = user.PasswordHash != ""
 	return user, nil
 }
 
-func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+// CreateWithIdentity cria a conta (ainda sem username) e o vínculo com o
+// provedor externo na mesma transação.
+func (r *UserRepository) CreateWithIdentity(ctx context.Context, provider, subject, email, avatarURL string, emailVerified bool) (*models.User, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
 	user := &models.User{}
-	query := `SELECT id, username, email, password_hash, google_id, avatar_url, created_at FROM users WHERE email = $1`
+	query := `INSERT INTO users (email, avatar_url, username, email_verified) 
+			  VALUES ($1, NULLIF($2, ''), '', $3) 
+			  RETURNING id, username, email, avatar_url, email_verified, created_at`
 
-	err := r.db.QueryRow(ctx, query, email).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.GoogleID, &user.AvatarURL, &user.CreatedAt)
+	err = tx.QueryRow(ctx, query, email, avatarURL, emailVerified).
+		Scan(&user.ID, &user.Username, &user.Email, &user.AvatarURL, &user.EmailVerified, &user.CreatedAt)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, nil
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrUserExists
+		}
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)`,
+		user.ID, provider, subject, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, tx.Commit(ctx)
+}
+
+// FillFromIdentity completa o avatar (se a conta não tiver) e marca o email
+// como verificado quando o provedor garante isso.
+func (r *UserRepository) FillFromIdentity(ctx context.Context, userID, avatarURL string, emailVerified bool) error {
+	query := `UPDATE users SET avatar_url = COALESCE(avatar_url, NULLIF($2, '')), email_verified = email_verified OR $3 WHERE id = $1`
+	_, err := r.db.Exec(ctx, query, userID, avatarURL, emailVerified)
+	return err
+}
+
+// CreateBot cria uma conta de bot do usuário. Bots não têm senha nem
+// identidade externa, então só entram com tokens de API.
+func (r *UserRepository) CreateBot(ctx context.Context, ownerID, username string) (*models.User, error) {
+	user := &models.User{IsBot: true}
+	query := `INSERT INTO users (username, email, email_verified, is_bot, bot_owner_id)
+			  VALUES ($1, $2, TRUE, TRUE, $3)
+			  RETURNING id, username, email, avatar_url, created_at`
+
+	err := r.db.QueryRow(ctx, query, username, strings.ToLower(username)+"@bots.invalid", ownerID).
+		Scan(&user.ID, &user.Username, &user.Email, &user.AvatarURL, &user.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrUserExists
 		}
 		return nil, err
 	}
 	return user, nil
 }
 
-func (r *UserRepository) CreateWithGoogle(ctx context.Context, email, googleID, avatarURL string) (*models.User, error) {
-	user := &models.User{}
-	query := `INSERT INTO users (email, google_id, avatar_url, username) 
-			  VALUES ($1, $2, $3, '') 
-			  RETURNING id, username, email, google_id, avatar_url, created_at`
+func (r *UserRepository) ListBots(ctx context.Context, ownerID string) ([]models.User, error) {
+	query := `SELECT id, username, avatar_url, is_online, last_seen, created_at
+			  FROM users WHERE is_bot AND bot_owner_id = $1 ORDER BY created_at`
 
-	err := r.db.QueryRow(ctx, query, email, googleID, avatarURL).
-		Scan(&user.ID, &user.Username, &user.Email, &user.GoogleID, &user.AvatarURL, &user.CreatedAt)
+	rows, err := r.db.Query(ctx, query, ownerID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return user, nil
+	bots := []models.User{}
+	for rows.Next() {
+		bot := models.User{IsBot: true}
+		if err := rows.Scan(&bot.ID, &bot.Username, &bot.AvatarURL, &bot.IsOnline, &bot.LastSeen, &bot.CreatedAt); err != nil {
+			return nil, err
+		}
+		bots = append(bots, bot)
+	}
+	return bots, rows.Err()
 }
 
-func (r *UserRepository) LinkGoogleAccount(ctx context.Context, userID, googleID, avatarURL string) error {
-	query := `UPDATE users SET google_id = $1, avatar_url = COALESCE(avatar_url, $2) WHERE id = $3`
-	_, err := r.db.Exec(ctx, query, googleID, avatarURL, userID)
-	return err
+// IsBotOwner diz se botID é um bot criado por ownerID.
+func (r *UserRepository) IsBotOwner(ctx context.Context, botID, ownerID string) (bool, error) {
+	var owner bool
+	query := `SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND is_bot AND bot_owner_id = $2)`
+	err := r.db.QueryRow(ctx, query, botID, ownerID).Scan(&owner)
+	return owner, err
+}
+
+// DeleteBot apaga o bot; os tokens dele caem junto pelo ON DELETE CASCADE.
+func (r *UserRepository) DeleteBot(ctx context.Context, botID, ownerID string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM users WHERE id = $1 AND is_bot AND bot_owner_id = $2`, botID, ownerID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
 }
 
 func (r *UserRepository) SetUsername(ctx context.Context, userID, username string) error {
@@ -210,9 +305,25 @@ func (r *UserRepository) SetUsername(ctx context.Context, userID, username strin
 	return err
 }
 
+// SetTimezone guarda o fuso IANA usado para interpretar horários agendados.
+func (r *UserRepository) SetTimezone(ctx context.Context, userID, timezone string) error {
+	_, err := r.db.Exec(ctx, `UPDATE users SET timezone = $1 WHERE id = $2`, timezone, userID)
+	return err
+}
+
 func (r *UserRepository) UsernameExists(ctx context.Context, username string) (bool, error) {
 	var exists bool
 	query := `SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(username) = LOWER($1))`
 	err := r.db.QueryRow(ctx, query, username).Scan(&exists)
 	return exists, err
 }
+
+func (r *UserRepository) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	var isAdmin bool
+	query := `SELECT is_admin FROM users WHERE id = $1`
+	err := r.db.QueryRow(ctx, query, userID).Scan(&isAdmin)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return isAdmin, err
+}
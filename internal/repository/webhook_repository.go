@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lucaspanzera1/chat/internal/models"
+)
+
+// WebhookRepository guarda os webhooks de entrada. Como nos tokens de API, o
+// segredo da URL só existe em claro na resposta da criação.
+type WebhookRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewWebhookRepository(db *pgxpool.Pool) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+// CreateIncoming cria o webhook e devolve o segredo que compõe a URL.
+func (r *WebhookRepository) CreateIncoming(ctx context.Context, hook *models.IncomingWebhook) (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+
+	query := `INSERT INTO incoming_webhooks (room_id, created_by, name, username, avatar_url, token_hash)
+			  VALUES ($1, $2, $3, $4, $5, $6)
+			  RETURNING id, created_at`
+
+	err := r.db.QueryRow(ctx, query, hook.RoomID, hook.CreatedBy, hook.Name, hook.Username, hook.AvatarURL, hashToken(secret)).
+		Scan(&hook.ID, &hook.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// GetIncomingBySecret devolve o webhook do segredo e registra o uso, ou nil.
+func (r *WebhookRepository) GetIncomingBySecret(ctx context.Context, secret string) (*models.IncomingWebhook, error) {
+	query := `UPDATE incoming_webhooks SET last_used_at = NOW()
+			  WHERE token_hash = $1
+			  RETURNING id, room_id, created_by, name, username, avatar_url, last_used_at, created_at`
+
+	hook := &models.IncomingWebhook{}
+	err := r.db.QueryRow(ctx, query, hashToken(secret)).Scan(&hook.ID, &hook.RoomID, &hook.CreatedBy, &hook.Name,
+		&hook.Username, &hook.AvatarURL, &hook.LastUsedAt, &hook.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return hook, nil
+}
+
+func (r *WebhookRepository) ListIncoming(ctx context.Context, roomID string) ([]models.IncomingWebhook, error) {
+	query := `SELECT id, room_id, created_by, name, username, avatar_url, last_used_at, created_at
+			  FROM incoming_webhooks WHERE room_id = $1 ORDER BY created_at`
+
+	rows, err := r.db.Query(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []models.IncomingWebhook{}
+	for rows.Next() {
+		var hook models.IncomingWebhook
+		if err := rows.Scan(&hook.ID, &hook.RoomID, &hook.CreatedBy, &hook.Name, &hook.Username, &hook.AvatarURL,
+			&hook.LastUsedAt, &hook.CreatedAt); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (r *WebhookRepository) DeleteIncoming(ctx context.Context, roomID, id string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM incoming_webhooks WHERE id = $1 AND room_id = $2`, id, roomID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
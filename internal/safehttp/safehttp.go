@@ -0,0 +1,158 @@
+// Package safehttp monta o cliente HTTP usado para chamar URLs informadas
+// por usuários (prévias de links, webhooks de saída, comandos de bots) sem
+// dar acesso à rede interna do servidor.
+package safehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultTimeout      = 10 * time.Second
+	defaultRedirects    = 3
+	defaultHeaderBytes  = 64 << 10
+	defaultIdleConns    = 16
+	defaultIdleConnTime = 30 * time.Second
+)
+
+// ErrBlocked é a tentativa de acessar um endereço interno ou um esquema
+// diferente de http(s).
+var ErrBlocked = errors.New("endereço bloqueado")
+
+// blockedPrefixes completa os métodos de netip com faixas que não são
+// "privadas" para a biblioteca padrão mas alcançam a rede interna ou não
+// são roteáveis.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// PublicAddr diz se o endereço pode ser acessado: nada de loopback, redes
+// privadas, link-local (inclusive o 169.254.169.254 dos metadados de
+// nuvem), multicast e afins.
+func PublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+type Options struct {
+	// Timeout vale para a requisição inteira e para cada etapa da conexão.
+	Timeout        time.Duration
+	MaxRedirects   int
+	MaxHeaderBytes int64
+	// Allow decide quais endereços podem ser acessados. Nil é PublicAddr;
+	// os testes liberam o loopback do httptest.
+	Allow func(netip.Addr) bool
+}
+
+func (o *Options) defaults() {
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.MaxRedirects <= 0 {
+		o.MaxRedirects = defaultRedirects
+	}
+	if o.MaxHeaderBytes <= 0 {
+		o.MaxHeaderBytes = defaultHeaderBytes
+	}
+	if o.Allow == nil {
+		o.Allow = PublicAddr
+	}
+}
+
+// NewClient devolve o cliente restrito: o endereço é conferido depois da
+// resolução de DNS, na hora de conectar, o que vale também para
+// redirecionamentos e não deixa um DNS com TTL curto trocar o IP entre a
+// checagem e a conexão.
+func NewClient(opts Options) *http.Client {
+	opts.defaults()
+	dialer := &net.Dialer{
+		Timeout: opts.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil || !opts.Allow(ip) {
+				return fmt.Errorf("%w: %s", ErrBlocked, host)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		// Sem proxy: um proxy do ambiente faria a conexão por nós e
+		// escaparia da checagem acima.
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    opts.Timeout,
+		ResponseHeaderTimeout:  opts.Timeout,
+		MaxResponseHeaderBytes: opts.MaxHeaderBytes,
+		MaxIdleConns:           defaultIdleConns,
+		IdleConnTimeout:        defaultIdleConnTime,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return errors.New("redirecionamentos demais")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("%w: esquema %s", ErrBlocked, req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// CheckURL valida um destino na hora do cadastro: http(s), sem usuário e
+// senha na URL e com o host resolvendo só para endereços públicos. Não
+// substitui a checagem do NewClient, já que o DNS pode mudar depois.
+func CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("%w: url inválida", ErrBlocked)
+	}
+
+	host := u.Hostname()
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !PublicAddr(ip) {
+			return fmt.Errorf("%w: %s", ErrBlocked, host)
+		}
+		return nil
+	}
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("host %s não encontrado: %w", host, err)
+	}
+	for _, ip := range ips {
+		if !PublicAddr(ip) {
+			return fmt.Errorf("%w: %s resolve para %s", ErrBlocked, host, ip)
+		}
+	}
+	return nil
+}
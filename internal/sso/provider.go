@@ -0,0 +1,232 @@
+package sso
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+var ErrNonceMismatch = errors.New("nonce do id_token não confere")
+
+// Identity é o que um provedor informa sobre o usuário autenticado. Subject é
+// estável por provedor; o email pode mudar e nem sempre vem verificado.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+}
+
+// Provider encapsula um login externo: OIDC com discovery ou um OAuth2 puro
+// (GitHub) que busca o perfil numa API própria.
+type Provider struct {
+	Name        string
+	DisplayName string
+
+	config   oauth2.Config
+	oidc     *gooidc.Provider
+	verifier *gooidc.IDTokenVerifier
+	profile  func(ctx context.Context, client *http.Client) (*Identity, error)
+}
+
+// NewOIDCProvider faz o discovery do issuer (/.well-known/openid-configuration)
+// e prepara a verificação do id_token com as chaves publicadas pelo provedor.
+func NewOIDCProvider(ctx context.Context, name, displayName, issuer string, cfg oauth2.Config) (*Provider, error) {
+	provider, err := gooidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovery de %s: %w", issuer, err)
+	}
+
+	cfg.Endpoint = provider.Endpoint()
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{gooidc.ScopeOpenID, "email", "profile"}
+	}
+
+	return &Provider{
+		Name:        name,
+		DisplayName: displayName,
+		config:      cfg,
+		oidc:        provider,
+		verifier:    provider.Verifier(&gooidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+// NewGitHubProvider usa o OAuth2 do GitHub, que não é OIDC: o perfil e o email
+// primário verificado vêm de apiURL (https://api.github.com).
+func NewGitHubProvider(cfg oauth2.Config, apiURL string) *Provider {
+	if cfg.Endpoint.AuthURL == "" {
+		cfg.Endpoint = github.Endpoint
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"read:user", "user:email"}
+	}
+	apiURL = strings.TrimSuffix(apiURL, "/")
+
+	return &Provider{
+		Name:        "github",
+		DisplayName: "GitHub",
+		config:      cfg,
+		profile: func(ctx context.Context, client *http.Client) (*Identity, error) {
+			return githubProfile(ctx, client, apiURL)
+		},
+	}
+}
+
+func (p *Provider) IsOIDC() bool {
+	return p.oidc != nil
+}
+
+// AuthCodeURL monta o redirect para o provedor com PKCE e, no OIDC, o nonce
+// que será exigido no id_token.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+	if p.IsOIDC() {
+		opts = append(opts, gooidc.Nonce(nonce))
+	}
+	return p.config.AuthCodeURL(state, opts...)
+}
+
+// Authenticate troca o código pelo token e devolve a identidade do usuário.
+func (p *Provider) Authenticate(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("troca do código: %w", err)
+	}
+
+	if !p.IsOIDC() {
+		return p.profile(ctx, p.config.Client(ctx, token))
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.New("resposta sem id_token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("id_token inválido: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	// Alguns provedores (Azure AD, Keycloak sem mappers) não colocam email no
+	// id_token; nesse caso o userinfo completa os dados.
+	if claims.Email == "" {
+		info, err := p.oidc.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, fmt.Errorf("userinfo: %w", err)
+		}
+		if info.Subject != idToken.Subject {
+			return nil, errors.New("userinfo de outro usuário")
+		}
+		if err := info.Claims(&claims); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Identity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+		Picture:       claims.Picture,
+	}, nil
+}
+
+type oidcClaims struct {
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+	Name          string   `json:"name"`
+	Picture       string   `json:"picture"`
+}
+
+// flexBool aceita email_verified como booleano ou string ("true"), formato
+// usado por alguns provedores.
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var v bool
+	if err := json.Unmarshal(data, &v); err == nil {
+		*b = flexBool(v)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, _ = strconv.ParseBool(s)
+	*b = flexBool(v)
+	return nil
+}
+
+func githubProfile(ctx context.Context, client *http.Client, apiURL string) (*Identity, error) {
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := getJSON(ctx, client, apiURL+"/user", &user); err != nil {
+		return nil, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, apiURL+"/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Subject: strconv.FormatInt(user.ID, 10),
+		Name:    user.Name,
+		Picture: user.AvatarURL,
+	}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = e.Verified
+			break
+		}
+	}
+	return identity, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s respondeu %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
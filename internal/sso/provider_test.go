@@ -0,0 +1,290 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// fakeIssuer é um provedor OIDC mínimo: discovery, JWKS, token e userinfo.
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	// Campos usados para montar o próximo id_token.
+	audience     string
+	nonce        string
+	idTokenEmail bool
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeIssuer{key: key, audience: "chat-client", idTokenEmail: true}
+	mux := http.NewServeMux()
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                f.URL,
+			"authorization_endpoint":                f.URL + "/authorize",
+			"token_endpoint":                        f.URL + "/token",
+			"jwks_uri":                              f.URL + "/jwks",
+			"userinfo_endpoint":                     f.URL + "/userinfo",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "good-code" || r.Form.Get("code_verifier") == "" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   f.URL,
+			"sub":   "user-123",
+			"aud":   f.audience,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": f.nonce,
+			"name":  "Ana",
+		}
+		if f.idTokenEmail {
+			claims["email"] = "ana@example.com"
+			claims["email_verified"] = true
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test-key"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access-123",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-123" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"sub":            "user-123",
+			"email":          "ana@userinfo.example.com",
+			"email_verified": "true",
+			"picture":        "https://example.com/ana.png",
+		})
+	})
+
+	return f
+}
+
+func newTestProvider(t *testing.T, f *fakeIssuer) *Provider {
+	t.Helper()
+	p, err := NewOIDCProvider(context.Background(), "fake", "Fake", f.URL, oauth2.Config{
+		ClientID:     "chat-client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/api/auth/sso/fake/callback",
+	})
+	if err != nil {
+		t.Fatalf("NewOIDCProvider: %v", err)
+	}
+	return p
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := newTestProvider(t, f)
+
+	raw := p.AuthCodeURL("state-1", "nonce-1", oauth2.GenerateVerifier())
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(raw, f.URL+"/authorize") {
+		t.Fatalf("URL fora do authorization_endpoint: %s", raw)
+	}
+
+	q := u.Query()
+	if q.Get("state") != "state-1" || q.Get("nonce") != "nonce-1" {
+		t.Fatalf("state/nonce ausentes: %s", raw)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("PKCE ausente: %s", raw)
+	}
+	if !strings.Contains(q.Get("scope"), "openid") {
+		t.Fatalf("escopo openid ausente: %s", q.Get("scope"))
+	}
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	f := newFakeIssuer(t)
+	f.nonce = "nonce-1"
+	p := newTestProvider(t, f)
+
+	identity, err := p.Authenticate(context.Background(), "good-code", "nonce-1", oauth2.GenerateVerifier())
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Subject != "user-123" || identity.Email != "ana@example.com" || !identity.EmailVerified {
+		t.Fatalf("identidade inesperada: %+v", identity)
+	}
+	if identity.Name != "Ana" {
+		t.Fatalf("nome = %q", identity.Name)
+	}
+}
+
+func TestOIDCUserInfoFallback(t *testing.T) {
+	f := newFakeIssuer(t)
+	f.nonce = "nonce-1"
+	f.idTokenEmail = false
+	p := newTestProvider(t, f)
+
+	identity, err := p.Authenticate(context.Background(), "good-code", "nonce-1", oauth2.GenerateVerifier())
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Email != "ana@userinfo.example.com" || !identity.EmailVerified {
+		t.Fatalf("email do userinfo não usado: %+v", identity)
+	}
+	if identity.Picture != "https://example.com/ana.png" {
+		t.Fatalf("picture = %q", identity.Picture)
+	}
+}
+
+func TestOIDCRejectsWrongNonce(t *testing.T) {
+	f := newFakeIssuer(t)
+	f.nonce = "outro-nonce"
+	p := newTestProvider(t, f)
+
+	_, err := p.Authenticate(context.Background(), "good-code", "nonce-1", oauth2.GenerateVerifier())
+	if !errors.Is(err, ErrNonceMismatch) {
+		t.Fatalf("esperava ErrNonceMismatch, veio %v", err)
+	}
+}
+
+func TestOIDCRejectsWrongAudience(t *testing.T) {
+	f := newFakeIssuer(t)
+	f.nonce = "nonce-1"
+	f.audience = "outro-client"
+	p := newTestProvider(t, f)
+
+	if _, err := p.Authenticate(context.Background(), "good-code", "nonce-1", oauth2.GenerateVerifier()); err == nil {
+		t.Fatal("id_token de outro client foi aceito")
+	}
+}
+
+func TestOIDCRejectsBadCode(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := newTestProvider(t, f)
+
+	if _, err := p.Authenticate(context.Background(), "bad-code", "", oauth2.GenerateVerifier()); err == nil {
+		t.Fatal("código inválido foi aceito")
+	}
+}
+
+func TestGitHubProfile(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "gh-token", "token_type": "bearer"})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"id": 42, "login": "ana", "avatar_url": "https://example.com/a.png"})
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{
+			{"email": "old@example.com", "primary": false, "verified": true},
+			{"email": "ana@example.com", "primary": true, "verified": true},
+		})
+	})
+
+	p := NewGitHubProvider(oauth2.Config{
+		ClientID: "gh-client",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  srv.URL + "/login/oauth/authorize",
+			TokenURL: srv.URL + "/login/oauth/access_token",
+		},
+	}, srv.URL)
+
+	identity, err := p.Authenticate(context.Background(), "any-code", "", oauth2.GenerateVerifier())
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Subject != "42" || identity.Email != "ana@example.com" || !identity.EmailVerified || identity.Name != "ana" {
+		t.Fatalf("identidade inesperada: %+v", identity)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	f := newFakeIssuer(t)
+
+	t.Setenv("GOOGLE_CLIENT_ID", "")
+	t.Setenv("GITHUB_CLIENT_ID", "")
+	t.Setenv("OIDC_PROVIDERS", "keycloak, broken")
+	t.Setenv("OIDC_KEYCLOAK_ISSUER", f.URL)
+	t.Setenv("OIDC_KEYCLOAK_CLIENT_ID", "chat-client")
+	t.Setenv("OIDC_KEYCLOAK_DISPLAY_NAME", "Keycloak")
+	t.Setenv("OIDC_BROKEN_ISSUER", f.URL+"/nao-existe")
+	t.Setenv("OIDC_BROKEN_CLIENT_ID", "x")
+
+	registry := FromEnv(context.Background(), "http://chat.local/")
+
+	list := registry.List()
+	if len(list) != 1 || list[0].Name != "keycloak" || list[0].DisplayName != "Keycloak" {
+		t.Fatalf("provedores = %+v", list)
+	}
+
+	p := registry.Get("keycloak")
+	if p.config.RedirectURL != "http://chat.local/api/auth/sso/keycloak/callback" {
+		t.Fatalf("redirect = %s", p.config.RedirectURL)
+	}
+}
@@ -0,0 +1,124 @@
+package sso
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Registry guarda os provedores configurados, na ordem em que aparecem na
+// tela de login.
+type Registry struct {
+	providers map[string]*Provider
+	order     []string
+}
+
+type ProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]*Provider)}
+}
+
+func (r *Registry) Register(p *Provider) {
+	if _, exists := r.providers[p.Name]; !exists {
+		r.order = append(r.order, p.Name)
+	}
+	r.providers[p.Name] = p
+}
+
+func (r *Registry) Get(name string) *Provider {
+	return r.providers[name]
+}
+
+func (r *Registry) List() []ProviderInfo {
+	list := make([]ProviderInfo, 0, len(r.order))
+	for _, name := range r.order {
+		p := r.providers[name]
+		list = append(list, ProviderInfo{Name: p.Name, DisplayName: p.DisplayName})
+	}
+	return list
+}
+
+// FromEnv monta o registro a partir das variáveis de ambiente:
+//
+//	GOOGLE_CLIENT_ID / GOOGLE_CLIENT_SECRET / GOOGLE_REDIRECT_URL
+//	GITHUB_CLIENT_ID / GITHUB_CLIENT_SECRET
+//	OIDC_PROVIDERS=keycloak,gitlab e, para cada um, OIDC_<NOME>_ISSUER,
+//	OIDC_<NOME>_CLIENT_ID, OIDC_<NOME>_CLIENT_SECRET, OIDC_<NOME>_DISPLAY_NAME
+//	e OIDC_<NOME>_SCOPES (separados por espaço).
+//
+// Um provedor cujo discovery falha é ignorado com aviso, para não derrubar o
+// servidor por causa de um issuer fora do ar.
+func FromEnv(ctx context.Context, appURL string) *Registry {
+	registry := NewRegistry()
+	appURL = strings.TrimSuffix(appURL, "/")
+
+	if clientID := os.Getenv("GOOGLE_CLIENT_ID"); clientID != "" {
+		redirect := os.Getenv("GOOGLE_REDIRECT_URL")
+		if redirect == "" {
+			redirect = appURL + "/api/auth/google/callback"
+		}
+		p, err := NewOIDCProvider(ctx, "google", "Google", "https://accounts.google.com", oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+			RedirectURL:  redirect,
+		})
+		if err != nil {
+			log.Printf("Aviso: login com Google desativado: %v", err)
+		} else {
+			registry.Register(p)
+		}
+	}
+
+	if clientID := os.Getenv("GITHUB_CLIENT_ID"); clientID != "" {
+		registry.Register(NewGitHubProvider(oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+			RedirectURL:  CallbackURL(appURL, "github"),
+		}, "https://api.github.com"))
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		issuer := os.Getenv(prefix + "ISSUER")
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		if issuer == "" || clientID == "" {
+			log.Printf("Aviso: provedor %s sem %sISSUER ou %sCLIENT_ID, ignorado", name, prefix, prefix)
+			continue
+		}
+
+		displayName := os.Getenv(prefix + "DISPLAY_NAME")
+		if displayName == "" {
+			displayName = name
+		}
+
+		p, err := NewOIDCProvider(ctx, name, displayName, issuer, oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  CallbackURL(appURL, name),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		})
+		if err != nil {
+			log.Printf("Aviso: provedor %s desativado: %v", name, err)
+			continue
+		}
+		registry.Register(p)
+	}
+
+	return registry
+}
+
+func CallbackURL(appURL, name string) string {
+	return appURL + "/api/auth/sso/" + name + "/callback"
+}
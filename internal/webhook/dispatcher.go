@@ -0,0 +1,242 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/safehttp"
+)
+
+// Delivery é uma entrega reservada para envio, já com o destino.
+type Delivery struct {
+	ID        int64
+	EventID   string
+	EventType string
+	Payload   []byte
+	Attempts  int
+	URL       string
+	Secret    string
+}
+
+// Result é o desfecho de uma tentativa. Sem Delivered, NextAttemptAt diz
+// quando tentar de novo, ou Dead manda a entrega para a fila de mortas.
+type Result struct {
+	StatusCode    int
+	Error         string
+	Duration      time.Duration
+	Delivered     bool
+	Dead          bool
+	NextAttemptAt time.Time
+}
+
+// Store é a fila durável de entregas (webhook_deliveries no Postgres).
+// Claim precisa ser seguro com várias réplicas: cada entrega reservada fica
+// invisível às outras até lease expirar.
+type Store interface {
+	Enqueue(ctx context.Context, event models.Event, payload []byte) error
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error)
+	Complete(ctx context.Context, d Delivery, result Result) error
+}
+
+type Config struct {
+	PollInterval time.Duration
+	BatchSize    int
+	Workers      int
+	Timeout      time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+func (c *Config) defaults() {
+	if c.PollInterval <= 0 {
+		c.PollInterval = 2 * time.Second
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 50
+	}
+	if c.Workers <= 0 {
+		c.Workers = 4
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 8
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = 30 * time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 6 * time.Hour
+	}
+}
+
+// Dispatcher grava os eventos na fila (Publish) e, em Run, entrega o que
+// estiver pendente, com novas tentativas em backoff exponencial.
+type Dispatcher struct {
+	store  Store
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewDispatcher entrega com o cliente de safehttp: as URLs são escolhidas
+// por quem cria a inscrição e não podem alcançar a rede interna.
+func NewDispatcher(store Store, cfg Config) *Dispatcher {
+	cfg.defaults()
+	return &Dispatcher{
+		store:  store,
+		cfg:    cfg,
+		client: safehttp.NewClient(safehttp.Options{Timeout: cfg.Timeout}),
+		now:    time.Now,
+	}
+}
+
+// Publish enfileira o evento para os webhooks inscritos. Falhas só vão para
+// o log: um webhook fora do ar não pode impedir o envio de uma mensagem.
+func (d *Dispatcher) Publish(ctx context.Context, event models.Event) {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = d.now()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Erro ao serializar evento %s: %v", event.Type, err)
+		return
+	}
+	if err := d.store.Enqueue(context.WithoutCancel(ctx), event, payload); err != nil {
+		log.Printf("Erro ao enfileirar evento %s: %v", event.Type, err)
+	}
+}
+
+// Run processa a fila até o contexto ser cancelado.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Esvazia o que já está vencido antes de esperar o próximo tick.
+		for {
+			n, err := d.RunOnce(ctx)
+			if err != nil {
+				log.Printf("Erro ao processar webhooks: %v", err)
+			}
+			if err != nil || n < d.cfg.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce reserva um lote, entrega em paralelo e devolve quantas entregas
+// foram processadas.
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	lease := d.cfg.Timeout + time.Minute
+	deliveries, err := d.store.Claim(ctx, d.cfg.BatchSize, lease)
+	if err != nil {
+		return 0, err
+	}
+
+	sem := make(chan struct{}, d.cfg.Workers)
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(delivery Delivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := d.deliver(ctx, delivery)
+			if ctx.Err() != nil {
+				// Desligando: a reserva expira e outra réplica (ou o
+				// próximo boot) tenta de novo sem contar esta tentativa.
+				return
+			}
+			if err := d.store.Complete(context.WithoutCancel(ctx), delivery, result); err != nil {
+				log.Printf("Erro ao registrar entrega %d: %v", delivery.ID, err)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery Delivery) Result {
+	start := d.now()
+	result := Result{}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		result.Error = err.Error()
+		result.Dead = true
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chat-webhooks/1")
+	req.Header.Set("X-Chat-Event", delivery.EventType)
+	req.Header.Set("X-Chat-Delivery", fmt.Sprint(delivery.ID))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, start.Unix(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	result.Duration = d.now().Sub(start)
+	if errors.Is(err, safehttp.ErrBlocked) {
+		// Um endereço interno não passa a ser aceito numa nova tentativa.
+		result.Error = err.Error()
+		result.Dead = true
+		return result
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		result.StatusCode = resp.StatusCode
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			result.Delivered = true
+			return result
+		}
+		result.Error = resp.Status
+	}
+
+	attempt := delivery.Attempts + 1
+	if attempt >= d.cfg.MaxAttempts {
+		result.Dead = true
+		return result
+	}
+	result.NextAttemptAt = d.now().Add(Backoff(attempt, d.cfg.BaseBackoff, d.cfg.MaxBackoff))
+	return result
+}
+
+// Backoff devolve a espera após a n-ésima falha: base, 2×base, 4×base...
+// limitada a max.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	wait := base
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= max {
+			return max
+		}
+	}
+	return min(wait, max)
+}
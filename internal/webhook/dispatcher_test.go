@@ -0,0 +1,304 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucaspanzera1/chat/internal/models"
+	"github.com/lucaspanzera1/chat/internal/safehttp"
+)
+
+// memStore é a fila em memória, com um único webhook inscrito.
+type memStore struct {
+	mu         sync.Mutex
+	url        string
+	secret     string
+	events     []string
+	now        func() time.Time
+	deliveries []*memDelivery
+}
+
+type memDelivery struct {
+	Delivery
+	status  string
+	nextAt  time.Time
+	results []Result
+}
+
+func (s *memStore) Enqueue(ctx context.Context, event models.Event, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.events, event.Type) {
+		return nil
+	}
+	s.deliveries = append(s.deliveries, &memDelivery{
+		Delivery: Delivery{
+			ID: int64(len(s.deliveries) + 1), EventID: event.ID, EventType: event.Type,
+			Payload: payload, URL: s.url, Secret: s.secret,
+		},
+		status: models.DeliveryPending,
+		nextAt: s.now(),
+	})
+	return nil
+}
+
+func (s *memStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var claimed []Delivery
+	for _, d := range s.deliveries {
+		if len(claimed) == limit {
+			break
+		}
+		if d.status == models.DeliveryPending && !d.nextAt.After(s.now()) {
+			d.nextAt = s.now().Add(lease)
+			claimed = append(claimed, d.Delivery)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *memStore) Complete(ctx context.Context, delivery Delivery, result Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.deliveries[delivery.ID-1]
+	d.Attempts++
+	d.results = append(d.results, result)
+	switch {
+	case result.Delivered:
+		d.status = models.DeliveryDelivered
+	case result.Dead:
+		d.status = models.DeliveryDead
+	default:
+		d.nextAt = result.NextAttemptAt
+	}
+	return nil
+}
+
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// receiver é o endpoint do integrador: confere a assinatura e responde com
+// os status da fila statuses (200 quando ela acaba).
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	statuses []int
+	events   []models.Event
+	headers  []http.Header
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := Verify(rc.secret, r.Header.Get(SignatureHeader), body, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	var event models.Event
+	json.Unmarshal(body, &event)
+	rc.events = append(rc.events, event)
+	rc.headers = append(rc.headers, r.Header.Clone())
+
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestDispatcher(t *testing.T, rc *receiver, cfg Config) (*Dispatcher, *memStore, *clock) {
+	t.Helper()
+
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+
+	clk := &clock{t: time.Unix(1_700_000_000, 0)}
+	store := &memStore{url: srv.URL, secret: rc.secret, events: []string{models.EventMessageCreated}, now: clk.now}
+	d := NewDispatcher(store, cfg)
+	d.client = safehttp.NewClient(safehttp.Options{Timeout: d.cfg.Timeout, Allow: loopback})
+	d.now = clk.now
+	return d, store, clk
+}
+
+// loopback libera o servidor do httptest, que o cliente padrão recusa.
+func loopback(ip netip.Addr) bool {
+	return ip.IsLoopback()
+}
+
+func TestDispatcherDeliversSignedEvent(t *testing.T) {
+	rc := &receiver{secret: "whsec_test"}
+	d, store, _ := newTestDispatcher(t, rc, Config{})
+
+	d.Publish(context.Background(), models.Event{
+		Type:   models.EventMessageCreated,
+		RoomID: "room-1",
+		Data:   models.Message{ID: "m1", Content: "oi"},
+	})
+	d.Publish(context.Background(), models.Event{Type: models.EventMemberJoined, RoomID: "room-1"})
+
+	n, err := d.RunOnce(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("RunOnce = %d, %v; esperado 1 entrega (member.joined não foi assinado)", n, err)
+	}
+
+	if len(rc.events) != 1 || rc.events[0].Type != models.EventMessageCreated || rc.events[0].ID == "" {
+		t.Fatalf("evento recebido = %+v", rc.events)
+	}
+	if got := rc.headers[0].Get("X-Chat-Event"); got != models.EventMessageCreated {
+		t.Errorf("X-Chat-Event = %q", got)
+	}
+	if got := store.deliveries[0].status; got != models.DeliveryDelivered {
+		t.Errorf("status = %q, esperado delivered", got)
+	}
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	rc := &receiver{secret: "whsec_test", statuses: []int{500, 503}}
+	d, store, clk := newTestDispatcher(t, rc, Config{BaseBackoff: time.Minute, MaxBackoff: time.Hour})
+
+	d.Publish(context.Background(), models.Event{Type: models.EventMessageCreated, RoomID: "room-1"})
+
+	d.RunOnce(context.Background())
+	delivery := store.deliveries[0]
+	if delivery.status != models.DeliveryPending || delivery.Attempts != 1 {
+		t.Fatalf("após 500: status=%q tentativas=%d", delivery.status, delivery.Attempts)
+	}
+	if want := clk.now().Add(time.Minute); !delivery.nextAt.Equal(want) {
+		t.Fatalf("próxima tentativa = %s, esperado %s", delivery.nextAt, want)
+	}
+
+	// Antes do backoff nada é reservado.
+	if n, _ := d.RunOnce(context.Background()); n != 0 {
+		t.Fatalf("entrega reenviada antes do backoff")
+	}
+
+	clk.advance(time.Minute)
+	d.RunOnce(context.Background())
+	if want := clk.now().Add(2 * time.Minute); !delivery.nextAt.Equal(want) {
+		t.Fatalf("segundo backoff = %s, esperado %s", delivery.nextAt, want)
+	}
+
+	clk.advance(2 * time.Minute)
+	d.RunOnce(context.Background())
+	if delivery.status != models.DeliveryDelivered || delivery.Attempts != 3 {
+		t.Fatalf("status=%q tentativas=%d, esperado delivered após 3", delivery.status, delivery.Attempts)
+	}
+	if delivery.results[0].StatusCode != 500 || delivery.results[1].StatusCode != 503 {
+		t.Errorf("log de tentativas = %+v", delivery.results)
+	}
+
+	// Todas as tentativas levam o mesmo evento.
+	for _, event := range rc.events {
+		if event.ID != rc.events[0].ID {
+			t.Errorf("ID do evento mudou entre tentativas: %s != %s", event.ID, rc.events[0].ID)
+		}
+	}
+}
+
+func TestDispatcherDeadLetter(t *testing.T) {
+	rc := &receiver{secret: "whsec_test", statuses: []int{500, 500, 500}}
+	d, store, clk := newTestDispatcher(t, rc, Config{MaxAttempts: 3, BaseBackoff: time.Second})
+
+	d.Publish(context.Background(), models.Event{Type: models.EventMessageCreated, RoomID: "room-1"})
+
+	for range 3 {
+		d.RunOnce(context.Background())
+		clk.advance(time.Hour)
+	}
+
+	delivery := store.deliveries[0]
+	if delivery.status != models.DeliveryDead || delivery.Attempts != 3 {
+		t.Fatalf("status=%q tentativas=%d, esperado dead após 3", delivery.status, delivery.Attempts)
+	}
+	if n, _ := d.RunOnce(context.Background()); n != 0 {
+		t.Fatalf("entrega morta foi reservada de novo")
+	}
+}
+
+func TestDispatcherWrongSecretIsRetried(t *testing.T) {
+	rc := &receiver{secret: "outro-segredo"}
+	d, store, _ := newTestDispatcher(t, rc, Config{})
+	store.secret = "whsec_test"
+
+	d.Publish(context.Background(), models.Event{Type: models.EventMessageCreated, RoomID: "room-1"})
+	d.RunOnce(context.Background())
+
+	result := store.deliveries[0].results[0]
+	if result.Delivered || result.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("resultado = %+v, esperado 401 do receptor", result)
+	}
+}
+
+func TestDispatcherRefusesInternalAddress(t *testing.T) {
+	rc := &receiver{secret: "whsec_test"}
+	d, store, _ := newTestDispatcher(t, rc, Config{})
+	// O cliente padrão, sem a liberação do loopback.
+	d.client = NewDispatcher(store, Config{}).client
+
+	d.Publish(context.Background(), models.Event{Type: models.EventMessageCreated, RoomID: "room-1"})
+	d.RunOnce(context.Background())
+
+	if len(rc.events) != 0 {
+		t.Fatalf("o servidor local recebeu %d eventos", len(rc.events))
+	}
+	delivery := store.deliveries[0]
+	if delivery.status != models.DeliveryDead || !strings.Contains(delivery.results[0].Error, "bloqueado") {
+		t.Fatalf("status=%q resultado=%+v, esperado dead por endereço bloqueado", delivery.status, delivery.results[0])
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"type":"message.created"}`)
+	header := Sign("s3cr3t", time.Now().Unix(), body)
+
+	if err := Verify("s3cr3t", header, body, time.Minute); err != nil {
+		t.Fatalf("assinatura válida recusada: %v", err)
+	}
+	if err := Verify("outro", header, body, time.Minute); err == nil {
+		t.Error("segredo errado aceito")
+	}
+	if err := Verify("s3cr3t", header, []byte(`{"type":"member.left"}`), time.Minute); err == nil {
+		t.Error("corpo alterado aceito")
+	}
+
+	old := Sign("s3cr3t", time.Now().Add(-time.Hour).Unix(), body)
+	if err := Verify("s3cr3t", old, body, 5*time.Minute); err == nil {
+		t.Error("timestamp antigo aceito")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 30*time.Second, 10*time.Minute
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, w := range want {
+		if got := Backoff(i+1, base, max); got != w {
+			t.Errorf("Backoff(%d) = %s, esperado %s", i+1, got, w)
+		}
+	}
+}
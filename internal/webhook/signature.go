@@ -0,0 +1,55 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader leva "t=<unix>,v1=<hex>", onde v1 é o HMAC-SHA256 de
+// "<t>.<corpo>" com o segredo do webhook. O timestamp no conteúdo assinado
+// impede que um corpo capturado seja reenviado depois.
+const SignatureHeader = "X-Chat-Signature"
+
+var ErrBadSignature = errors.New("assinatura inválida")
+
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Verify confere o header recebido; receptores em Go podem usá-la
+// diretamente. tolerance limita a idade do timestamp (zero não limita).
+func Verify(secret, header string, body []byte, tolerance time.Duration) error {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return ErrBadSignature
+	}
+	if tolerance > 0 && time.Since(time.Unix(timestamp, 0)).Abs() > tolerance {
+		return ErrBadSignature
+	}
+
+	expected := Sign(secret, timestamp, body)
+	_, want, _ := strings.Cut(expected, "v1=")
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		return ErrBadSignature
+	}
+	return nil
+}